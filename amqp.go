@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	amqpHeartbeat      = time.Minute
+	amqpReconnectDelay = 2 * time.Second
+	amqpRetryDelay     = 10 * time.Second // Delay before a task which failed transiently is delivered again
+	amqpTimeout        = 10 * time.Second
+)
+
+var (
+	amqpDeadLetterExchange string // Empty doesn't set the dead letter exchange of the queue
+	amqpQueue              string
+	amqpURL                string // Empty disables the consumer
+)
+
+// amqpConsumer runs the tasks of a queue. Prefetch is the size of the worker
+// pool so that the broker spreads messages across instances. Messages are
+// acknowledged once handled, rejected to be delivered again after a delay on
+// transient failures, or rejected without being requeued otherwise so that the
+// broker moves these poison messages to the dead letter exchange of the queue.
+type amqpConsumer struct {
+	tc *taskConsumer
+}
+
+func newAMQPConsumer(tc *taskConsumer) *amqpConsumer {
+	return &amqpConsumer{tc: tc}
+}
+
+// start consumes messages in the background, reconnecting on failure
+func (c *amqpConsumer) start() {
+	go func() {
+		for {
+			if err := c.consume(); err != nil {
+				rootLogger.error("main: consuming amqp messages failed", "error", err)
+			}
+			time.Sleep(amqpReconnectDelay)
+		}
+	}()
+}
+
+// consume handles messages until the connection fails, the broker delivers
+// again the messages which weren't acknowledged
+func (c *amqpConsumer) consume() (err error) {
+	// Connect
+	var conn *amqp.Connection
+	if conn, err = amqp.DialConfig(amqpURL, amqp.Config{
+		Dial:      amqp.DefaultDial(amqpTimeout),
+		Heartbeat: amqpHeartbeat,
+	}); err != nil {
+		err = fmt.Errorf("main: connecting to amqp failed: %w", err)
+		return
+	}
+	defer conn.Close()
+	var ch *amqp.Channel
+	if ch, err = conn.Channel(); err != nil {
+		err = fmt.Errorf("main: opening amqp channel failed: %w", err)
+		return
+	}
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	// Set prefetch
+	if err = ch.Qos(maxConcurrency, 0, false); err != nil {
+		err = fmt.Errorf("main: setting amqp prefetch failed: %w", err)
+		return
+	}
+
+	// Declare durable queue
+	args := amqp.Table{}
+	if amqpDeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = amqpDeadLetterExchange
+	}
+	if _, err = ch.QueueDeclare(amqpQueue, true, false, false, false, args); err != nil {
+		err = fmt.Errorf("main: declaring amqp queue failed: %w", err)
+		return
+	}
+
+	// Consume
+	var ds <-chan amqp.Delivery
+	if ds, err = ch.Consume(amqpQueue, "", false, false, false, false, nil); err != nil {
+		err = fmt.Errorf("main: consuming amqp queue failed: %w", err)
+		return
+	}
+	rootLogger.info("main: consuming amqp messages", "queue", amqpQueue)
+
+	// Handle messages until the channel or the connection is closed
+	for d := range ds {
+		go c.handle(ch, d)
+	}
+	if e := <-closed; e != nil {
+		return e
+	}
+	return amqp.ErrClosed
+}
+
+// handle runs the task of a message, acknowledges it and replies to requests
+func (c *amqpConsumer) handle(ch *amqp.Channel, d amqp.Delivery) {
+	// Run task
+	l := rootLogger.with("amqp_delivery_tag", d.DeliveryTag)
+	task, retry := c.tc.handle(d.Body, l, "amqp message")
+
+	// Reject message, transient failures are delivered again after a delay
+	if retry {
+		time.Sleep(amqpRetryDelay)
+		if err := d.Nack(false, true); err != nil {
+			l.error("main: rejecting amqp message failed", "error", err)
+		}
+		return
+	}
+
+	// Reply
+	if d.ReplyTo != "" {
+		b, err := json.Marshal(task.redacted())
+		if err != nil {
+			l.error("main: marshaling task failed", "error", err)
+		} else if err = c.reply(ch, d, b); err != nil {
+			l.error("main: replying to amqp message failed", "error", err)
+		}
+	}
+
+	// Acknowledge message, or dead-letter it
+	var err error
+	if task.Success {
+		err = d.Ack(false)
+	} else {
+		err = d.Nack(false, false)
+	}
+	if err != nil {
+		l.error("main: acknowledging amqp message failed", "error", err)
+	}
+}
+
+// reply publishes the task of a message on its reply_to queue, through the
+// default exchange
+func (c *amqpConsumer) reply(ch *amqp.Channel, d amqp.Delivery, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), amqpTimeout)
+	defer cancel()
+	return ch.PublishWithContext(ctx, "", d.ReplyTo, false, false, amqp.Publishing{
+		Body:          body,
+		ContentType:   "application/json",
+		CorrelationId: d.CorrelationId,
+	})
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/m/pipeline"
+	"github.com/asticode/go-astiav"
+	"github.com/gofiber/fiber/v2"
+)
+
+// analysisRequest is the body of the analysis endpoints, which decode the
+// input as transcodes do but return a report instead of an output
+type analysisRequest struct {
+	AudioUrl    string   `form:"audiourl"`
+	Headers     []string `form:"headers"`
+	StreamIndex *int     `form:"streamindex"` // Input index of the audio stream, defaults to the first one
+	Language    string   `form:"language"`    // Language of the audio stream, e.g. eng
+	Start       string   `form:"start"`       // Input position such as 1m30s or number of seconds
+	Duration    string   `form:"duration"`    // Duration such as 30s or number of seconds, empty until the end
+	Timeout     string   `form:"timeout"`     // Duration such as 30s or number of seconds
+
+	// Waveform
+	Mode       string `form:"mode"`       // minmax or rms, defaults to minmax
+	Resolution int    `form:"resolution"` // Peaks per second, defaults to 100
+
+	// Silence detection
+	SilenceThreshold float64 `form:"silencethreshold"` // In dBFS, defaults to -50
+	SilenceDuration  string  `form:"silenceduration"`  // Minimum duration such as 500ms or number of seconds, defaults to 2s
+
+	// Checksum
+	Algorithm string `form:"algorithm"` // md5 or sha256, defaults to md5
+}
+
+// waveformResult is the response of a waveform extraction
+type waveformResult struct {
+	Duration   float64   `json:"duration"` // Seconds of audio analyzed
+	Max        []float32 `json:"max,omitempty"`
+	Min        []float32 `json:"min,omitempty"`
+	Mode       string    `json:"mode"`
+	Resolution int       `json:"resolution"` // Peaks per second
+	RMS        []float32 `json:"rms,omitempty"`
+}
+
+// silenceResult is the response of a silence detection
+type silenceResult struct {
+	Duration float64         `json:"duration"` // Seconds of audio analyzed
+	Silences []silenceReport `json:"silences"`
+}
+
+// silenceReport is a silent interval, in seconds from the start of the
+// analyzed audio
+type silenceReport struct {
+	Duration float64 `json:"duration"`
+	End      float64 `json:"end"`
+	Start    float64 `json:"start"`
+}
+
+// fingerprintResult is the response of a fingerprint computation
+type fingerprintResult struct {
+	Duration    float64 `json:"duration"`    // Seconds of audio analyzed
+	Fingerprint string  `json:"fingerprint"` // Compressed and base64 encoded, as AcoustID expects
+}
+
+// clippingResult is the response of a clipping detection. The levels in dBFS
+// are left out for silent audio.
+type clippingResult struct {
+	ClippedSamples int64    `json:"clippedSamples"` // Samples at full scale or beyond, in all channels
+	Duration       float64  `json:"duration"`       // Seconds of audio analyzed
+	Peak           float64  `json:"peak"`           // Highest absolute sample value, 1 being full scale
+	PeakDb         *float64 `json:"peakDb,omitempty"`
+	TruePeak       float64  `json:"truePeak"` // Highest absolute value between samples
+	TruePeakDb     *float64 `json:"truePeakDb,omitempty"`
+}
+
+// checksumResult is the response of a checksum computation
+type checksumResult struct {
+	Algorithm  string  `json:"algorithm"`
+	Channels   int     `json:"channels"`
+	Checksum   string  `json:"checksum"` // Hex encoded hash of the samples as signed 32 bits little endian PCM
+	Duration   float64 `json:"duration"` // Seconds of audio analyzed
+	SampleRate int     `json:"sampleRate"`
+}
+
+// analysisHandler handles the requests of an analysis: the input is opened
+// with the restrictions and limits of transcodes, in a slot of the worker
+// pool, and the result of analyze is sent as JSON
+func analysisHandler(pool *workerPool, tracing *tracer, name string, analyze func(r *analysisRequest, task *TranscodeTask, parent *span) (interface{}, error)) fiber.Handler {
+	return func(ct *fiber.Ctx) (err error) {
+		r := new(analysisRequest)
+
+		// Trace the request, continuing the trace of the caller
+		sp := tracing.startSpan(ct.Get(headerTraceparent), name)
+		var analysisErr error
+		defer func() { sp.finish(analysisErr) }()
+
+		if err := ct.BodyParser(r); err != nil {
+			return sendError(ct, fiber.StatusBadRequest, err)
+		}
+		task := &TranscodeTask{
+			AudioUrl:    r.AudioUrl,
+			Duration:    r.Duration,
+			Headers:     r.Headers,
+			Language:    r.Language,
+			Start:       r.Start,
+			StreamIndex: r.StreamIndex,
+			Timeout:     r.Timeout,
+			log:         requestLogger(ct),
+		}
+
+		// Apply the restrictions of the api key
+		if analysisErr = authorizeTask(ct, task); analysisErr != nil {
+			if errors.Is(analysisErr, errQuotaExceeded) {
+				return sendQuotaExceeded(ct)
+			}
+			return sendError(ct, task.Status, analysisErr)
+		}
+
+		// Wait for a free slot in the worker pool
+		if err = pool.acquire(true); err != nil {
+			ct.Set(fiber.HeaderRetryAfter, "1")
+			return sendError(ct, overflowStatus, err)
+		}
+		defer pool.release()
+
+		// Analyze
+		res, analysisErr := analyze(r, task, sp)
+		if analysisErr != nil {
+			requestLogger(ct).error("main: analyzing failed", "url", task.AudioUrl, "error", analysisErr)
+			return sendError(ct, task.Status, analysisErr)
+		}
+		return ct.JSON(res)
+	}
+}
+
+// analyzeTask opens the task input and decodes it into the analyses, or
+// outputs whose result isn't sent, set up by add, updating task.Status on
+// failure. It returns the
+// duration of audio decoded, which counts in the quota of the task.
+func analyzeTask(task *TranscodeTask, parent *span, add func(t *pipeline.Transcoder) error) (d time.Duration, err error) {
+	// Check task
+	task.Status = http.StatusOK
+	if task.AudioUrl == "" {
+		task.Status = http.StatusBadRequest
+		err = errAudioURLRequired
+		return
+	}
+	if err = checkTimeout(task.Timeout); err != nil {
+		task.Status = http.StatusBadRequest
+		return
+	}
+	if v, err1 := parseTimeout(task.Start); err1 != nil || v < 0 {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: invalid start: %s", task.Start)
+		return
+	}
+	if v, err1 := parseTimeout(task.Duration); err1 != nil || v < 0 {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: invalid duration: %s", task.Duration)
+		return
+	}
+	if task.StreamIndex != nil && *task.StreamIndex < 0 {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: invalid stream index: %d", *task.StreamIndex)
+		return
+	}
+
+	// Open input and set up analyses
+	t := newTaskTranscoder(context.Background(), task, parent)
+	defer t.close()
+	if err = t.Open(t.ctx, t.inputURL()); err == nil {
+		err = add(t.Transcoder)
+	}
+	if err != nil {
+		t.checkError(&err)
+		return
+	}
+
+	// Analyze
+	err = t.run()
+	d = t.Position()
+	return
+}
+
+// waveform extracts the peaks of the input of a request
+func waveform(r *analysisRequest, task *TranscodeTask, parent *span) (interface{}, error) {
+	// Check options
+	w := &pipeline.Waveform{
+		MaxPeaks:   maxWaveformPeaks,
+		Mode:       r.Mode,
+		Resolution: r.Resolution,
+	}
+	if w.Mode == "" {
+		w.Mode = pipeline.WaveformMinMax
+	}
+	if w.Resolution == 0 {
+		w.Resolution = defaultWaveformResolution
+	}
+	if w.Mode != pipeline.WaveformMinMax && w.Mode != pipeline.WaveformRMS {
+		task.Status = http.StatusBadRequest
+		return nil, fmt.Errorf("main: waveform mode not supported: %s", r.Mode)
+	} else if w.Resolution < pipeline.MinWaveformResolution || w.Resolution > pipeline.MaxWaveformResolution {
+		task.Status = http.StatusBadRequest
+		return nil, fmt.Errorf("main: waveform resolution out of range: %d", w.Resolution)
+	}
+
+	// Analyze
+	d, err := analyzeTask(task, parent, func(t *pipeline.Transcoder) error {
+		return t.AddWaveform(w)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &waveformResult{
+		Duration:   d.Seconds(),
+		Max:        w.Max,
+		Min:        w.Min,
+		Mode:       w.Mode,
+		Resolution: w.Resolution,
+		RMS:        w.RMS,
+	}, nil
+}
+
+// silences detects the silences of the input of a request
+func silences(r *analysisRequest, task *TranscodeTask, parent *span) (interface{}, error) {
+	// Check options
+	s := &pipeline.SilenceDetection{
+		Duration:  defaultSilenceDuration,
+		Threshold: r.SilenceThreshold,
+	}
+	if s.Threshold == 0 {
+		s.Threshold = defaultSilenceThreshold
+	}
+	if !(s.Threshold >= minSilenceThreshold && s.Threshold < 0) {
+		task.Status = http.StatusBadRequest
+		return nil, fmt.Errorf("main: silence threshold out of range: %g", r.SilenceThreshold)
+	}
+	if r.SilenceDuration != "" {
+		d, err := parseTimeout(r.SilenceDuration)
+		if err != nil || d < 0 || d > maxSilenceDuration {
+			task.Status = http.StatusBadRequest
+			return nil, fmt.Errorf("main: invalid silence duration: %s", r.SilenceDuration)
+		}
+		s.Duration = d
+	}
+
+	// Analyze
+	d, err := analyzeTask(task, parent, func(t *pipeline.Transcoder) error {
+		return t.AddSilenceDetection(s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &silenceResult{
+		Duration: d.Seconds(),
+		Silences: []silenceReport{},
+	}
+	for _, i := range s.Silences {
+		res.Silences = append(res.Silences, silenceReport{
+			Duration: (i.End - i.Start).Seconds(),
+			End:      i.End.Seconds(),
+			Start:    i.Start.Seconds(),
+		})
+	}
+	return res, nil
+}
+
+// clipping measures the peaks of the input of a request and counts its
+// clipped samples
+func clipping(r *analysisRequest, task *TranscodeTask, parent *span) (interface{}, error) {
+	// Analyze
+	c := &pipeline.ClippingDetection{}
+	d, err := analyzeTask(task, parent, func(t *pipeline.Transcoder) error {
+		return t.AddClippingDetection(c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &clippingResult{
+		ClippedSamples: c.ClippedSamples,
+		Duration:       d.Seconds(),
+		Peak:           c.Peak,
+		PeakDb:         dbfs(c.Peak),
+		TruePeak:       c.TruePeak,
+		TruePeakDb:     dbfs(c.TruePeak),
+	}, nil
+}
+
+// dbfs returns a level in dBFS, nil if it's silent
+func dbfs(v float64) *float64 {
+	if v <= 0 {
+		return nil
+	}
+	db := 20 * math.Log10(v)
+	return &db
+}
+
+// checksum hashes the decoded samples of the input of a request
+func checksum(r *analysisRequest, task *TranscodeTask, parent *span) (interface{}, error) {
+	// Check options
+	c := &pipeline.Checksum{}
+	algorithm := strings.ToLower(r.Algorithm)
+	switch algorithm {
+	case "", checksumMD5:
+		algorithm = checksumMD5
+		c.Hash = md5.New()
+	case checksumSHA256:
+		c.Hash = sha256.New()
+	default:
+		task.Status = http.StatusBadRequest
+		return nil, fmt.Errorf("main: checksum algorithm not supported: %s", r.Algorithm)
+	}
+
+	// Analyze
+	d, err := analyzeTask(task, parent, func(t *pipeline.Transcoder) error {
+		return t.AddChecksum(c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &checksumResult{
+		Algorithm:  algorithm,
+		Channels:   c.Channels,
+		Checksum:   hex.EncodeToString(c.Hash.Sum(nil)),
+		Duration:   d.Seconds(),
+		SampleRate: c.SampleRate,
+	}, nil
+}
+
+// fingerprint computes the chromaprint fingerprint of the input of a request,
+// which the chromaprint muxer writes in its trailer
+func fingerprint(r *analysisRequest, task *TranscodeTask, parent *span) (interface{}, error) {
+	// Check muxer
+	fc, err := astiav.AllocOutputFormatContext(nil, "chromaprint", "")
+	if err != nil {
+		task.Status = http.StatusNotImplemented
+		return nil, errors.New("main: chromaprint isn't supported by the linked FFmpeg")
+	}
+	fc.Free()
+
+	// Read the fingerprint through a pipe
+	p, err := newOutputPipe()
+	if err != nil {
+		task.Status = http.StatusInternalServerError
+		return nil, fmt.Errorf("main: creating output pipe failed: %w", err)
+	}
+	read := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(p)
+		read <- b
+	}()
+
+	// Analyze
+	d, err := analyzeTask(task, parent, func(t *pipeline.Transcoder) error {
+		return t.AddOutput(pipeline.Output{
+			Channels:   defaultChannels,
+			Codec:      "pcm_s16le",
+			Format:     "chromaprint",
+			SampleRate: defaultSampleRate,
+			URL:        p.url(),
+		})
+	})
+	p.closeWrite(err)
+	b := <-read
+	if err != nil {
+		return nil, err
+	}
+	return &fingerprintResult{
+		Duration:    d.Seconds(),
+		Fingerprint: strings.TrimSpace(string(b)),
+	}, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	apiKeysFile         string // Empty disables authentication
+	defaultQuotaMinutes float64
+	defaultRateLimit    int
+)
+
+var (
+	errAuthDisabled  = errors.New("main: authentication is disabled")
+	errInvalidAPIKey = errors.New("main: invalid api key")
+	errQuotaExceeded = errors.New("main: daily quota exceeded")
+	errRateLimited   = errors.New("main: rate limit exceeded")
+)
+
+// apiKey is a key allowed to use the API and its restrictions
+type apiKey struct {
+	Key          string   `json:"key"`
+	MaxDuration  string   `json:"maxDuration"` // Maximum input duration, lower than TRANSGODE_MAX_INPUT_DURATION
+	MediaTypes   []string `json:"mediaTypes"`  // Allowed output media types, empty allows all
+	Name         string   `json:"name"`
+	QuotaMinutes float64  `json:"quotaMinutes"` // Decoded audio minutes per UTC day, 0 disables the quota
+	RateLimit    int      `json:"rateLimit"`    // Requests per minute, 0 disables the limit
+
+	limiter     *rateLimiter
+	maxDuration time.Duration
+	quota       *quota
+}
+
+// apiKeyStore holds the api keys indexed by their hash
+type apiKeyStore struct {
+	keys  map[string]*apiKey
+	names map[string]*apiKey
+}
+
+// loadAPIKeys loads api keys from a json file:
+// {"keys": [{"name": "...", "key": "...", "mediaTypes": ["wav"], "maxDuration": "10m", "rateLimit": 60, "quotaMinutes": 600}]}
+func loadAPIKeys(path string) (s *apiKeyStore, err error) {
+	// Read file
+	var b []byte
+	if b, err = ioutil.ReadFile(path); err != nil {
+		err = fmt.Errorf("main: reading api keys failed: %w", err)
+		return
+	}
+
+	// Unmarshal
+	var f struct {
+		Keys []*apiKey `json:"keys"`
+	}
+	if err = json.Unmarshal(b, &f); err != nil {
+		err = fmt.Errorf("main: unmarshaling api keys failed: %w", err)
+		return
+	}
+
+	// Index keys
+	s = &apiKeyStore{
+		keys:  make(map[string]*apiKey),
+		names: make(map[string]*apiKey),
+	}
+	for i, k := range f.Keys {
+		if k.Key == "" {
+			err = fmt.Errorf("main: api key %d has no key", i)
+			return
+		}
+		if k.Name == "" {
+			k.Name = strconv.Itoa(i)
+		}
+		if k.MaxDuration != "" {
+			if k.maxDuration, err = time.ParseDuration(k.MaxDuration); err != nil {
+				err = fmt.Errorf("main: parsing max duration of api key %s failed: %w", k.Name, err)
+				return
+			}
+		}
+		if k.RateLimit == 0 {
+			k.RateLimit = defaultRateLimit
+		}
+		if k.RateLimit > 0 {
+			k.limiter = newRateLimiter(k.RateLimit, time.Minute)
+		}
+		if k.QuotaMinutes == 0 {
+			k.QuotaMinutes = defaultQuotaMinutes
+		}
+		if k.QuotaMinutes > 0 {
+			k.quota = newQuota(time.Duration(k.QuotaMinutes * float64(time.Minute)))
+		}
+		s.keys[hashAPIKey(k.Key)] = k
+		s.names[k.Name] = k
+	}
+	return
+}
+
+// byName returns the api key with this name or nil
+func (s *apiKeyStore) byName(name string) *apiKey {
+	if s == nil {
+		return nil
+	}
+	return s.names[name]
+}
+
+// lookup returns the api key or nil
+func (s *apiKeyStore) lookup(key string) *apiKey {
+	if key == "" {
+		return nil
+	}
+	return s.keys[hashAPIKey(key)]
+}
+
+// hashAPIKey hashes keys so that looking them up doesn't compare secrets
+func hashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// allowsMediaType checks the output media type is allowed for the key
+func (k *apiKey) allowsMediaType(mediaType string) bool {
+	if len(k.MediaTypes) == 0 {
+		return true
+	}
+	for _, t := range k.MediaTypes {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimiter is a token bucket allowing n requests per period
+type rateLimiter struct {
+	last   time.Time
+	m      *sync.Mutex
+	n      float64
+	period time.Duration
+	tokens float64
+}
+
+func newRateLimiter(n int, period time.Duration) *rateLimiter {
+	return &rateLimiter{
+		last:   time.Now(),
+		m:      &sync.Mutex{},
+		n:      float64(n),
+		period: period,
+		tokens: float64(n),
+	}
+}
+
+// allow takes a token, if none is left it returns how long to wait for one
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	// Refill
+	now := time.Now()
+	l.tokens = math.Min(l.n, l.tokens+now.Sub(l.last).Seconds()*l.n/l.period.Seconds())
+	l.last = now
+
+	// Take token
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) * float64(l.period) / l.n)
+}
+
+// authenticate is the middleware checking the api key of requests when
+// authentication is enabled
+func authenticate(keys *apiKeyStore) fiber.Handler {
+	return func(ct *fiber.Ctx) error {
+		if keys == nil {
+			return ct.Next()
+		}
+
+		// Get key
+		k := keys.lookup(ct.Get(headerAPIKey))
+		if k == nil {
+			return sendError(ct, fiber.StatusUnauthorized, errInvalidAPIKey)
+		}
+
+		// Check rate limit
+		if k.limiter != nil {
+			if ok, wait := k.limiter.allow(); !ok {
+				ct.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				return sendError(ct, fiber.StatusTooManyRequests, errRateLimited)
+			}
+		}
+
+		// Store key
+		ct.Locals(localAPIKey, k)
+		ct.Locals(localLogger, requestLogger(ct).with("api_key", k.Name))
+		return ct.Next()
+	}
+}
+
+// requestAPIKey returns the api key of the request, nil if authentication
+// is disabled
+func requestAPIKey(ct *fiber.Ctx) *apiKey {
+	k, _ := ct.Locals(localAPIKey).(*apiKey)
+	return k
+}
+
+// authorizeTask applies the restrictions of the request api key to the task.
+// errQuotaExceeded is returned when the daily quota of the key is used up.
+func authorizeTask(ct *fiber.Ctx, task *TranscodeTask) error {
+	return authorizeTaskKey(requestAPIKey(ct), task)
+}
+
+// authorizeTaskKey applies the restrictions of an api key to the task, nil
+// when authentication is disabled
+func authorizeTaskKey(k *apiKey, task *TranscodeTask) error {
+	if k == nil {
+		return nil
+	}
+
+	// Check media type, analyses having none
+	if task.MediaType != "" && !k.allowsMediaType(task.MediaType) {
+		task.Status = http.StatusForbidden
+		return fmt.Errorf("main: media type not allowed for this api key: %s", task.MediaType)
+	}
+	task.MaxDuration = k.maxDuration
+
+	// Check quota, the input can't be longer than what is left of it
+	if k.quota != nil {
+		left := k.quota.remaining()
+		if left <= 0 {
+			task.Status = http.StatusTooManyRequests
+			return errQuotaExceeded
+		}
+		if task.MaxDuration <= 0 || left < task.MaxDuration {
+			task.MaxDuration = left
+		}
+		task.quota = k.quota
+	}
+	return nil
+}
+
+// sendQuotaExceeded responds to a request whose api key quota is used up
+func sendQuotaExceeded(ct *fiber.Ctx) error {
+	ct.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(time.Until(nextQuotaReset(time.Now())).Seconds()))))
+	return sendError(ct, fiber.StatusTooManyRequests, errQuotaExceeded)
+}
+
+// requestOwner returns the name of the api key jobs created by the request
+// belong to, empty if authentication is disabled
+func requestOwner(ct *fiber.Ctx) string {
+	if k := requestAPIKey(ct); k != nil {
+		return k.Name
+	}
+	return ""
+}
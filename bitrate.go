@@ -0,0 +1,49 @@
+package main
+
+import "fmt"
+
+// lossyMediaTypes are the media types whose encoder takes a bit rate
+var lossyMediaTypes = map[string]bool{
+	"dash": true,
+	"hls":  true,
+	"mp3":  true,
+}
+
+// mp3BitRates are the bit rates in kbps of MP3 frames, by the lowest sample
+// rate of their MPEG version: MPEG-1 from 32 kHz, MPEG-2 from 16 kHz and
+// MPEG-2.5 below, which shares the bit rates of MPEG-2
+var mp3BitRates = []struct {
+	bitRates   []int
+	sampleRate int
+}{
+	{bitRates: []int{32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}, sampleRate: 32000},
+	{bitRates: []int{8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}, sampleRate: 0},
+}
+
+// checkBitRate checks an output of the media type and sample rate can be
+// encoded at the bit rate in kbps. MP3 frames only have a few bit rates,
+// which depend on the sample rate, and libmp3lame would silently pick the
+// nearest one otherwise.
+func checkBitRate(mediaType string, sampleRate, bitRate int) error {
+	if !lossyMediaTypes[mediaType] {
+		return fmt.Errorf("main: bit rate isn't supported by %s", mediaType)
+	}
+	if bitRate < minBitRate || bitRate > maxBitRate {
+		return fmt.Errorf("main: bit rate out of range: %d", bitRate)
+	}
+	if mediaType != "mp3" {
+		return nil
+	}
+	for _, v := range mp3BitRates {
+		if sampleRate < v.sampleRate {
+			continue
+		}
+		for _, b := range v.bitRates {
+			if b == bitRate {
+				return nil
+			}
+		}
+		return fmt.Errorf("main: mp3 bit rate not supported at %d Hz: %d, valid values are %v", sampleRate, bitRate, v.bitRates)
+	}
+	return nil
+}
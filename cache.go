@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheTTL is how long outputs of synchronous transcodes are served again to
+// identical requests, 0 disables the cache
+var cacheTTL time.Duration
+
+// cacheHeadTimeout bounds the request getting the version of an input
+const cacheHeadTimeout = 10 * time.Second
+
+// headerCache tells whether the output was served from the cache
+const headerCache = "X-Cache"
+
+// outputCache maps the cache keys of tasks to the results storing their
+// output, so that repeated requests, e.g. for the same TTS phrase, are
+// answered without transcoding
+type outputCache struct {
+	ids map[string]string // Result ids by cache key
+	m   *sync.Mutex
+	s   *resultStore
+}
+
+func newOutputCache(s *resultStore) *outputCache {
+	return &outputCache{
+		ids: make(map[string]string),
+		m:   &sync.Mutex{},
+		s:   s,
+	}
+}
+
+// get returns the cached result of a key or nil
+func (c *outputCache) get(key string) *result {
+	c.m.Lock()
+	defer c.m.Unlock()
+	id, ok := c.ids[key]
+	if !ok {
+		return nil
+	}
+	r := c.s.get(id)
+	if r == nil {
+		delete(c.ids, key)
+	}
+	return r
+}
+
+// set caches a committed result under a key
+func (c *outputCache) set(key string, r *result) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.ids[key] = r.id
+}
+
+// cacheKey returns the cache key of a prepared task: a hash of its parameters
+// and of the versions of its inputs, see inputVersion, concatenated, mixed,
+// video and cover art ones included. Empty means the task isn't cached: the
+// cache is disabled, the output is pushed or paced, or an input is read from
+// the request, has no version or can't be reached. Inputs without a version,
+// e.g. dynamic TTS endpoints, could change between requests, so their outputs
+// would be served stale.
+func cacheKey(task *TranscodeTask) string {
+	// Check task
+	if cacheTTL <= 0 || task.PushUrl != "" || (task.Realtime != nil && *task.Realtime) || strings.HasPrefix(task.AudioUrl, "pipe:") {
+		return ""
+	}
+
+	// Get input versions, cover art is fetched without the headers
+	urls := append(append([]string{task.AudioUrl, task.VideoUrl}, task.Concat...), task.Mix...)
+	var versions []string
+	for i, u := range append(urls, task.CoverUrl) {
+		if u == "" || isDataURL(u) {
+			continue
+		}
+		headers := task.Headers
+		if i == len(urls) {
+			headers = nil
+		}
+		version, err := inputVersion(task, u, headers)
+		if err != nil {
+			taskLogger(task).debug("main: getting input version failed", "url", u, "error", err)
+			return ""
+		} else if version == "" {
+			return ""
+		}
+		versions = append(versions, version)
+	}
+
+	// Hash the fields describing the output
+	params := *task
+	params.Timeout, params.OutputDestination, params.OutputURL, params.OutputURLs = "", "", "", nil
+	params.Success, params.Status, params.Message, params.ValidSampleRates = false, 0, "", nil
+	b, err := json.Marshal(params)
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", strings.Join(versions, "\n"))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// inputVersion returns the ETag, or else the Last-Modified header, of an HTTP
+// input of a task, requested with headers, and the modification time and size
+// of a file input, which are checked against the input policy first. Other
+// inputs and HTTP inputs without these headers have no version.
+func inputVersion(task *TranscodeTask, rawurl string, headers []string) (version string, err error) {
+	// Parse url
+	var u *url.URL
+	if u, err = url.Parse(rawurl); err != nil {
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file" {
+		return
+	}
+
+	// Check url, and the url of redirects
+	policy := taskOptions(task, nil).InputPolicy
+	if err = policy.Check(rawurl); err != nil {
+		return
+	}
+
+	// Stat file
+	if u.Scheme == "file" {
+		var fi os.FileInfo
+		if fi, err = os.Stat(u.Path); err != nil {
+			return
+		}
+		version = fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size())
+		return
+	}
+	client := policy.HTTPClient()
+
+	// Create request
+	ctx, cancel := context.WithTimeout(context.Background(), cacheHeadTimeout)
+	defer cancel()
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodHead, rawurl, nil); err != nil {
+		return
+	}
+	for _, h := range headers {
+		if i := strings.Index(h, ":"); i > 0 {
+			req.Header.Add(h[:i], strings.TrimSpace(h[i+1:]))
+		}
+	}
+
+	// Send request
+	var resp *http.Response
+	if resp, err = client.Do(req); err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = fmt.Errorf("main: unexpected status %d", resp.StatusCode)
+		return
+	}
+	if version = resp.Header.Get("ETag"); version == "" {
+		version = resp.Header.Get("Last-Modified")
+	}
+	return
+}
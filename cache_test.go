@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	// Serve inputs, the version of /etag.wav changes with etag
+	etag := `"1"`
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/etag.wav":
+			w.Header().Set("ETag", etag)
+		case "/modified.wav":
+			w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+		case "/missing.wav":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	// Create file input
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "a.wav")
+	if err = os.WriteFile(file, []byte("a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set config
+	defer func(ttl time.Duration, private bool, schemes, roots []string) {
+		cacheTTL, inputAllowPrivate, inputAllowedSchemes, inputFileRoots = ttl, private, schemes, roots
+	}(cacheTTL, inputAllowPrivate, inputAllowedSchemes, inputFileRoots)
+	cacheTTL, inputAllowPrivate, inputAllowedSchemes, inputFileRoots = time.Hour, true, []string{"http"}, []string{dir}
+
+	newTask := func() *TranscodeTask {
+		return &TranscodeTask{AudioUrl: s.URL + "/etag.wav", MediaType: "mp3"}
+	}
+	key := cacheKey(newTask())
+	if key == "" {
+		t.Fatal("expected a key")
+	}
+
+	// Tasks which aren't cached
+	for _, c := range []struct {
+		name string
+		fn   func(task *TranscodeTask)
+	}{
+		{"disabled", func(*TranscodeTask) { cacheTTL = 0 }},
+		{"pushed", func(task *TranscodeTask) { task.PushUrl = "icecast://example.com/live" }},
+		{"paced", func(task *TranscodeTask) { realtime := true; task.Realtime = &realtime }},
+		{"piped", func(task *TranscodeTask) { task.AudioUrl = "pipe:0" }},
+		{"no version", func(task *TranscodeTask) { task.AudioUrl = s.URL + "/none.wav" }},
+		{"unreachable", func(task *TranscodeTask) { task.AudioUrl = s.URL + "/missing.wav" }},
+		{"blocked", func(*TranscodeTask) { inputAllowPrivate = false }},
+		{"not allowed", func(task *TranscodeTask) { task.AudioUrl = "file:///etc/passwd" }},
+		{"mix without version", func(task *TranscodeTask) { task.Mix = []string{s.URL + "/none.wav"} }},
+		{"cover art without version", func(task *TranscodeTask) { task.CoverUrl = s.URL + "/none.wav" }},
+	} {
+		task := newTask()
+		c.fn(task)
+		if v := cacheKey(task); v != "" {
+			t.Errorf("%s: expected no key, got %s", c.name, v)
+		}
+		cacheTTL, inputAllowPrivate = time.Hour, true
+	}
+
+	// Tasks compared with the key
+	for _, c := range []struct {
+		name  string
+		fn    func(task *TranscodeTask)
+		equal bool
+	}{
+		{"same task", func(*TranscodeTask) {}, true},
+		{"timeout", func(task *TranscodeTask) { task.Timeout = "30s" }, true},
+		{"destination", func(task *TranscodeTask) { task.OutputDestination = "s3://bucket/key" }, true},
+		{"media type", func(task *TranscodeTask) { task.MediaType = "wav" }, false},
+		{"headers", func(task *TranscodeTask) { task.Headers = []string{"Authorization: Bearer token"} }, false},
+		{"version", func(*TranscodeTask) { etag = `"2"` }, false},
+		{"last modified", func(task *TranscodeTask) { task.AudioUrl = s.URL + "/modified.wav" }, false},
+		{"file", func(task *TranscodeTask) { task.AudioUrl = "file://" + file }, false},
+		{"data cover art", func(task *TranscodeTask) { task.CoverUrl = "data:image/png;base64,iVBORw0KGgo=" }, false},
+		{"mix", func(task *TranscodeTask) { task.Mix = []string{s.URL + "/modified.wav"} }, false},
+	} {
+		task := newTask()
+		c.fn(task)
+		if v := cacheKey(task); v == "" {
+			t.Errorf("%s: expected a key", c.name)
+		} else if (v == key) != c.equal {
+			t.Errorf("%s: expected the key to be equal %v", c.name, c.equal)
+		}
+		etag = `"1"`
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"example.com/m/pipeline"
+	"github.com/asticode/go-astiav"
+)
+
+// Output ranges
+const (
+	defaultChannels   = 2
+	defaultSampleRate = 44100
+	maxChannels       = pipeline.MaxChannels
+	maxSampleRate     = 192000
+	minChannels       = 1
+	minSampleRate     = 8000
+)
+
+// Modes of custom filters
+const (
+	filterModeAppend  = "append"
+	filterModeReplace = "replace"
+)
+
+// Modes of target durations
+const (
+	targetModeLoop = "loop"
+	targetModePad  = "pad"
+)
+
+// Audio processing ranges
+const (
+	defaultSilenceThreshold = -50 // In dBFS
+	maxBitRate              = 320 // In kbps
+	maxConcatInputs         = 32
+	maxCuts                 = 255
+	maxFadeDuration         = time.Minute
+	maxFileNameSize         = 255
+	maxFilterSize           = 1024
+	maxEQBands              = 10
+	maxEQGain               = 30 // In dB, both ways
+	maxEQQ                  = 10
+	maxFilterFrequency      = 20000 // In Hz
+	maxGain                 = 60    // In dB, both ways
+	maxChapters             = 256
+	maxMetadataSize         = 1024 // Of a tag value or chapter title
+	maxMetadataTags         = 32
+	maxMixInputs            = 8
+	maxOutputs              = 8 // Added to the one of the task
+	maxPadDuration          = time.Minute
+	maxSilenceDuration      = 10 * time.Second
+	maxTargetDuration       = 10 * time.Minute // Looped audio is buffered up to it
+	minBitRate              = 8                // In kbps
+	minEQQ                  = 0.1
+	minFilterFrequency      = 20   // In Hz
+	minSilenceThreshold     = -100 // In dBFS
+)
+
+// Analysis ranges
+const (
+	defaultSilenceDuration    = 2 * time.Second // Of the silences detected, as silencedetect
+	defaultWaveformResolution = 100             // Peaks per second
+	maxWaveformPeaks          = 1000000
+)
+
+// Packaging ranges
+const (
+	defaultSegmentDuration = 6 * time.Second
+	maxSegmentDuration     = time.Minute
+	minSegmentDuration     = time.Second
+)
+
+// Segment types of HLS packages
+const (
+	segmentTypeFMP4   = "fmp4"
+	segmentTypeMPEGTS = "mpegts"
+)
+
+// Thumbnail and preview ranges
+const (
+	defaultPreviewDuration  = 3 * time.Second
+	defaultPreviewFrameRate = 10
+	defaultPreviewWidth     = 320 // In pixels
+	maxPreviewDuration      = 10 * time.Second
+	maxPreviewFrameRate     = 30
+	maxPreviewWidth         = 1280 // In pixels
+	maxThumbnailSize        = 4096 // Width and height in pixels
+	minPreviewWidth         = 16   // In pixels
+)
+
+// Checksum algorithms
+const (
+	checksumMD5    = "md5"
+	checksumSHA256 = "sha256"
+)
+
+// Test signal ranges
+const (
+	defaultSignalDuration  = time.Second
+	defaultSignalFrequency = 1000 // In Hz
+	maxDTMFDigits          = 32
+	maxSignalDuration      = 10 * time.Minute
+	maxSignalFrequency     = 20000 // In Hz
+	minSignalFrequency     = 20    // In Hz
+)
+
+// capabilities are the outputs the running binary supports
+type capabilities struct {
+	Channels    capabilityRange       `json:"channels"`
+	MediaTypes  []mediaTypeCapability `json:"mediaTypes"`
+	SampleRates capabilityRange       `json:"sampleRates"`
+}
+
+// capabilityRange is a range of values and the default one
+type capabilityRange struct {
+	Default int `json:"default"`
+	Max     int `json:"max"`
+	Min     int `json:"min"`
+}
+
+// mediaTypeCapability is an output media type with the muxer and encoder
+// writing it
+type mediaTypeCapability struct {
+	ChannelLayouts []string `json:"channelLayouts,omitempty"` // Empty if the encoder accepts any layout
+	Codec          string   `json:"codec"`
+	Container      string   `json:"container"`
+	ContentType    string   `json:"contentType"`
+	MediaType      string   `json:"mediaType"`
+	SampleFormats  []string `json:"sampleFormats,omitempty"` // Empty if the encoder accepts any format
+	SampleRates    []int    `json:"sampleRates,omitempty"`   // Empty if the encoder accepts any rate of the range
+}
+
+// queryCapabilities queries libavcodec and libavformat for the encoders and
+// muxers of the enabled media types. Media types whose encoder or muxer isn't
+// available in the linked FFmpeg are left out.
+func queryCapabilities() (c capabilities) {
+	c = capabilities{
+		Channels:    capabilityRange{Default: defaultChannels, Max: maxChannels, Min: minChannels},
+		MediaTypes:  []mediaTypeCapability{},
+		SampleRates: capabilityRange{Default: defaultSampleRate, Max: maxSampleRate, Min: minSampleRate},
+	}
+	var mediaTypes []string
+	for t := range supportedEncCodecs {
+		mediaTypes = append(mediaTypes, t)
+	}
+	sort.Strings(mediaTypes)
+	for _, t := range mediaTypes {
+		// Find encoder
+		format, codec := outputFormat(t)
+		e := astiav.FindEncoderByName(codec)
+		if e == nil {
+			rootLogger.warn("main: encoder not found", "media_type", t, "codec", codec)
+			continue
+		}
+
+		// Find muxer
+		fc, err := astiav.AllocOutputFormatContext(nil, format, "")
+		if err != nil {
+			rootLogger.warn("main: muxer not found", "media_type", t, "format", format, "error", err)
+			continue
+		}
+		fc.Free()
+
+		// Add media type
+		m := mediaTypeCapability{
+			Codec:       e.Name(),
+			Container:   format,
+			ContentType: outputContentType(t),
+			MediaType:   t,
+		}
+		for _, l := range e.ChannelLayouts() {
+			m.ChannelLayouts = append(m.ChannelLayouts, l.String())
+		}
+		for _, f := range e.SampleFormats() {
+			m.SampleFormats = append(m.SampleFormats, f.Name())
+		}
+		m.SampleRates = pipeline.SampleRates(e)
+		c.MediaTypes = append(c.MediaTypes, m)
+	}
+	return
+}
@@ -0,0 +1,557 @@
+// Package config loads the configuration of transgode from a JSON file and
+// from environment variables, which take precedence over the file
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of transgode. Fields are set in the JSON file
+// with their json name and in the environment with their env name.
+type Config struct {
+	Codecs         []string `json:"codecs" env:"TRANSGODE_CODECS"`                   // Enabled output media types
+	FFmpegLogLevel string   `json:"ffmpegLogLevel" env:"TRANSGODE_FFMPEG_LOG_LEVEL"` // Empty follows LogLevel
+	Filters        []string `json:"filters" env:"TRANSGODE_FILTERS"`                 // Filter names allowed in custom filter chains, empty disables them
+	HWAccel        string   `json:"hwAccel" env:"TRANSGODE_HW_ACCEL"`                // Device type video inputs are decoded on by hwaccels, e.g. vaapi, cuda or videotoolbox
+	HWAccelDevice  string   `json:"hwAccelDevice" env:"TRANSGODE_HW_ACCEL_DEVICE"`   // Device of HWAccel, e.g. /dev/dri/renderD128, empty picks the default one
+	HWDecoders     []string `json:"hwDecoders" env:"TRANSGODE_HW_DECODERS"`          // Suffixes of the hardware decoders tried first for video inputs, e.g. cuvid or qsv
+	ListenAddress  string   `json:"listenAddress" env:"TRANSGODE_LISTEN_ADDRESS"`
+	LogLevel       string   `json:"logLevel" env:"TRANSGODE_LOG_LEVEL"`
+	MaxBodySize    int      `json:"maxBodySize" env:"TRANSGODE_MAX_BODY_SIZE"`
+	MaxMemory      int64    `json:"maxMemory" env:"TRANSGODE_MAX_MEMORY"`          // Estimated bytes of audio a transcode buffers, 0 disables the limit
+	MaxOutputSize  int64    `json:"maxOutputSize" env:"TRANSGODE_MAX_OUTPUT_SIZE"` // 0 disables the limit
+	MemoryBudget   int64    `json:"memoryBudget" env:"TRANSGODE_MEMORY_BUDGET"`    // Estimated bytes of audio all transcodes buffer, 0 disables the budget
+	TempDir        string   `json:"tempDir" env:"TRANSGODE_TEMP_DIR"`
+	Threads        int      `json:"threads" env:"TRANSGODE_THREADS"` // FFmpeg threads of decoders and encoders of tasks which don't set them
+	Timeout        Duration `json:"timeout" env:"TRANSGODE_TIMEOUT"` // Default and maximum timeout of transcodes, 0 disables it
+
+	AMQP        AMQP        `json:"amqp"`
+	Auth        Auth        `json:"auth"`
+	Cache       Cache       `json:"cache"`
+	GRPC        GRPC        `json:"grpc"`
+	Idempotency Idempotency `json:"idempotency"`
+	Input       Input       `json:"input"`
+	Jobs        Jobs        `json:"jobs"`
+	Kafka       Kafka       `json:"kafka"`
+	NATS        NATS        `json:"nats"`
+	Parallel    Parallel    `json:"parallel"`
+	Pool        Pool        `json:"pool"`
+	Presets     []Preset    `json:"presets"` // Replace the built-in ones when set
+	Push        Push        `json:"push"`
+	Results     Results     `json:"results"`
+	SQS         SQS         `json:"sqs"`
+	Storage     Storage     `json:"storage"`
+	TLS         TLS         `json:"tls"`
+	Tracing     Tracing     `json:"tracing"`
+	Watch       Watch       `json:"watch"`
+}
+
+// AMQP configures the consumer of tasks queued in a RabbitMQ queue
+type AMQP struct {
+	DeadLetterExchange string `json:"deadLetterExchange" env:"TRANSGODE_AMQP_DEAD_LETTER_EXCHANGE"` // Empty doesn't set it on the queue
+	Queue              string `json:"queue" env:"TRANSGODE_AMQP_QUEUE"`
+	URL                string `json:"url" env:"TRANSGODE_AMQP_URL"` // Empty disables the consumer
+}
+
+// Auth configures api keys
+type Auth struct {
+	APIKeysFile         string  `json:"apiKeysFile" env:"TRANSGODE_API_KEYS_FILE"` // Empty disables authentication
+	DefaultQuotaMinutes float64 `json:"defaultQuotaMinutes" env:"TRANSGODE_DEFAULT_QUOTA_MINUTES"`
+	DefaultRateLimit    int     `json:"defaultRateLimit" env:"TRANSGODE_DEFAULT_RATE_LIMIT"`
+}
+
+// Cache configures the outputs served again to identical requests
+type Cache struct {
+	TTL Duration `json:"ttl" env:"TRANSGODE_CACHE_TTL"` // 0 disables the cache
+}
+
+// GRPC configures the server of the gRPC service of proto/transgode.proto,
+// which uses the TLS settings of the HTTP server
+type GRPC struct {
+	Address        string `json:"address" env:"TRANSGODE_GRPC_ADDRESS"`                 // Empty disables the gRPC server
+	MaxMessageSize int    `json:"maxMessageSize" env:"TRANSGODE_GRPC_MAX_MESSAGE_SIZE"` // Of requests, and of the responses of Transcode
+}
+
+// Idempotency configures replays of requests sent with an idempotency key
+type Idempotency struct {
+	MaxSize int      `json:"maxSize" env:"TRANSGODE_IDEMPOTENCY_MAX_SIZE"`
+	TTL     Duration `json:"ttl" env:"TRANSGODE_IDEMPOTENCY_TTL"`
+}
+
+// Input configures input restrictions and limits
+type Input struct {
+	AllowPrivate bool     `json:"allowPrivate" env:"TRANSGODE_INPUT_ALLOW_PRIVATE"`
+	CacheDir     string   `json:"cacheDir" env:"TRANSGODE_INPUT_CACHE_DIR"`   // Empty defaults to transgode-inputs in TempDir
+	CacheSize    int64    `json:"cacheSize" env:"TRANSGODE_INPUT_CACHE_SIZE"` // 0 disables the cache
+	FileRoots    []string `json:"fileRoots" env:"TRANSGODE_INPUT_FILE_ROOTS"`
+	MaxDuration  Duration `json:"maxDuration" env:"TRANSGODE_MAX_INPUT_DURATION"`
+	MaxSize      int64    `json:"maxSize" env:"TRANSGODE_MAX_INPUT_SIZE"`
+	MaxStreams   int      `json:"maxStreams" env:"TRANSGODE_MAX_INPUT_STREAMS"`
+	Retries      int      `json:"retries" env:"TRANSGODE_INPUT_RETRIES"`
+	RetryBackoff Duration `json:"retryBackoff" env:"TRANSGODE_INPUT_RETRY_BACKOFF"`
+	Schemes      []string `json:"schemes" env:"TRANSGODE_INPUT_SCHEMES"`
+}
+
+// Jobs configures background jobs
+type Jobs struct {
+	InstanceID string   `json:"instanceId" env:"TRANSGODE_INSTANCE_ID"`
+	QueueSize  int      `json:"queueSize" env:"TRANSGODE_JOB_QUEUE_SIZE"`
+	RedisURL   string   `json:"redisUrl" env:"TRANSGODE_REDIS_URL"`
+	Retention  Duration `json:"retention" env:"TRANSGODE_JOB_RETENTION"`
+	Workers    int      `json:"workers" env:"TRANSGODE_JOB_WORKERS"` // 0 defaults to MaxConcurrency
+}
+
+// Kafka configures the consumer of tasks produced on a Kafka topic
+type Kafka struct {
+	Brokers     []string `json:"brokers" env:"TRANSGODE_KAFKA_BROKERS"` // Empty disables the consumer
+	Group       string   `json:"group" env:"TRANSGODE_KAFKA_GROUP"`
+	ResultTopic string   `json:"resultTopic" env:"TRANSGODE_KAFKA_RESULT_TOPIC"` // Empty doesn't produce results
+	Topic       string   `json:"topic" env:"TRANSGODE_KAFKA_TOPIC"`
+}
+
+// NATS configures the consumer of tasks published on a NATS subject
+type NATS struct {
+	Queue         string `json:"queue" env:"TRANSGODE_NATS_QUEUE"`
+	ResultSubject string `json:"resultSubject" env:"TRANSGODE_NATS_RESULT_SUBJECT"` // Empty doesn't publish completion events
+	Subject       string `json:"subject" env:"TRANSGODE_NATS_SUBJECT"`
+	URL           string `json:"url" env:"TRANSGODE_NATS_URL"` // Empty disables the consumer
+}
+
+// Parallel configures the decoding of long inputs in segments decoded in
+// parallel
+type Parallel struct {
+	MinDuration Duration `json:"minDuration" env:"TRANSGODE_PARALLEL_MIN_DURATION"` // Inputs shorter than this are decoded in one piece
+	Segments    int      `json:"segments" env:"TRANSGODE_PARALLEL_SEGMENTS"`        // Up to 1 disables parallel decoding
+}
+
+// Pool configures the number of simultaneous transcodes
+type Pool struct {
+	MaxConcurrency int `json:"maxConcurrency" env:"TRANSGODE_MAX_CONCURRENCY"`
+	MaxQueueDepth  int `json:"maxQueueDepth" env:"TRANSGODE_MAX_QUEUE_DEPTH"`
+	OverflowStatus int `json:"overflowStatus" env:"TRANSGODE_OVERFLOW_STATUS"`
+}
+
+// Preset is a named set of task defaults, selected with the preset field of
+// tasks, whose own fields take precedence
+type Preset struct {
+	BitRate     int      `json:"bitRate"` // Of mp3, hls and dash outputs in kbps, 0 is the encoder default
+	Channels    int      `json:"channels"`
+	Description string   `json:"description"`
+	Encoding    string   `json:"encoding"`    // Of wav outputs, s16le or mulaw, empty is s16le
+	HighPass    float64  `json:"highPass"`    // Cutoff frequency in Hz, 0 doesn't filter
+	MaxDuration Duration `json:"maxDuration"` // Of outputs, longer ones fail, 0 is unlimited
+	MediaType   string   `json:"mediaType"`
+	Name        string   `json:"name"`
+	Normalize   string   `json:"normalize"` // ebu, peak or rms, empty doesn't normalize
+	SampleRate  int      `json:"sampleRate"`
+	Strict      bool     `json:"strict"` // Tasks can't override its options
+}
+
+// Push configures live outputs pushed to streaming servers
+type Push struct {
+	Hosts []string `json:"hosts" env:"TRANSGODE_PUSH_HOSTS"` // Allowed destinations as host or host:port, empty disables pushes
+}
+
+// Results configures stored outputs
+type Results struct {
+	Dir         string   `json:"dir" env:"TRANSGODE_RESULT_DIR"`            // Empty defaults to transgode in TempDir
+	MinFreeDisk int64    `json:"minFreeDisk" env:"TRANSGODE_MIN_FREE_DISK"` // Outputs aren't written below this free space, 0 disables the check
+	Retention   Duration `json:"retention" env:"TRANSGODE_RESULT_RETENTION"`
+}
+
+// SQS configures the worker polling tasks from an SQS queue, with the AWS
+// credentials of Storage
+type SQS struct {
+	QueueURL string `json:"queueUrl" env:"TRANSGODE_SQS_QUEUE_URL"` // Empty disables the worker
+}
+
+// Storage configures uploads to object storage
+type Storage struct {
+	GCSAccessKeyID     string   `json:"gcsAccessKeyId" env:"TRANSGODE_GCS_ACCESS_KEY_ID"`
+	GCSSecretAccessKey string   `json:"gcsSecretAccessKey" env:"TRANSGODE_GCS_SECRET_ACCESS_KEY"`
+	OutputBuckets      []string `json:"outputBuckets" env:"TRANSGODE_OUTPUT_BUCKETS"`
+	S3AccessKeyID      string   `json:"s3AccessKeyId" env:"AWS_ACCESS_KEY_ID"`
+	S3Endpoint         string   `json:"s3Endpoint" env:"TRANSGODE_S3_ENDPOINT"`
+	S3Region           string   `json:"s3Region" env:"AWS_REGION"`
+	S3SecretAccessKey  string   `json:"s3SecretAccessKey" env:"AWS_SECRET_ACCESS_KEY"`
+	S3SessionToken     string   `json:"s3SessionToken" env:"AWS_SESSION_TOKEN"`
+}
+
+// TLS configures the listener certificates, the server listens without TLS
+// when CertFile is empty
+type TLS struct {
+	CertFile     string `json:"certFile" env:"TRANSGODE_TLS_CERT_FILE"`
+	ClientAuth   string `json:"clientAuth" env:"TRANSGODE_TLS_CLIENT_AUTH"`      // require or verify-if-given, used with ClientCAFile
+	ClientCAFile string `json:"clientCaFile" env:"TRANSGODE_TLS_CLIENT_CA_FILE"` // Empty doesn't ask for client certificates
+	KeyFile      string `json:"keyFile" env:"TRANSGODE_TLS_KEY_FILE"`
+	MinVersion   string `json:"minVersion" env:"TRANSGODE_TLS_MIN_VERSION"` // 1.2 or 1.3
+}
+
+// Tracing configures the OTLP/HTTP exporter
+type Tracing struct {
+	Endpoint    string `json:"endpoint" env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"` // Empty disables tracing
+	ServiceName string `json:"serviceName" env:"OTEL_SERVICE_NAME"`
+}
+
+// Watch configures the folders whose new files are transcoded, it's only set
+// in the JSON file
+type Watch struct {
+	Folders []WatchFolder `json:"folders"`
+}
+
+// WatchFolder is a folder whose new files are transcoded with its profile into
+// OutputDir
+type WatchFolder struct {
+	Channels   int    `json:"channels"`
+	Dir        string `json:"dir"`
+	MediaType  string `json:"mediaType"`
+	OutputDir  string `json:"outputDir"`
+	SampleRate int    `json:"sampleRate"`
+}
+
+// Duration is a time.Duration written as a string such as "10m" in the JSON
+// file
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err != nil {
+		return
+	}
+	var v time.Duration
+	if v, err = time.ParseDuration(s); err != nil {
+		return
+	}
+	*d = Duration(v)
+	return
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	return &Config{
+		Codecs: []string{"wav", "raw", "mulaw", "mp3", "hls", "dash"},
+		Filters: []string{
+			"acompressor", "adeclick", "adeclip", "adelay", "aecho", "afade", "afftdn", "agate", "alimiter",
+			"allpass", "aphaser", "asetpts", "atempo", "atrim", "bandpass", "bandreject", "bass", "biquad",
+			"chorus", "compand", "dynaudnorm", "equalizer", "extrastereo", "flanger", "highpass", "highshelf",
+			"loudnorm", "lowpass", "lowshelf", "pan", "silenceremove", "stereotools", "treble", "tremolo",
+			"vibrato", "volume",
+		},
+		ListenAddress: ":8080",
+		LogLevel:      "debug",
+		MaxBodySize:   4 << 20,
+		MaxMemory:     1 << 30,
+		MaxOutputSize: 2 << 30,
+		TempDir:       os.TempDir(),
+		Threads:       1,
+		Timeout:       Duration(time.Hour),
+		AMQP: AMQP{
+			Queue: "transgode.tasks",
+		},
+		GRPC: GRPC{
+			MaxMessageSize: 16 << 20,
+		},
+		Idempotency: Idempotency{
+			MaxSize: 32 << 20,
+			TTL:     Duration(10 * time.Minute),
+		},
+		Input: Input{
+			MaxDuration:  Duration(3 * time.Hour),
+			MaxSize:      1 << 30,
+			MaxStreams:   16,
+			Retries:      3,
+			RetryBackoff: Duration(500 * time.Millisecond),
+			Schemes:      []string{"http", "https"},
+		},
+		Jobs: Jobs{
+			InstanceID: defaultInstanceID(),
+			QueueSize:  100,
+			Retention:  Duration(time.Hour),
+		},
+		Kafka: Kafka{
+			Group:       "transgode",
+			ResultTopic: "transgode.results",
+			Topic:       "transgode.tasks",
+		},
+		NATS: NATS{
+			Queue:         "transgode",
+			ResultSubject: "transgode.results",
+			Subject:       "transgode.tasks",
+		},
+		Parallel: Parallel{
+			MinDuration: Duration(10 * time.Minute),
+			Segments:    4,
+		},
+		Pool: Pool{
+			MaxConcurrency: runtime.NumCPU(),
+			MaxQueueDepth:  64,
+			OverflowStatus: http.StatusServiceUnavailable,
+		},
+		Presets: []Preset{
+			{Name: "asr", Description: "16 kHz mono 16-bit PCM for speech recognition", MediaType: "raw", Channels: 1, SampleRate: 16000},
+			{Name: "asr-clean", Description: "16 kHz mono 16-bit PCM high-passed and normalized for speech recognition of noisy or quiet recordings", MediaType: "raw", Channels: 1, SampleRate: 16000, HighPass: 80, Normalize: "rms"},
+			{Name: "alexa", Description: "24 kHz mono 48 kbps MP3 of at most 240 seconds for Alexa SSML <audio>", MediaType: "mp3", Channels: 1, SampleRate: 24000, BitRate: 48, MaxDuration: Duration(240 * time.Second), Strict: true},
+			{Name: "google-assistant", Description: "24 kHz mono 64 kbps MP3 of at most 240 seconds for Google Assistant SSML <audio>", MediaType: "mp3", Channels: 1, SampleRate: 24000, BitRate: 64, MaxDuration: Duration(240 * time.Second), Strict: true},
+			{Name: "podcast", Description: "44.1 kHz stereo 128 kbps MP3 normalized to -16 LUFS with EBU R128 loudnorm", MediaType: "mp3", Channels: 2, SampleRate: 44100, BitRate: 128, Normalize: "ebu"},
+			{Name: "telephony", Description: "8 kHz mono G.711 mu-law", MediaType: "mulaw", Channels: 1, SampleRate: 8000},
+			{Name: "twilio", Description: "8 kHz mono mu-law WAV as played by Twilio <Play>", MediaType: "wav", Encoding: "mulaw", Channels: 1, SampleRate: 8000, Strict: true},
+		},
+		Results: Results{
+			MinFreeDisk: 512 << 20,
+			Retention:   Duration(time.Hour),
+		},
+		Storage: Storage{
+			S3Region: "us-east-1",
+		},
+		TLS: TLS{
+			ClientAuth: "require",
+			MinVersion: "1.2",
+		},
+		Tracing: Tracing{
+			ServiceName: "transgode",
+		},
+	}
+}
+
+// defaultInstanceID identifies this instance by its host name, which must be
+// stable across restarts for interrupted jobs to be resumed
+func defaultInstanceID() string {
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return h
+	}
+	return "transgode"
+}
+
+// Load loads the default configuration, overridden by the JSON file if path
+// isn't empty, then by environment variables
+func Load(path string) (c *Config, err error) {
+	c = Default()
+
+	// Read file
+	if path != "" {
+		var b []byte
+		if b, err = ioutil.ReadFile(path); err != nil {
+			err = fmt.Errorf("config: reading %s failed: %w", path, err)
+			return
+		}
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		if err = d.Decode(c); err != nil {
+			err = fmt.Errorf("config: unmarshaling %s failed: %w", path, err)
+			return
+		}
+	}
+
+	// Read environment
+	if err = loadEnv(reflect.ValueOf(c).Elem()); err != nil {
+		return
+	}
+	if c.Tracing.Endpoint == "" {
+		if v := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); v != "" {
+			c.Tracing.Endpoint = strings.TrimSuffix(v, "/") + "/v1/traces"
+		}
+	}
+
+	// Validate
+	err = c.validate()
+	return
+}
+
+// loadEnv sets the fields of the struct whose environment variable is set
+func loadEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		// Nested struct
+		f := v.Field(i)
+		if f.Kind() == reflect.Struct {
+			if err := loadEnv(f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Get value
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+
+		// Set value
+		if err := setValue(f, s); err != nil {
+			return fmt.Errorf("config: parsing %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setValue(f reflect.Value, s string) error {
+	if _, ok := f.Interface().(Duration); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.Bool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(v)
+	case reflect.Float64:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(v)
+	case reflect.Int, reflect.Int64:
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(v)
+	case reflect.Slice:
+		f.Set(reflect.ValueOf(splitList(s)))
+	case reflect.String:
+		f.SetString(s)
+	default:
+		return fmt.Errorf("config: type %s not supported", f.Type())
+	}
+	return nil
+}
+
+// validate checks values are in range
+func (c *Config) validate() error {
+	for _, v := range []struct {
+		name string
+		ok   bool
+	}{
+		{"amqp.queue", c.AMQP.URL == "" || c.AMQP.Queue != ""},
+		{"auth.defaultQuotaMinutes", c.Auth.DefaultQuotaMinutes >= 0},
+		{"auth.defaultRateLimit", c.Auth.DefaultRateLimit >= 0},
+		{"cache.ttl", c.Cache.TTL >= 0},
+		{"grpc.maxMessageSize", c.GRPC.MaxMessageSize > 0},
+		{"hwAccel", c.HWAccel == "" || validHWDecoders([]string{c.HWAccel})},
+		{"hwAccelDevice", c.HWAccelDevice == "" || c.HWAccel != ""},
+		{"hwDecoders", validHWDecoders(c.HWDecoders)},
+		{"idempotency.maxSize", c.Idempotency.MaxSize >= 0},
+		{"idempotency.ttl", c.Idempotency.TTL > 0},
+		{"input.cacheSize", c.Input.CacheSize >= 0},
+		{"input.maxDuration", c.Input.MaxDuration >= 0},
+		{"input.maxSize", c.Input.MaxSize >= 0},
+		{"input.maxStreams", c.Input.MaxStreams >= 0},
+		{"input.retries", c.Input.Retries >= 0},
+		{"input.retryBackoff", c.Input.RetryBackoff > 0},
+		{"jobs.instanceId", c.Jobs.InstanceID != ""},
+		{"jobs.queueSize", c.Jobs.QueueSize >= 0},
+		{"jobs.retention", c.Jobs.Retention > 0},
+		{"jobs.workers", c.Jobs.Workers >= 0},
+		{"kafka.group", len(c.Kafka.Brokers) == 0 || c.Kafka.Group != ""},
+		{"kafka.topic", len(c.Kafka.Brokers) == 0 || c.Kafka.Topic != ""},
+		{"listenAddress", c.ListenAddress != ""},
+		{"maxBodySize", c.MaxBodySize > 0},
+		{"maxMemory", c.MaxMemory >= 0},
+		{"maxOutputSize", c.MaxOutputSize >= 0},
+		{"memoryBudget", c.MemoryBudget >= 0},
+		{"nats.queue", c.NATS.URL == "" || c.NATS.Queue != ""},
+		{"nats.subject", c.NATS.URL == "" || c.NATS.Subject != ""},
+		{"parallel.minDuration", c.Parallel.MinDuration >= 0},
+		{"parallel.segments", c.Parallel.Segments >= 0},
+		{"pool.maxConcurrency", c.Pool.MaxConcurrency > 0},
+		{"pool.maxQueueDepth", c.Pool.MaxQueueDepth >= 0},
+		{"pool.overflowStatus", c.Pool.OverflowStatus == http.StatusTooManyRequests || c.Pool.OverflowStatus == http.StatusServiceUnavailable},
+		{"presets", validPresets(c.Presets)},
+		{"results.minFreeDisk", c.Results.MinFreeDisk >= 0},
+		{"results.retention", c.Results.Retention >= 0},
+		{"tempDir", c.TempDir != ""},
+		{"threads", c.Threads > 0 && c.Threads <= runtime.NumCPU()},
+		{"timeout", c.Timeout >= 0},
+		{"tls.certFile", (c.TLS.CertFile == "") == (c.TLS.KeyFile == "")},
+		{"tls.clientAuth", c.TLS.ClientAuth == "require" || c.TLS.ClientAuth == "verify-if-given"},
+		{"tls.clientCaFile", c.TLS.ClientCAFile == "" || c.TLS.CertFile != ""},
+		{"tls.minVersion", c.TLS.MinVersion == "1.2" || c.TLS.MinVersion == "1.3"},
+		{"watch.folders", validWatchFolders(c.Watch.Folders)},
+	} {
+		if !v.ok {
+			return fmt.Errorf("config: invalid %s", v.name)
+		}
+	}
+	return nil
+}
+
+// validHWDecoders checks that hardware decoder suffixes, or device types, are
+// names such as cuvid, qsv or v4l2m2m
+func validHWDecoders(s []string) bool {
+	for _, v := range s {
+		if v == "" || strings.Trim(v, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// validPresets checks presets have distinct names and values in range
+func validPresets(ps []Preset) bool {
+	names := make(map[string]bool)
+	for _, p := range ps {
+		if p.Name == "" || names[p.Name] || p.MediaType == "" || p.Channels < 0 || p.SampleRate < 0 {
+			return false
+		}
+		if p.Normalize != "" && p.Normalize != "ebu" && p.Normalize != "peak" && p.Normalize != "rms" {
+			return false
+		}
+		names[p.Name] = true
+	}
+	return true
+}
+
+// validWatchFolders checks folders have a distinct output directory, outputs
+// would be transcoded again otherwise
+func validWatchFolders(fs []WatchFolder) bool {
+	dirs := make(map[string]bool)
+	for _, f := range fs {
+		if f.Dir == "" || f.OutputDir == "" {
+			return false
+		}
+		dirs[filepath.Clean(f.Dir)] = true
+	}
+	for _, f := range fs {
+		if dirs[filepath.Clean(f.OutputDir)] {
+			return false
+		}
+	}
+	return true
+}
+
+// splitList splits a comma separated list and drops empty items
+func splitList(v string) (o []string) {
+	for _, i := range strings.Split(v, ",") {
+		if i = strings.TrimSpace(i); i != "" {
+			o = append(o, i)
+		}
+	}
+	return
+}
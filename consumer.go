@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var errOutputDestinationRequired = errors.New("main: output destination is required")
+
+// taskConsumer runs the tasks received from message brokers. Tasks are
+// messages with the json schema of TranscodeTask, their output is uploaded to
+// their OutputDestination and the task with its result is the completion
+// event.
+type taskConsumer struct {
+	pool    *workerPool
+	results *resultStore
+	tracer  *tracer
+}
+
+func newTaskConsumer(pool *workerPool, results *resultStore, tracer *tracer) *taskConsumer {
+	return &taskConsumer{
+		pool:    pool,
+		results: results,
+		tracer:  tracer,
+	}
+}
+
+// handle decodes a task message, transcodes it once a slot is free in the
+// worker pool and uploads its output. It returns the task holding the result,
+// and whether the failure is transient so that the message is worth being
+// delivered again. name is the name of the span tracing the message.
+func (c *taskConsumer) handle(body []byte, l *logger, name string) (task *TranscodeTask, retry bool) {
+	// Trace
+	sp := c.tracer.startSpan("", name)
+	var err error
+	defer func() {
+		sp.finish(err)
+		if err != nil {
+			setTaskError(task, err)
+			retry = isTransientStatus(task.Status)
+			l.error("main: transcoding failed", "url", task.AudioUrl, "status", task.Status, "error", err)
+		}
+	}()
+
+	// Decode task
+	task = &TranscodeTask{}
+	if err = json.Unmarshal(body, task); err != nil {
+		err = fmt.Errorf("main: unmarshaling task failed: %w", err)
+		task.Status = http.StatusBadRequest
+		return
+	}
+	task.log = l
+	sp.setAttribute("transgode.mediatype", task.MediaType)
+
+	// Prepare task
+	if err = prepareTask(task); err != nil {
+		return
+	}
+	if task.OutputDestination == "" {
+		err = errOutputDestinationRequired
+		task.Status = http.StatusBadRequest
+		return
+	}
+
+	// Transcode and upload
+	c.pool.acquire(false)
+	err = transcodeAndUpload(task, c.results, sp)
+	c.pool.release()
+	if err != nil {
+		return
+	}
+	task.Success = true
+	return
+}
+
+// isTransientStatus reports whether a task which failed with this status may
+// succeed if tried again later
+func isTransientStatus(status int) bool {
+	return status >= http.StatusInternalServerError
+}
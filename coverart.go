@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"example.com/m/pipeline"
+)
+
+// maxCoverArtSize is the largest cover art of outputs, in bytes
+const maxCoverArtSize = 5 << 20
+
+var (
+	errCoverArtInvalid     = errors.New("main: cover art must be a JPEG or PNG image")
+	errCoverArtTooLarge    = fmt.Errorf("main: cover art exceeds %d bytes", maxCoverArtSize)
+	errCoverArtUnavailable = errors.New("main: cover art unavailable")
+)
+
+// coverArt returns the cover art of the outputs of a task, nil without
+// coverurl. Data urls are decoded and HTTP ones fetched, the url and those of
+// redirects being checked against the input policy.
+func coverArt(ctx context.Context, task *TranscodeTask) (p *pipeline.Picture, err error) {
+	if task.CoverUrl == "" {
+		return
+	}
+
+	// Read picture
+	var b []byte
+	if isDataURL(task.CoverUrl) {
+		b, err = parseDataURL(task.CoverUrl)
+	} else {
+		b, err = fetchCoverArt(ctx, task)
+	}
+	if err != nil {
+		return
+	} else if len(b) > maxCoverArtSize {
+		return nil, errCoverArtTooLarge
+	}
+
+	// Check format
+	p = &pipeline.Picture{Data: b}
+	if p.Codec = pictureCodec(b); p.Codec == "" {
+		return nil, errCoverArtInvalid
+	}
+	return
+}
+
+// fetchCoverArt downloads the cover art of a task, up to one byte more than
+// the limit. The headers of the task are only sent to its input.
+func fetchCoverArt(ctx context.Context, task *TranscodeTask) (b []byte, err error) {
+	// Check url, and the ones of redirects
+	policy := taskOptions(task, nil).InputPolicy
+	if err = policy.Check(task.CoverUrl); err != nil {
+		return nil, fmt.Errorf("main: invalid cover url: %w", err)
+	}
+	client := policy.HTTPClient()
+
+	// Send request
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, task.CoverUrl, nil); err != nil {
+		return nil, fmt.Errorf("main: invalid cover url: %w", err)
+	}
+	var resp *http.Response
+	if resp, err = client.Do(req); err != nil {
+		return nil, fmt.Errorf("%w: %v", errCoverArtUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status %d", errCoverArtUnavailable, resp.StatusCode)
+	}
+
+	// Read body
+	if b, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxCoverArtSize+1)); err != nil {
+		return nil, fmt.Errorf("%w: %v", errCoverArtUnavailable, err)
+	}
+	return
+}
+
+// isDataURL reports whether a url holds its data, e.g. an uploaded picture
+func isDataURL(rawurl string) bool {
+	return len(rawurl) >= 5 && strings.EqualFold(rawurl[:5], "data:")
+}
+
+// parseDataURL returns the data of a base64 data url, such as
+// data:image/png;base64,iVBORw0KGgo...
+func parseDataURL(rawurl string) ([]byte, error) {
+	i := strings.Index(rawurl, ",")
+	if !isDataURL(rawurl) || i < 0 || !strings.HasSuffix(strings.ToLower(rawurl[:i]), ";base64") {
+		return nil, errors.New("main: data url must be base64 encoded")
+	}
+	b, err := base64.StdEncoding.DecodeString(rawurl[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("main: decoding data url failed: %w", err)
+	}
+	return b, nil
+}
+
+// dataURL returns the base64 data url of a picture, e.g. an uploaded one
+func dataURL(b []byte) string {
+	t := "image/jpeg"
+	if pictureCodec(b) == "png" {
+		t = "image/png"
+	}
+	return "data:" + t + ";base64," + base64.StdEncoding.EncodeToString(b)
+}
+
+// pictureCodec returns the codec of a JPEG or PNG picture, as the decoders of
+// attached pictures are named, empty for other formats
+func pictureCodec(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")):
+		return "mjpeg"
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	}
+	return ""
+}
+
+// checkCoverArt checks the cover art of a task, which only mp3 outputs have.
+// It's written in the single output of the task and in its other mp3 outputs.
+func checkCoverArt(v *validator, task *TranscodeTask) {
+	switch {
+	case task.MediaType != "mp3":
+		v.addf("coverurl", "cover art is only supported by mp3 outputs")
+	case isCut(task):
+		v.addf("coverurl", "cover art isn't supported with cuts")
+	case task.VideoUrl != "":
+		v.addf("coverurl", "cover art isn't supported with videourl")
+	case isDataURL(task.CoverUrl):
+		if b, err := parseDataURL(task.CoverUrl); err != nil {
+			v.add("coverurl", err)
+		} else if len(b) > maxCoverArtSize {
+			v.add("coverurl", errCoverArtTooLarge)
+		} else if pictureCodec(b) == "" {
+			v.add("coverurl", errCoverArtInvalid)
+		}
+	default:
+		if u := strings.ToLower(task.CoverUrl); !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			v.addf("coverurl", "cover url must be an http, https or data url")
+		} else if err := checkInputURL(task, task.CoverUrl); err != nil {
+			v.add("coverurl", err)
+		}
+	}
+}
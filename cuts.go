@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cueFramesPerSecond is the frame rate of CUE sheet positions
+const cueFramesPerSecond = 75
+
+// isCut reports whether the output of a task is split into parts, written in
+// a temporary directory and zipped as several outputs are
+func isCut(task *TranscodeTask) bool {
+	return len(task.Cuts) > 0 || task.CueSheet != ""
+}
+
+// parseCuts returns the positions the output of a task is split at, from its
+// cuts or from the tracks of its CUE sheet, in order
+func parseCuts(task *TranscodeTask) (cs []time.Duration, err error) {
+	if task.CueSheet != "" {
+		if cs, err = parseCueSheet(task.CueSheet); err != nil {
+			return
+		}
+	} else {
+		for _, v := range task.Cuts {
+			var d time.Duration
+			if d, err = parseTimeout(v); err != nil || v == "" {
+				err = fmt.Errorf("main: invalid cut: %s", v)
+				return
+			}
+			cs = append(cs, d)
+		}
+	}
+
+	// Check cuts
+	if len(cs) > maxCuts {
+		err = fmt.Errorf("main: more than %d cuts", maxCuts)
+		return
+	}
+	var last time.Duration
+	for _, d := range cs {
+		if d <= last {
+			err = fmt.Errorf("main: cuts must be positive and in order: %s", d)
+			return
+		}
+		last = d
+	}
+	return
+}
+
+// parseCueSheet returns the positions of the tracks of a CUE sheet of a
+// single file, their INDEX 01, but the first one when it starts the file
+func parseCueSheet(s string) (cs []time.Duration, err error) {
+	files, tracks := 0, 0
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		fs := strings.Fields(sc.Text())
+		if len(fs) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fs[0]) {
+		case "FILE":
+			if files++; files > 1 {
+				err = fmt.Errorf("main: cue sheets of several files aren't supported")
+				return
+			}
+		case "TRACK":
+			tracks++
+		case "INDEX":
+			if len(fs) != 3 || fs[1] != "01" {
+				continue
+			}
+			var d time.Duration
+			if d, err = parseCueTime(fs[2]); err != nil {
+				return
+			}
+			if len(cs) > 0 || d > 0 {
+				cs = append(cs, d)
+			}
+		}
+	}
+	if err = sc.Err(); err != nil {
+		err = fmt.Errorf("main: reading cue sheet failed: %w", err)
+		return
+	}
+	if tracks == 0 {
+		err = fmt.Errorf("main: cue sheet has no track")
+	}
+	return
+}
+
+// parseCueTime parses a CUE sheet position written as mm:ss:ff, ff being
+// frames of 1/75 second
+func parseCueTime(v string) (d time.Duration, err error) {
+	ps := strings.Split(v, ":")
+	if len(ps) != 3 {
+		err = fmt.Errorf("main: invalid cue sheet index: %s", v)
+		return
+	}
+	var n [3]int
+	for i, p := range ps {
+		if n[i], err = strconv.Atoi(p); err != nil || n[i] < 0 {
+			err = fmt.Errorf("main: invalid cue sheet index: %s", v)
+			return
+		}
+	}
+	if n[1] >= 60 || n[2] >= cueFramesPerSecond {
+		err = fmt.Errorf("main: invalid cue sheet index: %s", v)
+		return
+	}
+	d = time.Duration(n[0])*time.Minute + time.Duration(n[1])*time.Second + time.Duration(n[2])*time.Second/cueFramesPerSecond
+	return
+}
+
+// checkCuts checks the output of a task can be split. Parts are written next
+// to each other in a zip, so neither packaged media types nor other outputs,
+// videos and pushes are supported.
+func checkCuts(v *validator, task *TranscodeTask) {
+	field := "cuts"
+	if task.CueSheet != "" {
+		field = "cuesheet"
+		if len(task.Cuts) > 0 {
+			v.addf("cuts", "cuts and cuesheet are exclusive")
+			return
+		}
+	}
+	if isPackaged(task.MediaType) {
+		v.addf(field, "cuts aren't supported by %s", task.MediaType)
+	} else if len(task.Outputs) > 0 || task.VideoUrl != "" || task.PushUrl != "" {
+		v.addf(field, "cuts aren't supported with outputs, videourl and pushurl")
+	} else if _, err := parseCuts(task); err != nil {
+		v.add(field, err)
+	}
+}
+
+// cutMuxerOptions returns the options of the segment muxer splitting the
+// output of a task written by the muxer format into parts
+func cutMuxerOptions(task *TranscodeTask, format string) map[string]string {
+	cs, _ := parseCuts(task)
+	ts := make([]string, 0, len(cs))
+	for _, d := range cs {
+		ts = append(ts, strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+	}
+	o := map[string]string{
+		"reset_timestamps":     "1",
+		"segment_format":       format,
+		"segment_start_number": "1",
+		"segment_times":        strings.Join(ts, ","),
+	}
+	if task.Bext {
+		o["segment_format_options"] = "write_bext=1"
+	}
+	return o
+}
+
+// cutFileName returns the pattern of the names of the parts of a split
+// output in its zip, e.g. part001.wav
+func cutFileName(mediaType string) string {
+	return "part%03d." + mediaType
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// headerDebug is the trailer describing the pipeline of debugged tasks
+const headerDebug = "X-Debug"
+
+// debugReport describes how a task was transcoded, for support tickets
+type debugReport struct {
+	Input   *probeResult    `json:"input,omitempty"` // Nil when a test signal is generated
+	Outputs []transcodeStep `json:"outputs"`
+	Steps   []stepTiming    `json:"steps"`
+}
+
+// stepTiming is a pipeline step of a debugged task
+type stepTiming struct {
+	Duration float64 `json:"duration"` // Seconds
+	Error    string  `json:"error,omitempty"`
+	Name     string  `json:"name"`
+	Start    float64 `json:"start"` // Seconds since the transcoder was created
+}
+
+// stepTimings records the pipeline steps of a transcoder
+type stepTimings struct {
+	created time.Time
+	m       *sync.Mutex
+	steps   []stepTiming
+}
+
+func newStepTimings() *stepTimings {
+	return &stepTimings{
+		created: time.Now(),
+		m:       &sync.Mutex{},
+		steps:   []stepTiming{},
+	}
+}
+
+// wrap returns a step hook recording the steps before passing them to next,
+// which can be nil
+func (st *stepTimings) wrap(next func(name string) func(err error)) func(name string) func(err error) {
+	return func(name string) func(err error) {
+		start := time.Now()
+		var end func(err error)
+		if next != nil {
+			end = next(name)
+		}
+		return func(err error) {
+			s := stepTiming{
+				Duration: time.Since(start).Seconds(),
+				Name:     name,
+				Start:    start.Sub(st.created).Seconds(),
+			}
+			if err != nil {
+				s.Error = err.Error()
+			}
+			st.m.Lock()
+			st.steps = append(st.steps, s)
+			st.m.Unlock()
+			if end != nil {
+				end(err)
+			}
+		}
+	}
+}
+
+// newDebugReport describes the pipeline of a task once t is set up, its steps
+// being added once the output is read
+func newDebugReport(task *TranscodeTask, t *transcoder) *debugReport {
+	r := &debugReport{Outputs: transcodeSteps(task, t)}
+	if info := t.Input(); info != nil {
+		// Cover art would bloat the trailer
+		r.Input = newProbeResult(info)
+		r.Input.CoverArt = nil
+	}
+	return r
+}
+
+// debugReader sets the debug trailer of a response once its body, the
+// output, is read until its end, by which time the steps have ended
+type debugReader struct {
+	io.ReadCloser
+	r     *debugReport
+	set   func(v []byte)
+	steps *stepTimings
+}
+
+func (dr *debugReader) Read(p []byte) (n int, err error) {
+	n, err = dr.ReadCloser.Read(p)
+	if err == io.EOF && dr.set != nil {
+		dr.steps.m.Lock()
+		dr.r.Steps = dr.steps.steps
+		b, _ := json.Marshal(dr.r)
+		dr.steps.m.Unlock()
+		dr.set(b)
+		dr.set = nil
+	}
+	return
+}
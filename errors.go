@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"example.com/m/pipeline"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error codes of the failures detected before the pipeline, the ones of the
+// pipeline being defined in its package
+const (
+	codeIdempotencyKeyReused  = "IDEMPOTENCY_KEY_REUSED"
+	codeInputUnavailable      = "INPUT_UNAVAILABLE"
+	codeInsufficientStorage   = "INSUFFICIENT_STORAGE"
+	codeInternalError         = "INTERNAL_ERROR"
+	codeInvalidAPIKey         = "INVALID_API_KEY"
+	codeInvalidRequest        = "INVALID_REQUEST"
+	codeJobCanceled           = "JOB_CANCELED"
+	codeMemoryBudgetExhausted = "MEMORY_BUDGET_EXHAUSTED"
+	codeNotAllowed            = "NOT_ALLOWED"
+	codeNotFound              = "NOT_FOUND"
+	codeOverloaded            = "OVERLOADED"
+	codePushFailed            = "PUSH_FAILED"
+	codeQuotaExceeded         = "QUOTA_EXCEEDED"
+	codeRateLimited           = "RATE_LIMITED"
+	codeUpgradeRequired       = "UPGRADE_REQUIRED"
+)
+
+// errorCode returns the machine-readable code of an error responded with
+// status, the status classifying the errors without a more specific code
+func errorCode(err error, status int) string {
+	// Known errors
+	switch {
+	case errors.Is(err, errCoverArtUnavailable):
+		return codeInputUnavailable
+	case errors.Is(err, errIdempotencyKeyReused):
+		return codeIdempotencyKeyReused
+	case errors.Is(err, errInsufficientStorage):
+		return codeInsufficientStorage
+	case errors.Is(err, errInvalidAPIKey):
+		return codeInvalidAPIKey
+	case errors.Is(err, errJobCanceled):
+		return codeJobCanceled
+	case errors.Is(err, errMemoryBudget):
+		return codeMemoryBudgetExhausted
+	case errors.Is(err, errJobQueueFull), errors.Is(err, errPoolFull):
+		return codeOverloaded
+	case errors.Is(err, errPushFailed):
+		return codePushFailed
+	case errors.Is(err, errQuotaExceeded):
+		return codeQuotaExceeded
+	case errors.Is(err, errRateLimited):
+		return codeRateLimited
+	}
+
+	// Pipeline errors
+	if c := pipeline.ErrorCode(err); c != "" {
+		return c
+	} else if pipeline.IsTransient(err) {
+		return codeInputUnavailable
+	}
+
+	// Status
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return codeInvalidRequest
+	case http.StatusForbidden:
+		return codeNotAllowed
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusUnsupportedMediaType:
+		return pipeline.CodeUnsupportedCodec
+	case http.StatusUpgradeRequired:
+		return codeUpgradeRequired
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return codeOverloaded
+	}
+	return codeInternalError
+}
+
+// sendError responds with status and the code and message of err, a status
+// which isn't an error one being replaced by 500
+func sendError(ct *fiber.Ctx, status int, err error) error {
+	if status < http.StatusBadRequest {
+		status = http.StatusInternalServerError
+	}
+	return ct.Status(status).JSON(fiber.Map{
+		"code":    errorCode(err, status),
+		"message": err.Error(),
+	})
+}
+
+// setTaskError records the failure of a task in its message and code. A task
+// without an error status gets a 500 one, so that failures are never
+// reported with 200.
+func setTaskError(task *TranscodeTask, err error) {
+	if task.Status < http.StatusBadRequest {
+		task.Status = http.StatusInternalServerError
+	}
+	task.Code = errorCode(err, task.Status)
+	task.Message = err.Error()
+}
+
+// sendTaskError responds with the task of a failed request, whose status is
+// the one of the response
+func sendTaskError(ct *fiber.Ctx, task *TranscodeTask, err error) error {
+	setTaskError(task, err)
+	return ct.Status(task.Status).JSON(task.redacted())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"example.com/m/pipeline"
+)
+
+// generateRequest is the body of POST /speak/generate, which transcodes a
+// test signal instead of an input
+type generateRequest struct {
+	Type       string  `form:"type"`      // sine, noise or dtmf, defaults to sine
+	Frequency  float64 `form:"frequency"` // Of the sine in Hz, defaults to 1000
+	Digits     string  `form:"digits"`    // DTMF digits, e.g. 123#
+	Duration   string  `form:"duration"`  // Duration such as 2s or number of seconds, defaults to 1s
+	MediaType  string  `form:"mediatype"`
+	Channels   int     `form:"channels"`
+	SampleRate int     `form:"samplerate"`
+	Timeout    string  `form:"timeout"` // Duration such as 30s or number of seconds
+}
+
+// prepareSignal checks a generate request and returns the task encoding the
+// signal it describes, task.Status being updated on failure
+func prepareSignal(r *generateRequest) (task *TranscodeTask, s pipeline.Signal, err error) {
+	// Check output as the one of a transcode
+	task = &TranscodeTask{
+		Channels:   r.Channels,
+		MediaType:  r.MediaType,
+		SampleRate: r.SampleRate,
+		Timeout:    r.Timeout,
+	}
+	if err = prepareTask(task); err != nil {
+		return
+	}
+
+	// Check duration
+	s = pipeline.Signal{
+		Digits:     strings.ToUpper(r.Digits),
+		Frequency:  r.Frequency,
+		SampleRate: task.SampleRate,
+		Type:       r.Type,
+	}
+	if s.Duration, err = parseTimeout(r.Duration); err != nil || s.Duration < 0 || s.Duration > maxSignalDuration {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: invalid signal duration: %s", r.Duration)
+		return
+	} else if s.Duration == 0 {
+		s.Duration = defaultSignalDuration
+	}
+
+	// Check type
+	if s.Type == "" {
+		s.Type = pipeline.SignalSine
+	}
+	switch s.Type {
+	case pipeline.SignalSine:
+		if s.Frequency == 0 {
+			s.Frequency = defaultSignalFrequency
+		}
+		if !(s.Frequency >= minSignalFrequency && s.Frequency <= maxSignalFrequency) || s.Frequency >= float64(s.SampleRate)/2 {
+			task.Status = http.StatusBadRequest
+			err = fmt.Errorf("main: frequency out of range: %g", s.Frequency)
+			return
+		}
+	case pipeline.SignalNoise:
+	case pipeline.SignalDTMF:
+		if s.Digits == "" || len(s.Digits) > maxDTMFDigits || strings.Trim(s.Digits, pipeline.DTMFDigits) != "" {
+			task.Status = http.StatusBadRequest
+			err = fmt.Errorf("main: invalid dtmf digits: %s", r.Digits)
+			return
+		}
+	default:
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: signal type not supported: %s", r.Type)
+		return
+	}
+
+	// Name the output after the signal
+	task.FileName = s.Type
+	return
+}
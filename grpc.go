@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	transgodepb "example.com/m/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcAddress        string // Empty disables the gRPC server
+	grpcMaxMessageSize int    // Of requests, and of the responses of Transcode
+)
+
+var errGRPCMessageTooBig = errors.New("main: grpc message too big")
+
+// grpcServer implements the Transcoder gRPC service of proto/transgode.proto
+type grpcServer struct {
+	transgodepb.UnimplementedTranscoderServer
+	keys    *apiKeyStore // Nil disables authentication
+	pool    *workerPool
+	tracing *tracer
+}
+
+func newGRPCServer(pool *workerPool, tracing *tracer, keys *apiKeyStore) *grpcServer {
+	return &grpcServer{
+		keys:    keys,
+		pool:    pool,
+		tracing: tracing,
+	}
+}
+
+// start listens on the gRPC address and serves RPCs in the background, over
+// TLS when a certificate is configured
+func (s *grpcServer) start() {
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(grpcMaxMessageSize),
+		grpc.ChainUnaryInterceptor(s.interceptUnary),
+		grpc.ChainStreamInterceptor(s.interceptStream),
+	}
+	if tlsCertFile != "" {
+		c, err := newTLSConfig()
+		if err != nil {
+			rootLogger.fatal("main: creating grpc tls config failed", "error", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(c)))
+	}
+	srv := grpc.NewServer(opts...)
+	transgodepb.RegisterTranscoderServer(srv, s)
+
+	ln, err := net.Listen("tcp", grpcAddress)
+	if err != nil {
+		rootLogger.fatal("main: listening on grpc address failed", "address", grpcAddress, "error", err)
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			rootLogger.fatal("main: serving grpc failed", "error", err)
+		}
+	}()
+}
+
+// grpcCall is what the interceptors know about an RPC
+type grpcCall struct {
+	key *apiKey // Nil when authentication is disabled
+	log *logger
+}
+
+type grpcCallKey struct{}
+
+// callOf returns the call of the context of an RPC
+func callOf(ctx context.Context) *grpcCall {
+	return ctx.Value(grpcCallKey{}).(*grpcCall)
+}
+
+// begin is the gRPC counterpart of the middlewares of the HTTP server: it
+// sets the request id and the logger of an RPC, and checks its api key. done
+// logs the RPC once handled.
+func (s *grpcServer) begin(ctx context.Context, method string) (_ context.Context, done func(err error), err error) {
+	// Use the request id of the caller or create one
+	id := incomingMetadata(ctx, headerRequestID)
+	if !validRequestID(id) {
+		id, _ = newID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(headerRequestID, id))
+	c := &grpcCall{log: rootLogger.with("request_id", id, "client_cert", grpcClientCertificateName(ctx))}
+	start := time.Now()
+	done = func(err error) {
+		c.log.info("main: rpc handled",
+			"method", method,
+			"code", status.Code(err).String(),
+			"duration", time.Since(start),
+			"error", err)
+	}
+	ctx = context.WithValue(ctx, grpcCallKey{}, c)
+	if s.keys == nil {
+		return ctx, done, nil
+	}
+
+	// Get key
+	if c.key = s.keys.lookup(incomingMetadata(ctx, headerAPIKey)); c.key == nil {
+		return ctx, done, status.Error(codes.Unauthenticated, errInvalidAPIKey.Error())
+	}
+	c.log = c.log.with("api_key", c.key.Name)
+
+	// Check rate limit
+	if c.key.limiter != nil {
+		if ok, _ := c.key.limiter.allow(); !ok {
+			return ctx, done, status.Error(codes.ResourceExhausted, "main: rate limit exceeded")
+		}
+	}
+	return ctx, done, nil
+}
+
+func (s *grpcServer) interceptUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	ctx, done, err := s.begin(ctx, info.FullMethod)
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	done(err)
+	return
+}
+
+func (s *grpcServer) interceptStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, done, err := s.begin(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &grpcServerStream{ServerStream: ss, ctx: ctx})
+	}
+	done(err)
+	return err
+}
+
+// grpcServerStream is a stream whose context holds its call
+type grpcServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcServerStream) Context() context.Context { return s.ctx }
+
+// prepare checks the task of an RPC against its api key and waits for a free
+// slot in the worker pool, which must then be released
+func (s *grpcServer) prepare(ctx context.Context, task *TranscodeTask) (err error) {
+	if err = prepareTask(task); err != nil {
+		return
+	}
+	if err = authorizeTaskKey(callOf(ctx).key, task); err != nil {
+		return
+	}
+	if err = s.pool.acquire(true); err != nil {
+		task.Status = overflowStatus
+	}
+	return
+}
+
+// Transcode implements transgodepb.TranscoderServer: the input, sent in the
+// request or fetched from its url, is transcoded and the whole output
+// returned in the response
+func (s *grpcServer) Transcode(ctx context.Context, req *transgodepb.TranscodeRequest) (resp *transgodepb.TranscodeResponse, err error) {
+	task := settingsTask(req.GetSettings())
+	task.Headers = req.GetHeaders()
+	task.log = callOf(ctx).log
+	sp := s.tracing.startSpan(incomingMetadata(ctx, headerTraceparent), transgodepb.Transcoder_Transcode_FullMethodName)
+	sp.setAttribute("transgode.mediatype", task.MediaType)
+	defer func() {
+		sp.finish(err)
+		err = grpcError(ctx, task, err)
+	}()
+
+	// Check input
+	var data []byte
+	switch in := req.GetInput().(type) {
+	case *transgodepb.TranscodeRequest_Data:
+		data = in.Data
+	case *transgodepb.TranscodeRequest_AudioUrl:
+		task.AudioUrl = in.AudioUrl
+	default:
+		return nil, status.Error(codes.InvalidArgument, "main: transcode request has no input")
+	}
+
+	// Prepare task
+	if err = s.prepare(ctx, task); err != nil {
+		return
+	}
+	defer s.pool.release()
+
+	// Write the input sent in the request in a temporary file, which can be
+	// seeked unlike a pipe, it's not restricted by the input policy
+	if data != nil {
+		var f *os.File
+		if f, err = ioutil.TempFile(packageTempDir, "transgode-grpc-"); err != nil {
+			task.Status = http.StatusInternalServerError
+			return nil, fmt.Errorf("main: creating input file failed: %w", err)
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			task.Status = http.StatusInternalServerError
+			return nil, fmt.Errorf("main: writing input file failed: %w", err)
+		}
+		task.AudioUrl = f.Name()
+		task.trusted = true
+	}
+
+	// Create output pipe
+	p, err := newOutputPipe()
+	if err != nil {
+		task.Status = http.StatusInternalServerError
+		return nil, fmt.Errorf("main: creating output pipe failed: %w", err)
+	}
+
+	// Read the output, which must fit in the response
+	var out []byte
+	read := make(chan error, 1)
+	go func() {
+		var err error
+		if out, err = ioutil.ReadAll(io.LimitReader(p, int64(grpcMaxMessageSize)+1)); err == nil && len(out) > grpcMaxMessageSize {
+			err = errGRPCMessageTooBig
+		}
+		p.Close()
+		read <- err
+	}()
+
+	// Transcode
+	var t *transcoder
+	if t, err = newTranscoder(ctx, task, p.url(), sp); err != nil {
+		p.abort(err)
+		<-read
+		return
+	}
+	err = t.run()
+	t.close()
+	p.closeWrite(err)
+	if rerr := <-read; errors.Is(rerr, errGRPCMessageTooBig) {
+		return nil, status.Errorf(codes.ResourceExhausted, "main: output exceeds %d bytes, use TranscodeStream", grpcMaxMessageSize)
+	} else if err == nil && rerr != nil {
+		task.Status = http.StatusInternalServerError
+		err = fmt.Errorf("main: reading output failed: %w", rerr)
+	}
+	if err != nil {
+		return
+	}
+	task.Success = true
+	return &transgodepb.TranscodeResponse{
+		Data:        out,
+		ContentType: outputContentType(outputMediaType(task)),
+	}, nil
+}
+
+// TranscodeStream implements transgodepb.TranscoderServer: the input chunks
+// are fed to FFmpeg through a pipe until the client half-closes the stream,
+// and the output chunks are sent back as soon as they are encoded
+func (s *grpcServer) TranscodeStream(stream transgodepb.Transcoder_TranscodeStreamServer) (err error) {
+	ctx := stream.Context()
+
+	// Read settings, sent in the first message
+	req, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return status.Error(codes.InvalidArgument, "main: request has no message")
+	} else if err != nil {
+		return
+	}
+	if req.GetSettings() == nil {
+		return status.Error(codes.InvalidArgument, "main: first message has no settings")
+	}
+	task := settingsTask(req.GetSettings())
+	task.log = callOf(ctx).log
+	sp := s.tracing.startSpan(incomingMetadata(ctx, headerTraceparent), transgodepb.Transcoder_TranscodeStream_FullMethodName)
+	sp.setAttribute("transgode.mediatype", task.MediaType)
+	defer func() {
+		sp.finish(err)
+		err = grpcError(ctx, task, err)
+	}()
+
+	// Prepare task, zips being only written once transcoded
+	if outputMediaType(task) == "zip" {
+		return status.Error(codes.InvalidArgument, "main: packages, several outputs and cuts can't be streamed")
+	}
+	if err = s.prepare(ctx, task); err != nil {
+		return
+	}
+	defer s.pool.release()
+
+	// Create input pipe, the input comes from the stream so it's not
+	// restricted by the input policy
+	ir, iw, err := os.Pipe()
+	if err != nil {
+		task.Status = http.StatusInternalServerError
+		return fmt.Errorf("main: creating input pipe failed: %w", err)
+	}
+	defer ir.Close()
+	task.AudioUrl = fmt.Sprintf("pipe:%d", ir.Fd())
+	task.trusted = true
+
+	// Create output pipe
+	p, err := newOutputPipe()
+	if err != nil {
+		iw.Close()
+		task.Status = http.StatusInternalServerError
+		return fmt.Errorf("main: creating output pipe failed: %w", err)
+	}
+
+	// Write input chunks in the input pipe until the client half-closes the
+	// stream, the transcode is canceled if the stream fails before
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	failed := make(chan error, 1)
+	go func() {
+		defer iw.Close()
+		for {
+			req, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err == nil && req.GetSettings() != nil {
+				err = status.Error(codes.InvalidArgument, "main: settings sent after the first message")
+			}
+			if err != nil {
+				failed <- err
+				cancel()
+				return
+			}
+			if _, err = iw.Write(req.GetChunk()); err != nil {
+				// The transcoder stopped reading
+				return
+			}
+		}
+	}()
+
+	// Send output chunks
+	sent := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := p.Read(buf)
+			if n > 0 {
+				if werr := stream.Send(&transgodepb.TranscodeStreamResponse{Chunk: buf[:n]}); werr != nil {
+					p.Close()
+					sent <- werr
+					return
+				}
+			}
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				sent <- err
+				return
+			}
+		}
+	}()
+
+	// Transcode
+	var t *transcoder
+	if t, err = newTranscoder(ctx, task, p.url(), sp); err != nil {
+		p.abort(err)
+		<-sent
+		return
+	}
+	err = t.run()
+	t.close()
+	p.closeWrite(err)
+	if serr := <-sent; err == nil && serr != nil {
+		task.Status = http.StatusInternalServerError
+		err = fmt.Errorf("main: sending output failed: %w", serr)
+	}
+
+	// Failures of the input stream are more telling than the cancellation
+	// they cause
+	select {
+	case ferr := <-failed:
+		return ferr
+	default:
+	}
+	if err != nil {
+		return
+	}
+	task.Success = true
+	return
+}
+
+// settingsTask returns the task of the settings of an RPC
+func settingsTask(s *transgodepb.Settings) *TranscodeTask {
+	task := &TranscodeTask{
+		MediaType:        s.GetMediaType(),
+		Channels:         int(s.GetChannels()),
+		SampleRate:       int(s.GetSampleRate()),
+		Timeout:          s.GetTimeout(),
+		Gain:             s.GetGain(),
+		Normalize:        s.GetNormalize(),
+		TrimSilence:      s.GetTrimSilence(),
+		SilenceThreshold: s.GetSilenceThreshold(),
+		SilenceDuration:  s.GetSilenceDuration(),
+		Start:            s.GetStart(),
+		Duration:         s.GetDuration(),
+		Tempo:            s.GetTempo(),
+		Pitch:            s.GetPitch(),
+		FadeIn:           s.GetFadeIn(),
+		FadeOut:          s.GetFadeOut(),
+		Filter:           s.GetFilter(),
+		FilterMode:       s.GetFilterMode(),
+		Resampler:        s.GetResampler(),
+		Precision:        int(s.GetPrecision()),
+		Dither:           s.GetDither(),
+		HighPass:         s.GetHighPass(),
+		LowPass:          s.GetLowPass(),
+		EQ:               s.GetEq(),
+		Denoise:          s.GetDenoise(),
+		ChannelMap:       s.GetChannelMap(),
+		Language:         s.GetLanguage(),
+		Concat:           s.GetConcat(),
+		Crossfade:        s.GetCrossfade(),
+		Gap:              s.GetGap(),
+		Mix:              s.GetMix(),
+		MixGain:          s.GetMixGain(),
+		Duck:             s.GetDuck(),
+		PadStart:         s.GetPadStart(),
+		PadEnd:           s.GetPadEnd(),
+		TargetDuration:   s.GetTargetDuration(),
+		TargetMode:       s.GetTargetMode(),
+		ExtractAudio:     s.GetExtractAudio(),
+		VideoUrl:         s.GetVideoUrl(),
+		SegmentDuration:  s.GetSegmentDuration(),
+		SegmentType:      s.GetSegmentType(),
+		PushUrl:          s.GetPushUrl(),
+		Threads:          int(s.GetThreads()),
+		Gapless:          s.GetGapless(),
+		Preset:           s.GetPreset(),
+		Outputs:          s.GetOutputs(),
+		FileName:         s.GetFileName(),
+		Metadata:         s.GetMetadata(),
+		StripMetadata:    s.GetStripMetadata(),
+		Bext:             s.GetBext(),
+		Chapters:         s.GetChapters(),
+		Cuts:             s.GetCuts(),
+		CueSheet:         s.GetCueSheet(),
+		Encoding:         s.GetEncoding(),
+		BitRate:          int(s.GetBitRate()),
+		LowLatency:       s.GetLowLatency(),
+		CoverUrl:         s.GetCoverUrl(),
+	}
+	if s.StreamIndex != nil {
+		i := int(s.GetStreamIndex())
+		task.StreamIndex = &i
+	}
+	if s.Realtime != nil {
+		r := s.GetRealtime()
+		task.Realtime = &r
+	}
+	return task
+}
+
+// grpcError returns the status of an RPC failing with err: the one of the
+// call when it's canceled or its deadline exceeded, else the one matching
+// the http status of its task, whose code is sent in the transgode-code
+// trailer
+func grpcError(ctx context.Context, task *TranscodeTask, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	setTaskError(task, err)
+	grpc.SetTrailer(ctx, metadata.Pairs("transgode-code", task.Code))
+	return status.Error(grpcCode(task.Status), err.Error())
+}
+
+// grpcCode returns the gRPC status code of a task failing with an http status
+func grpcCode(s int) codes.Code {
+	switch s {
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests, http.StatusInsufficientStorage:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.Internal
+}
+
+// incomingMetadata returns the first value of a metadata key of an RPC
+func incomingMetadata(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// grpcClientCertificateName returns the subject common name of the verified
+// client certificate of an RPC, nil without one so that it isn't logged
+func grpcClientCertificateName(ctx context.Context) interface{} {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if i, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(i.State.VerifiedChains) > 0 {
+		return i.State.VerifiedChains[0][0].Subject.CommonName
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	idempotencyMaxSize int // Outputs larger than this are not replayed
+	idempotencyTTL     time.Duration
+)
+
+var errIdempotencyKeyReused = errors.New("main: idempotency key reused with a different request")
+
+// idempotencyEntry is the result of a request sent with an idempotency key
+type idempotencyEntry struct {
+	body        []byte // Output of a synchronous transcode
+	contentType string
+	done        chan struct{} // Closed once the result is known
+	expiresAt   time.Time
+	fingerprint string
+	id          string // Id of a job
+}
+
+// idempotencyCache deduplicates requests sent with the same idempotency key:
+// the first one is performed while the others wait for its result, which is
+// then replayed until it expires
+type idempotencyCache struct {
+	entries map[string]*idempotencyEntry
+	m       *sync.Mutex
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{
+		entries: make(map[string]*idempotencyEntry),
+		m:       &sync.Mutex{},
+	}
+}
+
+// begin returns the entry of the key. When leader is true, the caller must
+// perform the request and call finish, otherwise it must wait for the entry
+// to be done and replay its result. errIdempotencyKeyReused is returned if
+// the key has been used with another request body.
+func (c *idempotencyCache) begin(key string, body []byte) (e *idempotencyEntry, leader bool, err error) {
+	// Fingerprint request
+	h := sha256.Sum256(body)
+	fingerprint := hex.EncodeToString(h[:])
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	// Remove expired entries
+	now := time.Now()
+	for k, e := range c.entries {
+		if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+
+	// Existing entry
+	if e = c.entries[key]; e != nil {
+		if e.fingerprint != fingerprint {
+			err = errIdempotencyKeyReused
+		}
+		return
+	}
+
+	// New entry
+	e = &idempotencyEntry{
+		done:        make(chan struct{}),
+		fingerprint: fingerprint,
+	}
+	c.entries[key] = e
+	leader = true
+	return
+}
+
+// finish stores the result of the entry. When ok is false, the entry is
+// removed so that the request can be retried and waiting requests are
+// performed on their own.
+func (c *idempotencyCache) finish(key string, e *idempotencyEntry, ok bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if ok {
+		e.expiresAt = time.Now().Add(idempotencyTTL)
+	} else if c.entries[key] == e {
+		delete(c.entries, key)
+	}
+	close(e.done)
+}
+
+// replayable returns whether the done entry holds a result to replay
+func (e *idempotencyEntry) replayable() bool {
+	return e.body != nil || e.id != ""
+}
+
+// idempotentReader records the output read from r in the entry, which is
+// finished once r is fully read or closed
+type idempotentReader struct {
+	b        *bytes.Buffer
+	c        *idempotencyCache
+	e        *idempotencyEntry
+	finished bool
+	key      string
+	r        io.ReadCloser
+}
+
+func newIdempotentReader(c *idempotencyCache, key string, e *idempotencyEntry, r io.ReadCloser) *idempotentReader {
+	return &idempotentReader{
+		b:   &bytes.Buffer{},
+		c:   c,
+		e:   e,
+		key: key,
+		r:   r,
+	}
+}
+
+func (r *idempotentReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+
+	// Record output unless it's too large
+	if r.b != nil {
+		if r.b.Len()+n > idempotencyMaxSize {
+			r.b = nil
+		} else {
+			r.b.Write(p[:n])
+		}
+	}
+
+	// Finish
+	if err == io.EOF {
+		r.finish(r.b != nil)
+	} else if err != nil {
+		r.finish(false)
+	}
+	return
+}
+
+func (r *idempotentReader) Close() error {
+	r.finish(false)
+	return r.r.Close()
+}
+
+func (r *idempotentReader) finish(ok bool) {
+	if r.finished {
+		return
+	}
+	r.finished = true
+	if ok {
+		r.e.body = r.b.Bytes()
+	}
+	r.c.finish(r.key, r.e, ok)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	inputCacheDir  string
+	inputCacheSize int64 // In bytes, 0 disables the cache
+)
+
+// inputs caches remote inputs on disk, nil when disabled
+var inputs *inputCache
+
+// inputCache keeps downloaded remote inputs, keyed by url, request headers
+// and version, so that transcoding the same input into several formats
+// downloads it once. The least recently used inputs are removed once the
+// cache exceeds its size, unless they're being transcoded.
+type inputCache struct {
+	dir     string
+	entries map[string]*list.Element // Of *inputCacheEntry
+	lru     *list.List               // Most recently used first
+	m       *sync.Mutex
+	maxSize int64
+	size    int64
+}
+
+type inputCacheEntry struct {
+	done  chan struct{} // Closed once downloaded
+	err   error         // Set before done is closed
+	key   string
+	path  string
+	size  int64
+	users int // Transcodes reading the file, which isn't removed meanwhile
+}
+
+var errInputTooLarge = errors.New("main: input too large to be cached")
+
+// newInputCache creates the cache directory, removing the inputs cached by a
+// previous run since the index is kept in memory
+func newInputCache(dir string, maxSize int64) (c *inputCache, err error) {
+	if err = os.RemoveAll(dir); err != nil {
+		err = fmt.Errorf("main: removing input cache failed: %w", err)
+		return
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		err = fmt.Errorf("main: creating input cache failed: %w", err)
+		return
+	}
+	c = &inputCache{
+		dir:     dir,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		m:       &sync.Mutex{},
+		maxSize: maxSize,
+	}
+	return
+}
+
+// open returns the path of the cached input of a task, downloading it first
+// if needed, and a func releasing it once the transcode is done. Empty means
+// the input isn't cached and is read from its url: it isn't an HTTP input, it
+// has no ETag or Last-Modified header, or it can't be downloaded.
+func (c *inputCache) open(ctx context.Context, task *TranscodeTask) (path string, release func()) {
+	// Get version
+	if !strings.HasPrefix(task.AudioUrl, "http://") && !strings.HasPrefix(task.AudioUrl, "https://") {
+		return
+	}
+	version, err := inputVersion(task, task.AudioUrl, task.Headers)
+	if err != nil || version == "" {
+		return
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s", task.AudioUrl, strings.Join(task.Headers, "\n"), version)
+	key := hex.EncodeToString(h.Sum(nil))
+
+	// Get entry, the first caller downloads it
+	c.m.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	} else {
+		el = c.lru.PushFront(&inputCacheEntry{
+			done: make(chan struct{}),
+			key:  key,
+			path: filepath.Join(c.dir, key),
+		})
+		c.entries[key] = el
+	}
+	e := el.Value.(*inputCacheEntry)
+	e.users++
+	c.m.Unlock()
+	if !ok {
+		e.err = c.download(ctx, task, e)
+		close(e.done)
+	}
+
+	// Wait for the download
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		c.release(e)
+		return
+	}
+	if e.err != nil {
+		taskLogger(task).debug("main: caching input failed", "url", task.AudioUrl, "error", e.err)
+		c.release(e)
+		return
+	}
+	return e.path, func() { c.release(e) }
+}
+
+// download downloads the input of a task into the file of an entry and adds
+// its size to the cache
+func (c *inputCache) download(ctx context.Context, task *TranscodeTask, e *inputCacheEntry) (err error) {
+	defer func() {
+		if err != nil {
+			os.Remove(e.path)
+		}
+	}()
+
+	// Create request, checking the url of redirects and the addresses connected to
+	policy := taskOptions(task, nil).InputPolicy
+	client := policy.HTTPClient()
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, task.AudioUrl, nil); err != nil {
+		return
+	}
+	for _, h := range task.Headers {
+		if i := strings.Index(h, ":"); i > 0 {
+			req.Header.Add(h[:i], strings.TrimSpace(h[i+1:]))
+		}
+	}
+
+	// Send request
+	var resp *http.Response
+	if resp, err = client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("main: unexpected status %d", resp.StatusCode)
+	}
+
+	// Write file, inputs larger than the limit of transcodes or than the
+	// cache aren't cached
+	max := c.maxSize
+	if maxInputSize > 0 && maxInputSize < max {
+		max = maxInputSize
+	}
+	var f *os.File
+	if f, err = os.OpenFile(e.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600); err != nil {
+		return
+	}
+	e.size, err = io.Copy(f, io.LimitReader(resp.Body, max+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	} else if e.size > max {
+		return errInputTooLarge
+	}
+
+	// Count it and evict the least recently used inputs
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.size += e.size
+	c.evict()
+	return
+}
+
+// release marks an entry as no longer read by a transcode, entries whose
+// download failed are removed once unused
+func (c *inputCache) release(e *inputCacheEntry) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	e.users--
+	if e.users == 0 && e.err != nil {
+		if el, ok := c.entries[e.key]; ok && el.Value == e {
+			c.lru.Remove(el)
+			delete(c.entries, e.key)
+		}
+	}
+	c.evict()
+}
+
+// evict removes the least recently used unused inputs while the cache
+// exceeds its size, c.m must be locked
+func (c *inputCache) evict() {
+	for el := c.lru.Back(); el != nil && c.size > c.maxSize; {
+		prev := el.Prev()
+		if e := el.Value.(*inputCacheEntry); e.users == 0 {
+			os.Remove(e.path)
+			c.size -= e.size
+			c.lru.Remove(el)
+			delete(c.entries, e.key)
+		}
+		el = prev
+	}
+}
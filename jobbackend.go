@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+)
+
+// jobBackend stores job records and queues the jobs to run. Records must be
+// stored as copies since they are modified by their users.
+type jobBackend interface {
+	// cancel flags the job as canceled
+	cancel(id string) error
+	// canceled returns whether the job has been flagged as canceled
+	canceled(id string) (bool, error)
+	// claim stores a new job as dequeued by this instance without queuing
+	// it, for jobs run by the instance which created them
+	claim(r *jobRecord) error
+	// dequeue waits for a job to run, an empty id may be returned on timeout
+	dequeue() (string, error)
+	// done acknowledges a dequeued job has been handled
+	done(id string) error
+	// enqueue stores a new job and queues it, errJobQueueFull is returned
+	// when the queue is full
+	enqueue(r *jobRecord) error
+	// load returns the job with this id or nil
+	load(id string) (*jobRecord, error)
+	// remove removes the job with this id
+	remove(id string) error
+	// save stores the job
+	save(r *jobRecord) error
+}
+
+// memoryJobBackend keeps jobs in memory, they are lost on restart
+type memoryJobBackend struct {
+	canceledIDs map[string]bool
+	m           *sync.Mutex
+	queue       chan string
+	records     map[string]*jobRecord
+}
+
+func newMemoryJobBackend(queueSize int) *memoryJobBackend {
+	return &memoryJobBackend{
+		canceledIDs: make(map[string]bool),
+		m:           &sync.Mutex{},
+		queue:       make(chan string, queueSize),
+		records:     make(map[string]*jobRecord),
+	}
+}
+
+func (b *memoryJobBackend) cancel(id string) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.canceledIDs[id] = true
+	return nil
+}
+
+func (b *memoryJobBackend) canceled(id string) (bool, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.canceledIDs[id], nil
+}
+
+func (b *memoryJobBackend) claim(r *jobRecord) error {
+	return b.save(r)
+}
+
+func (b *memoryJobBackend) dequeue() (string, error) {
+	return <-b.queue, nil
+}
+
+func (b *memoryJobBackend) done(id string) error {
+	return nil
+}
+
+func (b *memoryJobBackend) enqueue(r *jobRecord) error {
+	// Store job
+	b.save(r)
+
+	// Queue job
+	select {
+	case b.queue <- r.ID:
+	default:
+		b.remove(r.ID)
+		return errJobQueueFull
+	}
+	return nil
+}
+
+func (b *memoryJobBackend) load(id string) (*jobRecord, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	r, ok := b.records[id]
+	if !ok {
+		return nil, nil
+	}
+	return r.clone(), nil
+}
+
+func (b *memoryJobBackend) remove(id string) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	delete(b.canceledIDs, id)
+	delete(b.records, id)
+	return nil
+}
+
+func (b *memoryJobBackend) save(r *jobRecord) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.records[r.ID] = r.stored()
+	return nil
+}
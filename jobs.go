@@ -0,0 +1,628 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"example.com/m/pipeline"
+)
+
+var (
+	jobEventsKeepAlive  = 15 * time.Second // Comment sent to detect clients gone when the job doesn't change
+	jobInstance         string
+	jobProgressInterval = time.Second
+	jobQueueSize        int
+	jobRedisURL         string // Empty keeps jobs in memory
+	jobRetention        time.Duration
+	jobWorkers          int // 0 defaults to maxConcurrency
+)
+
+var (
+	errJobCanceled    = errors.New("main: job canceled")
+	errJobHeadersLost = errors.New("main: job headers were lost with the instance which queued it")
+	errJobNotFound    = errors.New("main: job not found")
+	errJobQueueFull   = errors.New("main: job queue is full")
+)
+
+// Job states
+const (
+	jobStateCanceled = "canceled"
+	jobStateDone     = "done"
+	jobStateFailed   = "failed"
+	jobStateQueued   = "queued"
+	jobStateRunning  = "running"
+)
+
+// jobRecord is the state of a job as stored in the job backend, shared by all
+// instances using the same backend
+type jobRecord struct {
+	BytesWritten int64          `json:"bytesWritten,omitempty"`
+	Checksum     string         `json:"checksum,omitempty"` // Hex SHA-256 of the stored output
+	DoneAt       time.Time      `json:"doneAt,omitempty"`
+	ID           string         `json:"id"`
+	Instance     string         `json:"instance,omitempty"`     // Instance running or having run the job, storing its result
+	LocalHeaders bool           `json:"localHeaders,omitempty"` // The task has headers, only kept by the instance which queued the job
+	Owner        string         `json:"owner,omitempty"`        // Name of the api key which created the job
+	Percent      *float64       `json:"percent,omitempty"`
+	Position     *float64       `json:"position,omitempty"`  // Seconds of input decoded
+	RequestID    string         `json:"requestId,omitempty"` // Request which created the job
+	State        string         `json:"state"`
+	Task         *TranscodeTask `json:"task"`
+	Traceparent  string         `json:"traceparent,omitempty"` // Trace the job is run in
+}
+
+// clone returns a copy of the record which can be modified independently
+func (r *jobRecord) clone() *jobRecord {
+	c := *r
+	if r.Percent != nil {
+		p := *r.Percent
+		c.Percent = &p
+	}
+	if r.Position != nil {
+		p := *r.Position
+		c.Position = &p
+	}
+	if r.Task != nil {
+		t := *r.Task
+		c.Task = &t
+	}
+	return &c
+}
+
+// stored returns a copy of the record to store, without the headers of its
+// task which are only kept in memory by the job manager
+func (r *jobRecord) stored() *jobRecord {
+	c := r.clone()
+	if c.Task != nil {
+		c.Task = c.Task.redacted()
+	}
+	return c
+}
+
+// logger returns a logger carrying the ids of the job and of the request
+// which created it
+func (r *jobRecord) logger() *logger {
+	return rootLogger.with("job_id", r.ID, "request_id", r.RequestID)
+}
+
+// setProgress updates the progress of the job from its transcoder
+func (r *jobRecord) setProgress(t *transcoder) {
+	if p, ok := t.Progress(); ok {
+		r.Percent = &p
+	}
+	pos := t.Position().Seconds()
+	r.Position = &pos
+	r.BytesWritten = t.BytesWritten()
+}
+
+// finished returns whether the job has reached a final state
+func (r *jobRecord) finished() bool {
+	switch r.State {
+	case jobStateCanceled, jobStateDone, jobStateFailed:
+		return true
+	}
+	return false
+}
+
+// status returns the json representation of the job
+func (r *jobRecord) status() (s jobStatus) {
+	s = jobStatus{
+		ID:    r.ID,
+		State: r.State,
+	}
+	switch r.State {
+	case jobStateDone:
+		p := 100.0
+		s.BytesWritten = r.BytesWritten
+		s.Checksum = r.Checksum
+		s.Percent = &p
+		s.Position = r.Position
+	case jobStateCanceled, jobStateFailed:
+		s.Code = r.Task.Code
+		s.Message = r.Task.Message
+	case jobStateRunning:
+		s.BytesWritten = r.BytesWritten
+		s.Percent = r.Percent
+		s.Position = r.Position
+	}
+	return
+}
+
+// jobStatus is the json representation of a job
+type jobStatus struct {
+	BytesWritten int64    `json:"bytesWritten,omitempty"`
+	Checksum     string   `json:"checksum,omitempty"` // Hex SHA-256 of the output, once done unless uploaded
+	Code         string   `json:"code,omitempty"`     // Of the failure
+	ID           string   `json:"id"`
+	Message      string   `json:"message,omitempty"`
+	Percent      *float64 `json:"percent,omitempty"`
+	Position     *float64 `json:"position,omitempty"` // Seconds of input decoded
+	State        string   `json:"state"`
+}
+
+// job is a job running on this instance
+type job struct {
+	cancel   context.CancelFunc
+	checksum string // Of the output, set once stored
+	r        *jobRecord
+	span     *span
+	t        *transcoder // Set once the transcoder is set up
+}
+
+// jobManager queues jobs in the backend, runs them with a fixed number of
+// workers sharing the worker pool with synchronous transcodes and keeps their
+// results, stored under the job id, until they expire. Jobs whose task has
+// headers are run by the instance which queued them, the headers being only
+// kept in its memory.
+type jobManager struct {
+	apiKeys  *apiKeyStore // Nil if authentication is disabled
+	backend  jobBackend
+	finished map[string]*jobRecord // Jobs finished by this instance
+	headers  map[string][]string   // Of the tasks of jobs queued by this instance, by job id
+	local    chan string           // Jobs with headers, run by this instance
+	m        *sync.Mutex
+	pool     *workerPool
+	queued   chan string // Jobs dequeued from the backend
+	results  *resultStore
+	running  map[string]*job
+	tracer   *tracer
+}
+
+func newJobManager(backend jobBackend, pool *workerPool, results *resultStore, tracer *tracer, apiKeys *apiKeyStore) *jobManager {
+	return &jobManager{
+		apiKeys:  apiKeys,
+		backend:  backend,
+		finished: make(map[string]*jobRecord),
+		headers:  make(map[string][]string),
+		local:    make(chan string, jobQueueSize),
+		m:        &sync.Mutex{},
+		pool:     pool,
+		queued:   make(chan string),
+		results:  results,
+		running:  make(map[string]*job),
+		tracer:   tracer,
+	}
+}
+
+// start starts the workers and the janitor removing expired jobs
+func (m *jobManager) start() {
+	workers := jobWorkers
+	if workers <= 0 {
+		workers = maxConcurrency
+	}
+	go func() {
+		for {
+			id, err := m.backend.dequeue()
+			if err != nil {
+				rootLogger.error("main: dequeuing job failed", "error", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			if id != "" {
+				m.queued <- id
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case id := <-m.local:
+					m.run(id)
+				case id := <-m.queued:
+					m.run(id)
+				}
+			}
+		}()
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			m.removeExpired()
+		}
+	}()
+}
+
+// add queues a new job, r holds its task and the context of the request
+// creating it
+func (m *jobManager) add(r *jobRecord) (err error) {
+	// Create job
+	r.State = jobStateQueued
+	if r.ID, err = newID(); err != nil {
+		err = fmt.Errorf("main: creating job id failed: %w", err)
+		return
+	}
+
+	// Queue job, on this instance when its headers must stay in memory
+	if len(r.Task.Headers) > 0 {
+		err = m.addLocal(r)
+	} else {
+		err = m.backend.enqueue(r)
+	}
+	if err != nil && !errors.Is(err, errJobQueueFull) {
+		err = fmt.Errorf("main: queuing job failed: %w", err)
+	}
+	return
+}
+
+// addLocal queues a job whose task has headers on this instance, which keeps
+// them. The job is stored as taken by this instance, so that it fails rather
+// than runs without its headers if this instance goes away first.
+func (m *jobManager) addLocal(r *jobRecord) (err error) {
+	r.LocalHeaders = true
+	m.m.Lock()
+	m.headers[r.ID] = r.Task.Headers
+	m.m.Unlock()
+	if err = m.backend.claim(r); err == nil {
+		select {
+		case m.local <- r.ID:
+			return
+		default:
+			err = errJobQueueFull
+		}
+		m.backend.done(r.ID)
+		m.backend.remove(r.ID)
+	}
+	m.m.Lock()
+	delete(m.headers, r.ID)
+	m.m.Unlock()
+	return
+}
+
+// get returns the job with this id or nil
+func (m *jobManager) get(id string) (r *jobRecord, err error) {
+	// Load job
+	if r, err = m.backend.load(id); err != nil {
+		err = fmt.Errorf("main: loading job failed: %w", err)
+		return
+	} else if r == nil {
+		return
+	}
+
+	// Use the live progress of jobs running on this instance
+	m.m.Lock()
+	defer m.m.Unlock()
+	if j, ok := m.running[id]; ok && j.t != nil && r.State == jobStateRunning {
+		r.setProgress(j.t)
+	}
+	return
+}
+
+// cancel cancels a queued or running job. It returns false if the job was
+// already finished.
+func (m *jobManager) cancel(r *jobRecord) (bool, error) {
+	if r.finished() {
+		return false, nil
+	}
+
+	// Flag the job as canceled first so that a worker dequeuing it or the
+	// instance running it stops it
+	if err := m.backend.cancel(r.ID); err != nil {
+		return false, fmt.Errorf("main: canceling job failed: %w", err)
+	}
+
+	switch r.State {
+	case jobStateQueued:
+		m.finish(r, errJobCanceled)
+	case jobStateRunning:
+		m.m.Lock()
+		if j, ok := m.running[r.ID]; ok {
+			j.cancel()
+		}
+		m.m.Unlock()
+	}
+	return true, nil
+}
+
+// remove removes a finished job along with its output
+func (m *jobManager) remove(r *jobRecord) error {
+	if r.Instance == jobInstance {
+		m.results.remove(r.ID)
+	}
+	m.m.Lock()
+	delete(m.finished, r.ID)
+	m.m.Unlock()
+	if err := m.backend.remove(r.ID); err != nil {
+		return fmt.Errorf("main: removing job failed: %w", err)
+	}
+	return nil
+}
+
+// markRunning marks the job as running on this instance, false is returned
+// if the job has been canceled while queued
+func (m *jobManager) markRunning(j *job) bool {
+	if canceled, err := m.backend.canceled(j.r.ID); err != nil {
+		j.r.logger().error("main: checking job is canceled failed", "error", err)
+	} else if canceled {
+		return false
+	}
+
+	j.r.BytesWritten = 0
+	j.r.Instance = jobInstance
+	j.r.Percent = nil
+	j.r.Position = nil
+	j.r.State = jobStateRunning
+	if err := m.backend.save(j.r); err != nil {
+		j.r.logger().error("main: saving job failed", "error", err)
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.running[j.r.ID] = j
+	return true
+}
+
+// setTranscoder stores the transcoder of a running job for progress
+func (m *jobManager) setTranscoder(j *job, t *transcoder) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	j.t = t
+}
+
+// run transcodes the job with this id into a temp file
+func (m *jobManager) run(id string) {
+	// Acknowledge the job once handled
+	defer func() {
+		if err := m.backend.done(id); err != nil {
+			rootLogger.error("main: acknowledging job failed", "job_id", id, "error", err)
+		}
+	}()
+
+	// Load job, it may have been canceled or removed while queued. Running
+	// jobs are those interrupted by a restart of this instance or by the
+	// death of another one.
+	r, err := m.backend.load(id)
+	if err != nil {
+		rootLogger.error("main: loading job failed", "job_id", id, "error", err)
+		return
+	} else if r == nil || r.finished() {
+		return
+	}
+
+	// Restore the headers of the task, only kept by the instance which
+	// queued the job
+	if r.LocalHeaders {
+		m.m.Lock()
+		h, ok := m.headers[id]
+		m.m.Unlock()
+		if !ok {
+			m.finish(r, errJobHeadersLost)
+			return
+		}
+		r.Task.Headers = h
+	}
+
+	// Mark job as running, its FFmpeg logs are attributed to it and its
+	// decoded audio is counted in the quota of its api key
+	r.Task.log = r.logger()
+	if k := m.apiKeys.byName(r.Owner); k != nil {
+		r.Task.quota = k.quota
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	j := &job{
+		cancel: cancel,
+		r:      r,
+	}
+	if !m.markRunning(j) {
+		return
+	}
+
+	// Transcode once a slot is free in the pool, jobs are queued already
+	j.span = m.tracer.startSpan(r.Traceparent, "job")
+	j.span.setAttribute("transgode.job.id", id)
+	m.pool.acquire(false)
+	err = m.transcode(ctx, j)
+	m.pool.release()
+	j.span.finish(err)
+	if err != nil && !errors.Is(err, pipeline.ErrCanceled) {
+		r.logger().error("main: job failed", "error", err)
+	}
+
+	// Finish job with its final progress
+	m.m.Lock()
+	if j.t != nil {
+		r.setProgress(j.t)
+	}
+	r.Checksum = j.checksum
+	delete(m.running, id)
+	m.m.Unlock()
+	m.finish(r, err)
+}
+
+// finish updates the job once it's done
+func (m *jobManager) finish(r *jobRecord, err error) {
+	canceled := errors.Is(err, errJobCanceled) || errors.Is(err, pipeline.ErrCanceled)
+	if !canceled && err != nil {
+		// Interrupting the input may surface as another error
+		canceled, _ = m.backend.canceled(r.ID)
+	}
+
+	r.DoneAt = time.Now()
+	r.Percent = nil
+	if canceled {
+		r.State = jobStateCanceled
+		r.Task.Status = http.StatusGone
+		setTaskError(r.Task, errJobCanceled)
+	} else if err != nil {
+		r.State = jobStateFailed
+		setTaskError(r.Task, err)
+	} else {
+		r.State = jobStateDone
+		r.Task.Success = true
+	}
+	if err := m.backend.save(r); err != nil {
+		r.logger().error("main: saving job failed", "error", err)
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+	delete(m.headers, r.ID)
+	m.finished[r.ID] = r.stored()
+}
+
+func (m *jobManager) transcode(ctx context.Context, j *job) (err error) {
+	// Create output file
+	var res *result
+	if res, err = m.results.create(j.r.ID, outputMediaType(j.r.Task)); err != nil {
+		j.r.Task.Status = resultErrorStatus(err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			m.results.discard(res)
+		}
+	}()
+
+	// Watch the job while it's running
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		m.watch(j, stop)
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	// Set up transcoder
+	var t *transcoder
+	if t, err = newTranscoder(ctx, j.r.Task, res.path, j.span); err != nil {
+		return
+	}
+	defer t.close()
+	m.setTranscoder(j, t)
+	res.info = newOutputInfo(j.r.Task, t)
+
+	// Run
+	if err = t.run(); err != nil {
+		return
+	}
+
+	// Upload the output to object storage instead of keeping it
+	t.close()
+	if j.r.Task.OutputDestination != "" {
+		if err = uploadResult(j.r.Task, res, j.span); err != nil {
+			return
+		}
+		m.results.discard(res)
+		return
+	}
+
+	// Store the result once the output is closed
+	if err = m.results.commit(res, jobRetention); err != nil {
+		return
+	}
+	j.checksum = res.checksum
+	return
+}
+
+// watch periodically publishes the progress of a running job to the backend
+// and cancels it when it has been canceled through another instance
+func (m *jobManager) watch(j *job, stop chan struct{}) {
+	ticker := time.NewTicker(jobProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// Check cancellation
+		if canceled, err := m.backend.canceled(j.r.ID); err == nil && canceled {
+			j.cancel()
+		}
+
+		// Publish progress
+		m.m.Lock()
+		t := j.t
+		m.m.Unlock()
+		if t == nil {
+			continue
+		}
+		r := j.r.clone()
+		r.setProgress(t)
+		if err := m.backend.save(r); err != nil {
+			r.logger().error("main: saving job progress failed", "error", err)
+		}
+	}
+}
+
+// streamEvents writes the status of the job as Server-Sent Events each time
+// it changes, until it's finished or removed or the client is gone
+func (m *jobManager) streamEvents(w *bufio.Writer, r *jobRecord) {
+	ticker := time.NewTicker(jobProgressInterval)
+	defer ticker.Stop()
+	var last []byte
+	lastWrite := time.Now()
+	for {
+		// Write status if it changed
+		b, err := json.Marshal(r.status())
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(b, last) {
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			last = b
+			lastWrite = time.Now()
+		} else if time.Since(lastWrite) >= jobEventsKeepAlive {
+			w.WriteString(": keepalive\n\n")
+			lastWrite = time.Now()
+		}
+
+		// Flush, which fails once the client is gone
+		if err = w.Flush(); err != nil || r.finished() {
+			return
+		}
+
+		// Reload job
+		<-ticker.C
+		id := r.ID
+		if r, err = m.get(id); err != nil {
+			rootLogger.error("main: loading job failed", "job_id", id, "error", err)
+			return
+		} else if r == nil {
+			return
+		}
+	}
+}
+
+// removeExpired removes jobs finished by this instance for longer than the
+// retention along with their output
+func (m *jobManager) removeExpired() {
+	m.m.Lock()
+	var expired []*jobRecord
+	for id, r := range m.finished {
+		if time.Since(r.DoneAt) >= jobRetention {
+			expired = append(expired, r)
+			delete(m.finished, id)
+		}
+	}
+	m.m.Unlock()
+
+	for _, r := range expired {
+		if err := m.remove(r); err != nil {
+			r.logger().error("main: removing expired job failed", "error", err)
+		}
+	}
+}
+
+// newID returns a random id for jobs and results
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	kafkaFetchMaxBytes     = 16 << 20
+	kafkaFetchWait         = time.Second
+	kafkaHeartbeatInterval = 3 * time.Second
+	kafkaRebalanceTimeout  = 5 * time.Minute // Time members have to finish their task and join again
+	kafkaReconnectDelay    = 2 * time.Second
+	kafkaRetryDelay        = 10 * time.Second // Delay before a task which failed transiently is run again
+	kafkaSessionTimeout    = 30 * time.Second
+	kafkaTimeout           = 10 * time.Second
+)
+
+var (
+	kafkaBrokers     []string // Empty disables the consumer
+	kafkaGroup       string
+	kafkaResultTopic string // Empty doesn't produce results
+	kafkaTopic       string
+)
+
+// kafkaLogger logs the errors of the client
+var kafkaLogger = kafka.LoggerFunc(func(msg string, args ...interface{}) {
+	rootLogger.warn("main: kafka: " + fmt.Sprintf(msg, args...))
+})
+
+// kafkaConsumer runs the tasks of a topic as a member of a consumer group.
+// Partitions are consumed in parallel and the tasks of a partition in order:
+// the offset of a message is committed once its task is done and its result
+// produced, so that messages are consumed again after a failure, and tasks
+// which failed transiently are run again after a delay.
+type kafkaConsumer struct {
+	tc *taskConsumer
+	w  *kafka.Writer // Nil without result topic
+}
+
+func newKafkaConsumer(tc *taskConsumer) *kafkaConsumer {
+	c := &kafkaConsumer{tc: tc}
+	if kafkaResultTopic != "" {
+		c.w = &kafka.Writer{
+			Addr:         kafka.TCP(kafkaBrokers...),
+			Balancer:     &kafka.RoundRobin{},
+			ErrorLogger:  kafkaLogger,
+			RequiredAcks: kafka.RequireAll,
+			Topic:        kafkaResultTopic,
+			WriteTimeout: kafkaTimeout,
+		}
+	}
+	return c
+}
+
+// start consumes messages in the background, joining the group again once it
+// fails
+func (c *kafkaConsumer) start() {
+	go func() {
+		for {
+			if err := c.consume(); err != nil {
+				rootLogger.error("main: consuming kafka messages failed", "error", err)
+			}
+			time.Sleep(kafkaReconnectDelay)
+		}
+	}()
+}
+
+// consume joins the group and runs the tasks of the partitions assigned in
+// each generation, with the range strategy, until the group fails
+func (c *kafkaConsumer) consume() (err error) {
+	// Join group, groups without committed offsets start from the earliest
+	// message
+	var g *kafka.ConsumerGroup
+	if g, err = kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		Brokers:           kafkaBrokers,
+		ErrorLogger:       kafkaLogger,
+		GroupBalancers:    []kafka.GroupBalancer{kafka.RangeGroupBalancer{}},
+		HeartbeatInterval: kafkaHeartbeatInterval,
+		ID:                kafkaGroup,
+		RebalanceTimeout:  kafkaRebalanceTimeout,
+		SessionTimeout:    kafkaSessionTimeout,
+		StartOffset:       kafka.FirstOffset,
+		Topics:            []string{kafkaTopic},
+	}); err != nil {
+		return
+	}
+	defer g.Close()
+
+	for {
+		// Wait for the next generation
+		var gen *kafka.Generation
+		if gen, err = g.Next(context.Background()); err != nil {
+			return
+		}
+		as := gen.Assignments[kafkaTopic]
+		ids := make([]int, 0, len(as))
+		for _, a := range as {
+			ids = append(ids, a.ID)
+		}
+		rootLogger.info("main: consuming kafka messages", "topic", kafkaTopic, "group", kafkaGroup, "generation", gen.ID, "partitions", ids)
+
+		// Consume partitions until the generation ends
+		for _, a := range as {
+			partition, offset := a.ID, a.Offset
+			gen.Start(func(ctx context.Context) {
+				c.consumePartition(ctx, gen, partition, offset)
+			})
+		}
+	}
+}
+
+// consumePartition runs the tasks of a partition in order from offset until
+// the generation ends. The partition is read again from the last offset
+// committed after a failure.
+func (c *kafkaConsumer) consumePartition(ctx context.Context, gen *kafka.Generation, partition int, offset int64) {
+	for ctx.Err() == nil {
+		if err := c.readPartition(ctx, gen, partition, &offset); err != nil && ctx.Err() == nil {
+			rootLogger.error("main: consuming kafka partition failed", "partition", partition, "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(kafkaReconnectDelay):
+			}
+		}
+	}
+}
+
+// readPartition runs the tasks of a partition from offset, which is moved past
+// the messages handled
+func (c *kafkaConsumer) readPartition(ctx context.Context, gen *kafka.Generation, partition int, offset *int64) (err error) {
+	// Connect to the leader, offsets out of range move to the earliest
+	// message
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     kafkaBrokers,
+		ErrorLogger: kafkaLogger,
+		MaxBytes:    kafkaFetchMaxBytes,
+		MaxWait:     kafkaFetchWait,
+		Partition:   partition,
+		Topic:       kafkaTopic,
+	})
+	defer r.Close()
+	if err = r.SetOffset(*offset); err != nil {
+		return
+	}
+
+	for {
+		// Fetch
+		var m kafka.Message
+		if m, err = r.FetchMessage(ctx); err != nil {
+			return
+		}
+
+		// Run task
+		if err = c.handle(ctx, gen, m); err != nil {
+			return
+		}
+		*offset = m.Offset + 1
+	}
+}
+
+// handle runs the task of a message, produces its result and commits its
+// offset
+func (c *kafkaConsumer) handle(ctx context.Context, gen *kafka.Generation, m kafka.Message) (err error) {
+	// Run task, again after a delay while it fails transiently
+	l := rootLogger.with("kafka_partition", m.Partition, "kafka_offset", m.Offset)
+	var task *TranscodeTask
+	for {
+		var retry bool
+		if task, retry = c.tc.handle(m.Value, l, "kafka message"); !retry {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			// The message is consumed again by the next generation
+			return ctx.Err()
+		case <-time.After(kafkaRetryDelay):
+		}
+	}
+
+	// Produce result with the key of the task
+	if c.w != nil {
+		var b []byte
+		if b, err = json.Marshal(task.redacted()); err != nil {
+			err = fmt.Errorf("main: marshaling task failed: %w", err)
+			return
+		}
+		if err = c.w.WriteMessages(ctx, kafka.Message{Key: m.Key, Value: b}); err != nil {
+			err = fmt.Errorf("main: producing kafka result failed: %w", err)
+			return
+		}
+	}
+
+	// Commit offset
+	if err = gen.CommitOffsets(map[string]map[int]int64{kafkaTopic: {m.Partition: m.Offset + 1}}); err != nil {
+		if errors.Is(err, kafka.ErrGenerationEnded) {
+			return ctx.Err()
+		}
+		err = fmt.Errorf("main: committing kafka offset failed: %w", err)
+	}
+	return
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Log levels
+const (
+	logLevelDebug = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+var (
+	ffmpegLevel = astiav.LogLevelDebug
+	logLevel    = logLevelDebug
+	logM        = &sync.Mutex{} // Locks writes to os.Stderr
+)
+
+var ffmpegLogLevelNames = map[string]astiav.LogLevel{
+	"quiet":   astiav.LogLevelQuiet,
+	"panic":   astiav.LogLevelPanic,
+	"fatal":   astiav.LogLevelFatal,
+	"error":   astiav.LogLevelError,
+	"warning": astiav.LogLevelWarning,
+	"info":    astiav.LogLevelInfo,
+	"verbose": astiav.LogLevelVerbose,
+	"debug":   astiav.LogLevelDebug,
+}
+
+// logger writes json lines with the fields it has been created with
+type logger struct {
+	fields []interface{} // Key value pairs
+}
+
+var rootLogger = &logger{}
+
+// with returns a logger adding the key value pairs to each line
+func (l *logger) with(kv ...interface{}) *logger {
+	return &logger{fields: append(append([]interface{}{}, l.fields...), kv...)}
+}
+
+func (l *logger) debug(msg string, kv ...interface{}) { l.log(logLevelDebug, msg, kv) }
+func (l *logger) info(msg string, kv ...interface{})  { l.log(logLevelInfo, msg, kv) }
+func (l *logger) warn(msg string, kv ...interface{})  { l.log(logLevelWarn, msg, kv) }
+func (l *logger) error(msg string, kv ...interface{}) { l.log(logLevelError, msg, kv) }
+
+func (l *logger) log(level int, msg string, kv []interface{}) {
+	if level < logLevel {
+		return
+	}
+
+	// Build line
+	line := map[string]interface{}{
+		"level": logLevelNames[level],
+		"msg":   msg,
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for _, fields := range [][]interface{}{l.fields, kv} {
+		for i := 0; i+1 < len(fields); i += 2 {
+			k := fmt.Sprint(fields[i])
+			switch v := fields[i+1].(type) {
+			case nil:
+			case error:
+				line[k] = v.Error()
+			case fmt.Stringer:
+				line[k] = v.String()
+			default:
+				line[k] = v
+			}
+		}
+	}
+
+	// Write line
+	b, err := json.Marshal(line)
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"level":"error","msg":"main: marshaling log line failed: %s"}`, err))
+	}
+	logM.Lock()
+	defer logM.Unlock()
+	os.Stderr.Write(append(b, '\n'))
+}
+
+// fatal logs the message and exits
+func (l *logger) fatal(msg string, kv ...interface{}) {
+	l.log(logLevelError, msg, kv)
+	os.Exit(1)
+}
+
+// parseLogLevel returns the level with this name
+func parseLogLevel(name string) (int, bool) {
+	for i, n := range logLevelNames {
+		if strings.EqualFold(n, name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// requestLogger returns the logger of the request, carrying its id
+func requestLogger(ct *fiber.Ctx) *logger {
+	if l, ok := ct.Locals(localLogger).(*logger); ok {
+		return l
+	}
+	return rootLogger
+}
+
+// requestID returns the id of the request
+func requestID(ct *fiber.Ctx) string {
+	id, _ := ct.Locals(localRequestID).(string)
+	return id
+}
+
+// validRequestID checks a request id sent by the caller can be reused
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// taskLogger returns the logger of the request or job the task belongs to
+func taskLogger(task *TranscodeTask) *logger {
+	if task.log != nil {
+		return task.log
+	}
+	return rootLogger
+}
+
+// ffmpegLoggers maps FFmpeg context pointers, formatted as in the log
+// callback, to the logger of the request using them so that FFmpeg logs can
+// be correlated with it
+var ffmpegLoggers = struct {
+	m *sync.Mutex
+	p map[string]*logger
+}{
+	m: &sync.Mutex{},
+	p: make(map[string]*logger),
+}
+
+// trackFFmpeg attributes FFmpeg logs of the context pointer to the logger
+// until the returned func is called
+func (l *logger) trackFFmpeg(p string) (release func()) {
+	ffmpegLoggers.m.Lock()
+	defer ffmpegLoggers.m.Unlock()
+	ffmpegLoggers.p[p] = l
+	return func() { untrackFFmpeg(p) }
+}
+
+// untrackFFmpeg stops attributing FFmpeg logs of the pointer, it must be
+// called before the context is freed since its address can be reused
+func untrackFFmpeg(p string) {
+	ffmpegLoggers.m.Lock()
+	defer ffmpegLoggers.m.Unlock()
+	delete(ffmpegLoggers.p, p)
+}
+
+// handleFFmpegLog logs an FFmpeg message with the logger of its context
+func handleFFmpegLog(l astiav.LogLevel, msg, parent string) {
+	// Get logger
+	ffmpegLoggers.m.Lock()
+	lg, ok := ffmpegLoggers.p[parent]
+	ffmpegLoggers.m.Unlock()
+	if !ok {
+		lg = rootLogger
+	}
+
+	// Get level
+	level := logLevelDebug
+	switch {
+	case l <= astiav.LogLevelError:
+		level = logLevelError
+	case l <= astiav.LogLevelWarning:
+		level = logLevelWarn
+	case l <= astiav.LogLevelInfo:
+		level = logLevelInfo
+	}
+	lg.log(level, strings.TrimSpace(msg), []interface{}{"component", "ffmpeg", "ffmpeg_level", int(l)})
+}
+
+// parseFFmpegLogLevel returns the FFmpeg log level with this name
+func parseFFmpegLogLevel(name string) (astiav.LogLevel, bool) {
+	l, ok := ffmpegLogLevelNames[strings.ToLower(name)]
+	return l, ok
+}
+
+// ffmpegLogLevel returns the FFmpeg log level matching the log level, so that
+// FFmpeg doesn't format messages which would be dropped
+func ffmpegLogLevel(level int) astiav.LogLevel {
+	switch level {
+	case logLevelDebug:
+		return astiav.LogLevelDebug
+	case logLevelInfo:
+		return astiav.LogLevelInfo
+	case logLevelWarn:
+		return astiav.LogLevelWarning
+	}
+	return astiav.LogLevelError
+}
@@ -1,608 +1,1533 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode"
 
+	"example.com/m/config"
+	"example.com/m/pipeline"
 	"github.com/asticode/go-astiav"
-	"github.com/asticode/go-astikit"
 	"github.com/gofiber/fiber/v2"
 )
 
-type stream struct {
-	buffersinkContext *astiav.FilterContext
-	buffersrcContext  *astiav.FilterContext
-	decCodec          *astiav.Codec
-	decCodecContext   *astiav.CodecContext
-	decFrame          *astiav.Frame
-	encCodec          *astiav.Codec
-	encCodecContext   *astiav.CodecContext
-	encPkt            *astiav.Packet
-	filterFrame       *astiav.Frame
-	filterGraph       *astiav.FilterGraph
-	inputStream       *astiav.Stream
-	outputStream      *astiav.Stream
+var (
+	listenAddress      string
+	maxBodySize        int
+	supportedEncCodecs = make(map[string]string) // Enabled output media types and their encoder
+)
+
+// encCodecs are the encoders of the output media types which can be enabled
+var encCodecs = map[string]string{
+	"dash":  "aac",
+	"hls":   "aac",
+	"mp3":   "libmp3lame",
+	"mulaw": "pcm_mulaw",
+	"raw":   "pcm_s16le",
+	"wav":   "pcm_s16le",
 }
 
-var (
-	supportedEncCodecs = make(map[string]string)
+// wavEncodings are the encoders of the sample encodings of wav outputs
+var wavEncodings = map[string]string{
+	"mulaw": "pcm_mulaw",
+	"s16le": "pcm_s16le",
+}
+
+// Headers
+const (
+	headerAPIKey             = "X-Api-Key"
+	headerIdempotencyKey     = "Idempotency-Key"
+	headerIdempotentReplayed = "Idempotent-Replayed"
+	headerRequestID          = "X-Request-Id"
+	headerTraceparent        = "Traceparent"
+)
+
+// Fiber locals
+const (
+	localAPIKey    = "apiKey"
+	localLogger    = "logger"
+	localRequestID = "requestID"
 )
 
 type TranscodeTask struct {
-	AudioUrl   string `form:"audiourl"`
-	MediaType  string `form:"mediatype"`
-	Channels   int    `form:"channels"`
-	SampleRate int    `form:"samplerate"`
-	Success    bool
-	Status     int
-	Message    string `default:""`
+	AudioUrl          string    `form:"audiourl"`
+	MediaType         string    `form:"mediatype"`
+	Encoding          string    `form:"encoding" json:",omitempty"`      // Of the samples of wav outputs, s16le or mulaw, defaults to s16le
+	BitRate           int       `form:"bitrate" json:",omitempty"`       // Of mp3, hls and dash outputs in kbps, e.g. 48
+	Preset            string    `form:"preset" json:",omitempty"`        // Named set of defaults listed by GET /presets, e.g. asr
+	Outputs           []string  `form:"outputs" json:",omitempty"`       // Other outputs as mediatype:samplerate:channels or preset name, e.g. wav:16000:1
+	FileName          string    `form:"filename" json:",omitempty"`      // Of the output in Content-Disposition, defaults to the name of the input
+	Metadata          []string  `form:"metadata" json:",omitempty"`      // Tags of the output as key=value, e.g. title=Episode 1
+	StripMetadata     bool      `form:"stripmetadata" json:",omitempty"` // Drop the tags of the input, only writing Metadata
+	Bext              bool      `form:"bext" json:",omitempty"`          // Write a Broadcast Wave bext chunk in WAV outputs from the tags
+	Chapters          []string  `form:"chapters" json:",omitempty"`      // As start:end:title, e.g. 0:90:Intro, replacing those of the input
+	CoverUrl          string    `form:"coverurl" json:",omitempty"`      // JPEG or PNG cover art of mp3 outputs, as an http(s) or base64 data url
+	Cuts              []string  `form:"cuts" json:",omitempty"`          // Positions the output is split at, such as 1m30s or number of seconds
+	CueSheet          string    `form:"cuesheet" json:",omitempty"`      // Tracks the output is split into, as a CUE sheet
+	StreamIndex       *int      `form:"streamindex" json:",omitempty"`   // Input index of the audio stream, defaults to the first one
+	Language          string    `form:"language" json:",omitempty"`      // Language of the audio stream, e.g. eng
+	Concat            []string  `form:"concat" json:",omitempty"`        // Inputs appended to AudioUrl
+	Crossfade         string    `form:"crossfade" json:",omitempty"`     // Duration such as 500ms or number of seconds
+	Gap               string    `form:"gap" json:",omitempty"`           // Duration such as 500ms or number of seconds
+	Gapless           bool      `form:"gapless" json:",omitempty"`       // Trim the encoder priming of concatenated lossy inputs
+	Mix               []string  `form:"mix" json:",omitempty"`           // Inputs mixed into AudioUrl
+	MixGain           []float64 `form:"mixgain" json:",omitempty"`       // In dB, of the input of Mix at the same position
+	Duck              bool      `form:"duck" json:",omitempty"`          // Lower the mixed inputs while AudioUrl is loud
+	Channels          int       `form:"channels"`
+	ChannelMap        string    `form:"channelmap" json:",omitempty"` // Preset such as downmix or pan filter matrix
+	SampleRate        int       `form:"samplerate"`
+	Resampler         string    `form:"resampler" json:",omitempty"` // swr or soxr
+	Precision         int       `form:"precision" json:",omitempty"` // Bits of precision of soxr
+	Dither            string    `form:"dither" json:",omitempty"`    // Dither method when reducing the bit depth
+	Start             string    `form:"start" json:",omitempty"`     // Input position such as 1m30s or number of seconds
+	Duration          string    `form:"duration" json:",omitempty"`  // Duration such as 30s or number of seconds, empty until the end
+	Denoise           string    `form:"denoise" json:",omitempty"`   // light, medium or strong
+	Tempo             float64   `form:"tempo" json:",omitempty"`     // Speed ratio
+	Pitch             float64   `form:"pitch" json:",omitempty"`     // In semitones
+	HighPass          float64   `form:"highpass" json:",omitempty"`  // Cutoff frequency in Hz
+	LowPass           float64   `form:"lowpass" json:",omitempty"`   // Cutoff frequency in Hz
+	EQ                []string  `form:"eq" json:",omitempty"`        // Bands as frequency:gain:q, e.g. 1000:-3:1.4
+	Gain              float64   `form:"gain" json:",omitempty"`      // In dB
+	FadeIn            string    `form:"fadein" json:",omitempty"`    // Duration such as 500ms or number of seconds
+	FadeOut           string    `form:"fadeout" json:",omitempty"`   // Duration such as 500ms or number of seconds
+	PadStart          string    `form:"padstart" json:",omitempty"`  // Duration such as 250ms or number of seconds
+	PadEnd            string    `form:"padend" json:",omitempty"`    // Duration such as 250ms or number of seconds
+	Normalize         string    `form:"normalize" json:",omitempty"` // peak or rms
+	TrimSilence       bool      `form:"trimsilence" json:",omitempty"`
+	SilenceThreshold  float64   `form:"silencethreshold" json:",omitempty"` // In dBFS, defaults to -50
+	SilenceDuration   string    `form:"silenceduration" json:",omitempty"`  // Duration such as 100ms or number of seconds
+	Filter            string    `form:"filter" json:",omitempty"`           // Custom filter chain, e.g. highpass=f=300,lowpass=f=3400
+	FilterMode        string    `form:"filtermode" json:",omitempty"`       // append or replace
+	TargetDuration    string    `form:"targetduration" json:",omitempty"`   // Exact output duration such as 30s or number of seconds
+	TargetMode        string    `form:"targetmode" json:",omitempty"`       // pad or loop, defaults to pad
+	ExtractAudio      bool      `form:"extractaudio" json:",omitempty"`     // Input is a video whose other streams are ignored
+	VideoUrl          string    `form:"videourl" json:",omitempty"`         // Video the output audio is muxed into, as Matroska
+	SegmentDuration   string    `form:"segmentduration" json:",omitempty"`  // Of packaged media types, such as 6s or number of seconds
+	SegmentType       string    `form:"segmenttype" json:",omitempty"`      // mpegts or fmp4, of HLS packages
+	PushUrl           string    `form:"pushurl" json:",omitempty"`          // Icecast, HTTP, RTP or SRT destination the output is pushed to live
+	Realtime          *bool     `form:"realtime" json:",omitempty"`         // Write the output at the pace it's played, defaults to true with PushUrl
+	LowLatency        bool      `form:"lowlatency" json:",omitempty"`       // Send each packet as soon as it's encoded, e.g. to voice bots
+	Threads           int       `form:"threads" json:",omitempty"`          // FFmpeg threads of the decoder and encoder, defaults to TRANSGODE_THREADS
+	Headers           []string  `form:"headers" json:",omitempty"`          // Only kept in memory, see redacted
+	OutputDestination string    `form:"outputdestination"`
+	OutputURL         string    // Object url when uploaded to OutputDestination
+	OutputURLs        []string  `form:"-" json:",omitempty"`       // Object urls of each output when there are several
+	Timeout           string    `form:"timeout" json:",omitempty"` // Duration such as 30s or number of seconds
+	Debug             bool      `form:"debug" json:",omitempty"`   // Send pipeline details in the X-Debug trailer of streamed outputs
+	Success           bool
+	Status            int
+	Message           string        `default:""`
+	Code              string        `form:"-" json:",omitempty"` // Machine-readable error code, e.g. INPUT_OPEN_FAILED
+	Errors            []fieldError  `form:"-" json:",omitempty"` // Invalid fields of the request
+	ValidSampleRates  []int         `form:"-" json:",omitempty"` // Sample rates of the encoder, when samplerate isn't one of them
+	MaxDuration       time.Duration `form:"-" json:",omitempty"` // Maximum input duration of the api key
+	log               *logger       // Logger of the request or job
+	quota             *quota        // Quota of the api key decoded audio is counted in
+	trusted           bool          // Input isn't restricted by the input policy, e.g. given on the command line
+	cachedInput       string        // Path of the copy of the input in the input cache, read instead of AudioUrl
+	dryRun            bool          // Set up without being run, its input isn't cached and its output isn't pushed
+}
+
+// redacted returns a copy of the task without its headers, which may hold
+// credentials of the input, to respond with it or store it. Headers are only
+// read from requests and messages, and kept in memory.
+func (t *TranscodeTask) redacted() *TranscodeTask {
+	c := *t
+	c.Headers = nil
+	return &c
 }
 
 func main() {
-	// Handle ffmpeg logs
-	astiav.SetLogLevel(astiav.LogLevelDebug)
-	astiav.SetLogCallback(func(l astiav.LogLevel, msg, parent string) {
-		log.Printf("ffmpeg log: %s (level: %d)\n", strings.TrimSpace(msg), l)
-	})
+	// FFmpeg writes in pipes whose reader can go away, we want EPIPE instead of being killed
+	signal.Ignore(syscall.SIGPIPE)
 
-	supportedEncCodecs = map[string]string{
-		"wav": "pcm_s16le",
-		"raw": "pcm_s16le",
+	// Configuration
+	c, err := config.Load(os.Getenv("TRANSGODE_CONFIG"))
+	if err != nil {
+		rootLogger.fatal("main: loading configuration failed", "error", err)
+	}
+	if err = applyConfig(c); err != nil {
+		rootLogger.fatal("main: applying configuration failed", "error", err)
 	}
 
-	app := fiber.New()
-	app.Post("/speak/transcode", func(ct *fiber.Ctx) (err error) {
-		task := new(TranscodeTask)
+	// Handle ffmpeg logs
+	astiav.SetLogLevel(ffmpegLevel)
+	astiav.SetLogCallback(handleFFmpegLog)
 
-		if err := ct.BodyParser(task); err != nil {
-			return ct.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"message": err.Error(),
-			})
-		}
+	// One-shot transcode without the server
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		os.Exit(convert(os.Args[2:]))
+	}
 
-		// default to stereo
-		if task.Channels < 1 {
-			task.Channels = 2
+	// Worker pool
+	pool := newWorkerPool(maxConcurrency, maxQueueDepth)
+	results, err := newResultStore()
+	if err != nil {
+		rootLogger.fatal("main: creating result store failed", "error", err)
+	}
+	results.start()
+	cache := newOutputCache(results)
+	if inputCacheSize > 0 {
+		if inputs, err = newInputCache(inputCacheDir, inputCacheSize); err != nil {
+			rootLogger.fatal("main: creating input cache failed", "error", err)
 		}
-		if task.Channels > 2 {
-			task.Channels = 2
+	}
+	var tracing *tracer
+	if traceEndpoint != "" {
+		if tracing, err = newTracer(traceEndpoint); err != nil {
+			rootLogger.fatal("main: creating tracer failed", "error", err)
 		}
+	}
 
-		// default to 44100
-		if task.SampleRate < 16000 {
-			task.SampleRate = 44100
+	// Api keys
+	var apiKeys *apiKeyStore
+	if apiKeysFile != "" {
+		if apiKeys, err = loadAPIKeys(apiKeysFile); err != nil {
+			rootLogger.fatal("main: loading api keys failed", "error", err)
 		}
-		if task.SampleRate > 48000 {
-			task.SampleRate = 48000
+	}
+
+	// Job backend
+	var backend jobBackend = newMemoryJobBackend(jobQueueSize)
+	if jobRedisURL != "" {
+		b, err := newRedisJobBackend(jobRedisURL)
+		if err != nil {
+			rootLogger.fatal("main: creating redis job backend failed", "error", err)
 		}
+		b.start()
+		backend = b
+	}
+	jobs := newJobManager(backend, pool, results, tracing, apiKeys)
+	jobs.start()
+	idempotency := newIdempotencyCache()
 
-		task.Success = false
-		task.Status = http.StatusOK
+	// gRPC server, on its own listener
+	if grpcAddress != "" {
+		newGRPCServer(pool, tracing, apiKeys).start()
+	}
+
+	// Message broker consumers
+	consumer := newTaskConsumer(pool, results, tracing)
+	if natsURL != "" {
+		newNATSConsumer(consumer).start()
+	}
+	if amqpURL != "" {
+		newAMQPConsumer(consumer).start()
+	}
+	if sqsQueueURL != "" {
+		w, err := newSQSWorker(consumer, sqsQueueURL)
+		if err != nil {
+			rootLogger.fatal("main: creating sqs worker failed", "error", err)
+		}
+		w.start()
+	}
+	if len(kafkaBrokers) > 0 {
+		newKafkaConsumer(consumer).start()
+	}
 
-		// support only PCM for now
-		if v := supportedEncCodecs[task.MediaType]; v == "" {
-			task.Message = fmt.Sprintf("main: codec not supported: %s", task.MediaType)
-			task.Status = http.StatusUnsupportedMediaType
-			return ct.JSON(task)
+	// Watch folders
+	for _, f := range watchFolders {
+		if err = newFolderWatcher(f, pool, tracing).start(); err != nil {
+			rootLogger.fatal("main: watching folder failed", "dir", f.Dir, "error", err)
 		}
+	}
 
-		var (
-			c                   = astikit.NewCloser()
-			inputFormatContext  *astiav.FormatContext
-			outputFormatContext *astiav.FormatContext
-			streams             = make(map[int]*stream) // Indexed by input stream index
-		)
+	app := fiber.New(fiber.Config{BodyLimit: maxBodySize})
+	app.Use(func(ct *fiber.Ctx) error {
+		// Use the request id of the caller or create one
+		id := ct.Get(headerRequestID)
+		if !validRequestID(id) {
+			id, _ = newID()
+		}
+		ct.Set(headerRequestID, id)
+		ct.Locals(localLogger, rootLogger.with("request_id", id, "client_cert", clientCertificateName(ct)))
+		ct.Locals(localRequestID, id)
+
+		// Handle request
+		start := time.Now()
+		err := ct.Next()
+		requestLogger(ct).info("main: request handled",
+			"method", ct.Method(),
+			"path", ct.Path(),
+			"status", ct.Response().StatusCode(),
+			"duration", time.Since(start),
+			"error", err)
+		return err
+	})
 
-		// We use an astikit.Closer to free all resources properly
-		defer c.Close()
+	// Describe the API and what it supports without authentication
+	openAPI := openAPIDocument()
+	capabilities := queryCapabilities()
+	build := queryVersion()
+	app.Get("/capabilities", func(ct *fiber.Ctx) error {
+		return ct.JSON(capabilities)
+	})
+	app.Get("/presets", func(ct *fiber.Ctx) error {
+		return ct.JSON(presets)
+	})
+	app.Get("/version", func(ct *fiber.Ctx) error {
+		return ct.JSON(build)
+	})
+	app.Get("/openapi.json", func(ct *fiber.Ctx) error {
+		return ct.JSON(openAPI)
+	})
+	app.Get("/docs", func(ct *fiber.Ctx) error {
+		ct.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return ct.SendString(swaggerUI)
+	})
 
-		// Open input file
-		// Alloc input format context
-		if inputFormatContext = astiav.AllocFormatContext(); inputFormatContext == nil {
-			task.Message = fmt.Sprintf("main: input format context is nil")
-			task.Status = http.StatusBadRequest
-			return ct.JSON(task)
+	app.Use(authenticate(apiKeys))
+	app.Post("/speak/transcode", func(ct *fiber.Ctx) (err error) {
+		task := new(TranscodeTask)
+
+		// Trace the request, continuing the trace of the caller
+		sp := tracing.startSpan(ct.Get(headerTraceparent), "POST /speak/transcode")
+		traced := false
+		defer func() {
+			if !traced {
+				var err error
+				if task.Message != "" {
+					err = errors.New(task.Message)
+				}
+				sp.finish(err)
+			}
+		}()
+
+		if err := parseTask(ct, task); err != nil {
+			return sendError(ct, fiber.StatusBadRequest, err)
 		}
-		c.Add(inputFormatContext.Free)
+		sp.setAttribute("transgode.mediatype", task.MediaType)
+		task.log = requestLogger(ct)
 
-		// Open input
-		if err = inputFormatContext.OpenInput(task.AudioUrl, nil, nil); err != nil {
-			task.Message = fmt.Sprintf("main: opening input failed: %s", err)
-			task.Status = http.StatusBadRequest
-			return ct.JSON(task)
+		// Prepare task
+		if err = prepareTask(task); err != nil {
+			return sendTaskError(ct, task, err)
 		}
-		c.Add(inputFormatContext.CloseInput)
 
-		// Find stream info
-		if err = inputFormatContext.FindStreamInfo(nil); err != nil {
-			task.Message = fmt.Sprintf("main: finding stream info failed: %w", err)
-			task.Status = http.StatusBadRequest
-			return ct.JSON(task)
+		// Apply the restrictions of the api key
+		if err = authorizeTask(ct, task); err != nil {
+			if errors.Is(err, errQuotaExceeded) {
+				return sendQuotaExceeded(ct)
+			}
+			return sendTaskError(ct, task, err)
 		}
 
-		// Loop through streams
-		for _, is := range inputFormatContext.Streams() {
-			// Only process audio
-			if is.CodecParameters().MediaType() != astiav.MediaTypeAudio {
-				continue
+		// Send the cached output of an identical request, unless debugged
+		// since it wasn't transcoded by this request
+		ck := ""
+		if task.OutputDestination == "" && !task.Debug {
+			if ck = cacheKey(task); ck != "" {
+				if r := cache.get(ck); r != nil {
+					ct.Set(headerCache, "HIT")
+					ct.Set(fiber.HeaderContentLocation, "/results/"+r.id)
+					return results.send(ct, r)
+				}
+				ct.Set(headerCache, "MISS")
 			}
+		}
 
-			// Create stream
-			s := &stream{inputStream: is}
+		// Deduplicate requests sent with the same idempotency key
+		var idem *idempotencyEntry
+		key := ct.Get(headerIdempotencyKey)
+		if key != "" {
+			key = "transcode:" + requestOwner(ct) + ":" + key
+			e, leader, err := idempotency.begin(key, ct.Body())
+			if err != nil {
+				return sendError(ct, fiber.StatusUnprocessableEntity, err)
+			}
+			if leader {
+				idem = e
+				defer func() {
+					if idem != nil {
+						idempotency.finish(key, idem, false)
+					}
+				}()
+			} else {
+				// Replay the result of the first request, or perform this
+				// one on its own if it failed
+				<-e.done
+				if e.replayable() {
+					ct.Set(headerIdempotentReplayed, "true")
+					ct.Set(fiber.HeaderContentType, e.contentType)
+					return ct.Send(e.body)
+				}
+			}
+		}
 
-			// Find decoder
-			if s.decCodec = astiav.FindDecoder(is.CodecParameters().CodecID()); s.decCodec == nil {
-				err = errors.New("main: codec is nil")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+		// Wait for a free slot in the worker pool
+		if err = pool.acquire(true); err != nil {
+			ct.Set(fiber.HeaderRetryAfter, "1")
+			return sendError(ct, overflowStatus, err)
+		}
+		released := false
+		defer func() {
+			if !released {
+				pool.release()
 			}
+		}()
 
-			// Alloc codec context
-			if s.decCodecContext = astiav.AllocCodecContext(s.decCodec); s.decCodecContext == nil {
-				err = errors.New("main: codec context is nil")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+		// Upload the output to object storage instead of sending it
+		if task.OutputDestination != "" {
+			if err = transcodeAndUpload(task, results, sp); err != nil {
+				requestLogger(ct).error("main: transcoding failed", "url", task.AudioUrl, "error", err)
+				return sendTaskError(ct, task, err)
+			}
+			task.Success = true
+			if idem != nil {
+				if idem.body, err = json.Marshal(task.redacted()); err == nil {
+					idem.contentType = fiber.MIMEApplicationJSON
+					idempotency.finish(key, idem, true)
+					idem = nil
+				}
 			}
-			c.Add(s.decCodecContext.Free)
+			return ct.JSON(task.redacted())
+		}
 
-			// Update codec context
-			if err = is.CodecParameters().ToCodecContext(s.decCodecContext); err != nil {
-				task.Message = fmt.Sprintf("main: updating codec context failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+		// Stream the output through a pipe FFmpeg writes in
+		p, err := newOutputPipe()
+		if err != nil {
+			task.Status = http.StatusInternalServerError
+			return sendTaskError(ct, task, fmt.Errorf("main: creating output pipe failed: %w", err))
+		}
+
+		// Set up transcoder
+		t, err := newTranscoder(context.Background(), task, p.url(), sp)
+		if err != nil {
+			p.abort(err)
+			return sendTaskError(ct, task, err)
+		}
+
+		// Describe the output in the response headers, its duration being
+		// known once the input is probed
+		info := newOutputInfo(task, t)
+
+		// Describe the pipeline of debugged tasks in a trailer, whose steps
+		// have all ended once the output is read until its end
+		var dr *debugReader
+		if task.Debug {
+			resp := ct.Response()
+			resp.Header.AddTrailer(headerDebug)
+			dr = &debugReader{
+				r:     newDebugReport(task, t),
+				set:   func(v []byte) { resp.Header.SetBytesV(headerDebug, v) },
+				steps: t.steps,
 			}
+		}
 
-			// Set framerate
-			if is.CodecParameters().MediaType() == astiav.MediaTypeVideo {
-				s.decCodecContext.SetFramerate(inputFormatContext.GuessFrameRate(is, nil))
+		// Transcode in the background while the response body is being sent,
+		// errors from now on can only abort the chunked response
+		released = true
+		traced = true
+		go func() {
+			defer pool.release()
+			err := t.run()
+			if err != nil {
+				taskLogger(task).error("main: transcoding failed", "url", task.AudioUrl, "error", err)
 			}
+			t.close()
+			p.closeWrite(err)
+			sp.finish(err)
+		}()
+
+		// Store the output while it's being sent so that it can be downloaded
+		// again, and cached for identical requests
+		var body io.ReadCloser = p
+		if resultRetention > 0 || ck != "" {
+			if rr, err := newStoredOutput(results, outputMediaType(task), info, p); err != nil {
+				requestLogger(ct).error("main: storing output failed", "error", err)
+			} else {
+				if ck != "" {
+					if cacheTTL > rr.ttl {
+						rr.ttl = cacheTTL
+					}
+					rr.committed = func(r *result) { cache.set(ck, r) }
+				}
+				ct.Set(fiber.HeaderContentLocation, "/results/"+rr.r.id)
+				body = rr
+			}
+		}
 
-			// Update channel layout
-			s.decCodecContext.SetChannelLayout(astiav.ChannelLayout(channels2Layout(s.decCodecContext.Channels())))
+		// Success
+		task.Success = true
+		info.setHeaders(ct)
+		if idem != nil {
+			// The entry is finished once the output has been sent
+			idem.contentType = info.contentType
+			body = newIdempotentReader(idempotency, key, idem, body)
+			idem = nil
+		}
+		body = newChecksumReader(ct, body)
+		if dr != nil {
+			dr.ReadCloser = body
+			body = dr
+		}
+		ct.Context().SetBodyStream(body, -1)
+		return nil
+	})
+	app.Post("/speak/probe", func(ct *fiber.Ctx) (err error) {
+		task := new(TranscodeTask)
 
-			// Open codec context
-			if err = s.decCodecContext.Open(s.decCodec, nil); err != nil {
-				task.Message = fmt.Sprintf("main: opening codec context failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
+		// Trace the request, continuing the trace of the caller
+		sp := tracing.startSpan(ct.Get(headerTraceparent), "POST /speak/probe")
+		var probeErr error
+		defer func() { sp.finish(probeErr) }()
 
-			// Alloc frame
-			s.decFrame = astiav.AllocFrame()
-			c.Add(s.decFrame.Free)
+		if err := parseTask(ct, task); err != nil {
+			return sendError(ct, fiber.StatusBadRequest, err)
+		}
+		task.log = requestLogger(ct)
 
-			// Store stream
-			streams[is.Index()] = s
+		// Wait for a free slot in the worker pool
+		if err = pool.acquire(true); err != nil {
+			ct.Set(fiber.HeaderRetryAfter, "1")
+			return sendError(ct, overflowStatus, err)
 		}
+		defer pool.release()
 
-		// Open output file
-		f, err := ioutil.TempFile("", fmt.Sprintf("transcode_*.%s", "wav"))
-		defer os.Remove(f.Name())
-		if err != nil {
-			task.Message = fmt.Sprintf("main: get temp output file failed: %s", err)
-			task.Status = http.StatusBadRequest
-			return ct.JSON(task)
+		// Probe
+		r, probeErr := probeTask(task, sp)
+		if probeErr != nil {
+			requestLogger(ct).error("main: probing failed", "url", task.AudioUrl, "error", probeErr)
+			return sendError(ct, task.Status, probeErr)
 		}
+		return ct.JSON(r)
+	})
+	app.Post("/speak/transcode/validate", func(ct *fiber.Ctx) (err error) {
+		task := new(TranscodeTask)
+
+		// Trace the request, continuing the trace of the caller
+		sp := tracing.startSpan(ct.Get(headerTraceparent), "POST /speak/transcode/validate")
+		var planErr error
+		defer func() { sp.finish(planErr) }()
 
-		mediaType := strings.ToLower(task.MediaType)
-		formatName := ""
-		if strings.ToLower(mediaType) == "raw" {
-			formatName = "data"
+		if err := parseTask(ct, task); err != nil {
+			return sendError(ct, fiber.StatusBadRequest, err)
 		}
+		task.log = requestLogger(ct)
 
-		// Alloc output format context
-		if outputFormatContext, err = astiav.AllocOutputFormatContext(nil, formatName, f.Name()); err != nil {
-			task.Message = fmt.Sprintf("main: allocating output format context failed: %w", err)
-			task.Status = http.StatusBadRequest
-			return ct.JSON(task)
-		} else if outputFormatContext == nil {
-			err = errors.New("main: output format context is nil")
-			task.Status = http.StatusBadRequest
-			return ct.JSON(task)
+		// Prepare task
+		if planErr = prepareTask(task); planErr != nil {
+			return sendTaskError(ct, task, planErr)
 		}
-		c.Add(outputFormatContext.Free)
 
-		// Loop through streams
-		for _, is := range inputFormatContext.Streams() {
-			// Get stream
-			s, ok := streams[is.Index()]
-			if !ok {
-				continue
+		// Apply the restrictions of the api key
+		if planErr = authorizeTask(ct, task); planErr != nil {
+			if errors.Is(planErr, errQuotaExceeded) {
+				return sendQuotaExceeded(ct)
 			}
+			return sendTaskError(ct, task, planErr)
+		}
 
-			// Create output stream
-			if s.outputStream = outputFormatContext.NewStream(nil); s.outputStream == nil {
-				err = errors.New("main: output stream is nil")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
+		// Wait for a free slot in the worker pool
+		if err = pool.acquire(true); err != nil {
+			ct.Set(fiber.HeaderRetryAfter, "1")
+			return sendError(ct, overflowStatus, err)
+		}
+		defer pool.release()
 
-			// Get codec for audio only
-			if s.decCodecContext.MediaType() != astiav.MediaTypeAudio {
-				err = errors.New("main: codec is not audio")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
+		// Set up the transcode without running it
+		p, planErr := planTask(task, sp)
+		if planErr != nil {
+			requestLogger(ct).info("main: validating failed", "url", task.AudioUrl, "error", planErr)
+			return sendTaskError(ct, task, planErr)
+		}
+		return ct.JSON(p)
+	})
+	app.Post("/speak/thumbnail", func(ct *fiber.Ctx) (err error) {
+		r := new(thumbnailRequest)
 
-			encCodec := mediaType
-			if v := supportedEncCodecs[mediaType]; v != "" {
-				encCodec = v
-			}
+		// Trace the request, continuing the trace of the caller
+		sp := tracing.startSpan(ct.Get(headerTraceparent), "POST /speak/thumbnail")
+		var thumbnailErr error
+		defer func() { sp.finish(thumbnailErr) }()
 
-			// Find encoder
-			if s.encCodec = astiav.FindEncoderByName(encCodec); s.encCodec == nil {
-				err = errors.New("main: codec is nil")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
+		if err := ct.BodyParser(r); err != nil {
+			return sendError(ct, fiber.StatusBadRequest, err)
+		}
+		task := &TranscodeTask{
+			AudioUrl: r.AudioUrl,
+			Headers:  r.Headers,
+			Timeout:  r.Timeout,
+			log:      requestLogger(ct),
+		}
 
-			// Alloc codec context
-			if s.encCodecContext = astiav.AllocCodecContext(s.encCodec); s.encCodecContext == nil {
-				err = errors.New("main: codec context is nil")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
-			c.Add(s.encCodecContext.Free)
-
-			// Update codec context
-			if s.decCodecContext.MediaType() == astiav.MediaTypeAudio {
-				channelLayout := astiav.ChannelLayout(channels2Layout(task.Channels))
-				if v := s.encCodec.ChannelLayouts(); len(v) > 0 {
-					result := false
-					for _, x := range v {
-						if x == channelLayout {
-							result = true
-							break
-						}
-					}
-					if !result {
-						err = errors.New("main: codec not support channel layout " + channelLayout.String())
-						task.Status = http.StatusBadRequest
-						return ct.JSON(task)
-					}
-				}
-				s.encCodecContext.SetChannelLayout(channelLayout)
-				s.encCodecContext.SetChannels(task.Channels)
-				s.encCodecContext.SetSampleRate(task.SampleRate)
-
-				sampleFormat := s.decCodecContext.SampleFormat()
-				if v := s.encCodec.SampleFormats(); len(v) > 0 {
-					result := false
-					for _, x := range v {
-						if x == sampleFormat {
-							result = true
-							break
-						}
-					}
-					if !result {
-						sampleFormat = v[0]
-					}
-				}
-				s.encCodecContext.SetSampleFormat(sampleFormat)
-				s.encCodecContext.SetTimeBase(s.decCodecContext.TimeBase())
-			} else {
-				s.encCodecContext.SetHeight(s.decCodecContext.Height())
-				if v := s.encCodec.PixelFormats(); len(v) > 0 {
-					s.encCodecContext.SetPixelFormat(v[0])
-				} else {
-					s.encCodecContext.SetPixelFormat(s.decCodecContext.PixelFormat())
-				}
-				s.encCodecContext.SetSampleAspectRatio(s.decCodecContext.SampleAspectRatio())
-				s.encCodecContext.SetTimeBase(s.decCodecContext.TimeBase())
-				s.encCodecContext.SetWidth(s.decCodecContext.Width())
-			}
+		// Wait for a free slot in the worker pool
+		if err = pool.acquire(true); err != nil {
+			ct.Set(fiber.HeaderRetryAfter, "1")
+			return sendError(ct, overflowStatus, err)
+		}
+		defer pool.release()
+
+		// Extract
+		b, format, thumbnailErr := thumbnailTask(r, task, sp)
+		if thumbnailErr != nil {
+			requestLogger(ct).error("main: extracting thumbnail failed", "url", task.AudioUrl, "error", thumbnailErr)
+			return sendError(ct, task.Status, thumbnailErr)
+		}
+		ct.Set(fiber.HeaderContentType, "image/"+format)
+		return ct.Send(b)
+	})
+	app.Post("/speak/preview", func(ct *fiber.Ctx) (err error) {
+		r := new(previewRequest)
+
+		// Trace the request, continuing the trace of the caller
+		sp := tracing.startSpan(ct.Get(headerTraceparent), "POST /speak/preview")
+		var previewErr error
+		defer func() { sp.finish(previewErr) }()
+
+		if err := ct.BodyParser(r); err != nil {
+			return sendError(ct, fiber.StatusBadRequest, err)
+		}
+		task := &TranscodeTask{
+			AudioUrl: r.AudioUrl,
+			Headers:  r.Headers,
+			Timeout:  r.Timeout,
+			log:      requestLogger(ct),
+		}
+
+		// Wait for a free slot in the worker pool
+		if err = pool.acquire(true); err != nil {
+			ct.Set(fiber.HeaderRetryAfter, "1")
+			return sendError(ct, overflowStatus, err)
+		}
+		defer pool.release()
+
+		// Extract
+		b, contentType, previewErr := previewTask(r, task, sp)
+		if previewErr != nil {
+			requestLogger(ct).error("main: extracting preview failed", "url", task.AudioUrl, "error", previewErr)
+			return sendError(ct, task.Status, previewErr)
+		}
+		ct.Set(fiber.HeaderContentType, contentType)
+		return ct.Send(b)
+	})
+	app.Post("/speak/subtitles", func(ct *fiber.Ctx) (err error) {
+		r := new(subtitlesRequest)
+
+		// Trace the request, continuing the trace of the caller
+		sp := tracing.startSpan(ct.Get(headerTraceparent), "POST /speak/subtitles")
+		var subtitlesErr error
+		defer func() { sp.finish(subtitlesErr) }()
+
+		if err := ct.BodyParser(r); err != nil {
+			return sendError(ct, fiber.StatusBadRequest, err)
+		}
+		task := &TranscodeTask{
+			AudioUrl:    r.AudioUrl,
+			Headers:     r.Headers,
+			Language:    r.Language,
+			StreamIndex: r.StreamIndex,
+			Timeout:     r.Timeout,
+			log:         requestLogger(ct),
+		}
+
+		// Wait for a free slot in the worker pool
+		if err = pool.acquire(true); err != nil {
+			ct.Set(fiber.HeaderRetryAfter, "1")
+			return sendError(ct, overflowStatus, err)
+		}
+		defer pool.release()
 
-			// Update flags
-			if s.decCodecContext.Flags().Has(astiav.CodecContextFlagGlobalHeader) {
-				s.encCodecContext.SetFlags(s.encCodecContext.Flags().Add(astiav.CodecContextFlagGlobalHeader))
+		// Extract
+		b, contentType, subtitlesErr := subtitlesTask(r, task, sp)
+		if subtitlesErr != nil {
+			requestLogger(ct).error("main: extracting subtitles failed", "url", task.AudioUrl, "error", subtitlesErr)
+			return sendError(ct, task.Status, subtitlesErr)
+		}
+		ct.Set(fiber.HeaderContentType, contentType)
+		return ct.Send(b)
+	})
+	app.Post("/speak/waveform", analysisHandler(pool, tracing, "POST /speak/waveform", waveform))
+	app.Post("/speak/silences", analysisHandler(pool, tracing, "POST /speak/silences", silences))
+	app.Post("/speak/fingerprint", analysisHandler(pool, tracing, "POST /speak/fingerprint", fingerprint))
+	app.Post("/speak/clipping", analysisHandler(pool, tracing, "POST /speak/clipping", clipping))
+	app.Post("/speak/checksum", analysisHandler(pool, tracing, "POST /speak/checksum", checksum))
+	app.Post("/speak/generate", func(ct *fiber.Ctx) (err error) {
+		r := new(generateRequest)
+
+		// Trace the request, continuing the trace of the caller
+		sp := tracing.startSpan(ct.Get(headerTraceparent), "POST /speak/generate")
+		var generateErr error
+		traced := false
+		defer func() {
+			if !traced {
+				sp.finish(generateErr)
 			}
+		}()
+
+		if err := ct.BodyParser(r); err != nil {
+			return sendError(ct, fiber.StatusBadRequest, err)
+		}
+		sp.setAttribute("transgode.mediatype", r.MediaType)
 
-			// Open codec context
-			if err = s.encCodecContext.Open(s.encCodec, nil); err != nil {
-				task.Message = fmt.Sprintf("main: opening codec context failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+		// Prepare task and apply the restrictions of the api key
+		task, s, generateErr := prepareSignal(r)
+		task.log = requestLogger(ct)
+		if generateErr == nil {
+			generateErr = authorizeTask(ct, task)
+		}
+		if generateErr != nil {
+			if errors.Is(generateErr, errQuotaExceeded) {
+				return sendQuotaExceeded(ct)
 			}
+			return sendError(ct, task.Status, generateErr)
+		}
 
-			// Update codec parameters
-			if err = s.outputStream.CodecParameters().FromCodecContext(s.encCodecContext); err != nil {
-				task.Message = fmt.Sprintf("main: updating codec parameters failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+		// Wait for a free slot in the worker pool
+		if err = pool.acquire(true); err != nil {
+			ct.Set(fiber.HeaderRetryAfter, "1")
+			return sendError(ct, overflowStatus, err)
+		}
+		released := false
+		defer func() {
+			if !released {
+				pool.release()
 			}
+		}()
 
-			// Update stream
-			s.outputStream.SetTimeBase(s.encCodecContext.TimeBase())
+		// Stream the output through a pipe FFmpeg writes in
+		p, err := newOutputPipe()
+		if err != nil {
+			generateErr = fmt.Errorf("main: creating output pipe failed: %w", err)
+			return sendError(ct, fiber.StatusInternalServerError, generateErr)
 		}
 
-		// If this is a file, we need to use an io context
-		if !outputFormatContext.OutputFormat().Flags().Has(astiav.IOFormatFlagNofile) {
-			// Create io context
-			ioContext := astiav.NewIOContext()
+		// Set up transcoder
+		t, generateErr := newSignalTranscoder(context.Background(), task, s, p.url(), sp)
+		if generateErr != nil {
+			p.abort(generateErr)
+			return sendError(ct, task.Status, generateErr)
+		}
 
-			// Open io context
-			if err = ioContext.Open(f.Name(), astiav.NewIOContextFlags(astiav.IOContextFlagWrite)); err != nil {
-				task.Message = fmt.Sprintf("main: opening io context failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+		// Describe the output in the response headers
+		info := newOutputInfo(task, t)
+
+		// Generate in the background while the response body is being sent
+		released = true
+		traced = true
+		go func() {
+			defer pool.release()
+			err := t.run()
+			if err != nil {
+				taskLogger(task).error("main: generating failed", "type", s.Type, "error", err)
 			}
-			c.AddWithError(ioContext.Closep)
+			t.close()
+			p.closeWrite(err)
+			sp.finish(err)
+		}()
+		info.setHeaders(ct)
+		ct.Context().SetBodyStream(newChecksumReader(ct, p), -1)
+		return nil
+	})
+	app.Get("/speak/transcode/ws", func(ct *fiber.Ctx) (err error) {
+		if !isWebSocketUpgrade(ct) {
+			return sendError(ct, fiber.StatusUpgradeRequired, errWebSocketUpgrade)
+		}
 
-			// Update output format context
-			outputFormatContext.SetPb(ioContext)
+		// Settings are sent in the query since the body is the socket
+		task := &TranscodeTask{
+			MediaType: ct.Query("mediatype"),
+			Timeout:   ct.Query("timeout"),
+			log:       requestLogger(ct),
 		}
+		task.Channels, _ = strconv.Atoi(ct.Query("channels"))
+		task.SampleRate, _ = strconv.Atoi(ct.Query("samplerate"))
 
-		// Write header
-		if err = outputFormatContext.WriteHeader(nil); err != nil {
-			task.Message = fmt.Sprintf("main: writing header failed: %s", err)
+		// Prepare task, packages being only written once transcoded
+		if err = prepareTask(task); err != nil {
+			return sendTaskError(ct, task, err)
+		} else if isPackaged(task.MediaType) {
 			task.Status = http.StatusBadRequest
-			return ct.JSON(task)
+			return sendTaskError(ct, task, fmt.Errorf("main: %s can't be streamed", task.MediaType))
 		}
 
-		// Init filters
-		// Loop through output streams
-		for _, s := range streams {
-			// Alloc graph
-			if s.filterGraph = astiav.AllocFilterGraph(); s.filterGraph == nil {
-				err = errors.New("main: graph is nil")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+		// Apply the restrictions of the api key
+		if err = authorizeTask(ct, task); err != nil {
+			if errors.Is(err, errQuotaExceeded) {
+				return sendQuotaExceeded(ct)
 			}
-			c.Add(s.filterGraph.Free)
-
-			// Alloc outputs
-			outputs := astiav.AllocFilterInOut()
-			if outputs == nil {
-				err = errors.New("main: outputs is nil")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
-			c.Add(outputs.Free)
-
-			// Alloc inputs
-			inputs := astiav.AllocFilterInOut()
-			if inputs == nil {
-				err = errors.New("main: inputs is nil")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+			return sendTaskError(ct, task, err)
+		}
+
+		// Transcode once the connection is upgraded
+		sp := tracing.startSpan(ct.Get(headerTraceparent), "GET /speak/transcode/ws")
+		sp.setAttribute("transgode.mediatype", task.MediaType)
+		return upgradeWebSocket(ct, func(c *wsConn) {
+			err := transcodeWebSocket(c, task, pool, sp)
+			if err != nil {
+				taskLogger(task).error("main: transcoding failed", "error", err)
 			}
-			c.Add(inputs.Free)
-
-			// Support only audio type
-			args := astiav.FilterArgs{
-				"channel_layout": s.decCodecContext.ChannelLayout().String(),
-				"sample_fmt":     s.decCodecContext.SampleFormat().Name(),
-				"sample_rate":    strconv.Itoa(s.decCodecContext.SampleRate()),
-				"time_base":      s.decCodecContext.TimeBase().String(),
+			sp.finish(err)
+		})
+	})
+	app.Post("/speak/transcode/jobs", func(ct *fiber.Ctx) (err error) {
+		task := new(TranscodeTask)
+
+		if err := parseTask(ct, task); err != nil {
+			return sendError(ct, fiber.StatusBadRequest, err)
+		}
+
+		// Prepare task
+		if err = prepareTask(task); err != nil {
+			return sendTaskError(ct, task, err)
+		}
+
+		// Apply the restrictions of the api key
+		if err = authorizeTask(ct, task); err != nil {
+			if errors.Is(err, errQuotaExceeded) {
+				return sendQuotaExceeded(ct)
 			}
-			buffersrc := astiav.FindFilterByName("abuffer")
-			buffersink := astiav.FindFilterByName("abuffersink")
-			content := fmt.Sprintf("aresample=isr=%d:osr=%d:icl=%s:ocl=%s:isf=%s:osf=%s", s.decCodecContext.SampleRate(), s.encCodecContext.SampleRate(), s.decCodecContext.ChannelLayout().String(), s.encCodecContext.ChannelLayout().String(), s.decCodecContext.SampleFormat().Name(), s.encCodecContext.SampleFormat().Name())
-
-			// Check filters
-			if buffersrc == nil {
-				err = errors.New("main: buffersrc is nil")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+			return sendTaskError(ct, task, err)
+		}
+
+		// Deduplicate requests sent with the same idempotency key
+		var idem *idempotencyEntry
+		key := ct.Get(headerIdempotencyKey)
+		if key != "" {
+			key = "jobs:" + requestOwner(ct) + ":" + key
+			e, leader, err := idempotency.begin(key, ct.Body())
+			if err != nil {
+				return sendError(ct, fiber.StatusUnprocessableEntity, err)
 			}
-			if buffersink == nil {
-				err = errors.New("main: buffersink is nil")
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+			if !leader {
+				<-e.done
+				if e.replayable() {
+					ct.Set(headerIdempotentReplayed, "true")
+					return ct.Status(fiber.StatusAccepted).JSON(fiber.Map{
+						"id": e.id,
+					})
+				}
+			} else {
+				idem = e
 			}
+		}
 
-			// Create filter contexts
-			if s.buffersrcContext, err = s.filterGraph.NewFilterContext(buffersrc, "in", args); err != nil {
-				task.Message = fmt.Sprintf("main: creating buffersrc context failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+		// Queue job, its transcode is traced within the trace of the caller
+		sp := tracing.startSpan(ct.Get(headerTraceparent), "POST /speak/transcode/jobs")
+		j := &jobRecord{
+			Owner:       requestOwner(ct),
+			RequestID:   requestID(ct),
+			Task:        task,
+			Traceparent: sp.traceparent(),
+		}
+		err = jobs.add(j)
+		sp.finish(err)
+		if idem != nil {
+			if err == nil {
+				idem.id = j.ID
 			}
-			if s.buffersinkContext, err = s.filterGraph.NewFilterContext(buffersink, "in", nil); err != nil {
-				task.Message = fmt.Sprintf("main: creating buffersink context failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
+			idempotency.finish(key, idem, err == nil)
+		}
+		if err != nil {
+			status := fiber.StatusInternalServerError
+			if errors.Is(err, errJobQueueFull) {
+				ct.Set(fiber.HeaderRetryAfter, "1")
+				status = overflowStatus
 			}
+			return sendError(ct, status, err)
+		}
+		return ct.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"id": j.ID,
+		})
+	})
+	app.Get("/speak/transcode/jobs/:id", func(ct *fiber.Ctx) error {
+		// Get job
+		j, err := jobs.get(ct.Params("id"))
+		if err != nil {
+			return sendError(ct, fiber.StatusInternalServerError, err)
+		} else if j == nil || j.Owner != requestOwner(ct) {
+			return sendError(ct, fiber.StatusNotFound, errJobNotFound)
+		}
+		return ct.JSON(j.status())
+	})
+	app.Get("/speak/transcode/jobs/:id/events", func(ct *fiber.Ctx) error {
+		// Get job
+		j, err := jobs.get(ct.Params("id"))
+		if err != nil {
+			return sendError(ct, fiber.StatusInternalServerError, err)
+		} else if j == nil || j.Owner != requestOwner(ct) {
+			return sendError(ct, fiber.StatusNotFound, errJobNotFound)
+		}
 
-			// Update outputs
-			outputs.SetName("in")
-			outputs.SetFilterContext(s.buffersrcContext)
-			outputs.SetPadIdx(0)
-			outputs.SetNext(nil)
-
-			// Update inputs
-			inputs.SetName("out")
-			inputs.SetFilterContext(s.buffersinkContext)
-			inputs.SetPadIdx(0)
-			inputs.SetNext(nil)
-
-			// Parse
-			if err = s.filterGraph.Parse(content, inputs, outputs); err != nil {
-				task.Message = fmt.Sprintf("main: parsing filter failed: %w", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
+		// Stream its status while the response body is being sent
+		ct.Set(fiber.HeaderContentType, "text/event-stream")
+		ct.Set(fiber.HeaderCacheControl, "no-cache")
+		ct.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			jobs.streamEvents(w, j)
+		})
+		return nil
+	})
+	app.Delete("/speak/transcode/jobs/:id", func(ct *fiber.Ctx) error {
+		// Get job
+		j, err := jobs.get(ct.Params("id"))
+		if err != nil {
+			return sendError(ct, fiber.StatusInternalServerError, err)
+		} else if j == nil || j.Owner != requestOwner(ct) {
+			return sendError(ct, fiber.StatusNotFound, errJobNotFound)
+		}
 
-			// Configure
-			if err = s.filterGraph.Configure(); err != nil {
-				task.Message = fmt.Sprintf("main: configuring filter failed: %w", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
+		// Cancel job, the transcode stops asynchronously
+		canceled, err := jobs.cancel(j)
+		if err != nil {
+			return sendError(ct, fiber.StatusInternalServerError, err)
+		} else if canceled {
+			return ct.Status(fiber.StatusAccepted).JSON(j.status())
+		}
 
-			// Alloc frame
-			s.filterFrame = astiav.AllocFrame()
-			c.Add(s.filterFrame.Free)
+		// Job is finished, remove it
+		if err = jobs.remove(j); err != nil {
+			return sendError(ct, fiber.StatusInternalServerError, err)
+		}
+		return ct.SendStatus(fiber.StatusNoContent)
+	})
+	app.Get("/speak/transcode/jobs/:id/result", func(ct *fiber.Ctx) error {
+		// Get job
+		j, err := jobs.get(ct.Params("id"))
+		if err != nil {
+			return sendError(ct, fiber.StatusInternalServerError, err)
+		} else if j == nil || j.Owner != requestOwner(ct) {
+			return sendError(ct, fiber.StatusNotFound, errJobNotFound)
+		}
 
-			// Alloc packet
-			s.encPkt = astiav.AllocPacket()
-			c.Add(s.encPkt.Free)
+		// Check job is done
+		if !j.finished() {
+			return ct.Status(fiber.StatusAccepted).JSON(fiber.Map{
+				"id":      j.ID,
+				"message": "main: job is not done",
+			})
 		}
 
-		// Alloc packet
-		pkt := astiav.AllocPacket()
-		c.Add(pkt.Free)
+		// Job failed or output uploaded to object storage
+		if j.State != jobStateDone {
+			return ct.Status(j.Task.Status).JSON(j.Task)
+		} else if j.Task.OutputURL != "" {
+			return ct.JSON(j.Task)
+		}
 
-		// Loop through packets
-		for {
-			// Read frame
-			if err := inputFormatContext.ReadFrame(pkt); err != nil {
-				if errors.Is(err, astiav.ErrEof) {
-					break
-				}
-				task.Message = fmt.Sprintf("main: reading frame failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
+		// Output is stored on the instance which ran the job
+		if j.Instance != jobInstance {
+			return ct.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"id":       j.ID,
+				"instance": j.Instance,
+				"message":  "main: job result is stored on another instance",
+			})
+		}
 
-			// Get stream
-			s, ok := streams[pkt.StreamIndex()]
-			if !ok {
-				continue
-			}
+		// Success
+		r := results.get(j.ID)
+		if r == nil {
+			return sendError(ct, fiber.StatusNotFound, errResultNotFound)
+		}
+		return results.send(ct, r)
+	})
+	app.Get("/speak/transcode/usage", func(ct *fiber.Ctx) error {
+		// Get key
+		k := requestAPIKey(ct)
+		if k == nil {
+			return sendError(ct, fiber.StatusNotFound, errAuthDisabled)
+		}
 
-			// Update packet
-			pkt.RescaleTs(s.inputStream.TimeBase(), s.decCodecContext.TimeBase())
+		// Usage
+		m := fiber.Map{
+			"name":    k.Name,
+			"resetAt": nextQuotaReset(time.Now()),
+		}
+		if k.quota != nil {
+			m["quotaMinutes"] = k.QuotaMinutes
+			m["usedMinutes"] = k.quota.usage().Minutes()
+		}
+		if k.RateLimit > 0 {
+			m["rateLimit"] = k.RateLimit
+		}
+		return ct.JSON(m)
+	})
+	app.Get("/results/:id", func(ct *fiber.Ctx) error {
+		// Get result
+		r := results.get(ct.Params("id"))
+		if r == nil {
+			return sendError(ct, fiber.StatusNotFound, errResultNotFound)
+		}
+		return results.send(ct, r)
+	})
 
-			// Send packet
-			if err := s.decCodecContext.SendPacket(pkt); err != nil {
-				task.Message = fmt.Sprintf("main: sending packet failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
+	// Listen
+	ln, err := newListener(listenAddress)
+	if err != nil {
+		rootLogger.fatal("main: creating listener failed", "error", err)
+	}
+	if err = app.Listener(ln); err != nil {
+		rootLogger.fatal("main: serving failed", "error", err)
+	}
+}
 
-			// Loop
-			for {
-				// Receive frame
-				if err := s.decCodecContext.ReceiveFrame(s.decFrame); err != nil {
-					if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
-						break
-					}
-					task.Message = fmt.Sprintf("main: receiving frame failed: %s", err)
-					task.Status = http.StatusBadRequest
-					return ct.JSON(task)
-				}
+// parseTask parses the task of a request from its form, multipart or JSON
+// body. JSON fields are named as form fields, matched case-insensitively, and
+// the fields of the result can't be set.
+func parseTask(ct *fiber.Ctx, task *TranscodeTask) error {
+	if err := ct.BodyParser(task); err != nil {
+		var te *json.UnmarshalTypeError
+		if errors.As(err, &te) && te.Field != "" {
+			return fmt.Errorf("main: %s must be of type %s", strings.ToLower(te.Field), te.Type)
+		}
+		return err
+	}
 
-				// Filter, encode and write frame
-				if err := filterEncodeWriteFrame(s.decFrame, s, outputFormatContext); err != nil {
-					task.Message = fmt.Sprintf("main: filtering, encoding and writing frame failed: %s", err)
-					task.Status = http.StatusBadRequest
-					return ct.JSON(task)
-				}
-			}
+	// Attach the cover art uploaded in a multipart body
+	if fh, err := ct.FormFile("cover"); err == nil {
+		if fh.Size > maxCoverArtSize {
+			return errCoverArtTooLarge
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return err
 		}
+		defer f.Close()
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		task.CoverUrl = dataURL(b)
+	}
 
-		// Loop through streams
-		for _, s := range streams {
-			// Flush filter
-			if err := filterEncodeWriteFrame(nil, s, outputFormatContext); err != nil {
-				task.Message = fmt.Sprintf("main: filtering, encoding and writing frame failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
+	// Reset the fields of the result
+	task.Code = ""
+	task.Errors = nil
+	task.Message = ""
+	task.MaxDuration = 0
+	task.OutputURL = ""
+	task.OutputURLs = nil
+	task.Status = 0
+	task.Success = false
+	task.ValidSampleRates = nil
+	return nil
+}
 
-			// Flush encoder
-			if err := encodeWriteFrame(nil, s, outputFormatContext); err != nil {
-				task.Message = fmt.Sprintf("main: encoding and writing frame failed: %s", err)
-				task.Status = http.StatusBadRequest
-				return ct.JSON(task)
-			}
+// prepareTask applies defaults to the task and checks it can be handled. All
+// the invalid fields are reported at once in a *validationError, values out
+// of range being rejected rather than replaced.
+func prepareTask(task *TranscodeTask) error {
+	var v validator
+
+	// Apply preset
+	if task.Preset != "" && !applyPreset(task) {
+		v.addf("preset", "preset not found: %s", task.Preset)
+	} else if task.Preset != "" {
+		checkPreset(&v, task)
+	}
+
+	// default to stereo
+	if task.Channels == 0 {
+		task.Channels = defaultChannels
+	}
+
+	// default to 44100
+	if task.SampleRate == 0 {
+		task.SampleRate = defaultSampleRate
+	}
+
+	task.Success = false
+	task.Status = http.StatusOK
+	task.Errors = nil
+	task.ValidSampleRates = nil
+
+	// Check inputs
+	if task.AudioUrl != "" {
+		if err := checkInputURL(task, task.AudioUrl); err != nil {
+			v.add("audiourl", err)
+		}
+	}
+	for _, u := range task.Concat {
+		if err := checkInputURL(task, u); err != nil {
+			v.add("concat", err)
+		}
+	}
+	for _, u := range task.Mix {
+		if err := checkInputURL(task, u); err != nil {
+			v.add("mix", err)
 		}
+	}
 
-		// Write trailer
-		if err := outputFormatContext.WriteTrailer(); err != nil {
-			task.Message = fmt.Sprintf("main: writing trailer failed: %s", err)
-			task.Status = http.StatusBadRequest
-			return ct.JSON(task)
+	// support only PCM for now
+	if c := supportedEncCodecs[task.MediaType]; c == "" {
+		v.status = http.StatusUnsupportedMediaType
+		v.addf("mediatype", "codec not supported: %s", task.MediaType)
+	}
+
+	// Check headers before anything is fetched
+	for _, h := range task.Headers {
+		if err := pipeline.CheckHeader(h); err != nil {
+			v.add("headers", err)
 		}
+	}
 
-		// Success
-		task.Success = true
-		return ct.SendFile(f.Name(), true)
-	})
-	app.Listen(":8080")
-}
+	// Check channels and sample rate against what the encoder supports
+	if task.Channels < minChannels || task.Channels > maxChannels {
+		v.addf("channels", "channels out of range: %d", task.Channels)
+	}
+	if task.SampleRate < minSampleRate || task.SampleRate > maxSampleRate {
+		v.addf("samplerate", "sample rate out of range: %d", task.SampleRate)
+	}
+	if task.Encoding != "" {
+		if wavEncodings[task.Encoding] == "" {
+			v.addf("encoding", "encoding not supported: %s", task.Encoding)
+		} else if task.MediaType != "wav" || task.VideoUrl != "" {
+			v.addf("encoding", "encoding is only supported by wav outputs")
+		}
+	}
+	if !v.invalid("mediatype") && !v.invalid("encoding") {
+		checkEncoder(&v, task, outputCodec(task.MediaType, task.Encoding))
+	}
+	if task.BitRate != 0 && !v.invalid("mediatype") {
+		if err := checkBitRate(task.MediaType, task.SampleRate, task.BitRate); err != nil {
+			v.add("bitrate", err)
+		}
+	}
 
-func filterEncodeWriteFrame(f *astiav.Frame, s *stream, outputFormatContext *astiav.FormatContext) (err error) {
-	// Add frame
-	if err = s.buffersrcContext.BuffersrcAddFrame(f, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
-		err = fmt.Errorf("main: adding frame failed: %w", err)
-		return
+	// Check other outputs and cuts
+	if len(task.Outputs) > 0 {
+		checkOutputProfiles(&v, task)
+	}
+	if isCut(task) {
+		checkCuts(&v, task)
 	}
 
-	// Loop
-	for {
-		// Unref frame
-		s.filterFrame.Unref()
+	// Check timeout
+	if err := checkTimeout(task.Timeout); err != nil {
+		v.add("timeout", err)
+	}
 
-		// Get frame
-		if err = s.buffersinkContext.BuffersinkGetFrame(s.filterFrame, astiav.NewBuffersinkFlags()); err != nil {
-			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
-				err = nil
-				break
-			}
-			err = fmt.Errorf("main: getting frame failed: %w", err)
-			return
+	// Check concatenation
+	if len(task.Concat) > maxConcatInputs {
+		v.addf("concat", "more than %d concatenated inputs", maxConcatInputs)
+	}
+	crossfade, err := parseTimeout(task.Crossfade)
+	if err != nil || crossfade < 0 || crossfade > maxFadeDuration {
+		v.addf("crossfade", "invalid crossfade: %s", task.Crossfade)
+	}
+	gap, err := parseTimeout(task.Gap)
+	if err != nil || gap < 0 || gap > maxFadeDuration {
+		v.addf("gap", "invalid gap: %s", task.Gap)
+	}
+	if crossfade > 0 && gap > 0 {
+		v.addf("gap", "crossfade and gap are exclusive")
+	}
+	if len(task.Concat) > 0 && (task.Start != "" || task.Duration != "") {
+		v.addf("concat", "start and duration aren't supported when concatenating")
+	}
+
+	// Check mixing
+	if len(task.Mix) > maxMixInputs {
+		v.addf("mix", "more than %d mixed inputs", maxMixInputs)
+	} else if len(task.MixGain) > len(task.Mix) {
+		v.addf("mixgain", "more mix gains than mixed inputs")
+	} else if len(task.Mix) > 0 && (len(task.Concat) > 0 || task.Start != "" || task.Duration != "") {
+		v.addf("mix", "concat, start and duration aren't supported when mixing")
+	}
+	for _, g := range task.MixGain {
+		if !(g >= -maxGain && g <= maxGain) {
+			v.addf("mixgain", "mix gain out of range: %g", g)
 		}
+	}
 
-		// Reset picture type
-		s.filterFrame.SetPictureType(astiav.PictureTypeNone)
+	// Check threads
+	if task.Threads < 0 || task.Threads > runtime.NumCPU() {
+		v.addf("threads", "threads out of range: %d", task.Threads)
+	}
 
-		// Encode and write frame
-		if err = encodeWriteFrame(s.filterFrame, s, outputFormatContext); err != nil {
-			err = fmt.Errorf("main: encoding and writing frame failed: %w", err)
-			return
+	// Check stream selection
+	if task.StreamIndex != nil && *task.StreamIndex < 0 {
+		v.addf("streamindex", "invalid stream index: %d", *task.StreamIndex)
+	}
+
+	// Check resampler
+	switch task.Resampler {
+	case "", pipeline.ResamplerSWR:
+		if task.Precision != 0 {
+			v.addf("precision", "precision is only supported by the soxr resampler")
+		}
+	case pipeline.ResamplerSoxr:
+		if task.Precision != 0 && (task.Precision < pipeline.MinSoxrPrecision || task.Precision > pipeline.MaxSoxrPrecision) {
+			v.addf("precision", "precision out of range: %d", task.Precision)
 		}
+	default:
+		v.addf("resampler", "resampler not supported: %s", task.Resampler)
 	}
-	return
-}
 
-func encodeWriteFrame(f *astiav.Frame, s *stream, outputFormatContext *astiav.FormatContext) (err error) {
-	// Unref packet
-	s.encPkt.Unref()
+	// Check dither
+	if task.Dither != "" && !pipeline.IsDitherMethod(task.Dither) {
+		v.addf("dither", "dither method not supported: %s", task.Dither)
+	}
 
-	// Send frame
-	if err = s.encCodecContext.SendFrame(f); err != nil {
-		err = fmt.Errorf("main: sending frame failed: %w", err)
-		return
+	// Check range
+	if d, err := parseTimeout(task.Start); err != nil || d < 0 {
+		v.addf("start", "invalid start: %s", task.Start)
+	}
+	if d, err := parseTimeout(task.Duration); err != nil || d < 0 {
+		v.addf("duration", "invalid duration: %s", task.Duration)
 	}
 
-	// Loop
-	for {
-		// Receive packet
-		if err = s.encCodecContext.ReceivePacket(s.encPkt); err != nil {
-			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
-				err = nil
-				break
-			}
-			err = fmt.Errorf("main: receiving packet failed: %w", err)
-			return
+	// Check channel map
+	if task.ChannelMap != "" {
+		if err := pipeline.ValidateChannelMap(task.ChannelMap); err != nil {
+			v.add("channelmap", err)
 		}
+	}
+
+	// Check noise reduction
+	switch task.Denoise {
+	case "", pipeline.DenoiseLight, pipeline.DenoiseMedium, pipeline.DenoiseStrong:
+	default:
+		v.addf("denoise", "denoise strength not supported: %s", task.Denoise)
+	}
+
+	// Check tempo and pitch
+	if task.Tempo != 0 && !(task.Tempo >= pipeline.MinTempo && task.Tempo <= pipeline.MaxTempo) {
+		v.addf("tempo", "tempo out of range: %g", task.Tempo)
+	}
+	if !(task.Pitch >= -pipeline.MaxPitch && task.Pitch <= pipeline.MaxPitch) {
+		v.addf("pitch", "pitch out of range: %g", task.Pitch)
+	}
+
+	// Check frequency shaping
+	if task.HighPass != 0 && !(task.HighPass >= minFilterFrequency && task.HighPass <= maxFilterFrequency) {
+		v.addf("highpass", "cutoff frequency out of range: %g", task.HighPass)
+	}
+	if task.LowPass != 0 && !(task.LowPass >= minFilterFrequency && task.LowPass <= maxFilterFrequency) {
+		v.addf("lowpass", "cutoff frequency out of range: %g", task.LowPass)
+	}
+	if task.HighPass != 0 && task.LowPass != 0 && task.HighPass >= task.LowPass {
+		v.addf("highpass", "high-pass cutoff frequency must be lower than the low-pass one")
+	}
+	if _, err := parseEQBands(task.EQ); err != nil {
+		v.add("eq", err)
+	}
+
+	// Check gain and normalization
+	if !(task.Gain >= -maxGain && task.Gain <= maxGain) {
+		v.addf("gain", "gain out of range: %g", task.Gain)
+	}
+	if task.Normalize != "" && task.Normalize != pipeline.NormalizeEBU && task.Normalize != pipeline.NormalizePeak && task.Normalize != pipeline.NormalizeRMS {
+		v.addf("normalize", "normalization not supported: %s", task.Normalize)
+	}
 
-		// Update pkt
-		s.encPkt.SetStreamIndex(s.outputStream.Index())
-		s.encPkt.RescaleTs(s.encCodecContext.TimeBase(), s.outputStream.TimeBase())
+	// Check fades
+	if d, err := parseTimeout(task.FadeIn); err != nil || d < 0 || d > maxFadeDuration {
+		v.addf("fadein", "invalid fade in: %s", task.FadeIn)
+	}
+	if d, err := parseTimeout(task.FadeOut); err != nil || d < 0 || d > maxFadeDuration {
+		v.addf("fadeout", "invalid fade out: %s", task.FadeOut)
+	}
 
-		// Write frame
-		if err = outputFormatContext.WriteInterleavedFrame(s.encPkt); err != nil {
-			err = fmt.Errorf("main: writing frame failed: %w", err)
-			return
+	// Check padding
+	if d, err := parseTimeout(task.PadStart); err != nil || d < 0 || d > maxPadDuration {
+		v.addf("padstart", "invalid start padding: %s", task.PadStart)
+	}
+	if d, err := parseTimeout(task.PadEnd); err != nil || d < 0 || d > maxPadDuration {
+		v.addf("padend", "invalid end padding: %s", task.PadEnd)
+	}
+
+	// Check custom filter
+	if task.Filter != "" {
+		if len(task.Filter) > maxFilterSize {
+			v.addf("filter", "filter exceeds %d bytes", maxFilterSize)
+		} else if err := pipeline.ValidateFilter(task.Filter, allowedFilters); err != nil {
+			v.add("filter", err)
+		}
+	}
+	if task.FilterMode != "" && task.FilterMode != filterModeAppend && task.FilterMode != filterModeReplace {
+		v.addf("filtermode", "filter mode not supported: %s", task.FilterMode)
+	}
+
+	// Check silence trimming
+	if task.TrimSilence {
+		if task.SilenceThreshold == 0 {
+			task.SilenceThreshold = defaultSilenceThreshold
+		}
+		if !(task.SilenceThreshold >= minSilenceThreshold && task.SilenceThreshold < 0) {
+			v.addf("silencethreshold", "silence threshold out of range: %g", task.SilenceThreshold)
+		}
+		if d, err := parseTimeout(task.SilenceDuration); err != nil || d < 0 || d > maxSilenceDuration {
+			v.addf("silenceduration", "invalid silence duration: %s", task.SilenceDuration)
+		}
+	}
+
+	// Check target duration
+	if d, err := parseTimeout(task.TargetDuration); err != nil || d < 0 || d > maxTargetDuration {
+		v.addf("targetduration", "invalid target duration: %s", task.TargetDuration)
+	}
+	if task.TargetMode != "" && task.TargetMode != targetModePad && task.TargetMode != targetModeLoop {
+		v.addf("targetmode", "target mode not supported: %s", task.TargetMode)
+	}
+
+	// Check packaging
+	if d, err := parseTimeout(task.SegmentDuration); err != nil || (d != 0 && (d < minSegmentDuration || d > maxSegmentDuration)) {
+		v.addf("segmentduration", "invalid segment duration: %s", task.SegmentDuration)
+	}
+	if task.SegmentType != "" && task.SegmentType != segmentTypeMPEGTS && task.SegmentType != segmentTypeFMP4 {
+		v.addf("segmenttype", "segment type not supported: %s", task.SegmentType)
+	}
+	if (task.SegmentDuration != "" || task.SegmentType != "") && !isPackaged(task.MediaType) {
+		v.addf("mediatype", "segments aren't supported by %s", task.MediaType)
+	} else if task.SegmentType != "" && task.MediaType != "hls" {
+		v.addf("segmenttype", "segment type isn't supported by %s", task.MediaType)
+	}
+
+	// Check push url, RTP and SRT pushes having their own encoder
+	if task.PushUrl != "" {
+		if u, err := parsePushURL(task.PushUrl); err != nil {
+			v.add("pushurl", err)
+		} else if isPackaged(task.MediaType) {
+			v.addf("pushurl", "%s can't be pushed", task.MediaType)
+		} else if f, ok := pushFormats[u.Scheme]; ok {
+			checkEncoder(&v, task, f.codec)
+		}
+	}
+
+	// Check low latency, which needs an output written as it's encoded
+	if task.LowLatency {
+		checkLowLatency(&v, task)
+	}
+
+	// Check file name, sent in a header
+	if len(task.FileName) > maxFileNameSize || strings.ContainsAny(task.FileName, "/\\") || strings.IndexFunc(task.FileName, unicode.IsControl) >= 0 {
+		v.addf("filename", "invalid file name: %q", task.FileName)
+	}
+
+	// Check tags, and the Broadcast Wave ones when written
+	if tags, err := parseMetadata(task.Metadata); err != nil {
+		v.add("metadata", err)
+	} else if task.Bext {
+		checkBext(&v, task, tags)
+	}
+
+	// Check chapters, which only MP3 and Matroska outputs have
+	if len(task.Chapters) > 0 {
+		if task.VideoUrl == "" && task.MediaType != "mp3" {
+			v.addf("chapters", "chapters are only supported by mp3 outputs and with videourl")
+		} else if _, err := parseChapters(task.Chapters); err != nil {
+			v.add("chapters", err)
+		}
+	}
+
+	// Check cover art
+	if task.CoverUrl != "" {
+		checkCoverArt(&v, task)
+	}
+
+	// Check output destination
+	if task.OutputDestination != "" {
+		if _, err := parseOutputDestination(task.OutputDestination); err != nil {
+			v.add("outputdestination", err)
+		}
+	}
+	return v.err(task)
+}
+
+// checkEncoder checks the channels and sample rate of the task against what
+// an encoder supports
+func checkEncoder(v *validator, task *TranscodeTask, codec string) {
+	e := astiav.FindEncoderByName(codec)
+	if e == nil {
+		return
+	}
+	if !v.invalid("channels") {
+		if _, err := pipeline.ChannelLayout(task.Channels, e.ChannelLayouts()); err != nil {
+			v.add("channels", err)
+		}
+	}
+	if !v.invalid("samplerate") {
+		if err := pipeline.CheckSampleRate(task.SampleRate, e); err != nil {
+			var se *pipeline.SampleRateError
+			if errors.As(err, &se) {
+				task.ValidSampleRates = se.Supported
+			}
+			v.add("samplerate", err)
 		}
 	}
-	return
 }
 
-func channels2Layout(channels int) uint64 {
-	if channels == 1 {
-		// mono (0x4)
-		return 4
-	} else {
-		// left (0x1) + right (0x2)
-		return 3
+// applyConfig sets the package settings from the configuration
+func applyConfig(c *config.Config) (err error) {
+	// Log levels
+	var ok bool
+	if logLevel, ok = parseLogLevel(c.LogLevel); !ok {
+		return fmt.Errorf("main: invalid log level: %s", c.LogLevel)
+	}
+	ffmpegLevel = ffmpegLogLevel(logLevel)
+	if c.FFmpegLogLevel != "" {
+		if ffmpegLevel, ok = parseFFmpegLogLevel(c.FFmpegLogLevel); !ok {
+			return fmt.Errorf("main: invalid ffmpeg log level: %s", c.FFmpegLogLevel)
+		}
+	}
+
+	// Codecs
+	supportedEncCodecs = make(map[string]string)
+	for _, t := range c.Codecs {
+		v, ok := encCodecs[t]
+		if !ok {
+			return fmt.Errorf("main: codec not supported: %s", t)
+		}
+		supportedEncCodecs[t] = v
 	}
+
+	// Filters
+	allowedFilters = c.Filters
+
+	// Hardware decoding
+	hwAccel = pipeline.HWAccel{Device: c.HWAccelDevice, Type: c.HWAccel}
+	hwDecoders = c.HWDecoders
+
+	// Server
+	listenAddress = c.ListenAddress
+	maxBodySize = c.MaxBodySize
+
+	// gRPC
+	grpcAddress = c.GRPC.Address
+	grpcMaxMessageSize = c.GRPC.MaxMessageSize
+
+	// Authentication
+	apiKeysFile = c.Auth.APIKeysFile
+	defaultQuotaMinutes = c.Auth.DefaultQuotaMinutes
+	defaultRateLimit = c.Auth.DefaultRateLimit
+
+	// Cache
+	cacheTTL = time.Duration(c.Cache.TTL)
+
+	// Idempotency
+	idempotencyMaxSize = c.Idempotency.MaxSize
+	idempotencyTTL = time.Duration(c.Idempotency.TTL)
+
+	// Input
+	inputAllowPrivate = c.Input.AllowPrivate
+	inputAllowedSchemes = c.Input.Schemes
+	inputCacheDir = c.Input.CacheDir
+	if inputCacheDir == "" {
+		inputCacheDir = filepath.Join(c.TempDir, "transgode-inputs")
+	}
+	inputCacheSize = c.Input.CacheSize
+	inputFileRoots = c.Input.FileRoots
+	inputRetries = c.Input.Retries
+	inputRetryBackoff = time.Duration(c.Input.RetryBackoff)
+	maxInputDuration = time.Duration(c.Input.MaxDuration)
+	maxInputSize = c.Input.MaxSize
+	maxInputStreams = c.Input.MaxStreams
+
+	// Jobs
+	jobInstance = c.Jobs.InstanceID
+	jobQueueSize = c.Jobs.QueueSize
+	jobRedisURL = c.Jobs.RedisURL
+	jobRetention = time.Duration(c.Jobs.Retention)
+	jobWorkers = c.Jobs.Workers
+
+	// AMQP
+	amqpDeadLetterExchange = c.AMQP.DeadLetterExchange
+	amqpQueue = c.AMQP.Queue
+	amqpURL = c.AMQP.URL
+
+	// Kafka
+	kafkaBrokers = c.Kafka.Brokers
+	kafkaGroup = c.Kafka.Group
+	kafkaResultTopic = c.Kafka.ResultTopic
+	kafkaTopic = c.Kafka.Topic
+
+	// NATS
+	natsQueue = c.NATS.Queue
+	natsResultSubject = c.NATS.ResultSubject
+	natsSubject = c.NATS.Subject
+	natsURL = c.NATS.URL
+
+	// SQS
+	sqsQueueURL = c.SQS.QueueURL
+
+	// Watch folders
+	for _, f := range c.Watch.Folders {
+		if supportedEncCodecs[f.MediaType] == "" {
+			return fmt.Errorf("main: codec of watch folder %s not supported: %s", f.Dir, f.MediaType)
+		}
+	}
+	watchFolders = c.Watch.Folders
+
+	// Presets, the ones of disabled media types being left out
+	presets = []config.Preset{}
+	for _, p := range c.Presets {
+		if encCodecs[p.MediaType] == "" {
+			return fmt.Errorf("main: codec of preset %s not supported: %s", p.Name, p.MediaType)
+		} else if p.Encoding != "" && (p.MediaType != "wav" || wavEncodings[p.Encoding] == "") {
+			return fmt.Errorf("main: encoding of preset %s not supported: %s", p.Name, p.Encoding)
+		} else if p.HighPass != 0 && (p.HighPass < minFilterFrequency || p.HighPass > maxFilterFrequency) {
+			return fmt.Errorf("main: high-pass cutoff of preset %s out of range: %g", p.Name, p.HighPass)
+		}
+		if p.BitRate != 0 {
+			if err := checkBitRate(p.MediaType, p.SampleRate, p.BitRate); err != nil {
+				return fmt.Errorf("main: bit rate of preset %s not supported: %w", p.Name, err)
+			}
+		}
+		if supportedEncCodecs[p.MediaType] != "" {
+			presets = append(presets, p)
+		}
+	}
+
+	// Parallel decoding
+	parallelMinDuration = time.Duration(c.Parallel.MinDuration)
+	parallelSegments = c.Parallel.Segments
+
+	// Worker pool
+	maxConcurrency = c.Pool.MaxConcurrency
+	maxQueueDepth = c.Pool.MaxQueueDepth
+	overflowStatus = c.Pool.OverflowStatus
+
+	// Results
+	minFreeDisk = c.Results.MinFreeDisk
+	resultDir = c.Results.Dir
+	if resultDir == "" {
+		resultDir = filepath.Join(c.TempDir, "transgode")
+	}
+	resultRetention = time.Duration(c.Results.Retention)
+
+	// Transcodes
+	maxMemory = c.MaxMemory
+	maxOutputSize = c.MaxOutputSize
+	memoryBudget = c.MemoryBudget
+	defaultThreads = c.Threads
+	packageTempDir = c.TempDir
+	transcodeTimeout = time.Duration(c.Timeout)
+
+	// Object storage
+	gcsAccessKeyID = c.Storage.GCSAccessKeyID
+	gcsSecretAccessKey = c.Storage.GCSSecretAccessKey
+	outputBuckets = c.Storage.OutputBuckets
+	s3AccessKeyID = c.Storage.S3AccessKeyID
+	s3Endpoint = c.Storage.S3Endpoint
+	s3Region = c.Storage.S3Region
+	s3SecretAccessKey = c.Storage.S3SecretAccessKey
+	s3SessionToken = c.Storage.S3SessionToken
+
+	// Push
+	pushHosts = c.Push.Hosts
+
+	// TLS
+	tlsCertFile = c.TLS.CertFile
+	tlsClientAuth = c.TLS.ClientAuth
+	tlsClientCAFile = c.TLS.ClientCAFile
+	tlsKeyFile = c.TLS.KeyFile
+	tlsMinVersion = c.TLS.MinVersion
+
+	// Tracing
+	traceEndpoint = c.Tracing.Endpoint
+	traceServiceName = c.Tracing.ServiceName
+	return
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	natsReconnectDelay = 2 * time.Second
+	natsTimeout        = 10 * time.Second
+)
+
+var (
+	natsQueue         string // Queue group shared by the instances
+	natsResultSubject string // Empty doesn't publish completion events
+	natsSubject       string
+	natsURL           string // Empty disables the consumer
+)
+
+// natsConsumer runs the tasks published on the subject. Instances share the
+// queue group so that each message is handled once. Messages delivered by a
+// JetStream push consumer are acknowledged once handled, or negatively
+// acknowledged on transient failures to be delivered again, so that its ack
+// wait and max ack pending settings apply.
+type natsConsumer struct {
+	tc *taskConsumer
+}
+
+func newNATSConsumer(tc *taskConsumer) *natsConsumer {
+	return &natsConsumer{tc: tc}
+}
+
+// start consumes messages in the background, connecting again until the
+// first connection succeeds. The client reconnects and subscribes again by
+// itself afterwards.
+func (c *natsConsumer) start() {
+	go func() {
+		for {
+			if err := c.consume(); err != nil {
+				rootLogger.error("main: consuming nats messages failed", "error", err)
+			}
+			time.Sleep(natsReconnectDelay)
+		}
+	}()
+}
+
+// consume handles messages until the connection is closed. Messages wait for
+// a free slot in the worker pool in their own goroutine so that the
+// connection keeps answering pings, and in-flight ones publish their
+// completion event once the client has reconnected, publishes being buffered
+// meanwhile.
+func (c *natsConsumer) consume() (err error) {
+	// Connect
+	closed := make(chan struct{})
+	var nc *nats.Conn
+	if nc, err = nats.Connect(natsURL,
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				rootLogger.warn("main: nats disconnected", "error", err)
+			}
+		}),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(natsReconnectDelay),
+		nats.Timeout(natsTimeout),
+	); err != nil {
+		return
+	}
+	defer nc.Close()
+
+	// Subscribe
+	if _, err = nc.QueueSubscribe(natsSubject, natsQueue, func(m *nats.Msg) {
+		go c.handle(nc, m)
+	}); err != nil {
+		return
+	}
+	rootLogger.info("main: consuming nats messages", "subject", natsSubject, "queue", natsQueue)
+
+	// Wait for the connection to be closed
+	<-closed
+	return nc.LastError()
+}
+
+// handle runs the task of a message and publishes its completion event
+func (c *natsConsumer) handle(nc *nats.Conn, m *nats.Msg) {
+	// Run task
+	l := rootLogger.with("nats_subject", m.Subject)
+	task, retry := c.tc.handle(m.Data, l, "nats message")
+
+	// Acknowledge JetStream message
+	jetStream := strings.HasPrefix(m.Reply, "$JS.ACK.")
+	if jetStream {
+		var err error
+		if retry {
+			err = m.Nak()
+		} else {
+			err = m.Ack()
+		}
+		if err != nil {
+			l.error("main: acknowledging nats message failed", "error", err)
+		}
+		if retry {
+			return
+		}
+	}
+
+	// Publish completion event, as a reply to requests
+	b, err := json.Marshal(task.redacted())
+	if err != nil {
+		l.error("main: marshaling task failed", "error", err)
+		return
+	}
+	subject := natsResultSubject
+	if m.Reply != "" && !jetStream {
+		subject = m.Reply
+	}
+	if subject == "" {
+		return
+	}
+	if err = nc.Publish(subject, b); err != nil {
+		l.error("main: publishing nats event failed", "error", err)
+	}
+}
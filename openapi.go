@@ -0,0 +1,533 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"example.com/m/config"
+	"example.com/m/pipeline"
+)
+
+// openAPIObject is an object of the OpenAPI document
+type openAPIObject = map[string]interface{}
+
+// swaggerUI is the page of the Swagger UI rendering /openapi.json
+const swaggerUI = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>transgode API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"})</script>
+</body>
+</html>
+`
+
+// openAPISchema returns the JSON schema of a type as marshaled by
+// encoding/json, or as parsed from a form body with the form tags when form is
+// set
+func openAPISchema(t reflect.Type, form bool) openAPIObject {
+	if t == reflect.TypeOf(config.Duration(0)) {
+		// Marshaled as a string, e.g. 4m0s
+		return openAPIObject{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return openAPISchema(t.Elem(), form)
+	case reflect.Bool:
+		return openAPIObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openAPIObject{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return openAPIObject{"type": "number"}
+	case reflect.String:
+		return openAPIObject{"type": "string"}
+	case reflect.Array, reflect.Slice:
+		return openAPIObject{"type": "array", "items": openAPISchema(t.Elem(), form)}
+	case reflect.Map:
+		return openAPIObject{"type": "object", "additionalProperties": openAPISchema(t.Elem(), form)}
+	case reflect.Struct:
+		props := openAPIObject{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if name := openAPIFieldName(f, form); name != "" {
+				props[name] = openAPISchema(f.Type, form)
+			}
+		}
+		return openAPIObject{"type": "object", "properties": props}
+	}
+	return openAPIObject{}
+}
+
+// openAPIFieldName returns the name of a field in JSON, or in a form body when
+// form is set, empty if it's not part of it
+func openAPIFieldName(f reflect.StructField, form bool) string {
+	if form {
+		name := f.Tag.Get("form")
+		if name == "-" {
+			return ""
+		}
+		return name
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	} else if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// openAPIRef references a schema of the components
+func openAPIRef(name string) openAPIObject {
+	return openAPIObject{"$ref": "#/components/schemas/" + name}
+}
+
+// openAPIResponse describes a response, with a JSON body when schema is set
+func openAPIResponse(description string, schema openAPIObject) openAPIObject {
+	r := openAPIObject{"description": description}
+	if schema != nil {
+		r["content"] = openAPIObject{"application/json": openAPIObject{"schema": schema}}
+	}
+	return r
+}
+
+// openAPIOutputResponse describes a response sending an output, or the task
+// when it failed or was uploaded
+func openAPIOutputResponse(description string) openAPIObject {
+	content := openAPIOutputContent()
+	content["application/json"] = openAPIObject{"schema": openAPIRef("TranscodeTask")}
+	return openAPIObject{"description": description, "content": content, "headers": openAPIOutputHeaders()}
+}
+
+// openAPIOutputHeaders describes the headers of responses sending an output
+func openAPIOutputHeaders() openAPIObject {
+	header := func(description, typ string) openAPIObject {
+		return openAPIObject{"description": description, "schema": openAPIObject{"type": typ}}
+	}
+	return openAPIObject{
+		"Content-Disposition": header("Attachment named after filename or the input", "string"),
+		headerAudioChannels:   header("Channels of the output", "integer"),
+		headerAudioDuration:   header("Expected duration of the output in seconds, unless unknown", "number"),
+		headerAudioSampleRate: header("Sample rate of the output", "integer"),
+		headerChecksum:        header("Hex SHA-256 of the output, a trailer when it's streamed", "string"),
+	}
+}
+
+// openAPIOutputContent describes the outputs of the enabled media types
+func openAPIOutputContent() openAPIObject {
+	content := openAPIObject{}
+	for t := range supportedEncCodecs {
+		content[outputContentType(t)] = openAPIObject{"schema": openAPIObject{"type": "string", "format": "binary"}}
+	}
+	return content
+}
+
+// openAPIDocument builds the OpenAPI 3 document of the API from the request
+// and response types, with the media types enabled in this instance
+func openAPIDocument() openAPIObject {
+	// Common objects
+	errorResponse := openAPIResponse("Error", openAPIRef("Error"))
+	taskErrorResponse := openAPIResponse("Failed task", openAPIRef("TranscodeTask"))
+	idParameter := openAPIObject{"name": "id", "in": "path", "required": true, "schema": openAPIObject{"type": "string"}}
+	idempotencyParameter := openAPIObject{"name": headerIdempotencyKey, "in": "header", "schema": openAPIObject{"type": "string"}}
+	taskBody := openAPIObject{"required": true, "content": openAPIObject{
+		"application/json":                  openAPIObject{"schema": openAPIRef("TranscodeRequest")},
+		"application/x-www-form-urlencoded": openAPIObject{"schema": openAPIRef("TranscodeRequest")},
+		"multipart/form-data":               openAPIObject{"schema": openAPIRef("TranscodeRequest")},
+	}}
+	var mediaTypes []string
+	for t := range supportedEncCodecs {
+		mediaTypes = append(mediaTypes, t)
+	}
+	sort.Strings(mediaTypes)
+	presetNames := []string{}
+	for _, p := range presets {
+		presetNames = append(presetNames, p.Name)
+	}
+
+	// Schemas
+	request := openAPISchema(reflect.TypeOf(TranscodeTask{}), true)
+	request["properties"].(openAPIObject)["mediatype"] = openAPIObject{"type": "string", "enum": mediaTypes}
+	request["properties"].(openAPIObject)["filtermode"] = openAPIObject{"type": "string", "enum": []string{filterModeAppend, filterModeReplace}}
+	request["properties"].(openAPIObject)["targetmode"] = openAPIObject{"type": "string", "enum": []string{targetModePad, targetModeLoop}}
+	request["properties"].(openAPIObject)["resampler"] = openAPIObject{"type": "string", "enum": []string{pipeline.ResamplerSWR, pipeline.ResamplerSoxr}}
+	request["properties"].(openAPIObject)["dither"] = openAPIObject{"type": "string", "enum": pipeline.DitherMethods}
+	request["properties"].(openAPIObject)["denoise"] = openAPIObject{"type": "string", "enum": []string{pipeline.DenoiseLight, pipeline.DenoiseMedium, pipeline.DenoiseStrong}}
+	request["properties"].(openAPIObject)["normalize"] = openAPIObject{"type": "string", "enum": []string{pipeline.NormalizeEBU, pipeline.NormalizePeak, pipeline.NormalizeRMS}}
+	request["properties"].(openAPIObject)["segmenttype"] = openAPIObject{"type": "string", "enum": []string{segmentTypeMPEGTS, segmentTypeFMP4}}
+	request["properties"].(openAPIObject)["preset"] = openAPIObject{"type": "string", "enum": presetNames}
+	request["required"] = []string{"audiourl"}
+	probeRequest := openAPIObject{"type": "object", "required": []string{"audiourl"}, "properties": openAPIObject{}}
+	for _, name := range []string{"audiourl", "headers", "timeout"} {
+		probeRequest["properties"].(openAPIObject)[name] = request["properties"].(openAPIObject)[name]
+	}
+	analysisRequest := openAPISchema(reflect.TypeOf(analysisRequest{}), true)
+	analysisRequest["properties"].(openAPIObject)["mode"] = openAPIObject{"type": "string", "enum": []string{pipeline.WaveformMinMax, pipeline.WaveformRMS}}
+	analysisRequest["properties"].(openAPIObject)["algorithm"] = openAPIObject{"type": "string", "enum": []string{checksumMD5, checksumSHA256}}
+	analysisRequest["required"] = []string{"audiourl"}
+	analysisBody := openAPIObject{"required": true, "content": openAPIObject{
+		"application/json":                  openAPIObject{"schema": openAPIRef("AnalysisRequest")},
+		"application/x-www-form-urlencoded": openAPIObject{"schema": openAPIRef("AnalysisRequest")},
+		"multipart/form-data":               openAPIObject{"schema": openAPIRef("AnalysisRequest")},
+	}}
+	generateRequest := openAPISchema(reflect.TypeOf(generateRequest{}), true)
+	generateRequest["properties"].(openAPIObject)["mediatype"] = openAPIObject{"type": "string", "enum": mediaTypes}
+	generateRequest["properties"].(openAPIObject)["type"] = openAPIObject{"type": "string", "enum": []string{pipeline.SignalSine, pipeline.SignalNoise, pipeline.SignalDTMF}}
+	generateRequest["required"] = []string{"mediatype"}
+	thumbnailRequest := openAPISchema(reflect.TypeOf(thumbnailRequest{}), true)
+	thumbnailRequest["properties"].(openAPIObject)["format"] = openAPIObject{"type": "string", "enum": []string{pipeline.ThumbnailJPEG, pipeline.ThumbnailPNG}}
+	thumbnailRequest["required"] = []string{"audiourl"}
+	previewRequest := openAPISchema(reflect.TypeOf(previewRequest{}), true)
+	previewRequest["properties"].(openAPIObject)["format"] = openAPIObject{"type": "string", "enum": []string{pipeline.PreviewGIF, pipeline.PreviewMP4}}
+	previewRequest["required"] = []string{"audiourl"}
+	subtitlesRequest := openAPISchema(reflect.TypeOf(subtitlesRequest{}), true)
+	subtitlesRequest["properties"].(openAPIObject)["format"] = openAPIObject{"type": "string", "enum": []string{pipeline.SubtitleSRT, pipeline.SubtitleWebVTT}}
+	subtitlesRequest["required"] = []string{"audiourl"}
+	schemas := openAPIObject{
+		"AnalysisRequest":  analysisRequest,
+		"Capabilities":     openAPISchema(reflect.TypeOf(capabilities{}), false),
+		"Checksum":         openAPISchema(reflect.TypeOf(checksumResult{}), false),
+		"Clipping":         openAPISchema(reflect.TypeOf(clippingResult{}), false),
+		"Error":            openAPIObject{"type": "object", "properties": openAPIObject{"code": openAPIObject{"type": "string"}, "message": openAPIObject{"type": "string"}}},
+		"Fingerprint":      openAPISchema(reflect.TypeOf(fingerprintResult{}), false),
+		"GenerateRequest":  generateRequest,
+		"JobCreated":       openAPIObject{"type": "object", "properties": openAPIObject{"id": openAPIObject{"type": "string"}}},
+		"JobStatus":        openAPISchema(reflect.TypeOf(jobStatus{}), false),
+		"Preset":           openAPISchema(reflect.TypeOf(config.Preset{}), false),
+		"PreviewRequest":   previewRequest,
+		"ProbeRequest":     probeRequest,
+		"ProbeResult":      openAPISchema(reflect.TypeOf(probeResult{}), false),
+		"SilenceResult":    openAPISchema(reflect.TypeOf(silenceResult{}), false),
+		"SubtitlesRequest": subtitlesRequest,
+		"ThumbnailRequest": thumbnailRequest,
+		"TranscodeRequest": request,
+		"TranscodePlan":    openAPISchema(reflect.TypeOf(transcodePlan{}), false),
+		"TranscodeTask":    openAPISchema(reflect.TypeOf(TranscodeTask{}), false),
+		"Version":          openAPISchema(reflect.TypeOf(versionInfo{}), false),
+		"Usage": openAPIObject{"type": "object", "properties": openAPIObject{
+			"name":         openAPIObject{"type": "string"},
+			"quotaMinutes": openAPIObject{"type": "number"},
+			"rateLimit":    openAPIObject{"type": "integer"},
+			"resetAt":      openAPIObject{"type": "string", "format": "date-time"},
+			"usedMinutes":  openAPIObject{"type": "number"},
+		}},
+		"WaveformResult": openAPISchema(reflect.TypeOf(waveformResult{}), false),
+	}
+
+	// Paths
+	paths := openAPIObject{
+		"/capabilities": openAPIObject{"get": openAPIObject{
+			"summary":   "List the media types, codecs, containers, sample rates, channel counts and sample formats supported",
+			"security":  []openAPIObject{},
+			"responses": openAPIObject{"200": openAPIResponse("Capabilities", openAPIRef("Capabilities"))},
+		}},
+		"/presets": openAPIObject{"get": openAPIObject{
+			"summary":   "List the named presets which can be set instead of the individual options",
+			"security":  []openAPIObject{},
+			"responses": openAPIObject{"200": openAPIResponse("Presets", openAPIObject{"type": "array", "items": openAPIRef("Preset")})},
+		}},
+		"/version": openAPIObject{"get": openAPIObject{
+			"summary":   "Describe the service version and the FFmpeg libraries it's linked with",
+			"security":  []openAPIObject{},
+			"responses": openAPIObject{"200": openAPIResponse("Version", openAPIRef("Version"))},
+		}},
+		"/speak/transcode": openAPIObject{"post": openAPIObject{
+			"summary":     "Transcode an input and stream the output, or upload it to outputdestination",
+			"parameters":  []openAPIObject{idempotencyParameter},
+			"requestBody": taskBody,
+			"responses": openAPIObject{
+				"200": openAPIOutputResponse("Output, or the task when the output was uploaded"),
+				"400": taskErrorResponse,
+				"413": taskErrorResponse,
+				"415": taskErrorResponse,
+				"422": taskErrorResponse,
+				"429": errorResponse,
+				"500": taskErrorResponse,
+				"502": taskErrorResponse,
+				"503": errorResponse,
+				"504": taskErrorResponse,
+				"507": taskErrorResponse,
+			},
+		}},
+		"/speak/transcode/validate": openAPIObject{"post": openAPIObject{
+			"summary":     "Check a transcode request and probe its input, then report the decoder, filter graph, encoder and container of each output without transcoding",
+			"requestBody": taskBody,
+			"responses": openAPIObject{
+				"200": openAPIResponse("What the transcode would do", openAPIRef("TranscodePlan")),
+				"400": taskErrorResponse,
+				"413": taskErrorResponse,
+				"415": taskErrorResponse,
+				"422": taskErrorResponse,
+				"429": errorResponse,
+				"502": taskErrorResponse,
+				"503": errorResponse,
+				"504": taskErrorResponse,
+			},
+		}},
+		"/speak/probe": openAPIObject{"post": openAPIObject{
+			"summary": "Describe the container and streams of an input without transcoding it",
+			"requestBody": openAPIObject{"required": true, "content": openAPIObject{
+				"application/json":                  openAPIObject{"schema": openAPIRef("ProbeRequest")},
+				"application/x-www-form-urlencoded": openAPIObject{"schema": openAPIRef("ProbeRequest")},
+				"multipart/form-data":               openAPIObject{"schema": openAPIRef("ProbeRequest")},
+			}},
+			"responses": openAPIObject{
+				"200": openAPIResponse("Input description", openAPIRef("ProbeResult")),
+				"400": errorResponse,
+				"502": errorResponse,
+				"503": errorResponse,
+				"504": errorResponse,
+			},
+		}},
+		"/speak/thumbnail": openAPIObject{"post": openAPIObject{
+			"summary": "Extract a frame of a video input as a JPEG or PNG image",
+			"requestBody": openAPIObject{"required": true, "content": openAPIObject{
+				"application/json":                  openAPIObject{"schema": openAPIRef("ThumbnailRequest")},
+				"application/x-www-form-urlencoded": openAPIObject{"schema": openAPIRef("ThumbnailRequest")},
+				"multipart/form-data":               openAPIObject{"schema": openAPIRef("ThumbnailRequest")},
+			}},
+			"responses": openAPIObject{
+				"200": openAPIObject{"description": "Image", "content": openAPIObject{
+					"image/jpeg": openAPIObject{"schema": openAPIObject{"type": "string", "format": "binary"}},
+					"image/png":  openAPIObject{"schema": openAPIObject{"type": "string", "format": "binary"}},
+				}},
+				"400": errorResponse,
+				"413": errorResponse,
+				"502": errorResponse,
+				"503": errorResponse,
+				"504": errorResponse,
+			},
+		}},
+		"/speak/preview": openAPIObject{"post": openAPIObject{
+			"summary": "Convert a time range of a video input into a GIF or MP4 preview at a reduced resolution and frame rate",
+			"requestBody": openAPIObject{"required": true, "content": openAPIObject{
+				"application/json":                  openAPIObject{"schema": openAPIRef("PreviewRequest")},
+				"application/x-www-form-urlencoded": openAPIObject{"schema": openAPIRef("PreviewRequest")},
+				"multipart/form-data":               openAPIObject{"schema": openAPIRef("PreviewRequest")},
+			}},
+			"responses": openAPIObject{
+				"200": openAPIObject{"description": "Preview", "content": openAPIObject{
+					"image/gif": openAPIObject{"schema": openAPIObject{"type": "string", "format": "binary"}},
+					"video/mp4": openAPIObject{"schema": openAPIObject{"type": "string", "format": "binary"}},
+				}},
+				"400": errorResponse,
+				"413": errorResponse,
+				"502": errorResponse,
+				"503": errorResponse,
+				"504": errorResponse,
+			},
+		}},
+		"/speak/subtitles": openAPIObject{"post": openAPIObject{
+			"summary": "Extract a subtitle stream of an input as SRT or WebVTT",
+			"requestBody": openAPIObject{"required": true, "content": openAPIObject{
+				"application/json":                  openAPIObject{"schema": openAPIRef("SubtitlesRequest")},
+				"application/x-www-form-urlencoded": openAPIObject{"schema": openAPIRef("SubtitlesRequest")},
+				"multipart/form-data":               openAPIObject{"schema": openAPIRef("SubtitlesRequest")},
+			}},
+			"responses": openAPIObject{
+				"200": openAPIObject{"description": "Subtitles", "content": openAPIObject{
+					"application/x-subrip": openAPIObject{"schema": openAPIObject{"type": "string"}},
+					"text/vtt":             openAPIObject{"schema": openAPIObject{"type": "string"}},
+				}},
+				"400": errorResponse,
+				"413": errorResponse,
+				"422": errorResponse,
+				"502": errorResponse,
+				"503": errorResponse,
+				"504": errorResponse,
+			},
+		}},
+		"/speak/waveform": openAPIObject{"post": openAPIObject{
+			"summary":     "Decode an input into min/max or RMS peaks for rendering its waveform",
+			"requestBody": analysisBody,
+			"responses": openAPIObject{
+				"200": openAPIResponse("Waveform peaks", openAPIRef("WaveformResult")),
+				"400": errorResponse,
+				"413": errorResponse,
+				"429": errorResponse,
+				"502": errorResponse,
+				"503": errorResponse,
+				"504": errorResponse,
+			},
+		}},
+		"/speak/silences": openAPIObject{"post": openAPIObject{
+			"summary":     "Decode an input and list its silent intervals",
+			"requestBody": analysisBody,
+			"responses": openAPIObject{
+				"200": openAPIResponse("Silent intervals", openAPIRef("SilenceResult")),
+				"400": errorResponse,
+				"413": errorResponse,
+				"429": errorResponse,
+				"502": errorResponse,
+				"503": errorResponse,
+				"504": errorResponse,
+			},
+		}},
+		"/speak/fingerprint": openAPIObject{"post": openAPIObject{
+			"summary":     "Decode an input and compute its AcoustID compatible chromaprint fingerprint",
+			"requestBody": analysisBody,
+			"responses": openAPIObject{
+				"200": openAPIResponse("Fingerprint", openAPIRef("Fingerprint")),
+				"400": errorResponse,
+				"413": errorResponse,
+				"429": errorResponse,
+				"501": errorResponse,
+				"502": errorResponse,
+				"503": errorResponse,
+				"504": errorResponse,
+			},
+		}},
+		"/speak/clipping": openAPIObject{"post": openAPIObject{
+			"summary":     "Decode an input and report its clipped samples, sample peak and true peak",
+			"requestBody": analysisBody,
+			"responses": openAPIObject{
+				"200": openAPIResponse("Clipping and peaks", openAPIRef("Clipping")),
+				"400": errorResponse,
+				"413": errorResponse,
+				"429": errorResponse,
+				"502": errorResponse,
+				"503": errorResponse,
+				"504": errorResponse,
+			},
+		}},
+		"/speak/checksum": openAPIObject{"post": openAPIObject{
+			"summary":     "Decode an input and hash its samples as canonical PCM to verify lossless round trips",
+			"requestBody": analysisBody,
+			"responses": openAPIObject{
+				"200": openAPIResponse("Checksum", openAPIRef("Checksum")),
+				"400": errorResponse,
+				"413": errorResponse,
+				"429": errorResponse,
+				"502": errorResponse,
+				"503": errorResponse,
+				"504": errorResponse,
+			},
+		}},
+		"/speak/generate": openAPIObject{"post": openAPIObject{
+			"summary": "Generate a sine, white noise or DTMF test signal and stream it in the output format",
+			"requestBody": openAPIObject{"required": true, "content": openAPIObject{
+				"application/json":                  openAPIObject{"schema": openAPIRef("GenerateRequest")},
+				"application/x-www-form-urlencoded": openAPIObject{"schema": openAPIRef("GenerateRequest")},
+				"multipart/form-data":               openAPIObject{"schema": openAPIRef("GenerateRequest")},
+			}},
+			"responses": openAPIObject{
+				"200": openAPIObject{"description": "Output", "content": openAPIOutputContent(), "headers": openAPIOutputHeaders()},
+				"400": errorResponse,
+				"403": errorResponse,
+				"413": errorResponse,
+				"415": errorResponse,
+				"429": errorResponse,
+				"503": errorResponse,
+			},
+		}},
+		"/speak/transcode/ws": openAPIObject{"get": openAPIObject{
+			"summary": "Transcode input chunks sent on a WebSocket, output chunks are sent back",
+			"parameters": []openAPIObject{
+				{"name": "mediatype", "in": "query", "required": true, "schema": openAPIObject{"type": "string", "enum": mediaTypes}},
+				{"name": "channels", "in": "query", "schema": openAPIObject{"type": "integer"}},
+				{"name": "samplerate", "in": "query", "schema": openAPIObject{"type": "integer"}},
+				{"name": "timeout", "in": "query", "schema": openAPIObject{"type": "string"}},
+			},
+			"responses": openAPIObject{
+				"101": openAPIResponse("WebSocket upgrade", nil),
+				"426": errorResponse,
+			},
+		}},
+		"/speak/transcode/jobs": openAPIObject{"post": openAPIObject{
+			"summary":     "Transcode an input in the background",
+			"parameters":  []openAPIObject{idempotencyParameter},
+			"requestBody": taskBody,
+			"responses": openAPIObject{
+				"200": openAPIResponse("Invalid task", openAPIRef("TranscodeTask")),
+				"202": openAPIResponse("Job created", openAPIRef("JobCreated")),
+				"422": errorResponse,
+				"429": errorResponse,
+				"503": errorResponse,
+			},
+		}},
+		"/speak/transcode/jobs/{id}": openAPIObject{
+			"parameters": []openAPIObject{idParameter},
+			"get": openAPIObject{
+				"summary": "Get the status of a job",
+				"responses": openAPIObject{
+					"200": openAPIResponse("Job status", openAPIRef("JobStatus")),
+					"404": errorResponse,
+				},
+			},
+			"delete": openAPIObject{
+				"summary": "Cancel a queued or running job, or remove a finished one",
+				"responses": openAPIObject{
+					"202": openAPIResponse("Job canceled", openAPIRef("JobStatus")),
+					"204": openAPIResponse("Job removed", nil),
+					"404": errorResponse,
+				},
+			},
+		},
+		"/speak/transcode/jobs/{id}/events": openAPIObject{"get": openAPIObject{
+			"summary":    "Stream the status of a job as Server-Sent Events until it's finished",
+			"parameters": []openAPIObject{idParameter},
+			"responses": openAPIObject{
+				"200": openAPIObject{"description": "Job status events", "content": openAPIObject{"text/event-stream": openAPIObject{"schema": openAPIObject{"type": "string"}}}},
+				"404": errorResponse,
+			},
+		}},
+		"/speak/transcode/jobs/{id}/result": openAPIObject{"get": openAPIObject{
+			"summary":    "Download the output of a job",
+			"parameters": []openAPIObject{idParameter},
+			"responses": openAPIObject{
+				"200": openAPIOutputResponse("Output, or the task when it failed or the output was uploaded"),
+				"202": openAPIResponse("Job is not done", openAPIRef("Error")),
+				"404": errorResponse,
+				"409": openAPIResponse("Output is stored on another instance", openAPIObject{"type": "object", "properties": openAPIObject{
+					"id":       openAPIObject{"type": "string"},
+					"instance": openAPIObject{"type": "string"},
+					"message":  openAPIObject{"type": "string"},
+				}}),
+			},
+		}},
+		"/speak/transcode/usage": openAPIObject{"get": openAPIObject{
+			"summary": "Get the quota and rate limit of the api key",
+			"responses": openAPIObject{
+				"200": openAPIResponse("Usage", openAPIRef("Usage")),
+				"404": errorResponse,
+			},
+		}},
+		"/results/{id}": openAPIObject{"get": openAPIObject{
+			"summary":    "Download a stored output again, with Range and conditional request support",
+			"parameters": []openAPIObject{idParameter},
+			"responses": openAPIObject{
+				"200": openAPIOutputResponse("Output"),
+				"206": openAPIOutputResponse("Range of the output"),
+				"304": openAPIObject{"description": "Not modified since the ETag or date of the request"},
+				"404": errorResponse,
+			},
+		}},
+	}
+
+	// Document
+	doc := openAPIObject{
+		"openapi": "3.0.3",
+		"info":    openAPIObject{"title": "transgode", "version": "1.0"},
+		"paths":   paths,
+		"components": openAPIObject{
+			"schemas": schemas,
+		},
+	}
+	if apiKeysFile != "" {
+		doc["components"].(openAPIObject)["securitySchemes"] = openAPIObject{
+			"apiKey": openAPIObject{"type": "apiKey", "in": "header", "name": headerAPIKey},
+		}
+		doc["security"] = []openAPIObject{{"apiKey": []string{}}}
+	}
+	return doc
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Headers describing the audio of outputs
+const (
+	headerAudioChannels   = "X-Audio-Channels"
+	headerAudioDuration   = "X-Audio-Duration"
+	headerAudioSampleRate = "X-Audio-Sample-Rate"
+)
+
+// headerChecksum is the hex SHA-256 of an output, a trailer of streamed
+// outputs since it's only known once they're sent
+const headerChecksum = "X-Content-Sha256"
+
+// outputPipe streams what FFmpeg writes in the pipe: protocol to a reader, so
+// that the output can be sent in the response body without a temp file
+type outputPipe struct {
+	copied chan struct{}
+	pr     *io.PipeReader
+	pw     *io.PipeWriter
+	r      *os.File
+	w      *os.File
+}
+
+func newOutputPipe() (p *outputPipe, err error) {
+	p = &outputPipe{copied: make(chan struct{})}
+	if p.r, p.w, err = os.Pipe(); err != nil {
+		return
+	}
+	p.pr, p.pw = io.Pipe()
+
+	// Copy from the os pipe to the io pipe so that errors can be reported to the reader
+	go func() {
+		defer close(p.copied)
+		if _, err := io.Copy(p.pw, p.r); err != nil {
+			p.pw.CloseWithError(err)
+		}
+		p.r.Close()
+	}()
+	return
+}
+
+// url returns the url FFmpeg can open to write in the pipe
+func (p *outputPipe) url() string {
+	return fmt.Sprintf("pipe:%d", p.w.Fd())
+}
+
+// Read implements io.Reader
+func (p *outputPipe) Read(b []byte) (int, error) {
+	return p.pr.Read(b)
+}
+
+// Close implements io.Closer. It's called once the body has been sent or the
+// client is gone, in which case FFmpeg writes start failing.
+func (p *outputPipe) Close() error {
+	return p.pr.Close()
+}
+
+// closeWrite must be called once FFmpeg is done writing. A non nil err is
+// returned to the reader instead of io.EOF.
+func (p *outputPipe) closeWrite(err error) {
+	p.w.Close()
+	<-p.copied
+	p.pw.CloseWithError(err)
+}
+
+// abort closes both ends of the pipe when the output won't be read
+func (p *outputPipe) abort(err error) {
+	p.pr.CloseWithError(err)
+	p.closeWrite(err)
+}
+
+// checksumReader hashes the output read from rc and sets the checksum trailer
+// of the response once rc is fully read
+type checksumReader struct {
+	io.ReadCloser
+	h   hash.Hash
+	set func(v []byte) // Nil once the trailer is set
+}
+
+// newChecksumReader declares the checksum trailer of the response streaming
+// the output read from rc
+func newChecksumReader(ct *fiber.Ctx, rc io.ReadCloser) *checksumReader {
+	resp := ct.Response()
+	resp.Header.AddTrailer(headerChecksum)
+	return &checksumReader{
+		ReadCloser: rc,
+		h:          sha256.New(),
+		set:        func(v []byte) { resp.Header.SetBytesV(headerChecksum, v) },
+	}
+}
+
+func (cr *checksumReader) Read(p []byte) (n int, err error) {
+	n, err = cr.ReadCloser.Read(p)
+	cr.h.Write(p[:n])
+	if err == io.EOF && cr.set != nil {
+		cr.set([]byte(hex.EncodeToString(cr.h.Sum(nil))))
+		cr.set = nil
+	}
+	return
+}
+
+// outputMediaType returns the media type of the output of a task, zip when
+// it's packaged or has several outputs and mkv when its audio is muxed into a
+// video
+func outputMediaType(task *TranscodeTask) string {
+	if isPackaged(task.MediaType) || len(task.Outputs) > 0 || isCut(task) {
+		return "zip"
+	}
+	if task.VideoUrl != "" {
+		return "mkv"
+	}
+	return task.MediaType
+}
+
+// outputInfo describes an output in the headers of the responses sending it,
+// so that clients don't have to probe it
+type outputInfo struct {
+	channels    int
+	contentType string
+	duration    time.Duration // 0 if unknown
+	fileName    string
+	sampleRate  int
+}
+
+// newOutputInfo describes the output of a task whose input t has opened
+func newOutputInfo(task *TranscodeTask, t *transcoder) outputInfo {
+	return outputInfo{
+		channels:    task.Channels,
+		contentType: outputContentType(outputMediaType(task)),
+		duration:    outputDuration(task, t.Duration()),
+		fileName:    outputDownloadName(task),
+		sampleRate:  task.SampleRate,
+	}
+}
+
+// setHeaders sets the content type, the file name and the audio headers of a
+// response sending the output
+func (i outputInfo) setHeaders(ct *fiber.Ctx) {
+	ct.Set(fiber.HeaderContentType, i.contentType)
+	if i.fileName != "" {
+		ct.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": i.fileName}))
+	}
+	if i.channels > 0 {
+		ct.Set(headerAudioChannels, strconv.Itoa(i.channels))
+	}
+	if i.duration > 0 {
+		ct.Set(headerAudioDuration, strconv.FormatFloat(i.duration.Seconds(), 'f', 3, 64))
+	}
+	if i.sampleRate > 0 {
+		ct.Set(headerAudioSampleRate, strconv.Itoa(i.sampleRate))
+	}
+}
+
+// checkLowLatency checks the output of a task can be sent as soon as its
+// first frames are encoded: outputs only written once the whole input is
+// transcoded, and filters buffering seconds of audio, would defeat it
+func checkLowLatency(v *validator, task *TranscodeTask) {
+	if isPackaged(task.MediaType) || len(task.Outputs) > 0 || isCut(task) || isRewritten(task) {
+		v.addf("lowlatency", "low latency isn't supported by zipped and mu-law WAV outputs")
+	} else if task.TrimSilence || task.FadeOut != "" || task.Normalize != "" || task.TargetMode == targetModeLoop {
+		v.addf("lowlatency", "low latency isn't supported with trimsilence, fadeout, normalize and targetmode=loop")
+	}
+}
+
+// outputDuration returns the expected duration of the output of a task whose
+// transcoded range lasts probed, 0 if unknown. It's unknown when silence is
+// trimmed, and exact when it has a target duration.
+func outputDuration(task *TranscodeTask, probed time.Duration) time.Duration {
+	if d, _ := parseTimeout(task.TargetDuration); d > 0 {
+		return d
+	} else if probed <= 0 || task.TrimSilence {
+		return 0
+	}
+	d := probed
+	if task.Tempo > 0 {
+		d = time.Duration(float64(d) / task.Tempo)
+	}
+	padStart, _ := parseTimeout(task.PadStart)
+	padEnd, _ := parseTimeout(task.PadEnd)
+	return d + padStart + padEnd
+}
+
+// outputDownloadName returns the file name of the output of a task: its
+// filename, or else the base name of its input, with the extension of the
+// output media type unless it has one
+func outputDownloadName(task *TranscodeTask) string {
+	ext := "." + outputMediaType(task)
+	if task.FileName != "" {
+		if path.Ext(task.FileName) == "" {
+			return task.FileName + ext
+		}
+		return task.FileName
+	}
+	name := "output"
+	if u, err := url.Parse(task.AudioUrl); err == nil {
+		if b := strings.TrimSuffix(path.Base(u.Path), path.Ext(u.Path)); b != "" && b != "." && b != "/" {
+			name = b
+		}
+	}
+	return name + ext
+}
+
+// outputContentType returns the content type of a media type
+func outputContentType(mediaType string) string {
+	switch strings.ToLower(mediaType) {
+	case "mkv":
+		return "video/x-matroska"
+	case "mp3":
+		return "audio/mpeg"
+	case "mulaw":
+		return "audio/basic"
+	case "wav":
+		return "audio/wav"
+	case "dash", "hls", "zip":
+		return "application/zip"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/m/pipeline"
+	"github.com/asticode/go-astiav"
+)
+
+// outputProfile is an output added to the one of a task, encoded from the
+// same decoded audio with its own media type, channels and sample rate
+type outputProfile struct {
+	BitRate     int // In kbps, set by presets or else the one of the task for the same media type
+	Channels    int
+	Encoding    string        // Of wav outputs, set by presets
+	MaxDuration time.Duration // Set by presets, 0 is unlimited
+	MediaType   string
+	Normalize   string
+	SampleRate  int
+}
+
+// parseOutputProfiles parses the outputs a task adds to its own, written as
+// mediatype:samplerate:channels, e.g. wav:16000:1, or as the name of a
+// preset. The sample rate, channels and normalization default to those of
+// the task.
+func parseOutputProfiles(task *TranscodeTask) (ps []outputProfile, err error) {
+	if len(task.Outputs) > maxOutputs {
+		err = fmt.Errorf("main: more than %d outputs", maxOutputs)
+		return
+	}
+	for _, v := range task.Outputs {
+		p := outputProfile{
+			Channels:   task.Channels,
+			Normalize:  task.Normalize,
+			SampleRate: task.SampleRate,
+		}
+		if pr, ok := findPreset(v); ok {
+			// Preset
+			p.MediaType, p.Encoding = pr.MediaType, pr.Encoding
+			p.BitRate, p.MaxDuration = pr.BitRate, time.Duration(pr.MaxDuration)
+			if pr.Channels > 0 {
+				p.Channels = pr.Channels
+			}
+			if pr.SampleRate > 0 {
+				p.SampleRate = pr.SampleRate
+			}
+			if pr.Normalize != "" {
+				p.Normalize = pr.Normalize
+			}
+		} else {
+			// Parse
+			fs := strings.Split(v, ":")
+			if len(fs) > 3 || fs[0] == "" {
+				err = fmt.Errorf("main: invalid output: %s", v)
+				return
+			}
+			p.MediaType = fs[0]
+			var err1, err2 error
+			if len(fs) > 1 && fs[1] != "" {
+				p.SampleRate, err1 = strconv.Atoi(fs[1])
+			}
+			if len(fs) > 2 && fs[2] != "" {
+				p.Channels, err2 = strconv.Atoi(fs[2])
+			}
+			if err1 != nil || err2 != nil {
+				err = fmt.Errorf("main: invalid output: %s", v)
+				return
+			}
+		}
+		if p.BitRate == 0 && p.MediaType == task.MediaType {
+			p.BitRate = task.BitRate
+		}
+		ps = append(ps, p)
+	}
+	return
+}
+
+// checkOutputProfiles checks the outputs a task adds to its own can be
+// encoded. They're written next to it in a zip, so neither packaged media
+// types nor videos and pushes are supported.
+func checkOutputProfiles(v *validator, task *TranscodeTask) {
+	// Check task
+	if isPackaged(task.MediaType) {
+		v.addf("outputs", "outputs aren't supported by %s", task.MediaType)
+	} else if task.VideoUrl != "" || task.PushUrl != "" {
+		v.addf("outputs", "outputs aren't supported with videourl and pushurl")
+	}
+
+	// Check profiles
+	ps, err := parseOutputProfiles(task)
+	if err != nil {
+		v.add("outputs", err)
+		return
+	}
+	for _, p := range ps {
+		if supportedEncCodecs[p.MediaType] == "" {
+			v.addf("outputs", "codec not supported: %s", p.MediaType)
+			continue
+		} else if isPackaged(p.MediaType) {
+			v.addf("outputs", "%s can't be an output", p.MediaType)
+			continue
+		}
+		if p.Channels < minChannels || p.Channels > maxChannels {
+			v.addf("outputs", "channels out of range: %d", p.Channels)
+			continue
+		}
+		if p.SampleRate < minSampleRate || p.SampleRate > maxSampleRate {
+			v.addf("outputs", "sample rate out of range: %d", p.SampleRate)
+			continue
+		}
+
+		// Check encoder
+		e := astiav.FindEncoderByName(outputCodec(p.MediaType, p.Encoding))
+		if e == nil {
+			continue
+		}
+		if _, err := pipeline.ChannelLayout(p.Channels, e.ChannelLayouts()); err != nil {
+			v.add("outputs", err)
+		} else if err := pipeline.CheckSampleRate(p.SampleRate, e); err != nil {
+			v.add("outputs", err)
+		} else if p.BitRate != 0 {
+			if err := checkBitRate(p.MediaType, p.SampleRate, p.BitRate); err != nil {
+				v.add("outputs", err)
+			}
+		}
+	}
+}
+
+// outputFileName returns the name of an output in the zip of a task with
+// several outputs, the one of the task being the first
+func outputFileName(index int, mediaType string) string {
+	return fmt.Sprintf("output%d.%s", index, mediaType)
+}
+
+// uploadOutputs uploads the files of zipped outputs under prefix and returns
+// their object urls, in the order of the outputs
+func uploadOutputs(d *objectDestination, prefix, zipPath string) (objectURLs []string, err error) {
+	// Open zip
+	var zr *zip.ReadCloser
+	if zr, err = zip.OpenReader(zipPath); err != nil {
+		err = fmt.Errorf("main: opening outputs failed: %w", err)
+		return
+	}
+	defer zr.Close()
+
+	// Upload files, which are zipped in order
+	for _, f := range zr.File {
+		var u string
+		if u, err = uploadZipFile(d, prefix+f.Name, f); err != nil {
+			return
+		}
+		objectURLs = append(objectURLs, u)
+	}
+	return
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// packagePlaylists are the playlist, or manifest, file names of the packaged
+// media types, whose muxer writes the playlist and its segments next to each
+// other
+var packagePlaylists = map[string]string{
+	"dash": "manifest.mpd",
+	"hls":  "index.m3u8",
+}
+
+// packageContentTypes are the content types of the files of packages, by
+// extension
+var packageContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".m4s":  "video/iso.segment",
+	".mpd":  "application/dash+xml",
+	".mp4":  "video/mp4",
+	".ts":   "video/mp2t",
+}
+
+// packageTempDir is where the temporary directories of packages are created,
+// empty defaults to the system temp directory
+var packageTempDir string
+
+var errPackageWrite = errors.New("main: writing package failed")
+
+// isPackaged reports whether the output of a media type is a package of
+// segments, written in a temporary directory and zipped once transcoded
+func isPackaged(mediaType string) bool {
+	_, ok := packagePlaylists[mediaType]
+	return ok
+}
+
+// packageMuxerOptions returns the muxer options of the package of a task
+func packageMuxerOptions(task *TranscodeTask) map[string]string {
+	d, _ := parseTimeout(task.SegmentDuration)
+	if d == 0 {
+		d = defaultSegmentDuration
+	}
+	seconds := strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	if task.MediaType == "dash" {
+		return map[string]string{
+			"seg_duration": seconds,
+		}
+	}
+	segmentType := task.SegmentType
+	if segmentType == "" {
+		segmentType = segmentTypeMPEGTS
+	}
+	return map[string]string{
+		"hls_playlist_type": "vod",
+		"hls_segment_type":  segmentType,
+		"hls_time":          seconds,
+	}
+}
+
+// writePackage zips the files of a package directory into url, which is
+// opened by FFmpeg so that it can be a pipe as the outputs of other media
+// types. Files are stored as is since segments are already compressed.
+func writePackage(dir, url string) (err error) {
+	// Open output
+	ioContext := astiav.NewIOContext()
+	if err = ioContext.Open(url, astiav.NewIOContextFlags(astiav.IOContextFlagWrite)); err != nil {
+		return fmt.Errorf("%w: opening io context failed: %v", errPackageWrite, err)
+	}
+	defer func() {
+		if errClose := ioContext.Closep(); errClose != nil && err == nil {
+			err = fmt.Errorf("%w: closing io context failed: %v", errPackageWrite, errClose)
+		}
+	}()
+
+	// List files
+	var es []os.DirEntry
+	if es, err = os.ReadDir(dir); err != nil {
+		return fmt.Errorf("%w: reading directory failed: %v", errPackageWrite, err)
+	}
+
+	// Zip files
+	zw := zip.NewWriter(ioContextWriter{ioContext})
+	for _, e := range es {
+		if err = zipFile(zw, filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("%w: %v", errPackageWrite, err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("%w: closing zip failed: %v", errPackageWrite, err)
+	}
+	return
+}
+
+// copyOutput copies an output file as is into url, opened by FFmpeg as in
+// writePackage
+func copyOutput(p, url string) (err error) {
+	// Open output
+	ioContext := astiav.NewIOContext()
+	if err = ioContext.Open(url, astiav.NewIOContextFlags(astiav.IOContextFlagWrite)); err != nil {
+		return fmt.Errorf("%w: opening io context failed: %v", errPackageWrite, err)
+	}
+	defer func() {
+		if errClose := ioContext.Closep(); errClose != nil && err == nil {
+			err = fmt.Errorf("%w: closing io context failed: %v", errPackageWrite, errClose)
+		}
+	}()
+
+	// Copy file
+	var f *os.File
+	if f, err = os.Open(p); err != nil {
+		return fmt.Errorf("%w: opening %s failed: %v", errPackageWrite, filepath.Base(p), err)
+	}
+	defer f.Close()
+	if _, err = io.Copy(ioContextWriter{ioContext}, f); err != nil {
+		return fmt.Errorf("%w: copying %s failed: %v", errPackageWrite, filepath.Base(p), err)
+	}
+	return
+}
+
+// zipFile stores a file in a zip, named after its base name
+func zipFile(zw *zip.Writer, p string) (err error) {
+	var f *os.File
+	if f, err = os.Open(p); err != nil {
+		return fmt.Errorf("opening %s failed: %w", filepath.Base(p), err)
+	}
+	defer f.Close()
+	var w io.Writer
+	if w, err = zw.CreateHeader(&zip.FileHeader{Name: filepath.Base(p), Method: zip.Store, Modified: time.Now()}); err != nil {
+		return fmt.Errorf("adding %s failed: %w", filepath.Base(p), err)
+	}
+	if _, err = io.Copy(w, f); err != nil {
+		return fmt.Errorf("copying %s failed: %w", filepath.Base(p), err)
+	}
+	return
+}
+
+// ioContextWriter writes in an FFmpeg io context, whose errors are reported
+// when it's closed
+type ioContextWriter struct {
+	*astiav.IOContext
+}
+
+// Write implements io.Writer
+func (w ioContextWriter) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		w.IOContext.Write(b)
+	}
+	return len(b), nil
+}
+
+// uploadPackage uploads the files of a zipped package under prefix and returns
+// the object url of its playlist
+func uploadPackage(d *objectDestination, prefix, zipPath, playlist string) (objectURL string, err error) {
+	// Open package
+	var zr *zip.ReadCloser
+	if zr, err = zip.OpenReader(zipPath); err != nil {
+		err = fmt.Errorf("main: opening package failed: %w", err)
+		return
+	}
+	defer zr.Close()
+
+	// Upload files
+	for _, f := range zr.File {
+		var u string
+		if u, err = uploadZipFile(d, prefix+f.Name, f); err != nil {
+			return
+		}
+		if f.Name == playlist {
+			objectURL = u
+		}
+	}
+	return
+}
+
+// uploadZipFile uploads a file of a zip to key
+func uploadZipFile(d *objectDestination, key string, f *zip.File) (objectURL string, err error) {
+	var rc io.ReadCloser
+	if rc, err = f.Open(); err != nil {
+		err = fmt.Errorf("main: opening %s failed: %w", f.Name, err)
+		return
+	}
+	defer rc.Close()
+	contentType := packageContentTypes[path.Ext(f.Name)]
+	if contentType == "" {
+		contentType = outputContentType(strings.TrimPrefix(path.Ext(f.Name), "."))
+	}
+	return uploadObject(d, key, rc, contentType)
+}
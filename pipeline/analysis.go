@@ -0,0 +1,343 @@
+package pipeline
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Waveform modes
+const (
+	WaveformMinMax = "minmax"
+	WaveformRMS    = "rms"
+)
+
+// Waveform ranges
+const (
+	MaxWaveformResolution = 1000 // Peaks per second
+	MinWaveformResolution = 1
+)
+
+// analyzer converts the decoded audio into planar doubles and passes them to
+// an analysis instead of encoding them
+type analyzer struct {
+	analyze   func(samples [][]float64, sampleRate int) error // Called with nil once the input ends
+	converter *graph
+	frame     *astiav.Frame
+}
+
+// addAnalyzer sets up an analysis of the decoded audio, once filtered by
+// filter if not empty, e.g. aformat=channel_layouts=mono. It must be called
+// after Open and before Run.
+func (t *Transcoder) addAnalyzer(filter string, analyze func(samples [][]float64, sampleRate int) error) (err error) {
+	// Trace step, finished with the error on failure
+	end := t.step("analysis setup")
+	defer func() { end(err) }()
+
+	// Get decoder
+	var d *decoder
+	if d, err = t.analyzedDecoder(); err != nil {
+		return
+	}
+
+	// Create converter
+	a := &analyzer{analyze: analyze}
+	content := "aformat=sample_fmts=dblp"
+	if filter != "" {
+		content = filter + "," + content
+	}
+	if a.converter, err = t.newGraph(content, []string{"in"}, []astiav.FilterArgs{decoderArgs(d)}); err != nil {
+		return
+	}
+	a.frame = t.allocFrame()
+
+	// Store analyzer
+	t.analyses = append(t.analyses, a)
+	return
+}
+
+// analyzedDecoder returns the decoder whose format is the one of the frames
+// written
+func (t *Transcoder) analyzedDecoder() (*decoder, error) {
+	for _, d := range t.decoders {
+		return d, nil
+	}
+	return nil, errors.New("pipeline: no stream to analyze")
+}
+
+// write converts a decoded frame and analyzes it, nil flushes the converter
+// and ends the analysis
+func (a *analyzer) write(f *astiav.Frame) (err error) {
+	// Add frame
+	if err = a.converter.sources[0].BuffersrcAddFrame(f, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
+		err = fmt.Errorf("pipeline: adding frame failed: %w", err)
+		return
+	}
+
+	// Analyze converted frames
+	if err = a.converter.pull(a.frame, func(f *astiav.Frame) error {
+		return a.analyze(frameSamples(f), f.SampleRate())
+	}); err != nil || f != nil {
+		return
+	}
+	return a.analyze(nil, 0)
+}
+
+// Waveform holds the peaks of the decoded audio mixed into mono, each one
+// covering 1/Resolution second. The peaks are filled once Run returns.
+type Waveform struct {
+	MaxPeaks   int    // 0 disables the limit
+	Mode       string // WaveformMinMax or WaveformRMS
+	Resolution int    // Peaks per second between MinWaveformResolution and MaxWaveformResolution
+
+	Max []float32 // Highest sample of each peak, in WaveformMinMax mode
+	Min []float32 // Lowest sample of each peak, in WaveformMinMax mode
+	RMS []float32 // Root mean square of each peak, in WaveformRMS mode
+
+	// Current peak
+	count   int
+	max     float64
+	min     float64
+	samples int64 // Analyzed before the current peak
+	sum     float64
+}
+
+// AddWaveform sets up the extraction of the peaks of the decoded audio into
+// w. It must be called after Open and before Run.
+func (t *Transcoder) AddWaveform(w *Waveform) error {
+	if w.Mode != WaveformMinMax && w.Mode != WaveformRMS {
+		return fmt.Errorf("pipeline: waveform mode not supported: %s", w.Mode)
+	} else if w.Resolution < MinWaveformResolution || w.Resolution > MaxWaveformResolution {
+		return fmt.Errorf("pipeline: waveform resolution out of range: %d", w.Resolution)
+	}
+	return t.addAnalyzer("aformat=channel_layouts=mono", w.analyze)
+}
+
+// analyze adds samples to the current peak, which is stored once the
+// following one starts or the input ends
+func (w *Waveform) analyze(samples [][]float64, sampleRate int) error {
+	if samples == nil {
+		return w.addPeak()
+	}
+	for _, v := range samples[0] {
+		// Store the current peak once this sample is past it
+		if w.count > 0 && (w.samples+int64(w.count))*int64(w.Resolution)/int64(sampleRate) > w.samples*int64(w.Resolution)/int64(sampleRate) {
+			if err := w.addPeak(); err != nil {
+				return err
+			}
+		}
+
+		// Add sample
+		if w.count == 0 || v > w.max {
+			w.max = v
+		}
+		if w.count == 0 || v < w.min {
+			w.min = v
+		}
+		w.sum += v * v
+		w.count++
+	}
+	return nil
+}
+
+// addPeak stores the current peak and starts the next one
+func (w *Waveform) addPeak() error {
+	if w.count == 0 {
+		return nil
+	}
+	if w.Mode == WaveformRMS {
+		w.RMS = append(w.RMS, float32(math.Sqrt(w.sum/float64(w.count))))
+	} else {
+		w.Max = append(w.Max, float32(w.max))
+		w.Min = append(w.Min, float32(w.min))
+	}
+	w.samples += int64(w.count)
+	w.count, w.sum = 0, 0
+	if w.MaxPeaks > 0 && len(w.Max)+len(w.RMS) > w.MaxPeaks {
+		return fmt.Errorf("%w: waveform exceeds %d peaks", ErrOutputLimitExceeded, w.MaxPeaks)
+	}
+	return nil
+}
+
+// Interval is a range of the decoded audio, from the start of the transcoded
+// range
+type Interval struct {
+	End   time.Duration
+	Start time.Duration
+}
+
+// SilenceDetection finds the intervals of the decoded audio whose samples stay
+// under Threshold in all channels for at least Duration, as the silencedetect
+// filter does. The silences are filled once Run returns, the last one ending
+// with the input if it's silent.
+type SilenceDetection struct {
+	Duration  time.Duration
+	Threshold float64 // In dBFS
+
+	Silences []Interval
+
+	// Current silence
+	sampleRate int
+	samples    int64 // Analyzed
+	silent     bool
+	start      int64 // First sample of the current silence
+}
+
+// AddSilenceDetection sets up the detection of the silences of the decoded
+// audio into s. It must be called after Open and before Run.
+func (t *Transcoder) AddSilenceDetection(s *SilenceDetection) error {
+	if s.Duration < 0 {
+		return errors.New("pipeline: negative silence duration")
+	} else if !(s.Threshold < 0) {
+		return fmt.Errorf("pipeline: silence threshold must be negative: %g", s.Threshold)
+	}
+	return t.addAnalyzer("", s.analyze)
+}
+
+// analyze starts a silence at the first sample under the threshold and ends
+// it at the next one above it in any channel
+func (s *SilenceDetection) analyze(samples [][]float64, sampleRate int) error {
+	// End the silence with the input
+	if samples == nil {
+		if s.silent {
+			s.addSilence()
+		}
+		return nil
+	}
+	s.sampleRate = sampleRate
+
+	// Compare samples with the threshold
+	threshold := math.Pow(10, s.Threshold/20)
+	for i := range samples[0] {
+		loud := false
+		for _, c := range samples {
+			if math.Abs(c[i]) >= threshold {
+				loud = true
+				break
+			}
+		}
+		if !loud && !s.silent {
+			s.silent, s.start = true, s.samples
+		} else if loud && s.silent {
+			s.addSilence()
+		}
+		s.samples++
+	}
+	return nil
+}
+
+// addSilence stores the current silence if it lasts long enough
+func (s *SilenceDetection) addSilence() {
+	s.silent = false
+	i := Interval{
+		End:   time.Duration(s.samples) * time.Second / time.Duration(s.sampleRate),
+		Start: time.Duration(s.start) * time.Second / time.Duration(s.sampleRate),
+	}
+	if i.End-i.Start >= s.Duration {
+		s.Silences = append(s.Silences, i)
+	}
+}
+
+// ClippingDetection measures the peaks of the decoded audio and counts its
+// clipped samples, in all channels. The results are filled once Run returns.
+type ClippingDetection struct {
+	ClippedSamples int64   // Samples at full scale or beyond, i.e. ±32767 or more in 16 bits
+	Peak           float64 // Highest absolute sample value, 1 being full scale
+	TruePeak       float64 // Highest absolute value of the signal between samples, 4 times oversampled as ebur128 does
+}
+
+// clippingLevel is the absolute sample value from which a sample is clipped
+const clippingLevel = 32767.0 / 32768
+
+// AddClippingDetection sets up the measure of the peaks of the decoded audio
+// into c. It must be called after Open and before Run.
+func (t *Transcoder) AddClippingDetection(c *ClippingDetection) (err error) {
+	// Get decoder
+	var d *decoder
+	if d, err = t.analyzedDecoder(); err != nil {
+		return
+	}
+
+	// Measure sample peaks
+	if err = t.addAnalyzer("", func(samples [][]float64, sampleRate int) error {
+		for _, ch := range samples {
+			for _, v := range ch {
+				v = math.Abs(v)
+				if v >= clippingLevel {
+					c.ClippedSamples++
+				}
+				if v > c.Peak {
+					c.Peak = v
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+
+	// Measure true peaks
+	return t.addAnalyzer(fmt.Sprintf("aresample=%d", 4*d.codecContext.SampleRate()), func(samples [][]float64, sampleRate int) error {
+		for _, ch := range samples {
+			for _, v := range ch {
+				if v = math.Abs(v); v > c.TruePeak {
+					c.TruePeak = v
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Checksum hashes the decoded audio converted into canonical PCM: signed 32
+// bits little endian samples, interleaved, at the sample rate and with the
+// channels of the input. It's the hash ffmpeg -i <input> -c:a pcm_s32le -f md5
+// computes, so that lossless round trips can be verified.
+type Checksum struct {
+	Hash hash.Hash // e.g. md5.New()
+
+	// Format of the canonical PCM, filled once Run returns
+	Channels   int
+	SampleRate int
+}
+
+// AddChecksum sets up the hash of the decoded audio into c. It must be called
+// after Open and before Run.
+func (t *Transcoder) AddChecksum(c *Checksum) error {
+	var b []byte
+	return t.addAnalyzer("", func(samples [][]float64, sampleRate int) error {
+		// Input has ended
+		if samples == nil {
+			return nil
+		}
+		c.Channels, c.SampleRate = len(samples), sampleRate
+
+		// Interleave samples
+		b = b[:0]
+		for i := range samples[0] {
+			for _, ch := range samples {
+				b = append(b, 0, 0, 0, 0)
+				binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(pcmS32(ch[i])))
+			}
+		}
+		_, err := c.Hash.Write(b)
+		return err
+	})
+}
+
+// pcmS32 converts a sample into a signed 32 bits one, rounded and clipped as
+// FFmpeg does
+func pcmS32(v float64) int32 {
+	v = math.RoundToEven(v * (1 << 31))
+	if v >= math.MaxInt32 {
+		return math.MaxInt32
+	} else if v <= math.MinInt32 {
+		return math.MinInt32
+	}
+	return int32(v)
+}
@@ -0,0 +1,98 @@
+package pipeline
+
+/*
+#cgo pkg-config: libavformat libavutil
+#include <errno.h>
+#include <libavformat/avformat.h>
+#include <libavutil/dict.h>
+#include <libavutil/mem.h>
+#include <stdlib.h>
+
+// add_chapter appends a chapter in milliseconds to a format context, which
+// frees its chapters along with it
+static int add_chapter(AVFormatContext *s, int64_t id, int64_t start, int64_t end, const char *title) {
+	AVChapter *c = av_mallocz(sizeof(*c));
+	if (!c) return AVERROR(ENOMEM);
+	c->id = id;
+	c->time_base = (AVRational){1, 1000};
+	c->start = start;
+	c->end = end;
+	int ret = 0;
+	if (title[0]) ret = av_dict_set(&c->metadata, "title", title, 0);
+	if (ret >= 0) ret = av_dynarray_add_nofree(&s->chapters, (int *)&s->nb_chapters, c);
+	if (ret < 0) {
+		av_dict_free(&c->metadata);
+		av_free(c);
+	}
+	return ret;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Chapter is a chapter of an input or of an output
+type Chapter struct {
+	End   time.Duration
+	Start time.Duration
+	Title string // Empty if none
+}
+
+// inputChapters returns the chapters of an input, which the bindings don't
+// expose
+func inputChapters(fc *astiav.FormatContext) (cs []Chapter) {
+	c := cFormatContext(fc)
+	if c.nb_chapters == 0 {
+		return
+	}
+	key := C.CString("title")
+	defer C.free(unsafe.Pointer(key))
+	for _, ch := range unsafe.Slice(c.chapters, c.nb_chapters) {
+		tb := astiav.NewRational(int(ch.time_base.num), int(ch.time_base.den))
+		cp := Chapter{
+			End:   time.Duration(astiav.RescaleQ(int64(ch.end), tb, astiav.NewRational(1, int(time.Second)))),
+			Start: time.Duration(astiav.RescaleQ(int64(ch.start), tb, astiav.NewRational(1, int(time.Second)))),
+		}
+		if e := C.av_dict_get(ch.metadata, key, nil, 0); e != nil {
+			cp.Title = C.GoString(e.value)
+		}
+		cs = append(cs, cp)
+	}
+	return
+}
+
+// outputChapters returns the chapters of an output: its own, or else those
+// of the input, unless stripped or the output timeline isn't the one of the
+// input, e.g. when it's trimmed, concatenated or time-stretched
+func (t *Transcoder) outputChapters(o Output) []Chapter {
+	switch {
+	case o.Chapters != nil:
+		return o.Chapters
+	case o.StripMetadata || t.in.formatContext == nil || t.concat != nil,
+		t.o.Start > 0 || t.o.Duration > 0,
+		o.Tempo != 0 && o.Tempo != 1,
+		o.PadStart > 0 || o.TrimSilence || o.TargetDuration > 0:
+		return nil
+	}
+	return inputChapters(t.in.formatContext)
+}
+
+// setChapters sets the chapters of an output format context, which muxers
+// supporting chapters, e.g. mp3 or matroska, write along with the header
+func setChapters(fc *astiav.FormatContext, cs []Chapter) error {
+	c := cFormatContext(fc)
+	for i, ch := range cs {
+		title := C.CString(ch.Title)
+		ret := C.add_chapter(c, C.int64_t(i+1), C.int64_t(ch.Start.Milliseconds()), C.int64_t(ch.End.Milliseconds()), title)
+		C.free(unsafe.Pointer(title))
+		if ret < 0 {
+			return fmt.Errorf("pipeline: adding chapter failed: %d", int(ret))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Concat appends inputs to the one opened. They are decoded one after the
+// other once the previous one ends and resampled into the format of the
+// first one.
+type Concat struct {
+	Crossfade time.Duration // Overlap of consecutive inputs faded into each other, exclusive with Gap
+	Gap       time.Duration // Silence inserted between consecutive inputs
+	Gapless   bool          // Trims the encoder priming of lossy inputs whose container doesn't signal it
+	URLs      []string      // Appended in order
+}
+
+// Priming samples lossy encoders prepend, which decoders only skip when the
+// container signals them: the edit lists of MP4 and the info tag of MP3
+// files do, raw AAC in ADTS doesn't. Concatenated chunks otherwise start with
+// a short silence, heard as a gap.
+const (
+	aacPriming = 1024 // FFmpeg and most encoders
+	mp3Priming = 1105 // The encoder delay of LAME and the delay of the decoder
+)
+
+// concatenation joins the decoded inputs into a single stream of frames in
+// the format of the first decoder
+type concatenation struct {
+	converter   *graph   // Resamples the current input, after the tail of the previous one when crossfading
+	first       *decoder // Its stream is freed once the next input is opened
+	frame       *astiav.Frame
+	index       int             // Index of the first stream, keying the outputs
+	next        int             // Index in URLs of the next input
+	offset      time.Duration   // Decoded duration of the previous inputs
+	samples     int64           // Samples written in the outputs
+	tail        []*astiav.Frame // Last converted frames, held back to be crossfaded
+	tailSamples int
+	t           *Transcoder
+}
+
+func newConcatenation(t *Transcoder) (c *concatenation, err error) {
+	// Check options
+	o := t.o.Concat
+	if o.Crossfade < 0 || o.Gap < 0 {
+		err = errors.New("pipeline: negative crossfade or gap")
+		return
+	} else if o.Crossfade > 0 && o.Gap > 0 {
+		err = errors.New("pipeline: crossfade and gap are exclusive")
+		return
+	} else if t.o.Start > 0 || t.o.Duration > 0 {
+		err = errors.New("pipeline: start and duration aren't supported when concatenating")
+		return
+	}
+
+	// Create concatenation
+	c = &concatenation{t: t}
+	c.frame = t.allocFrame()
+	t.c.Add(c.close)
+	return
+}
+
+// nextInput flushes the decoder of the current input, closes it and opens the
+// next concatenated one
+func (t *Transcoder) nextInput() (err error) {
+	// Flush decoder and converter
+	if err = t.flushDecoders(); err != nil {
+		return
+	}
+	if err = t.concat.write(nil); err != nil {
+		return
+	}
+
+	// Trace steps, the current one is finished with the error on failure
+	c := t.concat
+	end := t.step("input open")
+	defer func() { end(err) }()
+
+	// Close input
+	c.offset = t.Position()
+	t.in.close()
+	t.in.lastRead = nil
+	t.decoders = make(map[int]*decoder)
+
+	// Wait for the segment of a split input
+	if t.split != nil {
+		if err = t.split.wait(c.next); err != nil {
+			return
+		}
+	}
+
+	// Open input
+	t.in.url = t.o.Concat.URLs[c.next]
+	c.next++
+	if err = t.in.open(); err != nil {
+		err = withCode(CodeInputOpenFailed, fmt.Errorf("pipeline: opening input failed: %w", err))
+		return
+	}
+	fc := t.in.formatContext
+
+	// Find stream info
+	if err = fc.FindStreamInfo(nil); err != nil {
+		err = fmt.Errorf("pipeline: finding stream info failed: %w", err)
+		return
+	}
+
+	// Check limits
+	if err = t.checkInputLimits(fc); err != nil {
+		return
+	}
+
+	// Set up decoder
+	end(nil)
+	end = t.step("stream setup")
+	var d *decoder
+	if d, err = t.openStream(fc); err != nil {
+		return
+	}
+	return c.open(d)
+}
+
+// open sets up the converter of the decoder of a new input
+func (c *concatenation) open(d *decoder) (err error) {
+	// The first decoder sets the format
+	if c.first == nil {
+		c.first, c.index = d, d.stream.Index()
+	}
+
+	// Build filters, timestamps are reset so that the priming is trimmed from
+	// the first decoded sample
+	content := resampleTo(d, c.first)
+	if n := c.priming(d); n > 0 {
+		content = fmt.Sprintf("asetpts=N/SR/TB,atrim=start_sample=%d,%s", n, content)
+	}
+	names := []string{"in"}
+	args := []astiav.FilterArgs{decoderArgs(d)}
+	crossfade := c.converter != nil && c.t.o.Concat.Crossfade > 0 && len(c.tail) > 0
+	if c.converter != nil && c.t.o.Concat.Gap > 0 {
+		content = fmt.Sprintf("adelay=delays=%d:all=1,%s", c.t.o.Concat.Gap.Milliseconds(), content)
+	} else if crossfade {
+		content = fmt.Sprintf("[in]%s[a];[tail][a]acrossfade=d=%s", content, formatFloat(c.t.o.Concat.Crossfade.Seconds()))
+		names = append(names, "tail")
+		args = append(args, c.args())
+	}
+
+	// Create graph
+	var g *graph
+	if g, err = c.t.newGraph(content, names, args); err != nil {
+		return
+	}
+
+	// Crossfade the tail of the previous input
+	if crossfade {
+		for _, f := range c.tail {
+			err = g.sources[1].BuffersrcAddFrame(f, astiav.NewBuffersrcFlags())
+			f.Free()
+			if err != nil {
+				err = fmt.Errorf("pipeline: adding frame failed: %w", err)
+				return
+			}
+		}
+		c.tail, c.tailSamples = nil, 0
+		if err = g.sources[1].BuffersrcAddFrame(nil, astiav.NewBuffersrcFlags()); err != nil {
+			err = fmt.Errorf("pipeline: adding frame failed: %w", err)
+			return
+		}
+	}
+	c.converter = g
+	return
+}
+
+// priming returns the priming samples of the decoder of the current input
+// which it doesn't skip by itself, when concatenating gaplessly. The mp3
+// demuxer reports the encoder of the info tag, when there's one, in the
+// stream metadata.
+func (c *concatenation) priming(d *decoder) int {
+	if !c.t.o.Concat.Gapless {
+		return 0
+	}
+	switch format, codec := inputFormatName(c.t.in.formatContext.InputFormat()), d.codecContext.CodecID().Name(); {
+	case format == "aac" && codec == "aac":
+		return aacPriming
+	case format == "mp3" && codec == "mp3" && d.stream.Metadata().Get("encoder", nil, 0) == nil:
+		return mp3Priming
+	}
+	return 0
+}
+
+// args returns the arguments of buffer sources of frames in the format of the
+// concatenation, timestamped in samples
+func (c *concatenation) args() astiav.FilterArgs {
+	f := c.first.codecContext
+	return astiav.FilterArgs{
+		"channel_layout": f.ChannelLayout().String(),
+		"sample_fmt":     f.SampleFormat().Name(),
+		"sample_rate":    strconv.Itoa(f.SampleRate()),
+		"time_base":      astiav.NewRational(1, f.SampleRate()).String(),
+	}
+}
+
+// write converts a decoded frame, nil flushes the converter
+func (c *concatenation) write(f *astiav.Frame) (err error) {
+	// Add frame
+	if err = c.converter.sources[0].BuffersrcAddFrame(f, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
+		err = fmt.Errorf("pipeline: adding frame failed: %w", err)
+		return
+	}
+
+	// Write converted frames, unless they're held back to be crossfaded with
+	// the next input
+	return c.converter.pull(c.frame, func(f *astiav.Frame) error {
+		if c.t.o.Concat.Crossfade <= 0 || c.next >= len(c.t.o.Concat.URLs) {
+			return c.emit(f)
+		}
+		return c.hold(f)
+	})
+}
+
+// hold keeps the last frames covering the crossfade, the older ones are
+// written
+func (c *concatenation) hold(f *astiav.Frame) (err error) {
+	// Clone frame
+	h := f.Clone()
+	if h == nil {
+		err = errors.New("pipeline: cloning frame failed")
+		return
+	}
+	h.SetPts(int64(c.tailSamples))
+	c.tail = append(c.tail, h)
+	c.tailSamples += h.NbSamples()
+
+	// Write older frames
+	n := int(c.t.o.Concat.Crossfade.Seconds() * float64(c.first.codecContext.SampleRate()))
+	for len(c.tail) > 0 && c.tailSamples-c.tail[0].NbSamples() >= n {
+		h = c.tail[0]
+		c.tail = c.tail[1:]
+		c.tailSamples -= h.NbSamples()
+		err = c.emit(h)
+		h.Free()
+		if err != nil {
+			return
+		}
+	}
+
+	// Timestamp held frames from the start of the tail
+	var pts int64
+	for _, h := range c.tail {
+		h.SetPts(pts)
+		pts += int64(h.NbSamples())
+	}
+	return
+}
+
+// emit timestamps a converted frame and writes it in the outputs
+func (c *concatenation) emit(f *astiav.Frame) error {
+	f.SetPts(astiav.RescaleQ(c.samples, astiav.NewRational(1, c.first.codecContext.SampleRate()), c.first.codecContext.TimeBase()))
+	c.samples += int64(f.NbSamples())
+	return c.t.write(c.index, f)
+}
+
+// close frees the frames held back for a crossfade that didn't happen
+func (c *concatenation) close() {
+	for _, f := range c.tail {
+		f.Free()
+	}
+	c.tail, c.tailSamples = nil, 0
+}
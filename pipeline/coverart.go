@@ -0,0 +1,78 @@
+package pipeline
+
+/*
+#cgo pkg-config: libavformat libavcodec libavutil
+#include <errno.h>
+#include <libavcodec/avcodec.h>
+#include <libavformat/avformat.h>
+#include <libavutil/dict.h>
+#include <stdlib.h>
+#include <string.h>
+
+// add_attached_pic adds an attached picture stream of a codec to a format
+// context and returns its index, its comment being the picture type of ID3
+static int add_attached_pic(AVFormatContext *s, const char *codec) {
+	const AVCodecDescriptor *d = avcodec_descriptor_get_by_name(codec);
+	if (!d) return AVERROR_INVALIDDATA;
+	AVStream *st = avformat_new_stream(s, NULL);
+	if (!st) return AVERROR(ENOMEM);
+	st->codecpar->codec_type = AVMEDIA_TYPE_VIDEO;
+	st->codecpar->codec_id = d->id;
+	st->disposition |= AV_DISPOSITION_ATTACHED_PIC;
+	st->time_base = (AVRational){1, 90000};
+	int ret = av_dict_set(&st->metadata, "comment", "Cover (front)", 0);
+	return ret < 0 ? ret : st->index;
+}
+
+// write_attached_pic writes the single packet of an attached picture stream,
+// bypassing interleaving since no other packet of the stream follows
+static int write_attached_pic(AVFormatContext *s, int index, const void *data, int size) {
+	AVPacket *pkt = av_packet_alloc();
+	if (!pkt) return AVERROR(ENOMEM);
+	int ret = av_new_packet(pkt, size);
+	if (ret >= 0) {
+		memcpy(pkt->data, data, size);
+		pkt->stream_index = index;
+		pkt->flags |= AV_PKT_FLAG_KEY;
+		pkt->pts = pkt->dts = 0;
+		ret = av_write_frame(s, pkt);
+	}
+	av_packet_free(&pkt);
+	return ret;
+}
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// addCoverArt adds the attached picture stream of an output, e.g. the APIC
+// frame of mp3, which the bindings can't flag, and returns its index. Its
+// packet is written by writeCoverArt once the header is.
+func addCoverArt(fc *astiav.FormatContext, p *Picture) (int, error) {
+	c := cFormatContext(fc)
+	codec := C.CString(p.Codec)
+	defer C.free(unsafe.Pointer(codec))
+	ret := C.add_attached_pic(c, codec)
+	if ret < 0 {
+		return 0, fmt.Errorf("pipeline: adding cover art stream failed: %d", int(ret))
+	}
+	return int(ret), nil
+}
+
+// writeCoverArt writes the picture of the attached picture stream of an
+// output. Muxers such as mp3 hold the audio back until it's written.
+func writeCoverArt(fc *astiav.FormatContext, index int, p *Picture) error {
+	if len(p.Data) == 0 {
+		return errors.New("pipeline: cover art is empty")
+	}
+	c := cFormatContext(fc)
+	if ret := C.write_attached_pic(c, C.int(index), unsafe.Pointer(&p.Data[0]), C.int(len(p.Data))); ret < 0 {
+		return fmt.Errorf("pipeline: writing cover art failed: %d", int(ret))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package pipeline
+
+/*
+#cgo pkg-config: libavformat
+#include <libavformat/avformat.h>
+#include <stdint.h>
+
+// next_demuxer returns the name of the demuxer after the one at i, NULL after
+// the last one
+static const char *next_demuxer(uintptr_t *i) {
+	void *opaque = (void *)*i;
+	const AVInputFormat *f = av_demuxer_iterate(&opaque);
+	*i = (uintptr_t)opaque;
+	return f ? f->name : NULL;
+}
+*/
+import "C"
+import (
+	"strings"
+	"sync"
+)
+
+// Demuxers opening other files or urls named in their input, such as
+// playlists, manifests and scripts
+var fileBlockedDemuxers = map[string]bool{
+	"applehttp":          true,
+	"avisynth":           true,
+	"concat":             true,
+	"dash":               true,
+	"hls":                true,
+	"imf":                true,
+	"rtsp":               true,
+	"sdp":                true,
+	"vapoursynth":        true,
+	"webm_dash_manifest": true,
+}
+
+var fileDemuxers struct {
+	once sync.Once
+	v    string
+}
+
+// fileFormatWhitelist returns the demuxers file inputs restricted by a policy
+// can be opened with, as the format_whitelist option expects them: all the
+// linked ones but fileBlockedDemuxers, which could read files outside of the
+// allowed roots
+func fileFormatWhitelist() string {
+	fileDemuxers.once.Do(func() {
+		var ns []string
+		var i C.uintptr_t
+		for n := C.next_demuxer(&i); n != nil; n = C.next_demuxer(&i) {
+			name := C.GoString(n)
+			blocked := false
+			for _, s := range strings.Split(name, ",") {
+				if fileBlockedDemuxers[s] {
+					blocked = true
+					break
+				}
+			}
+			if !blocked {
+				ns = append(ns, name)
+			}
+		}
+		fileDemuxers.v = strings.Join(ns, ",")
+	})
+	return fileDemuxers.v
+}
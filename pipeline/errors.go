@@ -0,0 +1,65 @@
+package pipeline
+
+import "errors"
+
+// Error codes classifying why a transcode failed, so that callers don't have
+// to parse messages
+const (
+	CodeCanceled             = "CANCELED"
+	CodeDecodeError          = "DECODE_ERROR"
+	CodeEncodeError          = "ENCODE_ERROR"
+	CodeInputLimitExceeded   = "INPUT_LIMIT_EXCEEDED"
+	CodeInputOpenFailed      = "INPUT_OPEN_FAILED"
+	CodeMemoryLimitExceeded  = "MEMORY_LIMIT_EXCEEDED"
+	CodeNoAudioStream        = "NO_AUDIO_STREAM"
+	CodeNoSubtitleStream     = "NO_SUBTITLE_STREAM"
+	CodeOutputLimitExceeded  = "OUTPUT_LIMIT_EXCEEDED"
+	CodeTimeout              = "TIMEOUT"
+	CodeUnsupportedCodec     = "UNSUPPORTED_CODEC"
+	CodeUnsupportedSubtitles = "UNSUPPORTED_SUBTITLE_CODEC"
+)
+
+// codedError classifies the error it wraps with a code
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+
+func (e *codedError) Unwrap() error { return e.err }
+
+// withCode classifies err with code, nil is returned as is
+func withCode(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// ErrorCode returns the code classifying err, empty when it's unknown
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrCanceled):
+		return CodeCanceled
+	case errors.Is(err, ErrTimeout):
+		return CodeTimeout
+	case errors.Is(err, ErrInputLimitExceeded):
+		return CodeInputLimitExceeded
+	case errors.Is(err, ErrMemoryLimitExceeded):
+		return CodeMemoryLimitExceeded
+	case errors.Is(err, ErrOutputLimitExceeded):
+		return CodeOutputLimitExceeded
+	case errors.Is(err, ErrNoAudioStream):
+		return CodeNoAudioStream
+	case errors.Is(err, ErrNoSubtitleStream):
+		return CodeNoSubtitleStream
+	case errors.Is(err, ErrSubtitleCodec):
+		return CodeUnsupportedSubtitles
+	}
+	var e *codedError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package pipeline
+
+/*
+#cgo pkg-config: libavcodec libavformat libavutil
+#include <libavcodec/avcodec.h>
+#include <libavformat/avformat.h>
+#include <libavutil/frame.h>
+
+// supported_sample_rate returns the sample rate at index i of the ones an
+// encoder supports, 0 past the last one or if it supports any
+static int supported_sample_rate(const AVCodec *c, int i) {
+	return c->supported_samplerates ? c->supported_samplerates[i] : 0;
+}
+*/
+import "C"
+import (
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// cFormatContext returns the AVFormatContext of a format context
+func cFormatContext(fc *astiav.FormatContext) *C.AVFormatContext {
+	return (*C.AVFormatContext)(cPointer(unsafe.Pointer(fc)))
+}
+
+// cStream returns the AVStream of a stream
+func cStream(s *astiav.Stream) *C.AVStream {
+	return (*C.AVStream)(cPointer(unsafe.Pointer(s)))
+}
+
+// SampleRates returns the sample rates an encoder supports, nil if it
+// supports any, which the bindings don't expose
+func SampleRates(c *astiav.Codec) (o []int) {
+	if c == nil {
+		return
+	}
+	cc := (*C.AVCodec)(cPointer(unsafe.Pointer(c)))
+	if cc == nil {
+		return
+	}
+	for i := 0; ; i++ {
+		r := C.supported_sample_rate(cc, C.int(i))
+		if r == 0 {
+			return
+		}
+		o = append(o, int(r))
+	}
+}
+
+// inputFormatName returns the short names of the demuxer, which the bindings
+// don't expose
+func inputFormatName(f *astiav.InputFormat) string {
+	if f == nil {
+		return ""
+	}
+	c := (*C.AVInputFormat)(cPointer(unsafe.Pointer(f)))
+	if c == nil {
+		return ""
+	}
+	return C.GoString(c.name)
+}
+
+// frameSamples returns the samples of each channel of a frame of planar
+// doubles. They're read from the planes, since the bindings copy them with
+// the size of all of them.
+func frameSamples(f *astiav.Frame) [][]float64 {
+	c := (*C.AVFrame)(cPointer(unsafe.Pointer(f)))
+	channels := f.ChannelLayout().NbChannels()
+	if channels > len(c.data) {
+		channels = len(c.data)
+	}
+	ss := make([][]float64, channels)
+	for i := range ss {
+		ss[i] = make([]float64, f.NbSamples())
+		copy(ss[i], unsafe.Slice((*float64)(unsafe.Pointer(c.data[i])), f.NbSamples()))
+	}
+	return ss
+}
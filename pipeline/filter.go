@@ -0,0 +1,385 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Normalizations of Output.Normalize
+const (
+	NormalizeEBU  = "ebu"
+	NormalizePeak = "peak"
+	NormalizeRMS  = "rms"
+)
+
+// Resamplers of Output.Resampler
+const (
+	ResamplerSoxr = "soxr"
+	ResamplerSWR  = "swr"
+)
+
+// DitherNone disables dithering
+const DitherNone = "none"
+
+// DitherMethods are the dither methods of Output.Dither, the shibata ones shape
+// the noise out of the most audible frequencies
+var DitherMethods = []string{
+	DitherNone, "rectangular", "triangular", "triangular_hp", "lipshitz", "shibata", "low_shibata",
+	"high_shibata", "f_weighted", "e_weighted", "modified_e_weighted",
+}
+
+// Presets of Output.ChannelMap, for stereo inputs
+const (
+	ChannelMapDownmix = "downmix" // FL+FR to mono
+	ChannelMapLeft    = "left"    // FL only
+	ChannelMapRight   = "right"   // FR only
+	ChannelMapSwap    = "swap"    // FL and FR swapped
+)
+
+// channelMaps are the pan filters of the channel map presets
+var channelMaps = map[string]string{
+	ChannelMapDownmix: "pan=mono|c0=0.5*FL+0.5*FR",
+	ChannelMapLeft:    "pan=mono|c0=FL",
+	ChannelMapRight:   "pan=mono|c0=FR",
+	ChannelMapSwap:    "pan=stereo|FL=FR|FR=FL",
+}
+
+// Strengths of Output.Denoise
+const (
+	DenoiseLight  = "light"
+	DenoiseMedium = "medium"
+	DenoiseStrong = "strong"
+)
+
+// Precision range of the soxr resampler, in bits
+const (
+	MaxSoxrPrecision = 33
+	MinSoxrPrecision = 15
+)
+
+// EQBand is a band of the parametric equalizer
+type EQBand struct {
+	Frequency float64 // Center frequency in Hz
+	Gain      float64 // In dB
+	Q         float64 // Width of the band as a quality factor, 0 defaults to 1
+}
+
+// Tempo and pitch ranges
+const (
+	MaxPitch = 12 // In semitones, both ways
+	MaxTempo = 4
+	MinTempo = 0.25
+)
+
+// filters returns the filters applied to the decoded audio, at sampleRate,
+// before it's resampled into the encoder format
+func (o Output) filters(sampleRate int) (fs []string, err error) {
+	// Map channels, the mapped layout is then converted into the output one
+	// by the final resampling
+	if o.ChannelMap != "" {
+		if err = ValidateChannelMap(o.ChannelMap); err != nil {
+			return
+		}
+		f, ok := channelMaps[o.ChannelMap]
+		if !ok {
+			f = "pan=" + o.ChannelMap
+		}
+		fs = append(fs, f)
+	}
+
+	// Reduce noise first so that it isn't taken for signal by the following
+	// filters, e.g. when trimming silence. afftdn reduces stationary noise
+	// such as hiss and hum by the given dB.
+	switch o.Denoise {
+	case "":
+	case DenoiseLight:
+		fs = append(fs, "afftdn=nr=6")
+	case DenoiseMedium:
+		fs = append(fs, "afftdn=nr=12")
+	case DenoiseStrong:
+		fs = append(fs, "afftdn=nr=24")
+	default:
+		err = fmt.Errorf("pipeline: denoise strength not supported: %s", o.Denoise)
+		return
+	}
+
+	// Trim silence, the end is trimmed by trimming the start of the reversed
+	// audio which needs the whole input to be decoded
+	if o.TrimSilence {
+		trim := fmt.Sprintf("silenceremove=start_periods=1:start_threshold=%sdB:start_duration=%s",
+			formatFloat(o.SilenceThreshold), formatFloat(o.SilenceDuration.Seconds()))
+		fs = append(fs, trim, "areverse", trim, "areverse")
+	}
+
+	// Change tempo and pitch
+	tempo := o.Tempo
+	if tempo == 0 {
+		tempo = 1
+	}
+	if !(tempo >= MinTempo && tempo <= MaxTempo) {
+		err = fmt.Errorf("pipeline: tempo out of range: %g", o.Tempo)
+		return
+	} else if !(o.Pitch >= -MaxPitch && o.Pitch <= MaxPitch) {
+		err = fmt.Errorf("pipeline: pitch out of range: %g", o.Pitch)
+		return
+	}
+	if o.Pitch != 0 {
+		pitch := math.Pow(2, o.Pitch/12)
+		if astiav.FindFilterByName("rubberband") != nil {
+			// rubberband keeps the formants
+			fs = append(fs, fmt.Sprintf("rubberband=tempo=%s:pitch=%s", formatFloat(tempo), formatFloat(pitch)))
+			tempo = 1
+		} else {
+			// Playing faster raises the pitch, the tempo is then brought back
+			fs = append(fs, fmt.Sprintf("asetrate=%d", int(math.Round(float64(sampleRate)*pitch))), fmt.Sprintf("aresample=%d", sampleRate))
+			tempo /= pitch
+		}
+	}
+	fs = append(fs, atempo(tempo)...)
+
+	// Shape frequencies
+	if o.HighPass > 0 {
+		fs = append(fs, "highpass=f="+formatFloat(o.HighPass))
+	}
+	if o.LowPass > 0 {
+		fs = append(fs, "lowpass=f="+formatFloat(o.LowPass))
+	}
+	for _, b := range o.EQ {
+		if !(b.Frequency > 0) || !(b.Q >= 0) {
+			err = fmt.Errorf("pipeline: invalid eq band %s/%s/%s", formatFloat(b.Frequency), formatFloat(b.Gain), formatFloat(b.Q))
+			return
+		}
+		q := b.Q
+		if q == 0 {
+			q = 1
+		}
+		fs = append(fs, fmt.Sprintf("equalizer=f=%s:t=q:w=%s:g=%s", formatFloat(b.Frequency), formatFloat(q), formatFloat(b.Gain)))
+	}
+
+	// Normalize before fading since dynaudnorm would undo fades. It adapts
+	// the gain over time so that the output can be streamed without reading
+	// the whole input first, as loudnorm does in its single pass mode, which
+	// upsamples to 192 kHz and is resampled back.
+	switch o.Normalize {
+	case "":
+	case NormalizeEBU:
+		fs = append(fs, "loudnorm=I=-16:TP=-1.5:LRA=11", fmt.Sprintf("aresample=%d", sampleRate))
+	case NormalizePeak:
+		fs = append(fs, "dynaudnorm=p=0.95")
+	case NormalizeRMS:
+		fs = append(fs, "dynaudnorm=p=0.95:r=0.1")
+	default:
+		err = fmt.Errorf("pipeline: normalization not supported: %s", o.Normalize)
+		return
+	}
+
+	// Gain
+	if o.Gain != 0 {
+		fs = append(fs, "volume="+formatFloat(o.Gain)+"dB")
+	}
+
+	// Fade, timestamps are reset since afade positions fades with them and
+	// the output may not start at 0. The fade out is positioned from the end
+	// by fading in the reversed audio, whose length can't be known before
+	// the whole input is decoded.
+	if o.FadeIn > 0 {
+		fs = append(fs, "asetpts=N/SR/TB", "afade=t=in:d="+formatFloat(o.FadeIn.Seconds()))
+	}
+	if o.FadeOut > 0 {
+		fs = append(fs, "areverse", "asetpts=N/SR/TB", "afade=t=in:d="+formatFloat(o.FadeOut.Seconds()), "areverse")
+	}
+
+	// Pad with silence last, so that it's neither trimmed nor faded
+	if o.PadStart > 0 {
+		fs = append(fs, fmt.Sprintf("adelay=delays=%s:all=1", formatFloat(float64(o.PadStart)/float64(time.Millisecond))))
+	}
+	if o.PadEnd > 0 {
+		fs = append(fs, "apad=pad_dur="+formatFloat(o.PadEnd.Seconds()))
+	}
+	return
+}
+
+// targetFilters returns the filters fitting the resampled audio, at
+// sampleRate, to the target duration. It's looped, buffering up to the target
+// duration, or padded with silence, then what exceeds it is cut.
+func (o Output) targetFilters(sampleRate int) (fs []string) {
+	if o.TargetDuration <= 0 {
+		return
+	}
+	n := int64(math.Round(o.TargetDuration.Seconds() * float64(sampleRate)))
+	if o.Loop {
+		fs = append(fs, fmt.Sprintf("aloop=loop=-1:size=%d", n))
+	} else {
+		fs = append(fs, fmt.Sprintf("apad=whole_len=%d", n))
+	}
+	return append(fs, fmt.Sprintf("atrim=end_sample=%d", n))
+}
+
+// customFilters returns the filters applied to the decoded audio, the custom
+// chain is appended to the generated filters or replaces them
+func (o Output) customFilters(sampleRate int) (fs []string, err error) {
+	if o.Filter != "" && o.ReplaceFilters {
+		return []string{o.Filter}, nil
+	}
+	if fs, err = o.filters(sampleRate); err != nil {
+		return
+	}
+	if o.Filter != "" {
+		fs = append(fs, o.Filter)
+	}
+	return
+}
+
+// ValidateFilter checks a custom filter chain, such as
+// "highpass=f=300,lowpass=f=3400", only uses allowed filters. It must be a
+// single chain: several chains and link labels are rejected so that it has
+// exactly one input and one output.
+func ValidateFilter(chain string, allowed []string) error {
+	names, err := filterNames(chain)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		ok := false
+		for _, a := range allowed {
+			if n == a {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("pipeline: filter not allowed: %s", n)
+		}
+	}
+	return nil
+}
+
+// filterNames returns the names of the filters of a chain, splitting it the
+// way the filtergraph parser does: quoted and escaped characters don't
+// separate filters
+func filterNames(chain string) (names []string, err error) {
+	var quoted, escaped bool
+	start := 0
+	for i := 0; i <= len(chain); i++ {
+		// Skip quoted and escaped characters
+		if i < len(chain) {
+			c := chain[i]
+			if escaped {
+				escaped = false
+				continue
+			} else if c == '\\' {
+				escaped = true
+				continue
+			} else if c == '\'' {
+				quoted = !quoted
+				continue
+			} else if quoted {
+				continue
+			} else if c == ';' || c == '[' || c == ']' {
+				return nil, errors.New("pipeline: filter must be a single chain without labels")
+			} else if c != ',' {
+				continue
+			}
+		} else if quoted || escaped {
+			return nil, errors.New("pipeline: filter has an unterminated quote or escape")
+		}
+
+		// Get name of the filter, without its instance name
+		f := strings.TrimSpace(chain[start:i])
+		start = i + 1
+		name := strings.TrimSpace(strings.SplitN(strings.SplitN(f, "=", 2)[0], "@", 2)[0])
+		if name == "" {
+			return nil, errors.New("pipeline: filter chain has an empty filter")
+		}
+		names = append(names, name)
+	}
+	return
+}
+
+// ValidateChannelMap checks a channel map is a preset or a pan filter matrix,
+// such as "mono|c0=0.7*FL+0.3*FR", that can't escape the pan filter
+func ValidateChannelMap(m string) error {
+	if _, ok := channelMaps[m]; ok {
+		return nil
+	}
+	ps := strings.Split(m, "|")
+	if len(ps) < 2 || ps[0] == "" {
+		return fmt.Errorf("pipeline: invalid channel map: %s", m)
+	}
+	for _, c := range m {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("|=<+-*. ", c)) {
+			return fmt.Errorf("pipeline: invalid channel map: %s", m)
+		}
+	}
+	return nil
+}
+
+// resampleOptions returns the options of the aresample filter converting the
+// audio into the encoder format
+func (o Output) resampleOptions() (opts string, err error) {
+	switch o.Resampler {
+	case "", ResamplerSWR:
+		if o.ResamplerPrecision != 0 {
+			err = errors.New("pipeline: precision is only supported by the soxr resampler")
+			return
+		}
+	case ResamplerSoxr:
+		opts = ":resampler=soxr"
+		if o.ResamplerPrecision != 0 {
+			if o.ResamplerPrecision < MinSoxrPrecision || o.ResamplerPrecision > MaxSoxrPrecision {
+				err = fmt.Errorf("pipeline: precision out of range: %d", o.ResamplerPrecision)
+				return
+			}
+			opts += ":precision=" + strconv.Itoa(o.ResamplerPrecision)
+		}
+	default:
+		err = fmt.Errorf("pipeline: resampler not supported: %s", o.Resampler)
+		return
+	}
+
+	// Dither, only used when reducing the bit depth, e.g. from float or 24-bit
+	// to 16-bit
+	if o.Dither != "" && o.Dither != DitherNone {
+		if !IsDitherMethod(o.Dither) {
+			err = fmt.Errorf("pipeline: dither method not supported: %s", o.Dither)
+			return
+		}
+		opts += ":dither_method=" + o.Dither
+	}
+	return
+}
+
+// IsDitherMethod reports whether m is one of DitherMethods
+func IsDitherMethod(m string) bool {
+	for _, v := range DitherMethods {
+		if m == v {
+			return true
+		}
+	}
+	return false
+}
+
+// atempo returns the atempo filters changing the tempo by ratio, they are
+// chained since each of them is limited to the 0.5-2 range
+func atempo(ratio float64) (fs []string) {
+	for ; ratio > 2; ratio /= 2 {
+		fs = append(fs, "atempo=2")
+	}
+	for ; ratio < 0.5; ratio /= 0.5 {
+		fs = append(fs, "atempo=0.5")
+	}
+	if math.Abs(ratio-1) > 1e-9 {
+		fs = append(fs, "atempo="+formatFloat(ratio))
+	}
+	return
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
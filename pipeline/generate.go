@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Types of test signals
+const (
+	SignalDTMF  = "dtmf"
+	SignalNoise = "noise"
+	SignalSine  = "sine"
+)
+
+// DTMFDigits are the digits DTMF signals can be made of
+const DTMFDigits = "0123456789ABCD*#"
+
+// Signal describes a test signal generated by FFmpeg source filters instead
+// of decoding an input, e.g. to check a pipeline end to end without real audio
+type Signal struct {
+	Digits     string        // DTMF digits among 0-9, A-D, * and #, each taking an equal share of Duration
+	Duration   time.Duration // Of the whole signal
+	Frequency  float64       // Of the sine in Hz, below half the sample rate
+	SampleRate int
+	Type       string // SignalSine, SignalNoise or SignalDTMF
+}
+
+// dtmfFrequencies are the low and high frequencies in Hz of the DTMF digits
+var dtmfFrequencies = map[rune][2]int{
+	'1': {697, 1209}, '2': {697, 1336}, '3': {697, 1477}, 'A': {697, 1633},
+	'4': {770, 1209}, '5': {770, 1336}, '6': {770, 1477}, 'B': {770, 1633},
+	'7': {852, 1209}, '8': {852, 1336}, '9': {852, 1477}, 'C': {852, 1633},
+	'*': {941, 1209}, '0': {941, 1336}, '#': {941, 1477}, 'D': {941, 1633},
+}
+
+// OpenSignal sets up the generation of a test signal, which is then
+// transcoded into the outputs as a decoded input would be. It replaces Open.
+func (t *Transcoder) OpenSignal(s Signal) (err error) {
+	// Trace step, finished with the error on failure
+	end := t.step("signal setup")
+	defer func() { end(err) }()
+
+	// Check signal
+	if s.Duration <= 0 || s.SampleRate <= 0 {
+		err = errors.New("pipeline: signal duration and sample rate must be positive")
+		return
+	} else if max := t.o.Limits.MaxInputDuration; max > 0 && s.Duration > max {
+		err = fmt.Errorf("%w: signal duration exceeds %s", ErrInputLimitExceeded, max)
+		return
+	}
+
+	// Build source, generating mono samples as doubles
+	var content string
+	if content, err = s.source(); err != nil {
+		return
+	}
+	content += ",aformat=sample_fmts=dbl:channel_layouts=mono"
+
+	// Create graph
+	if t.signal, err = t.newGraph(content, nil, nil); err != nil {
+		return
+	}
+
+	// Create decoder, whose codec context only describes the generated frames
+	d := &decoder{}
+	if d.codecContext = astiav.AllocCodecContext(nil); d.codecContext == nil {
+		err = errors.New("pipeline: codec context is nil")
+		return
+	}
+	t.c.Add(d.codecContext.Free)
+	t.track(unsafe.Pointer(d.codecContext))
+	d.codecContext.SetChannelLayout(astiav.ChannelLayoutMono)
+	d.codecContext.SetChannels(1)
+	d.codecContext.SetSampleFormat(astiav.SampleFormatDbl)
+	d.codecContext.SetSampleRate(s.SampleRate)
+	d.codecContext.SetTimeBase(astiav.NewRational(1, s.SampleRate))
+	d.frame = t.allocFrame()
+
+	// Store decoder
+	t.decoders[0] = d
+	t.duration = s.Duration
+	return
+}
+
+// source returns the source filter of the signal
+func (s Signal) source() (string, error) {
+	d := formatFloat(s.Duration.Seconds())
+	switch s.Type {
+	case SignalSine:
+		if !(s.Frequency > 0 && s.Frequency < float64(s.SampleRate)/2) {
+			return "", fmt.Errorf("pipeline: sine frequency out of range: %g", s.Frequency)
+		}
+		return fmt.Sprintf("sine=f=%s:r=%d:d=%s", formatFloat(s.Frequency), s.SampleRate, d), nil
+	case SignalNoise:
+		return fmt.Sprintf("anoisesrc=c=white:a=0.5:r=%d:d=%s", s.SampleRate, d), nil
+	case SignalDTMF:
+		// Each digit sounds over the first half of its share, both of its
+		// frequencies being summed
+		if s.Digits == "" {
+			return "", errors.New("pipeline: no dtmf digits")
+		}
+		share := s.Duration.Seconds() / float64(len(s.Digits))
+		var terms []string
+		for i, c := range strings.ToUpper(s.Digits) {
+			fs, ok := dtmfFrequencies[c]
+			if !ok {
+				return "", fmt.Errorf("pipeline: invalid dtmf digit: %c", c)
+			}
+			start := float64(i) * share
+			terms = append(terms, fmt.Sprintf("between(t,%s,%s)*0.4*(sin(2*PI*%d*t)+sin(2*PI*%d*t))",
+				formatFloat(start), formatFloat(start+share/2), fs[0], fs[1]))
+		}
+		return fmt.Sprintf("aevalsrc=exprs='%s':s=%d:d=%s", strings.Join(terms, "+"), s.SampleRate, d), nil
+	}
+	return "", fmt.Errorf("pipeline: signal type not supported: %s", s.Type)
+}
+
+// generate writes the frames of the signal in the outputs
+func (t *Transcoder) generate() error {
+	d := t.decoders[0]
+	return t.signal.pull(d.frame, func(f *astiav.Frame) error {
+		// Stop once interrupted, since no input checks it
+		if err := t.in.interruptCause(); err != nil {
+			return err
+		}
+
+		// Update position
+		atomic.StoreInt64(&t.position, int64(time.Duration(f.Pts()+int64(f.NbSamples()))*time.Second/time.Duration(d.codecContext.SampleRate())))
+
+		// Write frame in each output and analyzer
+		return t.write(0, f)
+	})
+}
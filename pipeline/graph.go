@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// graph is a filter graph joining buffer sources into a buffer sink
+type graph struct {
+	sink    *astiav.FilterContext
+	sources []*astiav.FilterContext // In the order of their names
+}
+
+// newGraph sets up a filter graph from content, e.g. "[a][b]amix", whose
+// inputs are labeled with the names of the sources, args being their
+// arguments. A single source can be left unlabeled.
+func (t *Transcoder) newGraph(content string, names []string, args []astiav.FilterArgs) (*graph, error) {
+	return t.newFilterGraph("abuffer", "abuffersink", content, names, args)
+}
+
+// newVideoGraph is newGraph for video frames
+func (t *Transcoder) newVideoGraph(content string, names []string, args []astiav.FilterArgs) (*graph, error) {
+	return t.newFilterGraph("buffer", "buffersink", content, names, args)
+}
+
+// newFilterGraph sets up a filter graph whose sources and sink are the src
+// and sink filters
+func (t *Transcoder) newFilterGraph(src, sink, content string, names []string, args []astiav.FilterArgs) (g *graph, err error) {
+	// Alloc graph
+	fg := astiav.AllocFilterGraph()
+	if fg == nil {
+		err = errors.New("pipeline: graph is nil")
+		return
+	}
+	t.c.Add(fg.Free)
+	t.track(unsafe.Pointer(fg))
+
+	// Check filters
+	buffersrc := astiav.FindFilterByName(src)
+	buffersink := astiav.FindFilterByName(sink)
+	if buffersrc == nil {
+		err = errors.New("pipeline: buffersrc is nil")
+		return
+	}
+	if buffersink == nil {
+		err = errors.New("pipeline: buffersink is nil")
+		return
+	}
+
+	// Create sink
+	g = &graph{}
+	if g.sink, err = fg.NewFilterContext(buffersink, "out", nil); err != nil {
+		err = fmt.Errorf("pipeline: creating buffersink context failed: %w", err)
+		return
+	}
+	inputs := astiav.AllocFilterInOut()
+	if inputs == nil {
+		err = errors.New("pipeline: inputs is nil")
+		return
+	}
+	t.c.Add(inputs.Free)
+	inputs.SetName("out")
+	inputs.SetFilterContext(g.sink)
+	inputs.SetPadIdx(0)
+	inputs.SetNext(nil)
+
+	// Create sources, chained in reverse order. Only the head of the chain is
+	// freed since parsing consumes the others.
+	var outputs *astiav.FilterInOut
+	for i := len(names) - 1; i >= 0; i-- {
+		var s *astiav.FilterContext
+		if s, err = fg.NewFilterContext(buffersrc, names[i], args[i]); err != nil {
+			err = fmt.Errorf("pipeline: creating buffersrc context failed: %w", err)
+			return
+		}
+		g.sources = append([]*astiav.FilterContext{s}, g.sources...)
+		o := astiav.AllocFilterInOut()
+		if o == nil {
+			err = errors.New("pipeline: outputs is nil")
+			return
+		}
+		o.SetName(names[i])
+		o.SetFilterContext(s)
+		o.SetPadIdx(0)
+		o.SetNext(outputs)
+		outputs = o
+	}
+	if outputs != nil {
+		t.c.Add(outputs.Free)
+	}
+
+	// Parse
+	if err = fg.Parse(content, inputs, outputs); err != nil {
+		err = fmt.Errorf("pipeline: parsing filter failed: %w", err)
+		return
+	}
+
+	// Configure
+	if err = fg.Configure(); err != nil {
+		err = fmt.Errorf("pipeline: configuring filter failed: %w", err)
+		return
+	}
+	return
+}
+
+// decoderArgs returns the arguments of a buffer source of the frames of a
+// decoder
+func decoderArgs(d *decoder) astiav.FilterArgs {
+	return astiav.FilterArgs{
+		"channel_layout": d.codecContext.ChannelLayout().String(),
+		"sample_fmt":     d.codecContext.SampleFormat().Name(),
+		"sample_rate":    strconv.Itoa(d.codecContext.SampleRate()),
+		"time_base":      d.codecContext.TimeBase().String(),
+	}
+}
+
+// resampleTo returns the filter resampling the frames of a decoder into the
+// format of another one
+func resampleTo(d, to *decoder) string {
+	return fmt.Sprintf("aresample=isr=%d:osr=%d:icl=%s:ocl=%s:isf=%s:osf=%s", d.codecContext.SampleRate(), to.codecContext.SampleRate(),
+		d.codecContext.ChannelLayout().String(), to.codecContext.ChannelLayout().String(), d.codecContext.SampleFormat().Name(), to.codecContext.SampleFormat().Name())
+}
+
+// pull gets the frames available in the sink of the graph into f and calls fn
+// with each of them
+func (g *graph) pull(f *astiav.Frame, fn func(f *astiav.Frame) error) (err error) {
+	for {
+		// Get frame
+		f.Unref()
+		if err = g.sink.BuffersinkGetFrame(f, astiav.NewBuffersinkFlags()); err != nil {
+			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+				err = nil
+				break
+			}
+			err = fmt.Errorf("pipeline: getting frame failed: %w", err)
+			return
+		}
+
+		// Handle frame
+		if err = fn(f); err != nil {
+			return
+		}
+	}
+	return
+}
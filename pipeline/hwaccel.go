@@ -0,0 +1,138 @@
+package pipeline
+
+/*
+#cgo pkg-config: libavcodec libavutil
+#include <libavcodec/avcodec.h>
+#include <libavutil/frame.h>
+#include <libavutil/hwcontext.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+// hw_get_format picks the hardware pixel format stored in the opaque field of
+// a decoder, or else the default software one
+static enum AVPixelFormat hw_get_format(AVCodecContext *c, const enum AVPixelFormat *fmts) {
+	enum AVPixelFormat f = (enum AVPixelFormat)(intptr_t)c->opaque;
+	for (const enum AVPixelFormat *p = fmts; *p != AV_PIX_FMT_NONE; p++) {
+		if (*p == f) return f;
+	}
+	return avcodec_default_get_format(c, fmts);
+}
+
+// hw_device_type returns the device type of a name, e.g. vaapi
+static enum AVHWDeviceType hw_device_type(const char *name) {
+	return av_hwdevice_find_type_by_name(name);
+}
+
+// hw_device_create creates a device of a type, device naming it or NULL
+// picking the default one
+static int hw_device_create(AVBufferRef **ref, enum AVHWDeviceType type, const char *device) {
+	return av_hwdevice_ctx_create(ref, type, device, NULL, 0);
+}
+
+// hw_decoder_setup makes a decoder output frames in surfaces of a device,
+// AVERROR(ENOSYS) meaning it has no hwaccel for its type
+static int hw_decoder_setup(AVCodecContext *c, const AVCodec *codec, AVBufferRef *device) {
+	enum AVHWDeviceType type = ((AVHWDeviceContext *)device->data)->type;
+	for (int i = 0;; i++) {
+		const AVCodecHWConfig *cfg = avcodec_get_hw_config(codec, i);
+		if (!cfg) return AVERROR(ENOSYS);
+		if (cfg->methods & AV_CODEC_HW_CONFIG_METHOD_HW_DEVICE_CTX && cfg->device_type == type) {
+			if (!(c->hw_device_ctx = av_buffer_ref(device))) return AVERROR(ENOMEM);
+			c->opaque = (void *)(intptr_t)cfg->pix_fmt;
+			c->get_format = hw_get_format;
+			return 0;
+		}
+	}
+}
+
+// hw_frame_download replaces a frame in a hardware surface with a copy in
+// system memory, frames already there are left as is
+static int hw_frame_download(AVFrame *f) {
+	if (!f->hw_frames_ctx) return 0;
+	AVFrame *sw = av_frame_alloc();
+	if (!sw) return AVERROR(ENOMEM);
+	int ret = av_hwframe_transfer_data(sw, f, 0);
+	if (ret >= 0) ret = av_frame_copy_props(sw, f);
+	if (ret >= 0) {
+		av_frame_unref(f);
+		av_frame_move_ref(f, sw);
+	}
+	av_frame_free(&sw);
+	return ret;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// HWAccel is the hardware device video streams are decoded on by the hwaccel
+// of their software decoder
+type HWAccel struct {
+	Device string // e.g. /dev/dri/renderD128 for vaapi or 0 for cuda, empty picks the default one
+	Type   string // FFmpeg device type, e.g. vaapi, cuda (NVDEC) or videotoolbox, empty decodes in software
+}
+
+// hwDevices are the devices created, by type and device, which decoders
+// share until the process exits
+var hwDevices = struct {
+	m    *sync.Mutex
+	refs map[HWAccel]*C.AVBufferRef
+}{m: &sync.Mutex{}, refs: make(map[HWAccel]*C.AVBufferRef)}
+
+// hwDevice returns the device of a hwaccel, created on first use
+func hwDevice(h HWAccel) (*C.AVBufferRef, error) {
+	hwDevices.m.Lock()
+	defer hwDevices.m.Unlock()
+	if ref, ok := hwDevices.refs[h]; ok {
+		return ref, nil
+	}
+
+	// Find type
+	ct := C.CString(h.Type)
+	defer C.free(unsafe.Pointer(ct))
+	t := C.hw_device_type(ct)
+	if t == C.AV_HWDEVICE_TYPE_NONE {
+		return nil, fmt.Errorf("pipeline: hwaccel %s isn't supported by the linked FFmpeg", h.Type)
+	}
+
+	// Create device
+	var cd *C.char
+	if h.Device != "" {
+		cd = C.CString(h.Device)
+		defer C.free(unsafe.Pointer(cd))
+	}
+	var ref *C.AVBufferRef
+	if ret := C.hw_device_create(&ref, t, cd); ret < 0 {
+		return nil, fmt.Errorf("pipeline: creating %s device failed: %w", h.Type, astiav.Error(int(ret)))
+	}
+	hwDevices.refs[h] = ref
+	return ref, nil
+}
+
+// setHWAccel makes a decoder output frames in surfaces of the device of a
+// hwaccel, before it's opened
+func setHWAccel(cc *astiav.CodecContext, codec *astiav.Codec, h HWAccel) error {
+	ref, err := hwDevice(h)
+	if err != nil {
+		return err
+	}
+	c := (*C.AVCodecContext)(cPointer(unsafe.Pointer(cc)))
+	if ret := C.hw_decoder_setup(c, (*C.AVCodec)(cPointer(unsafe.Pointer(codec))), ref); ret < 0 {
+		return fmt.Errorf("pipeline: setting up %s hwaccel of %s decoder failed: %w", h.Type, codec.Name(), astiav.Error(int(ret)))
+	}
+	return nil
+}
+
+// downloadFrame copies a decoded frame in a hardware surface into system
+// memory, where filters and encoders expect it
+func downloadFrame(f *astiav.Frame) error {
+	if ret := C.hw_frame_download((*C.AVFrame)(cPointer(unsafe.Pointer(f)))); ret < 0 {
+		return fmt.Errorf("pipeline: downloading frame failed: %w", astiav.Error(int(ret)))
+	}
+	return nil
+}
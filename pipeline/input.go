@@ -0,0 +1,591 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Networks that are neither covered by the net.IP helpers nor routable on the internet
+var inputBlockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "This" network
+	"100.64.0.0/10", // Carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // Benchmarking
+	"240.0.0.0/4",   // Reserved
+	"64:ff9b::/96",  // NAT64
+)
+
+// InputPolicy restricts the inputs a Transcoder can open, e.g. when urls are
+// sent by untrusted callers
+type InputPolicy struct {
+	AllowPrivate bool     // Allow hosts resolving to private, loopback or link-local addresses
+	FileRoots    []string // Directories file:// inputs are allowed from, empty disables file inputs
+	Schemes      []string // Allowed network schemes, e.g. http and https
+}
+
+// input wraps the input format context so that it can be reopened when a
+// transient network failure occurs in the middle of the transfer
+type input struct {
+	bytesRead     int64
+	cause         error // ErrCanceled or ErrTimeout once interrupted
+	formatContext *astiav.FormatContext
+	interrupt     *int                 // Interrupt flag of the current format context
+	decoders      map[int]*decoder     // Nil uses the ones of the transcoder
+	lastRead      map[int]readPosition // Indexed by input stream index
+	m             *sync.Mutex          // Locks cause and interrupt
+	release       func()               // Stops tracking the logs of the current format context
+	t             *Transcoder
+	trusted       bool // Opens files written by the transcoder, out of the policy and limits
+	url           string
+}
+
+// readPosition is where the last packet of a stream was read
+type readPosition struct {
+	dts    int64
+	pos    int64 // Byte position in the input, -1 when unknown
+	replay bool  // Set on reopen, until a packet past this one is read
+}
+
+func newInput(t *Transcoder) *input {
+	return &input{
+		m: &sync.Mutex{},
+		t: t,
+	}
+}
+
+// open opens the input, retrying transient failures with exponential backoff
+func (i *input) open() (err error) {
+	defer func() {
+		if err != nil {
+			i.untrack()
+		}
+	}()
+	for attempt := 0; ; attempt++ {
+		if i.formatContext, err = i.openFormatContext(); err == nil {
+			return
+		}
+		if cause := i.interruptCause(); cause != nil {
+			return cause
+		}
+		if attempt >= i.t.o.Retries || !isTransientInputError(err) {
+			return
+		}
+		time.Sleep(i.backoff(attempt))
+	}
+}
+
+// watch interrupts any blocking FFmpeg call on the input and makes the
+// following ones fail once ctx is done, until the returned func is called
+func (i *input) watch(ctx context.Context) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		i.m.Lock()
+		defer i.m.Unlock()
+		if i.cause == nil {
+			i.cause = ErrCanceled
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				i.cause = ErrTimeout
+			}
+		}
+		if i.interrupt != nil {
+			*i.interrupt = 1
+		}
+	}()
+	return func() { close(done) }
+}
+
+// interruptCause returns ErrCanceled or ErrTimeout once the input has been
+// interrupted, nil otherwise
+func (i *input) interruptCause() error {
+	i.m.Lock()
+	defer i.m.Unlock()
+	return i.cause
+}
+
+// attach sets up a new format context before it's opened: its interrupt flag
+// is stored and its logs are tracked
+func (i *input) attach(fc *astiav.FormatContext) {
+	// Track logs
+	i.untrack()
+	i.release = i.t.trackPointer(ffmpegPointer(unsafe.Pointer(fc)))
+
+	// Store interrupt flag
+	i.m.Lock()
+	defer i.m.Unlock()
+	i.interrupt = fc.SetInterruptCallback()
+	if i.cause != nil {
+		*i.interrupt = 1
+	}
+}
+
+// untrack stops tracking the logs of the current format context
+func (i *input) untrack() {
+	if i.release != nil {
+		i.release()
+	}
+	i.release = nil
+}
+
+// close closes the current input format context
+func (i *input) close() {
+	i.untrack()
+	if i.formatContext == nil {
+		return
+	}
+	i.formatContext.CloseInput()
+	i.formatContext.Free()
+	i.formatContext = nil
+}
+
+// readFrame reads the next packet. On transient failures the input is reopened,
+// seeked back to the last packet read and packets already read are skipped.
+func (i *input) readFrame(pkt *astiav.Packet) (err error) {
+	failures := 0
+	for {
+		// Read frame
+		if err = i.formatContext.ReadFrame(pkt); err != nil {
+			if cause := i.interruptCause(); cause != nil {
+				return cause
+			}
+			if failures >= i.t.o.Retries || !isTransientInputError(err) {
+				return
+			}
+
+			// Reopen input until it succeeds or retries are exhausted
+			for {
+				time.Sleep(i.backoff(failures))
+				failures++
+				if cause := i.interruptCause(); cause != nil {
+					return cause
+				}
+				if err = i.reopen(); err == nil {
+					break
+				} else if failures >= i.t.o.Retries || !isTransientInputError(err) {
+					return
+				}
+			}
+			continue
+		}
+
+		// Skip packets already read before reopening, the ones sharing the
+		// dts of the last packet read being told apart by their position.
+		// Packets without dts can't be placed and are kept.
+		if dts := pkt.Dts(); dts != astiav.NoPtsValue {
+			idx := pkt.StreamIndex()
+			if last, ok := i.lastRead[idx]; ok && last.replay && (dts < last.dts || (dts == last.dts && (pkt.Pos() < 0 || pkt.Pos() <= last.pos))) {
+				pkt.Unref()
+				continue
+			}
+			if i.lastRead == nil {
+				i.lastRead = make(map[int]readPosition)
+			}
+			i.lastRead[idx] = readPosition{dts: dts, pos: pkt.Pos()}
+		}
+		return i.countBytes(pkt)
+	}
+}
+
+// countBytes tracks the size of packets read and checks it against the limit
+func (i *input) countBytes(pkt *astiav.Packet) error {
+	i.bytesRead += int64(pkt.Size())
+	if max := i.t.o.Limits.MaxInputSize; max > 0 && i.bytesRead > max && !i.trusted {
+		return fmt.Errorf("%w: size exceeds %d bytes", ErrInputLimitExceeded, max)
+	}
+	return nil
+}
+
+// reopen closes the input, opens it again and seeks back to the last packet read
+func (i *input) reopen() (err error) {
+	// Close previous input
+	i.close()
+
+	// Open input
+	if i.formatContext, err = i.openFormatContext(); err != nil {
+		i.untrack()
+		return
+	}
+
+	// Find stream info
+	if err = i.formatContext.FindStreamInfo(nil); err != nil {
+		err = fmt.Errorf("pipeline: finding stream info failed: %w", err)
+		return
+	}
+
+	// Update streams
+	iss := i.formatContext.Streams()
+	ds := i.decoders
+	if ds == nil {
+		ds = i.t.decoders
+	}
+	for idx, d := range ds {
+		if idx >= len(iss) {
+			err = errors.New("pipeline: input streams changed after reopening")
+			return
+		}
+		d.stream = iss[idx]
+	}
+
+	// Seek back to the earliest last packet read, the packets read again
+	// being skipped
+	var ts int64
+	seek := false
+	for idx, last := range i.lastRead {
+		last.replay = true
+		i.lastRead[idx] = last
+		if idx >= len(iss) {
+			continue
+		}
+		if v := astiav.RescaleQ(last.dts, iss[idx].TimeBase(), astiav.TimeBaseQ); !seek || v < ts {
+			ts, seek = v, true
+		}
+	}
+	if seek {
+		if err = i.formatContext.SeekFrame(-1, ts, astiav.NewSeekFlags(astiav.SeekFlagBackward)); err != nil {
+			err = fmt.Errorf("pipeline: seeking input failed: %w", err)
+			return
+		}
+	}
+	return
+}
+
+// openFormatContext allocates a format context and opens the input in it.
+// The format context is attached before opening so that its interrupt
+// callback can abort blocking FFmpeg calls.
+func (i *input) openFormatContext() (fc *astiav.FormatContext, err error) {
+	// Resolve url, this is done on every attempt since DNS may have changed
+	policy := i.t.o.InputPolicy
+	if i.trusted {
+		policy = nil
+	}
+	target, protocols, err := policy.resolve(i.url)
+	if err != nil {
+		err = fmt.Errorf("pipeline: invalid input url: %w", err)
+		return
+	}
+	var formats, proxy string
+	if protocols == "file" {
+		formats = fileFormatWhitelist()
+	} else if proxy, err = policy.proxyURL(); err != nil {
+		return
+	}
+
+	// Alloc input format context
+	if fc = astiav.AllocFormatContext(); fc == nil {
+		err = errors.New("pipeline: input format context is nil")
+		return
+	}
+
+	// Attach format context
+	i.attach(fc)
+
+	// Build input options
+	var d *astiav.Dictionary
+	if d, err = newInputOptions(i.t.o.Headers, protocols, formats, proxy, i.t.o.LowLatency); err != nil {
+		fc.Free()
+		fc = nil
+		err = fmt.Errorf("pipeline: building input options failed: %w", err)
+		return
+	}
+	defer d.Free()
+
+	// Open input
+	if err = fc.OpenInput(target, nil, d); err != nil {
+		fc.Free()
+		fc = nil
+		return
+	}
+	return
+}
+
+// backoff returns the delay before the next attempt
+func (i *input) backoff(attempt int) time.Duration {
+	d := i.t.o.RetryBackoff << uint(attempt)
+	if max := i.t.o.RetryBackoffMax; max > 0 && (d <= 0 || d > max) {
+		d = max
+	}
+	return d
+}
+
+// isTransientInputError reports whether err looks like a network failure that
+// is worth retrying
+func isTransientInputError(err error) bool {
+	for _, e := range []error{
+		astiav.ErrEio,
+		astiav.ErrEtimedout,
+		astiav.ErrEpipe,
+		astiav.ErrHttpServerError,
+		astiav.Error(-int(syscall.ECONNRESET)),
+		astiav.Error(-int(syscall.ECONNREFUSED)),
+		astiav.Error(-int(syscall.ECONNABORTED)),
+		astiav.Error(-int(syscall.ENETUNREACH)),
+		astiav.Error(-int(syscall.EHOSTUNREACH)),
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve validates the input url and returns the url FFmpeg should open
+// along with the protocols it's allowed to use, empty if all are. It rejects
+// schemes that are not allowlisted (concat:, pipe:, etc.), files outside of
+// the configured roots and hosts resolving to private, loopback, link-local
+// (including cloud metadata) or reserved addresses.
+// Note that FFmpeg resolves the host again and follows redirects on its own,
+// the protocol whitelist limits what it can reach then and HTTP connections
+// go through the proxy of proxyURL, which checks addresses again.
+func (p *InputPolicy) resolve(rawurl string) (target, protocols string, err error) {
+	// No restrictions
+	if p == nil {
+		return rawurl, "", nil
+	}
+
+	// Parse
+	var u *url.URL
+	if u, err = url.Parse(rawurl); err != nil {
+		err = fmt.Errorf("parsing url failed: %w", err)
+		return
+	}
+
+	// Local file
+	if strings.EqualFold(u.Scheme, "file") {
+		var path string
+		if path, err = p.resolveFile(u); err != nil {
+			return
+		}
+		return "file:" + path, "file", nil
+	}
+
+	// Check scheme
+	if !p.schemeAllowed(u.Scheme) {
+		err = fmt.Errorf("scheme %q is not allowed", u.Scheme)
+		return
+	}
+
+	// Check host
+	host := u.Hostname()
+	if host == "" {
+		err = errors.New("host is empty")
+		return
+	}
+	if !p.AllowPrivate {
+		var ips []net.IP
+		if ips, err = net.LookupIP(host); err != nil {
+			err = fmt.Errorf("resolving host %s failed: %w", host, err)
+			return
+		}
+		for _, ip := range ips {
+			if isBlockedInputIP(ip) {
+				err = fmt.Errorf("host %s resolves to blocked address %s", host, ip)
+				return
+			}
+		}
+	}
+	return rawurl, p.networkProtocols(), nil
+}
+
+// Check validates an input url as resolve does, for callers fetching it on
+// their own
+func (p *InputPolicy) Check(rawurl string) error {
+	_, _, err := p.resolve(rawurl)
+	return err
+}
+
+// resolveFile resolves symlinks in a file url path and makes sure the result
+// is a regular file located in one of the allowed roots
+func (p *InputPolicy) resolveFile(u *url.URL) (string, error) {
+	if len(p.FileRoots) == 0 {
+		return "", fmt.Errorf("scheme %q is not allowed", u.Scheme)
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("host %s is not allowed for files", u.Host)
+	}
+	if !filepath.IsAbs(u.Path) {
+		return "", errors.New("file path is not absolute")
+	}
+
+	// Resolve symlinks so that they can't point outside of the roots
+	path, err := filepath.EvalSymlinks(filepath.Clean(u.Path))
+	if err != nil {
+		return "", fmt.Errorf("resolving file path failed: %w", err)
+	}
+
+	// Check root
+	var ok bool
+	for _, root := range p.FileRoots {
+		if r, err := filepath.EvalSymlinks(root); err == nil && isPathInRoot(path, r) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return "", errors.New("file is outside of the allowed roots")
+	}
+
+	// Check file
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("stating file failed: %w", err)
+	}
+	if !fi.Mode().IsRegular() {
+		return "", errors.New("file is not a regular file")
+	}
+	return path, nil
+}
+
+func isPathInRoot(p, root string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (p *InputPolicy) schemeAllowed(scheme string) bool {
+	for _, s := range p.Schemes {
+		if strings.EqualFold(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlockedInputIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range inputBlockedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// networkProtocols returns the FFmpeg protocols needed by the allowed schemes
+func (p *InputPolicy) networkProtocols() string {
+	var ps []string
+	for _, s := range p.Schemes {
+		switch strings.ToLower(s) {
+		case "file":
+			// Files are only allowed through FileRoots
+		case "http":
+			ps = append(ps, "http", "tcp")
+		case "https":
+			ps = append(ps, "https", "tls", "httpproxy", "tcp")
+		default:
+			ps = append(ps, strings.ToLower(s))
+		}
+	}
+	return strings.Join(ps, ",")
+}
+
+func mustParseCIDRs(cidrs ...string) (ns []*net.IPNet) {
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		ns = append(ns, n)
+	}
+	return
+}
+
+// newInputOptions builds the avformat options used to open the input
+func newInputOptions(headers []string, protocols, formats, proxy string, lowLatency bool) (d *astiav.Dictionary, err error) {
+	d = astiav.NewDictionary()
+
+	// Restrict protocols, including the ones opened by demuxers such as hls
+	if protocols != "" {
+		if err = d.Set("protocol_whitelist", protocols, astiav.NewDictionaryFlags()); err != nil {
+			err = fmt.Errorf("pipeline: setting protocol whitelist failed: %w", err)
+			return
+		}
+	}
+
+	// Restrict demuxers, e.g. so that playlists can't name files out of the roots
+	if formats != "" {
+		if err = d.Set("format_whitelist", formats, astiav.NewDictionaryFlags()); err != nil {
+			err = fmt.Errorf("pipeline: setting format whitelist failed: %w", err)
+			return
+		}
+	}
+
+	// Connect through the proxy, which demuxers such as hls pass on too
+	if proxy != "" {
+		if err = d.Set("http_proxy", proxy, astiav.NewDictionaryFlags()); err != nil {
+			err = fmt.Errorf("pipeline: setting http proxy failed: %w", err)
+			return
+		}
+	}
+
+	// Custom HTTP headers, e.g. Authorization, Cookie or User-Agent
+	if len(headers) > 0 {
+		var v string
+		if v, err = formatHeaders(headers); err != nil {
+			err = fmt.Errorf("pipeline: invalid headers: %w", err)
+			return
+		}
+		if err = d.Set("headers", v, astiav.NewDictionaryFlags()); err != nil {
+			err = fmt.Errorf("pipeline: setting headers failed: %w", err)
+			return
+		}
+	}
+
+	// Don't buffer packets while probing the streams
+	if lowLatency {
+		if err = d.Set("fflags", "nobuffer", astiav.NewDictionaryFlags()); err != nil {
+			err = fmt.Errorf("pipeline: setting flags failed: %w", err)
+			return
+		}
+	}
+	return
+}
+
+// formatHeaders validates "Key: Value" headers and joins them the way the
+// avformat http protocol expects them
+func formatHeaders(headers []string) (string, error) {
+	var b strings.Builder
+	for _, h := range headers {
+		if err := CheckHeader(h); err != nil {
+			return "", err
+		}
+		i := strings.Index(h, ":")
+		b.WriteString(h[:i] + ": " + strings.TrimSpace(h[i+1:]) + "\r\n")
+	}
+	return b.String(), nil
+}
+
+// CheckHeader checks a "Key: Value" header. avformat sends headers as is,
+// so a line break would inject other headers or a body in its request.
+func CheckHeader(h string) error {
+	for i := 0; i < len(h); i++ {
+		switch c := h[i]; {
+		case c == '\r' || c == '\n':
+			return errors.New("header contains a line break")
+		case c < 0x20 && c != '\t' || c == 0x7f:
+			return fmt.Errorf("header contains the control character %#02x", c)
+		}
+	}
+	if i := strings.Index(h, ":"); i <= 0 || strings.TrimSpace(h[:i]) != h[:i] {
+		return fmt.Errorf("malformed header %q", h)
+	}
+	return nil
+}
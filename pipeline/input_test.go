@@ -0,0 +1,168 @@
+package pipeline
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInputPolicyCheck(t *testing.T) {
+	p := &InputPolicy{Schemes: []string{"http", "https"}}
+	for _, c := range []struct {
+		policy *InputPolicy
+		url    string
+		err    string // Empty if the url is allowed
+	}{
+		{nil, "concat:a.wav|b.wav", ""},
+		{nil, "http://127.0.0.1/a.wav", ""},
+		{p, "http://93.184.216.34/a.wav", ""},
+		{p, "https://93.184.216.34:8443/a.wav?x=1", ""},
+		{p, "HTTP://93.184.216.34/a.wav", ""},
+		{p, "ftp://93.184.216.34/a.wav", `scheme "ftp" is not allowed`},
+		{p, "concat:a.wav|b.wav", `scheme "concat" is not allowed`},
+		{p, "pipe:0", `scheme "pipe" is not allowed`},
+		{p, "a.wav", `scheme "" is not allowed`},
+		{p, "file:///etc/passwd", `scheme "file" is not allowed`},
+		{p, "http:///a.wav", "host is empty"},
+		{p, "http://127.0.0.1/a.wav", "blocked address 127.0.0.1"},
+		{p, "http://localhost/a.wav", "blocked address"},
+		{p, "http://10.0.0.1/a.wav", "blocked address 10.0.0.1"},
+		{p, "http://169.254.169.254/latest/meta-data", "blocked address 169.254.169.254"},
+		{p, "http://[::1]/a.wav", "blocked address ::1"},
+		{p, "http://[::ffff:192.168.0.1]/a.wav", "blocked address 192.168.0.1"},
+		{p, "http://%zz/a.wav", "parsing url failed"},
+		{&InputPolicy{AllowPrivate: true, Schemes: []string{"http"}}, "http://127.0.0.1/a.wav", ""},
+	} {
+		err := c.policy.Check(c.url)
+		if c.err == "" && err != nil {
+			t.Errorf("%s: expected no error, got %v", c.url, err)
+		} else if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", c.url, c.err, err)
+		}
+	}
+}
+
+func TestInputPolicyResolveFile(t *testing.T) {
+	// Create files
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{filepath.Join(root, "a.wav"), filepath.Join(outside, "b.wav")} {
+		if err = os.WriteFile(f, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Mkdir(filepath.Join(root, "dir"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"in.wav":  filepath.Join(root, "a.wav"),
+		"out.wav": filepath.Join(outside, "b.wav"),
+	} {
+		if err = os.Symlink(v, filepath.Join(root, k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &InputPolicy{FileRoots: []string{root}}
+	for _, c := range []struct {
+		policy *InputPolicy
+		url    string
+		path   string // Empty if the url is rejected
+	}{
+		{p, "file://" + root + "/a.wav", root + "/a.wav"},
+		{p, "file://localhost" + root + "/a.wav", root + "/a.wav"},
+		{p, "file://" + root + "/dir/../a.wav", root + "/a.wav"},
+		{p, "file://" + root + "/in.wav", root + "/a.wav"},
+		{p, "file://" + root + "/out.wav", ""},
+		{p, "file://" + root + "/../" + filepath.Base(outside) + "/b.wav", ""},
+		{p, "file://" + outside + "/b.wav", ""},
+		{p, "file://" + root + "/missing.wav", ""},
+		{p, "file://" + root + "/dir", ""},
+		{p, "file://example.com" + root + "/a.wav", ""},
+		{p, "file:a.wav", ""},
+		{&InputPolicy{}, "file://" + root + "/a.wav", ""},
+	} {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := c.policy.resolveFile(u)
+		if c.path == "" && err == nil {
+			t.Errorf("%s: expected an error, got %s", c.url, path)
+		} else if c.path != "" && (err != nil || path != c.path) {
+			t.Errorf("%s: expected %s, got %s, %v", c.url, c.path, path, err)
+		}
+	}
+}
+
+func TestIsBlockedInputIP(t *testing.T) {
+	for _, c := range []struct {
+		ip      string
+		blocked bool
+	}{
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"10.1.2.3", true},
+		{"100.64.0.1", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"172.16.0.1", true},
+		{"192.0.0.170", true},
+		{"192.168.1.1", true},
+		{"198.18.0.1", true},
+		{"224.0.0.1", true},
+		{"240.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::", true},
+		{"::1", true},
+		{"::ffff:10.0.0.1", true},
+		{"64:ff9b::a00:1", true},
+		{"fc00::1", true},
+		{"fe80::1", true},
+		{"ff02::1", true},
+		{"1.1.1.1", false},
+		{"93.184.216.34", false},
+		{"100.128.0.1", false},
+		{"2606:2800:220:1::1", false},
+	} {
+		if v := isBlockedInputIP(net.ParseIP(c.ip)); v != c.blocked {
+			t.Errorf("%s: expected %v, got %v", c.ip, c.blocked, v)
+		}
+	}
+}
+
+func TestCheckHeader(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		valid  bool
+	}{
+		{"Authorization: Bearer token", true},
+		{"X-Empty:", true},
+		{"User-Agent:transgode", true},
+		{"Cookie: a=b\tc=d", true},
+		{"Authorization: Bearer token\r\nX-Injected: 1", false},
+		{"Authorization: Bearer token\nX-Injected: 1", false},
+		{"Authorization: Bearer\x00token", false},
+		{"Authorization: Bearer\x7ftoken", false},
+		{"Authorization", false},
+		{": value", false},
+		{" Authorization: Bearer token", false},
+		{"Authorization : Bearer token", false},
+		{"", false},
+	} {
+		if err := CheckHeader(c.header); c.valid && err != nil {
+			t.Errorf("%q: expected no error, got %v", c.header, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%q: expected an error", c.header)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// bufferedSampleBytes bounds the size of a sample held by filters, which
+// process doubles at most
+const bufferedSampleBytes = 8
+
+// Memory is estimated from the decoded audio held by the filters, which
+// dwarfs the frames and packets in flight: reversing the audio, to trim
+// silence at its end or to fade it out, holds the whole input, looping it to
+// a target duration holds up to it, and crossfading concatenated inputs holds
+// the tail of each one.
+
+// memory returns the estimated bytes held once position is decoded
+func (t *Transcoder) memory(position time.Duration) (n int64) {
+	for _, out := range t.outputs {
+		for _, s := range out.streams {
+			if s.copy {
+				continue
+			}
+			if out.o.TrimSilence || out.o.FadeOut > 0 {
+				n += int64(position.Seconds() * float64(s.inputRate))
+			}
+			if out.o.Loop && out.o.TargetDuration > 0 {
+				d := position
+				if d > out.o.TargetDuration {
+					d = out.o.TargetDuration
+				}
+				n += int64(d.Seconds() * float64(s.outputRate))
+			}
+		}
+	}
+	if c := t.concat; c != nil && c.first != nil && t.o.Concat.Crossfade > 0 {
+		cc := c.first.codecContext
+		n += int64(t.o.Concat.Crossfade.Seconds() * float64(cc.SampleRate()*cc.Channels()*bufferedSampleBytes))
+	}
+	return
+}
+
+// MemoryEstimate returns the estimated bytes of audio held by the transcoder
+// at the end of the transcoded range. It must be called after AddOutput.
+// When the duration of the range is unknown, it's bounded by
+// Limits.MaxInputDuration, and by Limits.MaxMemory.
+func (t *Transcoder) MemoryEstimate() int64 {
+	d := t.duration
+	if d <= 0 {
+		d = t.o.Limits.MaxInputDuration
+	}
+	n := t.memory(d)
+	if max := t.o.Limits.MaxMemory; max > 0 && (n > max || d <= 0) {
+		n = max
+	}
+	return n
+}
+
+// checkMemory checks the memory held once position is decoded against the
+// limit
+func (t *Transcoder) checkMemory(position time.Duration) error {
+	if max := t.o.Limits.MaxMemory; max > 0 {
+		if n := t.memory(position); n > max {
+			return fmt.Errorf("%w: buffered audio needs about %d bytes, more than %d", ErrMemoryLimitExceeded, n, max)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+//#cgo pkg-config: libavformat libavutil
+//#include <libavformat/avformat.h>
+//#include <libavutil/dict.h>
+//#include <stdlib.h>
+import "C"
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// metadataTags returns the tags of a dictionary, nil if it has none
+func metadataTags(d *astiav.Dictionary) (tags map[string]string) {
+	if d == nil {
+		return
+	}
+	var e *astiav.DictionaryEntry
+	for {
+		if e = d.Get("", e, astiav.NewDictionaryFlags(astiav.DictionaryFlagIgnoreSuffix)); e == nil {
+			return
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[e.Key()] = e.Value()
+	}
+}
+
+// attachedPicture returns the picture of an attached picture stream, e.g.
+// the cover of an MP3 file, nil for other streams. The bindings expose
+// neither the disposition nor the attached picture of streams.
+func attachedPicture(s *astiav.Stream) []byte {
+	c := cStream(s)
+	if c.disposition&C.AV_DISPOSITION_ATTACHED_PIC == 0 || c.attached_pic.size <= 0 {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(c.attached_pic.data), c.attached_pic.size)
+}
+
+// outputMetadata returns the tags of an output: those of the container of
+// the input, if any and unless stripped, replaced by the ones of the output
+func (t *Transcoder) outputMetadata(o Output) map[string]string {
+	tags := make(map[string]string)
+	if t.in.formatContext != nil && !o.StripMetadata {
+		for k, v := range metadataTags(t.in.formatContext.Metadata()) {
+			tags[k] = v
+		}
+	}
+	for k, v := range o.Metadata {
+		tags[k] = v
+	}
+	return tags
+}
+
+// setMetadata sets the tags of an output format context, which the bindings
+// can only read, in the order of their keys so that outputs are reproducible.
+// Tags with an empty value are removed.
+func setMetadata(fc *astiav.FormatContext, tags map[string]string) error {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	c := cFormatContext(fc)
+	for _, k := range keys {
+		ck := C.CString(k)
+		var cv *C.char
+		if v := tags[k]; v != "" {
+			cv = C.CString(v)
+		}
+		ret := C.av_dict_set(&c.metadata, ck, cv, 0)
+		C.free(unsafe.Pointer(ck))
+		C.free(unsafe.Pointer(cv))
+		if ret < 0 {
+			return fmt.Errorf("pipeline: setting %s tag failed: %d", k, int(ret))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Mix mixes inputs into the one opened, which sets the duration and format,
+// e.g. background music under a voice
+type Mix struct {
+	Duck   bool // Lowers the mixed inputs while the opened one is loud
+	Inputs []MixInput
+}
+
+// MixInput is an input mixed into the one opened
+type MixInput struct {
+	Gain float64 // In dB
+	URL  string
+}
+
+// mixer decodes the mixed inputs along the opened one and mixes them
+type mixer struct {
+	frame   *astiav.Frame
+	graph   *graph // Its first source is the opened input
+	index   int    // Index of the stream of the opened input, keying the outputs
+	inputs  []*mixerInput
+	primary *decoder
+	samples int64 // Samples written in the outputs
+	t       *Transcoder
+}
+
+// mixerInput is a mixed input, decoded up to the position of the opened one
+type mixerInput struct {
+	d        *decoder
+	ended    bool
+	in       *input
+	pkt      *astiav.Packet
+	position time.Duration // Decoded duration
+}
+
+func newMixer(t *Transcoder) (m *mixer, err error) {
+	// Check options
+	if t.o.Start > 0 || t.o.Duration > 0 {
+		err = errors.New("pipeline: start and duration aren't supported when mixing")
+		return
+	} else if len(t.o.Concat.URLs) > 0 {
+		err = errors.New("pipeline: inputs can't be both mixed and concatenated")
+		return
+	}
+
+	// Create mixer
+	m = &mixer{t: t}
+	m.frame = t.allocFrame()
+	return
+}
+
+// open opens the mixed inputs and sets up the graph mixing them into the
+// format of the decoder of the opened input. The inputs are interrupted when
+// ctx is done.
+func (m *mixer) open(ctx context.Context, d *decoder) (err error) {
+	m.primary, m.index = d, d.stream.Index()
+	for _, mi := range m.t.o.Mix.Inputs {
+		// Open input
+		i := &mixerInput{in: newInput(m.t)}
+		m.inputs = append(m.inputs, i)
+		defer i.in.watch(ctx)()
+		i.in.url = mi.URL
+		if err = i.in.open(); err != nil {
+			err = withCode(CodeInputOpenFailed, fmt.Errorf("pipeline: opening mixed input failed: %w", err))
+			return
+		}
+		m.t.c.Add(i.in.close)
+		fc := i.in.formatContext
+
+		// Find stream info
+		if err = fc.FindStreamInfo(nil); err != nil {
+			err = fmt.Errorf("pipeline: finding stream info failed: %w", err)
+			return
+		}
+
+		// Check limits
+		if err = m.t.checkInputLimits(fc); err != nil {
+			return
+		}
+
+		// Open decoder of the first audio stream
+		var is *astiav.Stream
+		if is, err = selectStream(fc, nil, ""); err != nil {
+			return
+		}
+		if i.d, err = m.t.openDecoder(is); err != nil {
+			return
+		}
+		i.in.decoders = map[int]*decoder{is.Index(): i.d}
+
+		// Alloc packet
+		i.pkt = m.t.allocPacket()
+	}
+
+	// Build filters, mixed inputs are resampled and their gain applied
+	names := []string{"in0"}
+	args := []astiav.FilterArgs{decoderArgs(d)}
+	var fs, mixed []string
+	for idx, i := range m.inputs {
+		name := fmt.Sprintf("in%d", idx+1)
+		names = append(names, name)
+		args = append(args, decoderArgs(i.d))
+		fs = append(fs, fmt.Sprintf("[%s]%s,volume=%sdB[a%d]", name, resampleTo(i.d, d), formatFloat(m.t.o.Mix.Inputs[idx].Gain), idx+1))
+		mixed = append(mixed, fmt.Sprintf("[a%d]", idx+1))
+	}
+
+	// Mix inputs. Their levels are kept, amix scaling them down by the
+	// number of inputs otherwise. When ducking, the mixed inputs are
+	// compressed by the opened one.
+	background := strings.Join(mixed, "")
+	if len(mixed) > 1 {
+		fs = append(fs, fmt.Sprintf("%samix=inputs=%d:duration=longest:normalize=0[bg]", background, len(mixed)))
+		background = "[bg]"
+	}
+	if m.t.o.Mix.Duck {
+		fs = append(fs, "[in0]asplit[fg][sc]", background+"[sc]sidechaincompress=threshold=0.02:ratio=8:attack=20:release=300[ducked]")
+		fs = append(fs, "[fg][ducked]amix=inputs=2:duration=first:normalize=0")
+	} else {
+		fs = append(fs, "[in0]"+background+"amix=inputs=2:duration=first:normalize=0")
+	}
+
+	// Create graph
+	m.graph, err = m.t.newGraph(strings.Join(fs, ";"), names, args)
+	return
+}
+
+// write mixes a decoded frame of the opened input at position, after the
+// mixed inputs have been decoded up to its end. A nil frame flushes the mixer,
+// the rest of the mixed inputs being left out.
+func (m *mixer) write(f *astiav.Frame, position time.Duration) (err error) {
+	// Decode mixed inputs
+	for idx, i := range m.inputs {
+		src := m.graph.sources[idx+1]
+		if f != nil {
+			if err = i.decode(src, position+time.Duration(f.NbSamples())*time.Second/time.Duration(m.primary.codecContext.SampleRate())); err != nil {
+				return
+			}
+		} else if !i.ended {
+			i.ended = true
+			if err = src.BuffersrcAddFrame(nil, astiav.NewBuffersrcFlags()); err != nil {
+				err = fmt.Errorf("pipeline: adding frame failed: %w", err)
+				return
+			}
+		}
+	}
+
+	// Add frame
+	if err = m.graph.sources[0].BuffersrcAddFrame(f, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
+		err = fmt.Errorf("pipeline: adding frame failed: %w", err)
+		return
+	}
+
+	// Write mixed frames
+	return m.graph.pull(m.frame, m.emit)
+}
+
+// decode decodes the input into src up to position
+func (i *mixerInput) decode(src *astiav.FilterContext, position time.Duration) (err error) {
+	for !i.ended && i.position < position {
+		// Read frame
+		if err = i.in.readFrame(i.pkt); err != nil {
+			if !errors.Is(err, astiav.ErrEof) {
+				err = fmt.Errorf("pipeline: reading mixed frame failed: %w", err)
+				return
+			}
+			err = nil
+
+			// Flush decoder and source
+			i.ended = true
+			if err = i.send(src, nil); err != nil {
+				return
+			}
+		} else {
+			// Skip other streams
+			if i.pkt.StreamIndex() != i.d.stream.Index() {
+				i.pkt.Unref()
+				continue
+			}
+
+			// Send packet
+			i.pkt.RescaleTs(i.d.stream.TimeBase(), i.d.codecContext.TimeBase())
+			err = i.send(src, i.pkt)
+			i.pkt.Unref()
+			if err != nil {
+				return
+			}
+		}
+
+		// Add decoded frames
+		if err = i.receive(src); err != nil {
+			return
+		}
+		if i.ended {
+			if err = src.BuffersrcAddFrame(nil, astiav.NewBuffersrcFlags()); err != nil {
+				err = fmt.Errorf("pipeline: adding frame failed: %w", err)
+				return
+			}
+		}
+	}
+	return
+}
+
+// send sends a packet to the decoder, nil flushes it. While the decoder is
+// full, its frames are added to src before the packet is sent again.
+func (i *mixerInput) send(src *astiav.FilterContext, pkt *astiav.Packet) (err error) {
+	for {
+		if err = i.d.codecContext.SendPacket(pkt); !errors.Is(err, astiav.ErrEagain) {
+			break
+		}
+		if err = i.receive(src); err != nil {
+			return
+		}
+	}
+	if err != nil {
+		err = withCode(CodeDecodeError, fmt.Errorf("pipeline: sending packet failed: %w", err))
+	}
+	return
+}
+
+// receive adds the frames of the decoder to src
+func (i *mixerInput) receive(src *astiav.FilterContext) (err error) {
+	for {
+		if err = i.d.codecContext.ReceiveFrame(i.d.frame); err != nil {
+			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+				err = nil
+				break
+			}
+			err = withCode(CodeDecodeError, fmt.Errorf("pipeline: receiving frame failed: %w", err))
+			return
+		}
+		i.position += time.Duration(i.d.frame.NbSamples()) * time.Second / time.Duration(i.d.codecContext.SampleRate())
+		err = src.BuffersrcAddFrame(i.d.frame, astiav.NewBuffersrcFlags())
+		i.d.frame.Unref()
+		if err != nil {
+			err = fmt.Errorf("pipeline: adding frame failed: %w", err)
+			return
+		}
+	}
+	return
+}
+
+// emit timestamps a mixed frame and writes it in the outputs
+func (m *mixer) emit(f *astiav.Frame) error {
+	f.SetPts(astiav.RescaleQ(m.samples, astiav.NewRational(1, m.primary.codecContext.SampleRate()), m.primary.codecContext.TimeBase()))
+	m.samples += int64(f.NbSamples())
+	return m.t.write(m.index, f)
+}
+
+// watch interrupts the mixed inputs once ctx is done, until the returned func
+// is called
+func (m *mixer) watch(ctx context.Context) (stop func()) {
+	var stops []func()
+	for _, i := range m.inputs {
+		stops = append(stops, i.in.watch(ctx))
+	}
+	return func() {
+		for _, s := range stops {
+			s()
+		}
+	}
+}
@@ -0,0 +1,639 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// output muxes the encoded audio stream of the input
+type output struct {
+	formatContext *astiav.FormatContext
+	o             Output
+	size          int64                 // Size of the packets written, accessed atomically
+	streams       map[int]*outputStream // Indexed by input stream index
+	t             *Transcoder
+	video         *astiav.Stream // Copy of the video stream of Options.Video, nil without it
+}
+
+// outputStream resamples and encodes a decoded stream into an output stream
+type outputStream struct {
+	buffersinkContext *astiav.FilterContext
+	buffersrcContext  *astiav.FilterContext
+	codec             *astiav.Codec
+	codecContext      *astiav.CodecContext
+	copy              bool   // The packets of the input stream are copied as is, without being decoded and encoded
+	filter            string // Description of filterGraph
+	filterFrame       *astiav.Frame
+	filterGraph       *astiav.FilterGraph
+	inputRate         int // Bytes per second of the decoded audio buffered by filters
+	outputRate        int // Bytes per second of the resampled audio buffered by filters
+	pkt               *astiav.Packet
+	stream            *astiav.Stream
+}
+
+// AddOutput sets up the encoders and filters of an output for each audio
+// stream of the input and writes the output header. It must be called after
+// Open and before Run.
+func (t *Transcoder) AddOutput(o Output) (err error) {
+	// Trace steps, the current one is finished with the error on failure
+	end := t.step("output setup")
+	defer func() { end(err) }()
+
+	// Create output
+	out := &output{
+		o:       o,
+		streams: make(map[int]*outputStream),
+		t:       t,
+	}
+	c := t.c
+
+	// Alloc output format context
+	if out.formatContext, err = astiav.AllocOutputFormatContext(nil, o.Format, o.URL); err != nil {
+		err = fmt.Errorf("pipeline: allocating output format context failed: %w", err)
+		return
+	} else if out.formatContext == nil {
+		err = errors.New("pipeline: output format context is nil")
+		return
+	}
+	c.Add(out.formatContext.Free)
+	t.track(unsafe.Pointer(out.formatContext))
+
+	// Loop through decoders
+	for idx, d := range t.decoders {
+		s := &outputStream{}
+
+		// Create output stream
+		if s.stream = out.formatContext.NewStream(nil); s.stream == nil {
+			err = errors.New("pipeline: output stream is nil")
+			return
+		}
+
+		// Find encoder
+		if s.codec = astiav.FindEncoderByName(o.Codec); s.codec == nil {
+			err = withCode(CodeUnsupportedCodec, fmt.Errorf("pipeline: %s encoder not found", o.Codec))
+			return
+		}
+
+		// Alloc codec context
+		if s.codecContext = astiav.AllocCodecContext(s.codec); s.codecContext == nil {
+			err = errors.New("pipeline: codec context is nil")
+			return
+		}
+		c.Add(s.codecContext.Free)
+		t.track(unsafe.Pointer(s.codecContext))
+
+		// Update channel layout
+		var channelLayout astiav.ChannelLayout
+		if channelLayout, err = ChannelLayout(o.Channels, s.codec.ChannelLayouts()); err != nil {
+			return
+		}
+		s.codecContext.SetChannelLayout(channelLayout)
+		s.codecContext.SetChannels(o.Channels)
+
+		// Update sample rate
+		if err = CheckSampleRate(o.SampleRate, s.codec); err != nil {
+			return
+		}
+		s.codecContext.SetSampleRate(o.SampleRate)
+
+		// Update sample format
+		sampleFormat := d.codecContext.SampleFormat()
+		if v := s.codec.SampleFormats(); len(v) > 0 {
+			result := false
+			for _, x := range v {
+				if x == sampleFormat {
+					result = true
+					break
+				}
+			}
+			if !result {
+				sampleFormat = v[0]
+			}
+		}
+
+		// Copy the packets of the input stream as is when they would only be
+		// decoded and encoded again, which makes passthroughs near instant
+		if out.copies(d, s.codec, channelLayout, sampleFormat) {
+			if err = d.stream.CodecParameters().Copy(s.stream.CodecParameters()); err != nil {
+				err = fmt.Errorf("pipeline: copying codec parameters failed: %w", err)
+				return
+			}
+			s.stream.CodecParameters().SetCodecTag(0)
+			s.stream.SetTimeBase(d.stream.TimeBase())
+			s.copy = true
+			out.streams[idx] = s
+			continue
+		}
+		s.codecContext.SetSampleFormat(sampleFormat)
+		s.codecContext.SetTimeBase(d.codecContext.TimeBase())
+		if o.BitRate > 0 {
+			s.codecContext.SetBitRate(o.BitRate)
+		}
+		t.setThreads(s.codecContext)
+
+		// Update flags
+		if d.codecContext.Flags().Has(astiav.CodecContextFlagGlobalHeader) || out.formatContext.OutputFormat().Flags().Has(astiav.IOFormatFlagGlobalheader) {
+			s.codecContext.SetFlags(s.codecContext.Flags().Add(astiav.CodecContextFlagGlobalHeader))
+		}
+
+		// Open codec context
+		if err = s.codecContext.Open(s.codec, nil); err != nil {
+			err = fmt.Errorf("pipeline: opening codec context failed: %w", err)
+			return
+		}
+
+		// Update codec parameters
+		if err = s.stream.CodecParameters().FromCodecContext(s.codecContext); err != nil {
+			err = fmt.Errorf("pipeline: updating codec parameters failed: %w", err)
+			return
+		}
+
+		// Update stream
+		s.stream.SetTimeBase(s.codecContext.TimeBase())
+
+		// Store stream
+		out.streams[idx] = s
+	}
+
+	// Add the copy of the video stream
+	if t.video != nil {
+		if err = t.video.addStream(out); err != nil {
+			return
+		}
+	}
+
+	// Add the attached picture stream of the cover art
+	coverArt := -1
+	if o.CoverArt != nil {
+		if coverArt, err = addCoverArt(out.formatContext, o.CoverArt); err != nil {
+			return
+		}
+	}
+
+	// If this is a file, we need to use an io context
+	if err = t.openOutputIO(out.formatContext, o.URL); err != nil {
+		return
+	}
+
+	// Set tags and chapters
+	if err = setMetadata(out.formatContext, t.outputMetadata(o)); err != nil {
+		return
+	}
+	if err = setChapters(out.formatContext, t.outputChapters(o)); err != nil {
+		return
+	}
+
+	// Write header
+	opts := astiav.NewDictionary()
+	defer opts.Free()
+	for k, v := range o.MuxerOptions {
+		if err = opts.Set(k, v, astiav.NewDictionaryFlags()); err != nil {
+			err = fmt.Errorf("pipeline: setting muxer options failed: %w", err)
+			return
+		}
+	}
+	if o.StripMetadata {
+		// Bitexact muxers don't write the encoder tag
+		if err = opts.Set("fflags", "+bitexact", astiav.NewDictionaryFlags()); err != nil {
+			err = fmt.Errorf("pipeline: setting muxer options failed: %w", err)
+			return
+		}
+	}
+	if t.o.LowLatency {
+		// Packets are sent to the io context as soon as they're muxed
+		if err = opts.Set("flush_packets", "1", astiav.NewDictionaryFlags()); err != nil {
+			err = fmt.Errorf("pipeline: setting muxer options failed: %w", err)
+			return
+		}
+	}
+	if err = out.formatContext.WriteHeader(opts); err != nil {
+		err = fmt.Errorf("pipeline: writing header failed: %w", err)
+		return
+	}
+	if coverArt >= 0 {
+		if err = writeCoverArt(out.formatContext, coverArt, o.CoverArt); err != nil {
+			return
+		}
+	}
+
+	// Init filters
+	end(nil)
+	end = t.step("filter configure")
+	for idx, s := range out.streams {
+		if s.copy {
+			continue
+		}
+		if err = out.initFilters(t.decoders[idx], s); err != nil {
+			return
+		}
+	}
+
+	// Store output
+	t.outputs = append(t.outputs, out)
+
+	// Check the audio buffered once the transcoded range is decoded, when its
+	// duration is known
+	if t.duration > 0 {
+		err = t.checkMemory(t.duration)
+	}
+	return
+}
+
+// Plan describes how an input stream is written in an output, e.g. to report
+// what a transcode would do without running it
+type Plan struct {
+	Container   string // Muxer name, e.g. wav
+	Copy        bool   // Packets are copied as is, without being decoded and encoded
+	Decoder     string // Decoder name, e.g. mp3float, empty when frames are generated
+	Encoder     string // Encoder name, e.g. pcm_s16le
+	Filter      string // Filter graph between the decoder and the encoder, empty when packets are copied
+	Output      int    // Index of the output, in the order outputs were added
+	StreamIndex int    // Input index of the stream
+	URL         string // Where the output is written
+}
+
+// Plans returns how each stream is written in each output. It must be called
+// after AddOutput.
+func (t *Transcoder) Plans() (ps []Plan) {
+	for i, out := range t.outputs {
+		for idx, s := range out.streams {
+			p := Plan{
+				Container:   out.o.Format,
+				Copy:        s.copy,
+				Encoder:     s.codec.Name(),
+				Filter:      s.filter,
+				Output:      i,
+				StreamIndex: idx,
+				URL:         out.o.URL,
+			}
+			if d := t.decoders[idx]; d != nil && d.codec != nil {
+				p.Decoder = d.codec.Name()
+			}
+			ps = append(ps, p)
+		}
+	}
+	return
+}
+
+// copies reports whether the packets of the input stream of d can be copied
+// as is: they're in the codec of the encoder c, they decode to frames in the
+// format it would be fed with and neither the transcoder nor the output
+// filters them
+func (out *output) copies(d *decoder, c *astiav.Codec, l astiav.ChannelLayout, f astiav.SampleFormat) bool {
+	// Check transcoder
+	t := out.t
+	if t.concat != nil || t.mix != nil || t.signal != nil || t.o.Start > 0 || t.o.Duration > 0 {
+		return false
+	}
+
+	// Check format
+	cc := d.codecContext
+	if out.o.BitRate > 0 || cc.CodecID().Name() != c.Name() || cc.SampleFormat() != f || cc.SampleRate() != out.o.SampleRate ||
+		cc.Channels() != out.o.Channels || (cc.ChannelLayout() != 0 && cc.ChannelLayout() != l) {
+		return false
+	}
+
+	// Check filters
+	fs, err := out.o.customFilters(cc.SampleRate())
+	return err == nil && len(fs) == 0 && len(out.o.targetFilters(cc.SampleRate())) == 0
+}
+
+// openOutputIO opens the io context of an output format context writing in
+// url, unless its muxer writes by itself
+func (t *Transcoder) openOutputIO(fc *astiav.FormatContext, url string) (err error) {
+	if fc.OutputFormat().Flags().Has(astiav.IOFormatFlagNofile) {
+		return
+	}
+
+	// Create io context
+	ioContext := astiav.NewIOContext()
+
+	// Open io context
+	if err = ioContext.Open(url, astiav.NewIOContextFlags(astiav.IOContextFlagWrite)); err != nil {
+		err = fmt.Errorf("pipeline: opening io context failed: %w", err)
+		return
+	}
+	t.c.AddWithError(ioContext.Closep)
+
+	// Update output format context
+	fc.SetPb(ioContext)
+	return
+}
+
+// initFilters sets up the filter graph resampling the decoded stream into the
+// encoder format
+func (out *output) initFilters(d *decoder, s *outputStream) (err error) {
+	c := out.t.c
+
+	// Alloc graph
+	if s.filterGraph = astiav.AllocFilterGraph(); s.filterGraph == nil {
+		err = errors.New("pipeline: graph is nil")
+		return
+	}
+	c.Add(s.filterGraph.Free)
+	out.t.track(unsafe.Pointer(s.filterGraph))
+
+	// Alloc outputs
+	outputs := astiav.AllocFilterInOut()
+	if outputs == nil {
+		err = errors.New("pipeline: outputs is nil")
+		return
+	}
+	c.Add(outputs.Free)
+
+	// Alloc inputs
+	inputs := astiav.AllocFilterInOut()
+	if inputs == nil {
+		err = errors.New("pipeline: inputs is nil")
+		return
+	}
+	c.Add(inputs.Free)
+
+	// Support only audio type
+	args := astiav.FilterArgs{
+		"channel_layout": d.codecContext.ChannelLayout().String(),
+		"sample_fmt":     d.codecContext.SampleFormat().Name(),
+		"sample_rate":    strconv.Itoa(d.codecContext.SampleRate()),
+		"time_base":      d.codecContext.TimeBase().String(),
+	}
+	buffersrc := astiav.FindFilterByName("abuffer")
+	buffersink := astiav.FindFilterByName("abuffersink")
+	filters, err := out.o.customFilters(d.codecContext.SampleRate())
+	if err != nil {
+		return
+	}
+	resampleOptions, err := out.o.resampleOptions()
+	if err != nil {
+		return
+	}
+	filters = append(filters, fmt.Sprintf("aresample=isr=%d:osr=%d:icl=%s:ocl=%s:isf=%s:osf=%s%s", d.codecContext.SampleRate(), s.codecContext.SampleRate(), d.codecContext.ChannelLayout().String(), s.codecContext.ChannelLayout().String(), d.codecContext.SampleFormat().Name(), s.codecContext.SampleFormat().Name(), resampleOptions))
+	filters = append(filters, out.o.targetFilters(s.codecContext.SampleRate())...)
+	s.inputRate = d.codecContext.SampleRate() * d.codecContext.Channels() * bufferedSampleBytes
+	s.outputRate = s.codecContext.SampleRate() * s.codecContext.Channels() * bufferedSampleBytes
+
+	// Encoders such as aac only take frames of a fixed number of samples
+	if n := s.codecContext.FrameSize(); n > 0 {
+		filters = append(filters, fmt.Sprintf("asetnsamples=n=%d:p=0", n))
+	}
+	content := strings.Join(filters, ",")
+	s.filter = content
+
+	// Check filters
+	if buffersrc == nil {
+		err = errors.New("pipeline: buffersrc is nil")
+		return
+	}
+	if buffersink == nil {
+		err = errors.New("pipeline: buffersink is nil")
+		return
+	}
+
+	// Create filter contexts
+	if s.buffersrcContext, err = s.filterGraph.NewFilterContext(buffersrc, "in", args); err != nil {
+		err = fmt.Errorf("pipeline: creating buffersrc context failed: %w", err)
+		return
+	}
+	if s.buffersinkContext, err = s.filterGraph.NewFilterContext(buffersink, "in", nil); err != nil {
+		err = fmt.Errorf("pipeline: creating buffersink context failed: %w", err)
+		return
+	}
+
+	// Update outputs
+	outputs.SetName("in")
+	outputs.SetFilterContext(s.buffersrcContext)
+	outputs.SetPadIdx(0)
+	outputs.SetNext(nil)
+
+	// Update inputs
+	inputs.SetName("out")
+	inputs.SetFilterContext(s.buffersinkContext)
+	inputs.SetPadIdx(0)
+	inputs.SetNext(nil)
+
+	// Parse
+	if err = s.filterGraph.Parse(content, inputs, outputs); err != nil {
+		err = fmt.Errorf("pipeline: parsing filter failed: %w", err)
+		return
+	}
+
+	// Configure
+	if err = s.filterGraph.Configure(); err != nil {
+		err = fmt.Errorf("pipeline: configuring filter failed: %w", err)
+		return
+	}
+
+	// Alloc frame
+	s.filterFrame = out.t.allocFrame()
+
+	// Alloc packet
+	s.pkt = out.t.allocPacket()
+	return
+}
+
+// write filters, encodes and writes a decoded frame of the input stream at idx
+func (out *output) write(idx int, f *astiav.Frame) (err error) {
+	// Get stream
+	s, ok := out.streams[idx]
+	if !ok || s.copy {
+		return
+	}
+
+	// Filter, encode and write frame
+	if err = out.filterEncodeWriteFrame(f, s); err != nil {
+		err = fmt.Errorf("pipeline: filtering, encoding and writing frame failed: %w", err)
+		return
+	}
+	return out.checkSize()
+}
+
+// writePacket writes a copy of a packet of the input stream at idx, whose
+// timestamps are in timeBase, if the output copies the stream
+func (out *output) writePacket(idx int, pkt *astiav.Packet, timeBase astiav.Rational) error {
+	s, ok := out.streams[idx]
+	if !ok || !s.copy {
+		return nil
+	}
+	return out.writeCopy(s.stream, pkt, timeBase)
+}
+
+// writeVideo writes a copy of a packet of the video input, whose timestamps
+// are in timeBase
+func (out *output) writeVideo(pkt *astiav.Packet, timeBase astiav.Rational) error {
+	return out.writeCopy(out.video, pkt, timeBase)
+}
+
+// writeCopy writes a copy of a packet in the output stream st
+func (out *output) writeCopy(st *astiav.Stream, pkt *astiav.Packet, timeBase astiav.Rational) (err error) {
+	// Copy packet, since writing it takes its reference
+	p := pkt.Clone()
+	if p == nil {
+		return errors.New("pipeline: packet is nil")
+	}
+	defer p.Free()
+
+	// Update packet
+	p.SetStreamIndex(st.Index())
+	p.SetPos(-1)
+	p.RescaleTs(timeBase, st.TimeBase())
+	if st != out.video {
+		if err = out.checkDuration(p, st.TimeBase()); err != nil {
+			return
+		}
+	}
+
+	// Write packet
+	atomic.AddInt64(&out.size, int64(p.Size()))
+	if err = out.formatContext.WriteInterleavedFrame(p); err != nil {
+		err = fmt.Errorf("pipeline: writing copied frame failed: %w", err)
+		return
+	}
+	return out.checkSize()
+}
+
+// flush flushes the filters and encoders of the output
+func (out *output) flush() (err error) {
+	for _, s := range out.streams {
+		if s.copy {
+			continue
+		}
+
+		// Flush filter
+		if err = out.filterEncodeWriteFrame(nil, s); err != nil {
+			err = fmt.Errorf("pipeline: filtering, encoding and writing frame failed: %w", err)
+			return
+		}
+
+		// Flush encoder
+		if err = out.encodeWriteFrame(nil, s); err != nil {
+			err = fmt.Errorf("pipeline: encoding and writing frame failed: %w", err)
+			return
+		}
+		if err = out.checkSize(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// checkSize checks the size of the packets written against the limit, so
+// that a pathological input can't fill the disk
+func (out *output) checkSize() error {
+	if max := out.t.o.Limits.MaxOutputSize; max > 0 && atomic.LoadInt64(&out.size) > max {
+		return fmt.Errorf("%w: size exceeds %d bytes", ErrOutputLimitExceeded, max)
+	}
+	return nil
+}
+
+// checkDuration checks the end of an audio packet, whose timestamps are in
+// timeBase, against the maximum duration of the output before it's written
+func (out *output) checkDuration(pkt *astiav.Packet, timeBase astiav.Rational) error {
+	max := out.o.MaxDuration
+	if max <= 0 {
+		return nil
+	}
+	if end := time.Duration(float64(pkt.Pts()+pkt.Duration()) * timeBase.ToDouble() * float64(time.Second)); end > max {
+		return fmt.Errorf("%w: duration exceeds %s", ErrOutputLimitExceeded, max)
+	}
+	return nil
+}
+
+func (out *output) filterEncodeWriteFrame(f *astiav.Frame, s *outputStream) (err error) {
+	// Add frame
+	if err = s.buffersrcContext.BuffersrcAddFrame(f, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
+		// The graph ends before the input once the target duration is reached
+		if !errors.Is(err, astiav.ErrEof) {
+			err = fmt.Errorf("pipeline: adding frame failed: %w", err)
+			return
+		}
+		err = nil
+	}
+
+	// Loop
+	for {
+		// Unref frame
+		s.filterFrame.Unref()
+
+		// Get frame
+		if err = s.buffersinkContext.BuffersinkGetFrame(s.filterFrame, astiav.NewBuffersinkFlags()); err != nil {
+			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+				err = nil
+				break
+			}
+			err = fmt.Errorf("pipeline: getting frame failed: %w", err)
+			return
+		}
+
+		// Reset picture type
+		s.filterFrame.SetPictureType(astiav.PictureTypeNone)
+
+		// Encode and write frame
+		if err = out.encodeWriteFrame(s.filterFrame, s); err != nil {
+			err = fmt.Errorf("pipeline: encoding and writing frame failed: %w", err)
+			return
+		}
+	}
+	return
+}
+
+// encodeWriteFrame encodes a frame, nil flushes the encoder, and writes the
+// packets received. Encoders taking frames of a fixed size, such as aac or
+// opus, can be full before they're sent a frame: their packets are then
+// written first and the frame is sent again.
+func (out *output) encodeWriteFrame(f *astiav.Frame, s *outputStream) (err error) {
+	// Send frame
+	for {
+		if err = s.codecContext.SendFrame(f); !errors.Is(err, astiav.ErrEagain) {
+			break
+		}
+		if err = out.writePackets(s); err != nil {
+			return
+		}
+	}
+	if err != nil {
+		err = withCode(CodeEncodeError, fmt.Errorf("pipeline: sending frame failed: %w", err))
+		return
+	}
+	return out.writePackets(s)
+}
+
+// writePackets receives the packets of the encoder until it needs another
+// frame, and writes them
+func (out *output) writePackets(s *outputStream) (err error) {
+	// Unref packet
+	s.pkt.Unref()
+
+	// Loop
+	for {
+		// Receive packet
+		if err = s.codecContext.ReceivePacket(s.pkt); err != nil {
+			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+				err = nil
+				break
+			}
+			err = withCode(CodeEncodeError, fmt.Errorf("pipeline: receiving packet failed: %w", err))
+			return
+		}
+
+		// Update pkt
+		s.pkt.SetStreamIndex(s.stream.Index())
+		s.pkt.RescaleTs(s.codecContext.TimeBase(), s.stream.TimeBase())
+		if err = out.checkDuration(s.pkt, s.stream.TimeBase()); err != nil {
+			return
+		}
+
+		// Write frame
+		atomic.AddInt64(&out.size, int64(s.pkt.Size()))
+		if err = out.formatContext.WriteInterleavedFrame(s.pkt); err != nil {
+			err = fmt.Errorf("pipeline: writing frame failed: %w", err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,1065 @@
+// Package pipeline transcodes audio with FFmpeg: a Transcoder opens an input,
+// decodes one of its audio streams once and resamples, encodes and muxes it
+// into one or more outputs.
+//
+//	t := pipeline.New(pipeline.Options{})
+//	defer t.Close()
+//	if err := t.Open(ctx, "https://example.com/in.mp3"); err != nil { ... }
+//	if err := t.AddOutput(pipeline.Output{Format: "wav", Codec: "pcm_s16le", URL: "out.wav", Channels: 1, SampleRate: 16000}); err != nil { ... }
+//	if err := t.Run(ctx); err != nil { ... }
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+	"github.com/asticode/go-astikit"
+)
+
+var (
+	// ErrCanceled is returned when the context is canceled
+	ErrCanceled = errors.New("pipeline: canceled")
+	// ErrNoAudioStream is returned when the input has no audio stream to decode
+	ErrNoAudioStream = errors.New("pipeline: no audio stream")
+	// ErrNoSubtitleStream is returned when the input has no subtitle stream to extract
+	ErrNoSubtitleStream = errors.New("pipeline: no subtitle stream")
+	// ErrSubtitleCodec is returned when a subtitle stream isn't in the codec of the requested format
+	ErrSubtitleCodec = errors.New("pipeline: subtitle codec not supported")
+	// ErrInputLimitExceeded is returned when the input exceeds one of the limits
+	ErrInputLimitExceeded = errors.New("pipeline: input limit exceeded")
+	// ErrMemoryLimitExceeded is returned when the audio buffered by the
+	// filters would exceed the memory limit
+	ErrMemoryLimitExceeded = errors.New("pipeline: memory limit exceeded")
+	// ErrOutputLimitExceeded is returned when an output exceeds its size or
+	// duration limit
+	ErrOutputLimitExceeded = errors.New("pipeline: output limit exceeded")
+	// ErrTimeout is returned when the deadline of the context is exceeded
+	ErrTimeout = errors.New("pipeline: timed out")
+)
+
+// Options configures a Transcoder
+type Options struct {
+	Duration        time.Duration // Duration of the input transcoded from Start, 0 transcodes until its end
+	ExtractAudio    bool          // The input is a video, e.g. MP4, MKV or WebM, whose video, subtitle and data streams are ignored
+	Headers         []string      // HTTP headers sent when fetching the input, as "Key: Value"
+	HWAccel         HWAccel       // Hardware device video streams are decoded on when no hardware decoder opens
+	HWDecoders      []string      // Hardware decoders tried first for video streams by suffix, e.g. cuvid for h264_cuvid, in order
+	Hooks           Hooks         // Optional
+	InputPolicy     *InputPolicy  // Nil allows any input
+	Concat          Concat        // Inputs appended to the one opened
+	Language        string        // Language of the audio stream decoded when StreamIndex is nil, e.g. eng, empty picks the first audio stream
+	Limits          Limits
+	LowLatency      bool          // The input is demuxed without buffering and outputs flush each packet, so that frames are written as soon as they're encoded
+	Mix             Mix           // Inputs mixed into the one opened
+	Realtime        bool          // Frames are written in the outputs at the pace they're played, e.g. to push a live stream
+	Retries         int           // Retries on transient input network failures
+	RetryBackoff    time.Duration // Initial retry backoff, doubled on each retry
+	RetryBackoffMax time.Duration // 0 doesn't cap the backoff
+	Split           Split         // Parallel decoding of long inputs
+	Start           time.Duration // Position of the input transcoding starts at
+	StreamIndex     *int          // Index among the input streams of the audio stream decoded
+	Threads         int           // FFmpeg threads of each decoder and encoder, 0 keeps the FFmpeg default of 1
+	Video           string        // URL of a video input whose first video stream is copied into the outputs, e.g. to dub it with the audio
+}
+
+// Limits protects the transcoder from inputs that are too large. Zero values
+// disable the limits.
+type Limits struct {
+	MaxInputDuration time.Duration
+	MaxInputSize     int64 // In bytes of packets read
+	MaxInputStreams  int
+	MaxMemory        int64 // In estimated bytes of decoded audio buffered, see MemoryEstimate
+	MaxOutputSize    int64 // In bytes of packets written, per output
+}
+
+// Hooks lets callers observe a Transcoder, nil funcs are skipped
+type Hooks struct {
+	// FFmpegContext is called with the pointer, formatted as the parent of
+	// FFmpeg log callbacks, of each FFmpeg context used by the transcoder so
+	// that its logs can be attributed. The returned func, if any, is called
+	// before the context is freed since its address can be reused.
+	FFmpegContext func(pointer string) (release func())
+	// Step is called when a step starts. The returned func, if any, is called
+	// with its error once it ends.
+	Step func(name string) (end func(err error))
+}
+
+// Output describes an encoded output
+type Output struct {
+	BitRate    int64         // In bits per second of lossy encoders, e.g. libmp3lame, 0 keeps the encoder default
+	Channels   int           // Up to MaxChannels
+	ChannelMap string        // One of the ChannelMap presets or a pan filter matrix, e.g. mono|c0=0.7*FL+0.3*FR
+	Codec      string        // Encoder name, e.g. pcm_s16le
+	Denoise    string        // DenoiseLight, DenoiseMedium or DenoiseStrong, empty disables noise reduction
+	EQ         []EQBand      // Applied after the high-pass and low-pass filters
+	FadeIn     time.Duration // Fade from the start of the output, after silence is trimmed
+	FadeOut    time.Duration // Fade to the end of the output, the output is then only written once the whole input is decoded
+	Format     string        // Muxer name, e.g. wav
+	Gain       float64       // In dB, applied after normalization
+	HighPass   float64       // Cutoff frequency in Hz, 0 disables the filter
+	LowPass    float64       // Cutoff frequency in Hz, 0 disables the filter
+	Normalize  string        // NormalizeEBU, NormalizePeak or NormalizeRMS, empty disables normalization
+	PadEnd     time.Duration // Silence appended
+	PadStart   time.Duration // Silence prepended
+	Pitch      float64       // In semitones, up to MaxPitch both ways
+	SampleRate int
+	Tempo      float64 // Speed ratio between MinTempo and MaxTempo, 0 keeps the tempo
+	URL        string  // Where the muxer writes, e.g. a file path or pipe:1
+
+	// Resampling into the encoder format, soxr sounds better when
+	// downsampling a lot, e.g. 48k to 8k, but FFmpeg must be built with it
+	Dither             string // One of DitherMethods, empty disables dithering
+	Resampler          string // ResamplerSWR or ResamplerSoxr, empty defaults to swr
+	ResamplerPrecision int    // Bits of precision of soxr between MinSoxrPrecision and MaxSoxrPrecision, 0 defaults to 20
+
+	// Leading and trailing silence is trimmed when TrimSilence is set, the
+	// output is then only written once the whole input is decoded
+	SilenceDuration  time.Duration // Sound shorter than this, e.g. clicks, is trimmed as silence
+	SilenceThreshold float64       // In dBFS, e.g. -50
+	TrimSilence      bool
+
+	// Filter is a custom filter chain, e.g. highpass=f=300,lowpass=f=3400,
+	// appended to the filters generated from the other fields or replacing
+	// them when ReplaceFilters is set. The resampling into the encoder format
+	// is always kept.
+	Filter         string
+	ReplaceFilters bool
+
+	// TargetDuration is the exact duration of the output, e.g. to fill an ad
+	// break. It's applied after the resampling into the encoder format: the
+	// audio is padded with silence, or looped when Loop is set, then cut.
+	Loop           bool
+	TargetDuration time.Duration
+
+	// Metadata are the tags of the output container, e.g. title, replacing
+	// those of the input container, which are kept unless StripMetadata is
+	// set. Tags with an empty value are removed, and muxers only write the
+	// tags their format supports. StripMetadata also keeps muxers from
+	// writing the encoder tag, so that only Metadata is written.
+	Metadata      map[string]string
+	StripMetadata bool
+
+	// Chapters are written by muxers supporting them, e.g. mp3 or matroska. When
+	// nil, those of the input are kept unless StripMetadata is set or the
+	// timeline of the output differs, see outputChapters.
+	Chapters []Chapter
+
+	// CoverArt is written as an attached picture stream by muxers supporting
+	// one, e.g. as the APIC frame of mp3. Its Codec is mjpeg or png, and the
+	// cover art of the input is never copied.
+	CoverArt *Picture
+
+	// MuxerOptions are passed to the muxer when the header is written, e.g.
+	// hls_time for segmented formats writing several files next to URL
+	MuxerOptions map[string]string
+
+	// MaxDuration fails the transcode with ErrOutputLimitExceeded once the
+	// audio written exceeds it, e.g. for platforms rejecting longer files. 0
+	// is unlimited.
+	MaxDuration time.Duration
+}
+
+// Transcoder transcodes an audio stream of an input into outputs. Open, or
+// OpenSignal to generate a test signal instead, must be called first, then
+// AddOutput once per output and analyses such as AddWaveform, then Run. Close
+// frees all resources and must always be called.
+type Transcoder struct {
+	analyses []*analyzer // Of the decoded audio, along with outputs
+	c        *astikit.Closer
+	concat   *concatenation   // Nil unless inputs are concatenated
+	mix      *mixer           // Nil unless inputs are mixed
+	segment  bool             // Decodes a segment of a split input
+	signal   *graph           // Nil unless a test signal is generated
+	split    *split           // Nil unless the input is decoded in segments
+	video    *videoCopy       // Nil unless a video is dubbed
+	decoders map[int]*decoder // Indexed by input stream index
+	duration time.Duration    // Probed duration of the transcoded range, 0 if unknown
+	in       *input
+	o        Options
+	outputs  []*output
+	position int64 // Decoded duration in nanoseconds, accessed atomically
+
+	// ctx and started are those of Run, writes are paced from them when
+	// Options.Realtime is set
+	ctx     context.Context
+	started time.Time
+}
+
+// decoder decodes an audio stream of the input, or the video stream of a
+// thumbnail
+type decoder struct {
+	codec        *astiav.Codec // Nil when frames are generated
+	codecContext *astiav.CodecContext
+	copied       bool // All outputs copy its packets, which aren't decoded
+	ended        bool // Its frames have passed the end of the transcoded range
+	frame        *astiav.Frame
+	stream       *astiav.Stream // Updated when the input is reopened
+}
+
+// New creates a transcoder
+func New(o Options) *Transcoder {
+	t := &Transcoder{
+		c:        astikit.NewCloser(),
+		decoders: make(map[int]*decoder),
+		o:        o,
+	}
+	t.in = newInput(t)
+	return t
+}
+
+// Close frees all resources
+func (t *Transcoder) Close() {
+	t.c.Close()
+}
+
+// Duration returns the probed duration of the input, or of the range of it
+// being transcoded, 0 if unknown
+func (t *Transcoder) Duration() time.Duration {
+	return t.duration
+}
+
+// Position returns the duration of the input decoded so far, from Start
+func (t *Transcoder) Position() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.position))
+}
+
+// BytesWritten returns the size of the packets written in all outputs so far
+func (t *Transcoder) BytesWritten() (n int64) {
+	for _, o := range t.outputs {
+		n += atomic.LoadInt64(&o.size)
+	}
+	return
+}
+
+// Progress returns the percentage of the input decoded so far, false if the
+// input duration is unknown
+func (t *Transcoder) Progress() (float64, bool) {
+	if t.duration <= 0 {
+		return 0, false
+	}
+	p := float64(t.Position()) / float64(t.duration) * 100
+	if p < 0 {
+		p = 0
+	} else if p > 100 {
+		p = 100
+	}
+	return p, true
+}
+
+// IsTransient reports whether err looks like a network failure of the input
+// that may succeed if tried again later
+func IsTransient(err error) bool {
+	return isTransientInputError(err)
+}
+
+// Open opens the input, retrying transient network failures, and sets up the
+// decoder of the selected audio stream. The input is interrupted when ctx is done.
+func (t *Transcoder) Open(ctx context.Context, url string) (err error) {
+	// Interrupt the input when the context is done
+	defer t.in.watch(ctx)()
+	defer t.checkInterrupted(&err)
+
+	// Trace steps, the current one is finished with the error on failure
+	end := t.step("input open")
+	defer func() { end(err) }()
+
+	// Create concatenation or mixer
+	if len(t.o.Concat.URLs) > 0 {
+		if t.concat, err = newConcatenation(t); err != nil {
+			return
+		}
+	} else if len(t.o.Mix.Inputs) > 0 {
+		if t.mix, err = newMixer(t); err != nil {
+			return
+		}
+	}
+
+	// Open input
+	t.in.url = url
+	if err = t.in.open(); err != nil {
+		err = withCode(CodeInputOpenFailed, fmt.Errorf("pipeline: opening input failed: %w", err))
+		return
+	}
+	t.c.Add(t.in.close)
+	fc := t.in.formatContext
+
+	// Find stream info
+	if err = fc.FindStreamInfo(nil); err != nil {
+		err = fmt.Errorf("pipeline: finding stream info failed: %w", err)
+		return
+	}
+
+	// Store duration of the transcoded range
+	if d := fc.Duration(); d > 0 && d != astiav.NoPtsValue {
+		t.duration = time.Duration(astiav.RescaleQ(d, astiav.TimeBaseQ, astiav.NewRational(1, int(time.Second))))
+		if t.o.Start > 0 {
+			if t.o.Start >= t.duration {
+				err = fmt.Errorf("pipeline: start %s is past the end of the input at %s", t.o.Start, t.duration)
+				return
+			}
+			t.duration -= t.o.Start
+		}
+	}
+	if t.o.Duration > 0 && (t.duration <= 0 || t.o.Duration < t.duration) {
+		t.duration = t.o.Duration
+	}
+
+	// Check limits
+	if err = t.checkInputLimits(fc); err != nil {
+		return
+	}
+
+	// The duration of concatenated inputs is unknown until they're opened
+	if t.concat != nil {
+		t.duration = 0
+	}
+
+	// Seek start. Inputs which can't be seeked, such as pipes, are decoded
+	// from their beginning instead, frames before the start being dropped.
+	// Segments are seeked a bit earlier so that their first frame decodes as
+	// it would without seeking.
+	if t.o.Start > 0 {
+		start := t.o.Start
+		if t.segment {
+			if start -= segmentPreroll; start < 0 {
+				start = 0
+			}
+		}
+		ts := astiav.RescaleQ(int64(start), astiav.NewRational(1, int(time.Second)), astiav.TimeBaseQ)
+		if st := fc.StartTime(); st != astiav.NoPtsValue {
+			ts += st
+		}
+		fc.SeekFrame(-1, ts, astiav.NewSeekFlags(astiav.SeekFlagBackward))
+	}
+
+	// Set up decoder
+	end(nil)
+	end = t.step("stream setup")
+	var d *decoder
+	if d, err = t.openStream(fc); err != nil {
+		return
+	}
+	if t.concat != nil {
+		err = t.concat.open(d)
+	} else if t.mix != nil {
+		err = t.mix.open(ctx, d)
+	} else if t.splits(d) {
+		err = t.openSplit(d)
+	}
+	if err != nil || t.o.Video == "" {
+		return
+	}
+
+	// Open video
+	t.video, err = newVideoCopy(ctx, t)
+	return
+}
+
+// openStream sets up and stores the decoder of the selected audio stream of
+// the input
+func (t *Transcoder) openStream(fc *astiav.FormatContext) (d *decoder, err error) {
+	// Select stream, files written by the transcoder have a single one
+	index, language := t.o.StreamIndex, t.o.Language
+	if t.in.trusted {
+		index, language = nil, ""
+	}
+	var is *astiav.Stream
+	if is, err = selectStream(fc, index, language); err != nil {
+		return
+	}
+
+	// Open decoder
+	if d, err = t.openDecoder(is); err != nil {
+		return
+	}
+
+	// Store decoder
+	t.decoders[is.Index()] = d
+	return
+}
+
+// openDecoder sets up the decoder of an input stream
+func (t *Transcoder) openDecoder(is *astiav.Stream) (d *decoder, err error) {
+	// Create decoder
+	d = &decoder{stream: is}
+
+	// Find decoder
+	codec := astiav.FindDecoder(is.CodecParameters().CodecID())
+	if codec == nil {
+		err = withCode(CodeUnsupportedCodec, fmt.Errorf("pipeline: %s decoder not found", is.CodecParameters().CodecID()))
+		return
+	}
+
+	// Try the hardware decoders of video streams first, falling back to the
+	// software decoder when none of them is available or opens. They output
+	// frames in system memory, like the software decoder.
+	if is.CodecParameters().MediaType() == astiav.MediaTypeVideo {
+		for _, s := range t.o.HWDecoders {
+			c := astiav.FindDecoderByName(codec.Name() + "_" + s)
+			if c == nil {
+				continue
+			}
+			if d.codecContext, err = t.openCodecContext(is, c, HWAccel{}); err == nil {
+				d.codec = c
+				d.frame = t.allocFrame()
+				return
+			}
+		}
+	}
+
+	// Then the hwaccel of the software decoder, falling back to software
+	// decoding when it has none of that type or the device can't be created.
+	// Frames are downloaded into system memory as they're received.
+	if is.CodecParameters().MediaType() == astiav.MediaTypeVideo && t.o.HWAccel.Type != "" {
+		if d.codecContext, err = t.openCodecContext(is, codec, t.o.HWAccel); err == nil {
+			d.codec = codec
+			d.frame = t.allocFrame()
+			return
+		}
+	}
+
+	// Open codec context
+	if d.codecContext, err = t.openCodecContext(is, codec, HWAccel{}); err != nil {
+		return
+	}
+	d.codec = codec
+
+	// Alloc frame
+	d.frame = t.allocFrame()
+	return
+}
+
+// openCodecContext opens a codec context of the codec decoding an input
+// stream, on the device of hw when its type is set
+func (t *Transcoder) openCodecContext(is *astiav.Stream, codec *astiav.Codec, hw HWAccel) (cc *astiav.CodecContext, err error) {
+	// Alloc codec context
+	if cc = astiav.AllocCodecContext(codec); cc == nil {
+		err = errors.New("pipeline: codec context is nil")
+		return
+	}
+	t.c.Add(cc.Free)
+	t.track(unsafe.Pointer(cc))
+
+	// Update codec context
+	if err = is.CodecParameters().ToCodecContext(cc); err != nil {
+		err = fmt.Errorf("pipeline: updating codec context failed: %w", err)
+		return
+	}
+
+	// Update threads
+	t.setThreads(cc)
+
+	// Default channel layout, when the input doesn't tell it
+	if is.CodecParameters().MediaType() == astiav.MediaTypeAudio && cc.ChannelLayout() == 0 {
+		var l astiav.ChannelLayout
+		if l, err = ChannelLayout(cc.Channels(), nil); err != nil {
+			return
+		}
+		cc.SetChannelLayout(l)
+	}
+
+	// Set up hwaccel
+	if hw.Type != "" {
+		if err = setHWAccel(cc, codec, hw); err != nil {
+			return
+		}
+	}
+
+	// Open codec context
+	if err = cc.Open(codec, nil); err != nil {
+		err = fmt.Errorf("pipeline: opening codec context failed: %w", err)
+		return
+	}
+	return
+}
+
+// selectStream returns the audio stream decoded: the one at index, the first
+// one of language or the first one. Only one stream is decoded since muxing
+// several of them into outputs such as WAV files breaks them.
+func selectStream(fc *astiav.FormatContext, index *int, language string) (*astiav.Stream, error) {
+	ss := fc.Streams()
+	if index != nil {
+		i := *index
+		if i < 0 || i >= len(ss) {
+			return nil, fmt.Errorf("pipeline: stream %d isn't an audio stream", i)
+		}
+		if t := ss[i].CodecParameters().MediaType(); t != astiav.MediaTypeAudio {
+			return nil, fmt.Errorf("pipeline: stream %d is a %s stream, not an audio one", i, t)
+		}
+		return ss[i], nil
+	}
+	for _, s := range ss {
+		if s.CodecParameters().MediaType() == astiav.MediaTypeAudio && (language == "" || streamLanguage(s) == language) {
+			return s, nil
+		}
+	}
+	if language != "" {
+		return nil, fmt.Errorf("%w in %s", ErrNoAudioStream, language)
+	}
+	return nil, ErrNoAudioStream
+}
+
+// setThreads sets the threads of a codec context before it's opened, FFmpeg
+// picking frame or slice threading among the ones the codec supports
+func (t *Transcoder) setThreads(cc *astiav.CodecContext) {
+	if t.o.Threads > 0 {
+		cc.SetThreadCount(t.o.Threads)
+		cc.SetThreadType(astiav.ThreadTypeFrame | astiav.ThreadTypeSlice)
+	}
+}
+
+// streamLanguage returns the language tag of a stream, e.g. eng
+func streamLanguage(s *astiav.Stream) string {
+	if e := s.Metadata().Get("language", nil, 0); e != nil {
+		return e.Value()
+	}
+	return ""
+}
+
+// Run transcodes all packets into the outputs, flushes them and writes their
+// trailer. The input is interrupted when ctx is done.
+func (t *Transcoder) Run(ctx context.Context) (err error) {
+	// Interrupt the input when the context is done
+	defer t.in.watch(ctx)()
+	if t.mix != nil {
+		defer t.mix.watch(ctx)()
+	}
+	if t.video != nil {
+		defer t.video.in.watch(ctx)()
+	}
+	defer t.checkInterrupted(&err)
+
+	// Trace steps, the current one is finished with the error on failure
+	end := t.step("packet loop")
+	defer func() { end(err) }()
+
+	// Pace writes from now on
+	t.ctx, t.started = ctx, time.Now()
+
+	// Decode the segments of a split input in the background
+	if t.split != nil {
+		t.decodeSegments(ctx, t.concat.first)
+	}
+
+	// Generate the signal or transcode the packets of the input
+	if t.signal != nil {
+		err = t.generate()
+	} else {
+		err = t.transcodePackets()
+	}
+	if err != nil {
+		return
+	}
+
+	// Flush outputs and analyzers
+	if err = t.eachOutput((*output).flush); err != nil {
+		return
+	}
+	for _, a := range t.analyses {
+		if err = a.write(nil); err != nil {
+			return
+		}
+	}
+
+	// Copy the rest of the video
+	if t.video != nil {
+		if err = t.video.copy(-1); err != nil {
+			return
+		}
+	}
+
+	// Write trailers
+	end(nil)
+	end = t.step("trailer write")
+	for _, o := range t.outputs {
+		if err = o.formatContext.WriteTrailer(); err != nil {
+			err = fmt.Errorf("pipeline: writing trailer failed: %w", err)
+			return
+		}
+	}
+	return
+}
+
+// transcodePackets decodes all packets of the input and writes their frames
+// in the outputs, through the concatenation or mixer if any
+func (t *Transcoder) transcodePackets() (err error) {
+	// Alloc packet
+	pkt := t.allocPacket()
+
+	// Read the segments of a split input in place of the input
+	if t.split != nil {
+		if err = t.nextInput(); err != nil {
+			return
+		}
+	}
+
+	// Don't decode the streams all outputs copy, unless they're analyzed
+	for idx, d := range t.decoders {
+		d.copied = len(t.outputs) > 0 && len(t.analyses) == 0
+		for _, o := range t.outputs {
+			if s, ok := o.streams[idx]; !ok || !s.copy {
+				d.copied = false
+			}
+		}
+	}
+
+	// Loop through packets
+	for {
+		// Read frame, reopening the input on transient network failures
+		if err = t.in.readFrame(pkt); err != nil {
+			if errors.Is(err, astiav.ErrEof) {
+				// Open the next concatenated input
+				if t.concat != nil && t.concat.next < len(t.o.Concat.URLs) {
+					if err = t.nextInput(); err != nil {
+						return
+					}
+					continue
+				}
+				break
+			}
+			err = fmt.Errorf("pipeline: reading frame failed: %w", err)
+			return
+		}
+
+		// Get decoder
+		d, ok := t.decoders[pkt.StreamIndex()]
+		if !ok || d.ended {
+			pkt.Unref()
+			if t.ended() {
+				break
+			}
+			continue
+		}
+
+		// Copy packet in the outputs copying the stream
+		if err = t.copyPacket(d, pkt); err != nil {
+			pkt.Unref()
+			return
+		} else if d.copied {
+			pkt.Unref()
+			continue
+		}
+
+		// Update packet
+		pkt.RescaleTs(d.stream.TimeBase(), d.codecContext.TimeBase())
+
+		// Send packet
+		err = t.sendPacket(d, pkt)
+		pkt.Unref()
+		if err != nil {
+			return
+		}
+
+		// Decode frames
+		if err = t.decode(d); err != nil {
+			return
+		}
+	}
+
+	// Flush concatenation or mixer
+	if t.concat != nil || t.mix != nil {
+		if err = t.flushDecoders(); err != nil {
+			return
+		}
+		if t.concat != nil {
+			return t.concat.write(nil)
+		}
+		return t.mix.write(nil, t.Position())
+	}
+	return
+}
+
+// copyPacket writes a packet of the input stream of d in the outputs copying
+// it. The position follows the packets of the streams which aren't decoded.
+func (t *Transcoder) copyPacket(d *decoder, pkt *astiav.Packet) (err error) {
+	if d.copied {
+		// Update position
+		if pkt.Pts() != astiav.NoPtsValue {
+			pts := pkt.Pts()
+			if st := d.stream.StartTime(); st != astiav.NoPtsValue {
+				pts -= st
+			}
+			v := time.Duration(astiav.RescaleQ(pts, d.stream.TimeBase(), astiav.NewRational(1, int(time.Second))))
+			if t.o.Limits.MaxInputDuration > 0 && v > t.o.Limits.MaxInputDuration {
+				err = fmt.Errorf("%w: decoded duration exceeds %s", ErrInputLimitExceeded, t.o.Limits.MaxInputDuration)
+				return
+			}
+			atomic.StoreInt64(&t.position, int64(v))
+		}
+
+		// Pace and interleave the video as decoded frames are
+		if err = t.pace(); err != nil {
+			return
+		}
+		if t.video != nil {
+			if err = t.video.copy(t.Position()); err != nil {
+				return
+			}
+		}
+	}
+
+	// Write packet
+	for _, o := range t.outputs {
+		if err = o.writePacket(d.stream.Index(), pkt, d.stream.TimeBase()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// sendPacket sends a packet to the decoder, nil flushes it. While the decoder
+// is full, its frames are decoded before the packet is sent again.
+func (t *Transcoder) sendPacket(d *decoder, pkt *astiav.Packet) (err error) {
+	for {
+		if err = d.codecContext.SendPacket(pkt); !errors.Is(err, astiav.ErrEagain) {
+			break
+		}
+		if err = t.decode(d); err != nil {
+			return
+		}
+	}
+	if err != nil {
+		err = withCode(CodeDecodeError, fmt.Errorf("pipeline: sending packet failed: %w", err))
+	}
+	return
+}
+
+// decode receives the frames of the decoder and writes them in the outputs
+func (t *Transcoder) decode(d *decoder) (err error) {
+	for {
+		// Receive frame
+		if err = d.codecContext.ReceiveFrame(d.frame); err != nil {
+			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+				err = nil
+				break
+			}
+			err = withCode(CodeDecodeError, fmt.Errorf("pipeline: receiving frame failed: %w", err))
+			return
+		}
+
+		// Drop frames out of the transcoded range
+		v, ok := framePosition(d)
+		if ok && t.o.Start > 0 {
+			// Frames of segments are kept in the one they start in, so that
+			// consecutive segments neither overlap nor leave gaps
+			end := v + time.Duration(d.frame.NbSamples())*time.Second/time.Duration(d.codecContext.SampleRate())
+			if t.segment {
+				end = v + 1
+			}
+			if end <= t.o.Start {
+				d.frame.Unref()
+				continue
+			}
+			if v -= t.o.Start; v < 0 {
+				v = 0
+			}
+		}
+		if ok && t.o.Duration > 0 && v >= t.o.Duration {
+			d.ended = true
+			d.frame.Unref()
+			continue
+		}
+
+		// Count the previous concatenated inputs
+		if ok && t.concat != nil {
+			v += t.concat.offset
+		}
+
+		// Check decoded duration, the duration probed from the container can
+		// be missing or wrong
+		if ok && t.o.Limits.MaxInputDuration > 0 && v > t.o.Limits.MaxInputDuration {
+			err = fmt.Errorf("%w: decoded duration exceeds %s", ErrInputLimitExceeded, t.o.Limits.MaxInputDuration)
+			return
+		}
+
+		// Update position and check the audio buffered until then
+		if ok {
+			atomic.StoreInt64(&t.position, int64(v))
+			if err = t.checkMemory(v); err != nil {
+				return
+			}
+		}
+
+		// Filter, encode and write frame in each output, through the
+		// concatenation or mixer if any
+		if t.concat != nil {
+			if err = t.concat.write(d.frame); err != nil {
+				return
+			}
+		} else if t.mix != nil {
+			if err = t.mix.write(d.frame, t.Position()); err != nil {
+				return
+			}
+		} else if err = t.write(d.stream.Index(), d.frame); err != nil {
+			return
+		}
+		d.frame.Unref()
+	}
+	return
+}
+
+// write filters, encodes and writes a decoded frame in each output, and
+// passes it to each analyzer
+func (t *Transcoder) write(idx int, f *astiav.Frame) error {
+	if err := t.pace(); err != nil {
+		return err
+	}
+	if t.video != nil {
+		if err := t.video.copy(t.Position()); err != nil {
+			return err
+		}
+	}
+	if err := t.eachOutput(func(o *output) error { return o.write(idx, f) }); err != nil {
+		return err
+	}
+	for _, a := range t.analyses {
+		if err := a.write(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachOutput calls fn with each output and returns the first error. Several
+// outputs are filtered and encoded in parallel, each having its own graph,
+// encoder and muxer, while the decoded frame is only read.
+func (t *Transcoder) eachOutput(fn func(o *output) error) error {
+	if len(t.outputs) < 2 {
+		for _, o := range t.outputs {
+			if err := fn(o); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	errs := make([]error, len(t.outputs))
+	wg := &sync.WaitGroup{}
+	for i, o := range t.outputs {
+		wg.Add(1)
+		go func(i int, o *output) {
+			defer wg.Done()
+			errs[i] = fn(o)
+		}(i, o)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pace waits until the position has been played since Run started, when
+// Options.Realtime is set, so that a live output isn't written faster than
+// it's consumed
+func (t *Transcoder) pace() error {
+	if !t.o.Realtime {
+		return nil
+	}
+	d := time.Until(t.started.Add(t.Position()))
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.ctx.Done():
+		if errors.Is(t.ctx.Err(), context.DeadlineExceeded) {
+			return ErrTimeout
+		}
+		return ErrCanceled
+	}
+}
+
+// flushDecoders flushes the decoders of the current input
+func (t *Transcoder) flushDecoders() (err error) {
+	for _, d := range t.decoders {
+		if err = t.sendPacket(d, nil); err != nil {
+			return
+		}
+		if err = t.decode(d); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// checkInputLimits checks the probed input against the stream count and
+// duration limits, only the transcoded range counts in the duration. When
+// extracting the audio of a video only its audio streams are counted, since
+// containers such as MKV often carry many subtitle streams.
+func (t *Transcoder) checkInputLimits(fc *astiav.FormatContext) error {
+	l := t.o.Limits
+	n := fc.NbStreams()
+	if t.o.ExtractAudio {
+		n = 0
+		for _, s := range fc.Streams() {
+			if s.CodecParameters().MediaType() == astiav.MediaTypeAudio {
+				n++
+			}
+		}
+	}
+	if l.MaxInputStreams > 0 && n > l.MaxInputStreams {
+		return fmt.Errorf("%w: %d streams exceeds %d", ErrInputLimitExceeded, n, l.MaxInputStreams)
+	}
+	if l.MaxInputDuration > 0 && t.duration > l.MaxInputDuration {
+		return fmt.Errorf("%w: duration %s exceeds %s", ErrInputLimitExceeded, t.duration, l.MaxInputDuration)
+	}
+	return nil
+}
+
+// ended reports whether the frames of all decoders have passed the end of the
+// transcoded range
+func (t *Transcoder) ended() bool {
+	if len(t.decoders) == 0 {
+		return false
+	}
+	for _, d := range t.decoders {
+		if !d.ended {
+			return false
+		}
+	}
+	return true
+}
+
+// checkInterrupted replaces an error caused by the input being interrupted
+// with ErrCanceled or ErrTimeout
+func (t *Transcoder) checkInterrupted(err *error) {
+	if *err == nil {
+		return
+	}
+	if cause := t.in.interruptCause(); cause != nil {
+		*err = cause
+	}
+}
+
+// step starts a step with the hook
+func (t *Transcoder) step(name string) func(err error) {
+	if t.o.Hooks.Step != nil {
+		if end := t.o.Hooks.Step(name); end != nil {
+			return end
+		}
+	}
+	return func(error) {}
+}
+
+// track attributes the logs of the astiav context with the hook until the
+// transcoder is closed, it must be called after the context free func has
+// been added to the closer
+func (t *Transcoder) track(v unsafe.Pointer) {
+	if release := t.trackPointer(ffmpegPointer(v)); release != nil {
+		t.c.Add(release)
+	}
+}
+
+func (t *Transcoder) trackPointer(p string) func() {
+	if t.o.Hooks.FFmpegContext == nil {
+		return nil
+	}
+	return t.o.Hooks.FFmpegContext(p)
+}
+
+// ffmpegPointer returns the C pointer wrapped by an astiav context, as FFmpeg
+// logs it
+func ffmpegPointer(v unsafe.Pointer) string {
+	return fmt.Sprintf("%p", cPointer(v))
+}
+
+// framePosition returns the position of a decoded frame relative to the
+// stream start
+func framePosition(d *decoder) (time.Duration, bool) {
+	f := d.frame
+	if f.Pts() == astiav.NoPtsValue {
+		return 0, false
+	}
+	pts := f.Pts()
+	if st := d.stream.StartTime(); st != astiav.NoPtsValue {
+		pts -= astiav.RescaleQ(st, d.stream.TimeBase(), d.codecContext.TimeBase())
+	}
+	return time.Duration(astiav.RescaleQ(pts, d.codecContext.TimeBase(), astiav.NewRational(1, int(time.Second)))), true
+}
+
+// SampleRateError is returned when an encoder doesn't support a sample rate
+type SampleRateError struct {
+	SampleRate int
+	Supported  []int
+}
+
+func (e *SampleRateError) Error() string {
+	s := make([]string, 0, len(e.Supported))
+	for _, v := range e.Supported {
+		s = append(s, strconv.Itoa(v))
+	}
+	return fmt.Sprintf("pipeline: codec doesn't support sample rate %d, supported ones are %s", e.SampleRate, strings.Join(s, ", "))
+}
+
+// CheckSampleRate returns a *SampleRateError if the encoder doesn't support
+// the sample rate
+func CheckSampleRate(sampleRate int, c *astiav.Codec) error {
+	v := SampleRates(c)
+	if len(v) == 0 {
+		return nil
+	}
+	for _, r := range v {
+		if r == sampleRate {
+			return nil
+		}
+	}
+	return &SampleRateError{SampleRate: sampleRate, Supported: v}
+}
+
+// MaxChannels is the most channels a default channel layout is known for
+const MaxChannels = 8
+
+// defaultChannelLayouts are the channel layouts of 1 to MaxChannels channels,
+// the ones av_get_default_channel_layout returns
+var defaultChannelLayouts = []astiav.ChannelLayout{
+	astiav.ChannelLayoutMono,
+	astiav.ChannelLayoutStereo,
+	astiav.ChannelLayout2Point1,
+	astiav.ChannelLayout4Point0,
+	astiav.ChannelLayout5Point0Back,
+	astiav.ChannelLayout5Point1Back,
+	astiav.ChannelLayout6Point1,
+	astiav.ChannelLayout7Point1,
+}
+
+// ChannelLayout returns the channel layout of an output with channels among
+// the layouts an encoder supports, preferring the default one. Any layout is
+// supported when supported is empty.
+func ChannelLayout(channels int, supported []astiav.ChannelLayout) (l astiav.ChannelLayout, err error) {
+	// Get default layout
+	if channels < 1 || channels > MaxChannels {
+		err = fmt.Errorf("pipeline: channel layout of %d channels unknown", channels)
+		return
+	}
+	l = defaultChannelLayouts[channels-1]
+	if len(supported) == 0 {
+		return
+	}
+
+	// Pick supported layout
+	var found bool
+	for _, v := range supported {
+		if v == l {
+			return
+		} else if !found && v.NbChannels() == channels {
+			l, found = v, true
+		}
+	}
+	if !found {
+		err = fmt.Errorf("pipeline: codec doesn't support %d channels", channels)
+	}
+	return
+}
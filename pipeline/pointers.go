@@ -0,0 +1,34 @@
+package pipeline
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Check the layout cPointer relies on, so that bindings changing it fail on
+// startup rather than corrupting memory
+func init() {
+	for _, v := range []interface{}{
+		(*astiav.Codec)(nil),
+		(*astiav.CodecContext)(nil),
+		(*astiav.FilterGraph)(nil),
+		(*astiav.FormatContext)(nil),
+		(*astiav.Frame)(nil),
+		(*astiav.InputFormat)(nil),
+		(*astiav.Stream)(nil),
+	} {
+		if t := reflect.TypeOf(v).Elem(); t.Kind() != reflect.Struct || t.NumField() != 1 || t.Field(0).Type.Kind() != reflect.Ptr {
+			panic(fmt.Sprintf("pipeline: %s doesn't only wrap a C pointer", t))
+		}
+	}
+}
+
+// cPointer returns the C pointer wrapped by an astiav value, which it holds
+// in its first and only field, for the fields and functions the bindings
+// don't expose
+func cPointer(v unsafe.Pointer) unsafe.Pointer {
+	return *(*unsafe.Pointer)(v)
+}
@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"github.com/asticode/go-astiav"
+)
+
+// maxPooled is the number of frames, and of packets, kept for reuse
+const maxPooled = 256
+
+// Frames and packets are reused across transcoders instead of being allocated
+// and freed for each of them, which dominates the latency of small inputs
+// under load. Pools are bounded free lists rather than sync.Pools since the
+// garbage collector can't free what FFmpeg allocates. Codec contexts and
+// filter graphs aren't pooled: encoders can't be reopened once flushed and
+// graphs are bound to the format of their input.
+var (
+	framePool  = make(chan *astiav.Frame, maxPooled)
+	packetPool = make(chan *astiav.Packet, maxPooled)
+)
+
+// allocFrame returns a frame of the pool, or a new one if it's empty. The
+// frame is unreferenced and put back in the pool when the transcoder is
+// closed.
+func (t *Transcoder) allocFrame() (f *astiav.Frame) {
+	select {
+	case f = <-framePool:
+	default:
+		f = astiav.AllocFrame()
+	}
+	t.c.Add(func() {
+		f.Unref()
+		select {
+		case framePool <- f:
+		default:
+			f.Free()
+		}
+	})
+	return
+}
+
+// allocPacket is allocFrame for packets
+func (t *Transcoder) allocPacket() (pkt *astiav.Packet) {
+	select {
+	case pkt = <-packetPool:
+	default:
+		pkt = astiav.AllocPacket()
+	}
+	t.c.Add(func() {
+		pkt.Unref()
+		select {
+		case packetPool <- pkt:
+		default:
+			pkt.Free()
+		}
+	})
+	return
+}
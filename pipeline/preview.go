@@ -0,0 +1,308 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Preview formats
+const (
+	PreviewGIF = "gif"
+	PreviewMP4 = "mp4"
+)
+
+// Preview describes a short clip of a video input at a reduced resolution and
+// frame rate, e.g. for link previews
+type Preview struct {
+	Duration  time.Duration // Of the clip
+	Format    string        // PreviewGIF or PreviewMP4
+	FrameRate int           // Frames per second
+	Start     time.Duration // Input position of the clip
+	Subtitles bool          // Burn the first subtitle stream of the input in, which needs FFmpeg built with libass
+	URL       string        // Where the muxer writes, e.g. a file path or pipe:1
+	Width     int           // In pixels, the height keeps the aspect ratio
+}
+
+// previewEncoders are the encoders of the preview formats, in order of
+// preference
+var previewEncoders = map[string][]string{
+	PreviewGIF: {"gif"},
+	PreviewMP4: {"libx264", "mpeg4"},
+}
+
+// ExtractPreview opens the input, retrying transient network failures, and
+// writes the clip of its first video stream p describes. GIF palettes are
+// generated from the whole clip, and MP4 clips are fragmented so that they can
+// be written in pipes. The input is interrupted when ctx is done. The stream
+// count, input size and output size limits apply.
+func ExtractPreview(ctx context.Context, url string, o Options, p Preview) (err error) {
+	// Create transcoder holding the input
+	t := New(o)
+	defer t.Close()
+
+	// Interrupt the input when the context is done
+	defer t.in.watch(ctx)()
+	defer t.checkInterrupted(&err)
+
+	// Trace steps, the current one is finished with the error on failure
+	end := t.step("input open")
+	defer func() { end(err) }()
+
+	// Find encoder
+	var codec *astiav.Codec
+	for _, n := range previewEncoders[p.Format] {
+		if codec = astiav.FindEncoderByName(n); codec != nil {
+			break
+		}
+	}
+	if codec == nil {
+		err = withCode(CodeUnsupportedCodec, fmt.Errorf("pipeline: preview format not supported: %s", p.Format))
+		return
+	}
+
+	// Open video
+	var d *decoder
+	if d, err = t.openVideo(url, p.Start); err != nil {
+		return
+	}
+
+	// Describe filters, subtitles being burnt in at the resolution of the video
+	// and GIF colors being reduced to a palette of the clip. The graph is set
+	// up with the first frame, whose pixel format is the one of the decoder
+	// unless it's downloaded from a hardware surface.
+	content := fmt.Sprintf("fps=%d,scale=w=%d:h=-2:flags=lanczos,setsar=1", p.FrameRate, p.Width&^1)
+	if p.Subtitles {
+		var s string
+		if s, err = t.subtitlesFilter(); err != nil {
+			return
+		}
+		content = s + "," + content
+	}
+	if p.Format == PreviewGIF {
+		content += ",split[a][b];[a]palettegen=stats_mode=diff[p];[b][p]paletteuse"
+	} else {
+		content += ",format=pix_fmts=yuv420p"
+	}
+	var g *graph
+
+	// Set up output
+	end(nil)
+	end = t.step("output setup")
+	pw := &previewWriter{frameRate: p.FrameRate, t: t}
+	if err = pw.open(p, codec); err != nil {
+		return
+	}
+
+	// Decode the frames of the clip. The encoder is set up with the first
+	// filtered frame, which GIF clips only get once the graph is flushed since
+	// their palette is generated from all frames.
+	end(nil)
+	end = t.step("packet loop")
+	f := t.allocFrame()
+	if err = t.decodeVideo(d, func() (bool, error) {
+		// Drop frames out of the clip
+		v, ok := framePosition(d)
+		if ok && v < p.Start {
+			d.frame.Unref()
+			return false, nil
+		} else if ok && v >= p.Start+p.Duration {
+			d.frame.Unref()
+			return true, nil
+		}
+
+		// Set up filters
+		if g == nil {
+			var err error
+			if g, err = t.newVideoGraph(content, []string{"in"}, []astiav.FilterArgs{videoArgs(d, d.frame)}); err != nil {
+				return false, err
+			}
+		}
+
+		// Filter and write frame
+		if err := g.sources[0].BuffersrcAddFrame(d.frame, astiav.NewBuffersrcFlags()); err != nil {
+			return false, fmt.Errorf("pipeline: adding frame failed: %w", err)
+		}
+		return false, g.pull(f, pw.write)
+	}); err != nil {
+		return
+	}
+
+	// Flush filters and encoder
+	if g == nil {
+		err = errors.New("pipeline: no frame decoded in the clip")
+		return
+	}
+	if err = g.sources[0].BuffersrcAddFrame(nil, astiav.NewBuffersrcFlags()); err != nil {
+		err = fmt.Errorf("pipeline: flushing filters failed: %w", err)
+		return
+	}
+	if err = g.pull(f, pw.write); err != nil {
+		return
+	}
+	if err = pw.write(nil); err != nil {
+		return
+	}
+
+	// Write trailer
+	end(nil)
+	end = t.step("trailer write")
+	if err = pw.formatContext.WriteTrailer(); err != nil {
+		err = fmt.Errorf("pipeline: writing trailer failed: %w", err)
+	}
+	return
+}
+
+// previewWriter encodes and muxes the filtered frames of a preview
+type previewWriter struct {
+	codec         *astiav.Codec
+	codecContext  *astiav.CodecContext // Nil until the first frame is written
+	format        string
+	formatContext *astiav.FormatContext
+	frameRate     int
+	frames        int64
+	pkt           *astiav.Packet
+	size          int64
+	stream        *astiav.Stream
+	t             *Transcoder
+	url           string
+}
+
+// open allocates the output format context of the preview
+func (pw *previewWriter) open(p Preview, codec *astiav.Codec) (err error) {
+	pw.codec, pw.format, pw.url = codec, p.Format, p.URL
+	if pw.formatContext, err = astiav.AllocOutputFormatContext(nil, p.Format, p.URL); err != nil {
+		err = fmt.Errorf("pipeline: allocating output format context failed: %w", err)
+		return
+	} else if pw.formatContext == nil {
+		err = errors.New("pipeline: output format context is nil")
+		return
+	}
+	pw.t.c.Add(pw.formatContext.Free)
+	pw.t.track(unsafe.Pointer(pw.formatContext))
+	if pw.stream = pw.formatContext.NewStream(nil); pw.stream == nil {
+		err = errors.New("pipeline: output stream is nil")
+		return
+	}
+	pw.pkt = pw.t.allocPacket()
+	return
+}
+
+// start sets up the encoder with the format of the first filtered frame and
+// writes the output header
+func (pw *previewWriter) start(f *astiav.Frame) (err error) {
+	// Alloc codec context
+	if pw.codecContext = astiav.AllocCodecContext(pw.codec); pw.codecContext == nil {
+		err = errors.New("pipeline: codec context is nil")
+		return
+	}
+	pw.t.c.Add(pw.codecContext.Free)
+	pw.t.track(unsafe.Pointer(pw.codecContext))
+	pw.codecContext.SetFramerate(astiav.NewRational(pw.frameRate, 1))
+	pw.codecContext.SetHeight(f.Height())
+	pw.codecContext.SetPixelFormat(f.PixelFormat())
+	pw.codecContext.SetSampleAspectRatio(astiav.NewRational(1, 1))
+	pw.codecContext.SetTimeBase(astiav.NewRational(1, pw.frameRate))
+	pw.codecContext.SetWidth(f.Width())
+	pw.t.setThreads(pw.codecContext)
+	if pw.formatContext.OutputFormat().Flags().Has(astiav.IOFormatFlagGlobalheader) {
+		pw.codecContext.SetFlags(pw.codecContext.Flags().Add(astiav.CodecContextFlagGlobalHeader))
+	}
+
+	// Open codec context
+	if err = pw.codecContext.Open(pw.codec, nil); err != nil {
+		err = fmt.Errorf("pipeline: opening codec context failed: %w", err)
+		return
+	}
+
+	// Update stream
+	if err = pw.stream.CodecParameters().FromCodecContext(pw.codecContext); err != nil {
+		err = fmt.Errorf("pipeline: updating codec parameters failed: %w", err)
+		return
+	}
+	pw.stream.SetTimeBase(pw.codecContext.TimeBase())
+
+	// Open io context
+	if err = pw.t.openOutputIO(pw.formatContext, pw.url); err != nil {
+		return
+	}
+
+	// Write header, MP4 clips being fragmented since pipes can't be seeked
+	opts := astiav.NewDictionary()
+	defer opts.Free()
+	if pw.format == PreviewMP4 {
+		if err = opts.Set("movflags", "frag_keyframe+empty_moov+default_base_moof", astiav.NewDictionaryFlags()); err != nil {
+			err = fmt.Errorf("pipeline: setting muxer options failed: %w", err)
+			return
+		}
+	}
+	if err = pw.formatContext.WriteHeader(opts); err != nil {
+		err = fmt.Errorf("pipeline: writing header failed: %w", err)
+		return
+	}
+	return
+}
+
+// write encodes and muxes a filtered frame, nil flushes the encoder
+func (pw *previewWriter) write(f *astiav.Frame) (err error) {
+	// Set up encoder
+	if pw.codecContext == nil {
+		if f == nil {
+			return errors.New("pipeline: no frame in the clip")
+		}
+		if err = pw.start(f); err != nil {
+			return
+		}
+	}
+
+	// Send frame, numbered in the encoder time base
+	if f != nil {
+		f.SetPictureType(astiav.PictureTypeNone)
+		f.SetPts(pw.frames)
+		pw.frames++
+	}
+	for {
+		if err = pw.codecContext.SendFrame(f); !errors.Is(err, astiav.ErrEagain) {
+			break
+		}
+		if err = pw.writePackets(); err != nil {
+			return
+		}
+	}
+	if err != nil {
+		err = withCode(CodeEncodeError, fmt.Errorf("pipeline: sending frame failed: %w", err))
+		return
+	}
+	return pw.writePackets()
+}
+
+// writePackets receives the packets of the encoder until it needs another
+// frame, and writes them
+func (pw *previewWriter) writePackets() (err error) {
+	for {
+		if err = pw.codecContext.ReceivePacket(pw.pkt); err != nil {
+			if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+				err = nil
+				break
+			}
+			err = withCode(CodeEncodeError, fmt.Errorf("pipeline: receiving packet failed: %w", err))
+			return
+		}
+		pw.pkt.SetStreamIndex(pw.stream.Index())
+		pw.pkt.RescaleTs(pw.codecContext.TimeBase(), pw.stream.TimeBase())
+		pw.size += int64(pw.pkt.Size())
+		if max := pw.t.o.Limits.MaxOutputSize; max > 0 && pw.size > max {
+			pw.pkt.Unref()
+			return fmt.Errorf("%w: size exceeds %d bytes", ErrOutputLimitExceeded, max)
+		}
+		if err = pw.formatContext.WriteInterleavedFrame(pw.pkt); err != nil {
+			err = fmt.Errorf("pipeline: writing frame failed: %w", err)
+			return
+		}
+	}
+	return
+}
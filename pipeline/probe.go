@@ -0,0 +1,137 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// MediaInfo describes an input as probed by FFmpeg
+type MediaInfo struct {
+	BitRate  int64 // In bits per second, 0 if unknown
+	Chapters []Chapter
+	Duration time.Duration // 0 if unknown
+	CoverArt *Picture      // First attached picture, nil if none
+	Format   string        // Demuxer names, e.g. mov,mp4,m4a,3gp,3g2,mj2
+	Streams  []StreamInfo
+	Tags     map[string]string // Of the container, e.g. title, nil if none
+}
+
+// StreamInfo describes a stream of an input, the audio fields are only set
+// for audio streams
+type StreamInfo struct {
+	BitRate       int64 // In bits per second, 0 if unknown
+	ChannelLayout string
+	Channels      int
+	Codec         string
+	CoverArt      bool          // Attached picture, e.g. the cover of an MP3 file
+	Duration      time.Duration // 0 if unknown
+	Index         int
+	Language      string // e.g. eng, empty if unknown
+	MediaType     string // e.g. audio or video
+	SampleFormat  string
+	SampleRate    int
+	Tags          map[string]string // e.g. handler_name, nil if none
+}
+
+// Picture is the cover art of an input or of an output, as stored in its
+// attached picture stream
+type Picture struct {
+	Codec       string // e.g. mjpeg or png
+	Data        []byte
+	StreamIndex int // Of inputs, ignored for outputs
+}
+
+// Probe opens the input, retrying transient network failures, and returns its
+// format and streams without decoding it. The input is interrupted when ctx is
+// done. Limits don't apply since no packet is read past stream probing.
+func Probe(ctx context.Context, url string, o Options) (info *MediaInfo, err error) {
+	// Create transcoder holding the input
+	t := New(o)
+	defer t.Close()
+
+	// Interrupt the input when the context is done
+	defer t.in.watch(ctx)()
+	defer t.checkInterrupted(&err)
+
+	// Trace step
+	end := t.step("input probe")
+	defer func() { end(err) }()
+
+	// Open input
+	t.in.url = url
+	if err = t.in.open(); err != nil {
+		err = withCode(CodeInputOpenFailed, fmt.Errorf("pipeline: opening input failed: %w", err))
+		return
+	}
+	t.c.Add(t.in.close)
+	fc := t.in.formatContext
+
+	// Find stream info
+	if err = fc.FindStreamInfo(nil); err != nil {
+		err = fmt.Errorf("pipeline: finding stream info failed: %w", err)
+		return
+	}
+	info = describeInput(fc)
+	return
+}
+
+// Input describes the input opened, nil when a test signal is generated
+func (t *Transcoder) Input() *MediaInfo {
+	if t.in.formatContext == nil {
+		return nil
+	}
+	return describeInput(t.in.formatContext)
+}
+
+// describeInput describes the format and streams of an input whose stream
+// info has been found
+func describeInput(fc *astiav.FormatContext) (info *MediaInfo) {
+	// Describe format
+	info = &MediaInfo{
+		BitRate:  fc.BitRate(),
+		Format:   inputFormatName(fc.InputFormat()),
+		Chapters: inputChapters(fc),
+		Streams:  []StreamInfo{},
+		Tags:     metadataTags(fc.Metadata()),
+	}
+	if d := fc.Duration(); d > 0 && d != astiav.NoPtsValue {
+		info.Duration = time.Duration(astiav.RescaleQ(d, astiav.TimeBaseQ, astiav.NewRational(1, int(time.Second))))
+	}
+
+	// Describe streams
+	for _, s := range fc.Streams() {
+		cp := s.CodecParameters()
+		si := StreamInfo{
+			BitRate:   cp.BitRate(),
+			Codec:     cp.CodecID().Name(),
+			Index:     s.Index(),
+			Language:  streamLanguage(s),
+			MediaType: cp.MediaType().String(),
+			Tags:      metadataTags(s.Metadata()),
+		}
+		if d := s.Duration(); d > 0 && d != astiav.NoPtsValue {
+			si.Duration = time.Duration(astiav.RescaleQ(d, s.TimeBase(), astiav.NewRational(1, int(time.Second))))
+		}
+		if b := attachedPicture(s); b != nil {
+			si.CoverArt = true
+			if info.CoverArt == nil {
+				info.CoverArt = &Picture{
+					Codec:       si.Codec,
+					Data:        b,
+					StreamIndex: si.Index,
+				}
+			}
+		}
+		if cp.MediaType() == astiav.MediaTypeAudio {
+			si.Channels = cp.Channels()
+			si.ChannelLayout = cp.ChannelLayout().StringWithNbChannels(cp.Channels())
+			si.SampleFormat = cp.SampleFormat().Name()
+			si.SampleRate = cp.SampleRate()
+		}
+		info.Streams = append(info.Streams, si)
+	}
+	return
+}
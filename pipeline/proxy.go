@@ -0,0 +1,195 @@
+package pipeline
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errBlockedAddress is returned when dialing a blocked address
+var errBlockedAddress = errors.New("pipeline: address is blocked by the input policy")
+
+// inputDialer dials the addresses hosts resolved to, refusing blocked ones at
+// connect time so that neither redirects nor DNS changing since resolve can
+// reach them
+var inputDialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+	Control: func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || isBlockedInputIP(ip) {
+			return fmt.Errorf("%w: %s", errBlockedAddress, host)
+		}
+		return nil
+	},
+}
+
+// inputTransport is the HTTP transport of inputs restricted by a policy
+var inputTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = inputDialer.DialContext
+	t.Proxy = nil
+	return t
+}()
+
+// inputProxy is the forward proxy FFmpeg connects to remote inputs through
+var inputProxy struct {
+	err  error
+	once sync.Once
+	url  string
+}
+
+// HTTPClient returns the client fetching urls the policy allows on the Go
+// side, e.g. cover art or cached inputs. Redirects are checked like the url
+// and connections to blocked addresses refused.
+func (p *InputPolicy) HTTPClient() *http.Client {
+	if p == nil {
+		return &http.Client{}
+	}
+	c := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("pipeline: stopped after 10 redirects")
+			}
+			return p.Check(req.URL.String())
+		},
+	}
+	if !p.AllowPrivate {
+		c.Transport = inputTransport
+	}
+	return c
+}
+
+// proxyURL returns the url of the proxy FFmpeg should open the network inputs
+// of the policy through, empty when they aren't restricted. The proxy is
+// started on first use.
+func (p *InputPolicy) proxyURL() (string, error) {
+	if p == nil || p.AllowPrivate {
+		return "", nil
+	}
+	inputProxy.once.Do(func() {
+		inputProxy.url, inputProxy.err = startInputProxy()
+	})
+	return inputProxy.url, inputProxy.err
+}
+
+// startInputProxy starts the forward proxy on a loopback port. FFmpeg resolves
+// hosts and follows redirects on its own, so the addresses it connects to are
+// checked by the proxy: plain HTTP requests are forwarded through
+// inputTransport and HTTPS ones tunneled with CONNECT through inputDialer.
+// Other local processes are kept out by random credentials.
+func startInputProxy() (string, error) {
+	// FFmpeg bypasses the proxy for the hosts of no_proxy
+	for _, k := range []string{"no_proxy", "NO_PROXY"} {
+		if err := os.Unsetenv(k); err != nil {
+			return "", fmt.Errorf("pipeline: unsetting %s failed: %w", k, err)
+		}
+	}
+
+	// Generate credentials
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("pipeline: generating proxy credentials failed: %w", err)
+	}
+	password := hex.EncodeToString(b)
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("transgode:"+password))
+
+	// Listen
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("pipeline: listening for the input proxy failed: %w", err)
+	}
+
+	// Serve
+	rp := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			// Don't reveal the proxy to the input server
+			r.Header["X-Forwarded-For"] = nil
+		},
+		ErrorHandler:  proxyError,
+		FlushInterval: -1,
+		Transport:     inputTransport,
+	}
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Proxy-Authorization")), []byte(auth)) != 1 {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="transgode"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		switch {
+		case r.Method == http.MethodConnect:
+			tunnel(w, r)
+		case r.URL.Scheme == "http" && r.URL.Host != "":
+			rp.ServeHTTP(w, r)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	return "http://transgode:" + password + "@" + l.Addr().String(), nil
+}
+
+// tunnel connects to the host of a CONNECT request and copies bytes both ways
+// until either side closes
+func tunnel(w http.ResponseWriter, r *http.Request) {
+	// Dial
+	upstream, err := inputDialer.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		proxyError(w, r, err)
+		return
+	}
+	defer upstream.Close()
+
+	// Hijack
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if _, err = rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+	if err = rw.Flush(); err != nil {
+		return
+	}
+
+	// Copy, bytes already buffered by the server included
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, rw.Reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// proxyError replies to a request the proxy couldn't forward. Blocked
+// addresses get 403, which FFmpeg doesn't retry.
+func proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errBlockedAddress) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
@@ -0,0 +1,151 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segmentPreroll is decoded before the start of a segment and dropped, so
+// that decoders of lossy codecs have warmed up once its first frame is decoded
+const segmentPreroll = time.Second
+
+// Split decodes long inputs in parallel: the input is split into time
+// segments decoded by their own goroutine, into lossless temporary files
+// concatenated in order once decoded. Filters and encoders still process the
+// whole stream, so outputs are the same as without splitting. Inputs which
+// can't be seeked are decoded from their beginning by each goroutine.
+type Split struct {
+	Dir         string        // Directory of the temporary files, empty defaults to the system temp directory
+	MinDuration time.Duration // Inputs shorter than this aren't split
+	Segments    int           // Number of segments, up to 1 disables splitting
+}
+
+// split decodes the segments of a split input in the background
+type split struct {
+	cancel context.CancelFunc
+	done   []chan struct{} // Closed once the segment at the same index is decoded
+	errs   []error         // Set before done is closed
+	paths  []string
+	wg     *sync.WaitGroup
+}
+
+// pcmCodecs are the codecs of the temporary files of segments, by decoded
+// sample format name, which they store as is or interleaved
+var pcmCodecs = map[string]string{
+	"dbl":  "pcm_f64le",
+	"dblp": "pcm_f64le",
+	"flt":  "pcm_f32le",
+	"fltp": "pcm_f32le",
+	"s16":  "pcm_s16le",
+	"s16p": "pcm_s16le",
+	"s32":  "pcm_s32le",
+	"s32p": "pcm_s32le",
+	"u8":   "pcm_u8",
+	"u8p":  "pcm_u8",
+}
+
+// splits reports whether the opened input is decoded in segments
+func (t *Transcoder) splits(d *decoder) bool {
+	s := t.o.Split
+	if _, ok := pcmCodecs[d.codecContext.SampleFormat().Name()]; !ok {
+		return false
+	}
+	return s.Segments > 1 && t.duration > 0 && t.duration >= s.MinDuration && t.concat == nil && t.mix == nil &&
+		t.o.Start == 0 && t.o.Duration == 0 && t.o.Video == "" && !strings.HasPrefix(t.in.url, "pipe:")
+}
+
+// openSplit creates the temporary files of the segments, which are then
+// concatenated in place of the opened input, resampled back into the format
+// of its decoder
+func (t *Transcoder) openSplit(d *decoder) (err error) {
+	// Create files
+	s := &split{wg: &sync.WaitGroup{}}
+	t.c.Add(s.close)
+	for i := 0; i < t.o.Split.Segments; i++ {
+		var f *os.File
+		if f, err = os.CreateTemp(t.o.Split.Dir, "transgode-segment-*.wav"); err != nil {
+			err = fmt.Errorf("pipeline: creating segment file failed: %w", err)
+			return
+		}
+		f.Close()
+		s.paths = append(s.paths, f.Name())
+		s.done = append(s.done, make(chan struct{}))
+		s.errs = append(s.errs, nil)
+	}
+	t.split = s
+
+	// Concatenate segments, which are only read once decoded
+	t.o.Concat = Concat{URLs: s.paths}
+	if t.concat, err = newConcatenation(t); err != nil {
+		return
+	}
+	t.in.trusted = true
+	return t.concat.open(d)
+}
+
+// decodeSegments starts decoding the segments, each one in its own goroutine
+func (t *Transcoder) decodeSegments(ctx context.Context, d *decoder) {
+	ctx, t.split.cancel = context.WithCancel(ctx)
+	n := len(t.split.paths)
+	for i := 0; i < n; i++ {
+		// Get range
+		o := t.o
+		o.Concat, o.Realtime, o.Split = Concat{}, false, Split{}
+		o.Start = t.duration * time.Duration(i) / time.Duration(n)
+		if i < n-1 {
+			o.Duration = t.duration*time.Duration(i+1)/time.Duration(n) - o.Start
+		}
+
+		// Decode
+		t.split.wg.Add(1)
+		go func(i int, o Options) {
+			defer t.split.wg.Done()
+			defer close(t.split.done[i])
+			t.split.errs[i] = decodeSegment(ctx, t.in.url, o, Output{
+				Channels:   d.codecContext.Channels(),
+				Codec:      pcmCodecs[d.codecContext.SampleFormat().Name()],
+				Format:     "wav",
+				SampleRate: d.codecContext.SampleRate(),
+				URL:        t.split.paths[i],
+			})
+		}(i, o)
+	}
+}
+
+// decodeSegment decodes the range of o of the input into out
+func decodeSegment(ctx context.Context, url string, o Options, out Output) (err error) {
+	t := New(o)
+	defer t.Close()
+	t.segment = true
+	if err = t.Open(ctx, url); err != nil {
+		return
+	}
+	if err = t.AddOutput(out); err != nil {
+		return
+	}
+	return t.Run(ctx)
+}
+
+// wait waits until the segment at index i is decoded
+func (s *split) wait(i int) error {
+	<-s.done[i]
+	if err := s.errs[i]; err != nil {
+		return fmt.Errorf("pipeline: decoding segment %d failed: %w", i, err)
+	}
+	return nil
+}
+
+// close stops decoding the segments and removes their files
+func (s *split) close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	for _, p := range s.paths {
+		os.Remove(p)
+	}
+}
@@ -0,0 +1,228 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Subtitle formats
+const (
+	SubtitleSRT    = "srt"
+	SubtitleWebVTT = "webvtt"
+)
+
+// subtitleCodecs are the codecs of the subtitle formats. Subtitle streams are
+// copied as is since FFmpeg can't be asked to convert them here, so only
+// streams already in the codec of the format can be extracted.
+var subtitleCodecs = map[string]astiav.CodecID{
+	SubtitleSRT:    astiav.CodecIDSubrip,
+	SubtitleWebVTT: astiav.CodecIDWebvtt,
+}
+
+// Subtitles describes the extraction of a subtitle stream of an input
+type Subtitles struct {
+	Format string // SubtitleSRT or SubtitleWebVTT
+	URL    string // Where the muxer writes, e.g. a file path or pipe:1
+}
+
+// ExtractSubtitles opens the input, retrying transient network failures, and
+// writes its subtitle stream at Options.StreamIndex, or its first one in
+// Options.Language, in the format s describes. The input is interrupted when
+// ctx is done. The stream count, input size and output size limits apply.
+func ExtractSubtitles(ctx context.Context, url string, o Options, s Subtitles) (err error) {
+	// Create transcoder holding the input
+	t := New(o)
+	defer t.Close()
+
+	// Interrupt the input when the context is done
+	defer t.in.watch(ctx)()
+	defer t.checkInterrupted(&err)
+
+	// Trace steps, the current one is finished with the error on failure
+	end := t.step("input open")
+	defer func() { end(err) }()
+
+	// Check format
+	id, ok := subtitleCodecs[s.Format]
+	if !ok {
+		err = fmt.Errorf("pipeline: subtitle format not supported: %s", s.Format)
+		return
+	}
+
+	// Open input
+	t.in.url = url
+	if err = t.in.open(); err != nil {
+		err = withCode(CodeInputOpenFailed, fmt.Errorf("pipeline: opening input failed: %w", err))
+		return
+	}
+	t.c.Add(t.in.close)
+	fc := t.in.formatContext
+
+	// Find stream info
+	if err = fc.FindStreamInfo(nil); err != nil {
+		err = fmt.Errorf("pipeline: finding stream info failed: %w", err)
+		return
+	}
+
+	// Check limits
+	if err = t.checkInputLimits(fc); err != nil {
+		return
+	}
+
+	// Select stream
+	var is *astiav.Stream
+	if is, err = selectSubtitleStream(fc, o.StreamIndex, o.Language); err != nil {
+		return
+	}
+	if c := is.CodecParameters().CodecID(); c != id {
+		err = fmt.Errorf("%w: stream %d is %s, not %s", ErrSubtitleCodec, is.Index(), c, id)
+		return
+	}
+
+	// Set up output
+	end(nil)
+	end = t.step("output setup")
+	var ofc *astiav.FormatContext
+	if ofc, err = astiav.AllocOutputFormatContext(nil, s.Format, s.URL); err != nil {
+		err = fmt.Errorf("pipeline: allocating output format context failed: %w", err)
+		return
+	} else if ofc == nil {
+		err = errors.New("pipeline: output format context is nil")
+		return
+	}
+	t.c.Add(ofc.Free)
+	t.track(unsafe.Pointer(ofc))
+	out := ofc.NewStream(nil)
+	if out == nil {
+		err = errors.New("pipeline: output stream is nil")
+		return
+	}
+	if err = is.CodecParameters().Copy(out.CodecParameters()); err != nil {
+		err = fmt.Errorf("pipeline: copying codec parameters failed: %w", err)
+		return
+	}
+	out.CodecParameters().SetCodecTag(0)
+	out.SetTimeBase(is.TimeBase())
+	if err = t.openOutputIO(ofc, s.URL); err != nil {
+		return
+	}
+	if err = ofc.WriteHeader(nil); err != nil {
+		err = fmt.Errorf("pipeline: writing header failed: %w", err)
+		return
+	}
+
+	// Copy the packets of the stream, with timestamps starting at 0
+	end(nil)
+	end = t.step("packet loop")
+	pkt := t.allocPacket()
+	var size int64
+	for {
+		// Read packet
+		if err = t.in.readFrame(pkt); errors.Is(err, astiav.ErrEof) {
+			err = nil
+			break
+		} else if err != nil {
+			err = fmt.Errorf("pipeline: reading frame failed: %w", err)
+			return
+		} else if pkt.StreamIndex() != is.Index() {
+			pkt.Unref()
+			continue
+		}
+
+		// Write packet
+		if st := fc.StartTime(); st != astiav.NoPtsValue {
+			st = astiav.RescaleQ(st, astiav.TimeBaseQ, is.TimeBase())
+			if pkt.Pts() != astiav.NoPtsValue {
+				pkt.SetPts(pkt.Pts() - st)
+			}
+			if pkt.Dts() != astiav.NoPtsValue {
+				pkt.SetDts(pkt.Dts() - st)
+			}
+		}
+		pkt.SetStreamIndex(out.Index())
+		pkt.RescaleTs(is.TimeBase(), out.TimeBase())
+		if size += int64(pkt.Size()); o.Limits.MaxOutputSize > 0 && size > o.Limits.MaxOutputSize {
+			pkt.Unref()
+			return fmt.Errorf("%w: size exceeds %d bytes", ErrOutputLimitExceeded, o.Limits.MaxOutputSize)
+		}
+		if err = ofc.WriteInterleavedFrame(pkt); err != nil {
+			err = fmt.Errorf("pipeline: writing frame failed: %w", err)
+			return
+		}
+	}
+
+	// Write trailer
+	end(nil)
+	end = t.step("trailer write")
+	if err = ofc.WriteTrailer(); err != nil {
+		err = fmt.Errorf("pipeline: writing trailer failed: %w", err)
+	}
+	return
+}
+
+// selectSubtitleStream returns the subtitle stream extracted: the one at
+// index, the first one in language or the first one
+func selectSubtitleStream(fc *astiav.FormatContext, index *int, language string) (*astiav.Stream, error) {
+	ss := fc.Streams()
+	if index != nil {
+		i := *index
+		if i < 0 || i >= len(ss) {
+			return nil, fmt.Errorf("pipeline: stream %d isn't a subtitle stream", i)
+		}
+		if t := ss[i].CodecParameters().MediaType(); t != astiav.MediaTypeSubtitle {
+			return nil, fmt.Errorf("pipeline: stream %d is a %s stream, not a subtitle one", i, t)
+		}
+		return ss[i], nil
+	}
+	for _, s := range ss {
+		if s.CodecParameters().MediaType() == astiav.MediaTypeSubtitle && (language == "" || streamLanguage(s) == language) {
+			return s, nil
+		}
+	}
+	if language != "" {
+		return nil, fmt.Errorf("%w in %s", ErrNoSubtitleStream, language)
+	}
+	return nil, ErrNoSubtitleStream
+}
+
+// subtitlesFilter returns the filter burning the first subtitle stream of the
+// input in its video. The filter opens the input again by itself, out of the
+// protocol whitelist, so only local files are allowed when the input is
+// restricted by a policy.
+func (t *Transcoder) subtitlesFilter() (string, error) {
+	// Check filter
+	if astiav.FindFilterByName("subtitles") == nil {
+		return "", errors.New("pipeline: burning subtitles in isn't supported by the linked FFmpeg")
+	}
+
+	// Resolve input
+	target, protocols, err := t.o.InputPolicy.resolve(t.in.url)
+	if err != nil {
+		return "", fmt.Errorf("pipeline: invalid input url: %w", err)
+	}
+	if t.o.InputPolicy != nil && protocols != "file" {
+		return "", errors.New("pipeline: burning subtitles in needs a file input")
+	}
+	return "subtitles=filename=" + escapeFilterValue(target), nil
+}
+
+// escapeFilterValue escapes a filter option value so that it can be used in a
+// filter graph description, which takes two levels of escaping
+func escapeFilterValue(v string) string {
+	for _, special := range []string{`\':`, `\'[],;`} {
+		var b strings.Builder
+		for _, r := range v {
+			if strings.ContainsRune(special, r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		v = b.String()
+	}
+	return v
+}
@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// Thumbnail formats
+const (
+	ThumbnailJPEG = "jpeg"
+	ThumbnailPNG  = "png"
+)
+
+// Thumbnail describes a frame of a video input encoded as an image
+type Thumbnail struct {
+	Format    string        // ThumbnailJPEG or ThumbnailPNG
+	Height    int           // 0 keeps the height of the video, or its aspect ratio when Width is set
+	Position  time.Duration // Of the frame in the input
+	Subtitles bool          // Burn the first subtitle stream of the input in, which needs FFmpeg built with libass
+	Width     int           // 0 keeps the width of the video, or its aspect ratio when Height is set
+}
+
+// qp2Lambda converts quantizers into the lambdas of the global quality, as
+// FF_QP2LAMBDA
+const qp2Lambda = 118
+
+// thumbnailEncoders are the encoders and pixel formats of the thumbnail
+// formats
+var thumbnailEncoders = map[string][2]string{
+	ThumbnailJPEG: {"mjpeg", "yuvj420p"},
+	ThumbnailPNG:  {"png", "rgb24"},
+}
+
+// ExtractThumbnail opens the input, retrying transient network failures, seeks
+// the first video stream to th.Position and returns its first frame from then,
+// or its last one when the video ends before, encoded as an image. The input
+// is interrupted when ctx is done. Only the stream count limit applies.
+func ExtractThumbnail(ctx context.Context, url string, o Options, th Thumbnail) (b []byte, err error) {
+	// Create transcoder holding the input
+	t := New(o)
+	defer t.Close()
+
+	// Interrupt the input when the context is done
+	defer t.in.watch(ctx)()
+	defer t.checkInterrupted(&err)
+
+	// Trace steps, the current one is finished with the error on failure
+	end := t.step("input open")
+	defer func() { end(err) }()
+
+	// Check format
+	enc, ok := thumbnailEncoders[th.Format]
+	if !ok {
+		err = fmt.Errorf("pipeline: thumbnail format not supported: %s", th.Format)
+		return
+	}
+
+	// Open video
+	var d *decoder
+	if d, err = t.openVideo(url, th.Position); err != nil {
+		return
+	}
+
+	// Decode frame
+	end(nil)
+	end = t.step("frame decode")
+	f := t.allocFrame()
+	if err = t.decodeThumbnail(d, th.Position, f); err != nil {
+		return
+	}
+
+	// Scale and convert frame
+	end(nil)
+	end = t.step("image encode")
+	w, h := th.Width, th.Height
+	if w == 0 && h == 0 {
+		w, h = f.Width(), f.Height()
+	} else if w == 0 {
+		w = -2
+	} else if h == 0 {
+		h = -2
+	}
+	content := fmt.Sprintf("scale=w=%d:h=%d,setsar=1,format=pix_fmts=%s", w, h, enc[1])
+	if th.Subtitles {
+		var s string
+		if s, err = t.subtitlesFilter(); err != nil {
+			return
+		}
+		content = s + "," + content
+	}
+	var g *graph
+	if g, err = t.newVideoGraph(content, []string{"in"}, []astiav.FilterArgs{videoArgs(d, f)}); err != nil {
+		return
+	}
+	if err = g.sources[0].BuffersrcAddFrame(f, astiav.NewBuffersrcFlags()); err != nil {
+		err = fmt.Errorf("pipeline: adding frame failed: %w", err)
+		return
+	}
+	if err = g.sink.BuffersinkGetFrame(f, astiav.NewBuffersinkFlags()); err != nil {
+		err = fmt.Errorf("pipeline: getting frame failed: %w", err)
+		return
+	}
+
+	// Encode image
+	return t.encodeThumbnail(enc[0], f, d.stream.TimeBase())
+}
+
+// decodeThumbnail decodes the first frame of d from position into f, or its
+// last frame when the stream ends before
+func (t *Transcoder) decodeThumbnail(d *decoder, position time.Duration, f *astiav.Frame) (err error) {
+	if err = t.decodeVideo(d, func() (bool, error) {
+		v, ok := framePosition(d)
+		f.Unref()
+		f.MoveRef(d.frame)
+		return !ok || v >= position, nil
+	}); err != nil {
+		return
+	}
+	if f.Width() == 0 {
+		err = errors.New("pipeline: no frame decoded")
+	}
+	return
+}
+
+// encodeThumbnail encodes a frame into an image with the encoder codec
+func (t *Transcoder) encodeThumbnail(codec string, f *astiav.Frame, timeBase astiav.Rational) (b []byte, err error) {
+	// Find encoder
+	c := astiav.FindEncoderByName(codec)
+	if c == nil {
+		err = withCode(CodeUnsupportedCodec, fmt.Errorf("pipeline: %s encoder not found", codec))
+		return
+	}
+
+	// Alloc codec context
+	cc := astiav.AllocCodecContext(c)
+	if cc == nil {
+		err = errors.New("pipeline: codec context is nil")
+		return
+	}
+	t.c.Add(cc.Free)
+	cc.SetHeight(f.Height())
+	cc.SetPixelFormat(f.PixelFormat())
+	cc.SetSampleAspectRatio(astiav.NewRational(1, 1))
+	cc.SetTimeBase(timeBase)
+	cc.SetWidth(f.Width())
+	t.setThreads(cc)
+
+	// Encode JPEG images with a fixed quality, as ffmpeg -q:v 2 does
+	opts := astiav.NewDictionary()
+	defer opts.Free()
+	if codec == "mjpeg" {
+		cc.SetFlags(cc.Flags().Add(astiav.CodecContextFlagQscale))
+		if err = opts.Set("global_quality", strconv.Itoa(2*qp2Lambda), astiav.NewDictionaryFlags()); err != nil {
+			err = fmt.Errorf("pipeline: setting quality failed: %w", err)
+			return
+		}
+	}
+
+	// Open codec context
+	if err = cc.Open(c, opts); err != nil {
+		err = fmt.Errorf("pipeline: opening codec context failed: %w", err)
+		return
+	}
+
+	// Encode frame
+	if err = cc.SendFrame(f); err != nil {
+		err = withCode(CodeEncodeError, fmt.Errorf("pipeline: sending frame failed: %w", err))
+		return
+	}
+	if err = cc.SendFrame(nil); err != nil {
+		err = fmt.Errorf("pipeline: flushing encoder failed: %w", err)
+		return
+	}
+	pkt := t.allocPacket()
+	if err = cc.ReceivePacket(pkt); err != nil {
+		err = withCode(CodeEncodeError, fmt.Errorf("pipeline: receiving packet failed: %w", err))
+		return
+	}
+	b = pkt.Data()
+	return
+}
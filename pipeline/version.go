@@ -0,0 +1,45 @@
+package pipeline
+
+//#cgo pkg-config: libavcodec libavfilter libavformat libavutil
+//#include <libavcodec/avcodec.h>
+//#include <libavfilter/avfilter.h>
+//#include <libavformat/avformat.h>
+//#include <libavutil/avutil.h>
+import "C"
+import (
+	"fmt"
+	"strings"
+)
+
+// Build describes the FFmpeg libraries linked, whose codecs, muxers and
+// filters depend on how FFmpeg was built
+type Build struct {
+	Configuration []string  // Flags FFmpeg was configured with, e.g. --enable-libmp3lame
+	Libraries     []Library // Sorted by name
+	Version       string    // Of FFmpeg, e.g. 5.0.1
+}
+
+// Library is a linked FFmpeg library
+type Library struct {
+	Name    string // e.g. libavcodec
+	Version string // major.minor.micro, e.g. 59.18.100
+}
+
+// FFmpegBuild describes the FFmpeg libraries linked
+func FFmpegBuild() Build {
+	return Build{
+		Configuration: strings.Fields(C.GoString(C.avutil_configuration())),
+		Libraries: []Library{
+			{Name: "libavcodec", Version: libraryVersion(uint(C.avcodec_version()))},
+			{Name: "libavfilter", Version: libraryVersion(uint(C.avfilter_version()))},
+			{Name: "libavformat", Version: libraryVersion(uint(C.avformat_version()))},
+			{Name: "libavutil", Version: libraryVersion(uint(C.avutil_version()))},
+		},
+		Version: C.GoString(C.av_version_info()),
+	}
+}
+
+// libraryVersion formats a version as packed by AV_VERSION_INT
+func libraryVersion(v uint) string {
+	return fmt.Sprintf("%d.%d.%d", v>>16, v>>8&0xff, v&0xff)
+}
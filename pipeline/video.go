@@ -0,0 +1,281 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/asticode/go-astiav"
+)
+
+// openVideo opens the input and sets up the decoder of its first video stream,
+// seeked to position. The decoder frames keep the time base of the stream.
+func (t *Transcoder) openVideo(url string, position time.Duration) (d *decoder, err error) {
+	// Open input
+	t.in.url = url
+	if err = t.in.open(); err != nil {
+		err = withCode(CodeInputOpenFailed, fmt.Errorf("pipeline: opening input failed: %w", err))
+		return
+	}
+	t.c.Add(t.in.close)
+	fc := t.in.formatContext
+
+	// Find stream info
+	if err = fc.FindStreamInfo(nil); err != nil {
+		err = fmt.Errorf("pipeline: finding stream info failed: %w", err)
+		return
+	}
+
+	// Check limits
+	if err = t.checkInputLimits(fc); err != nil {
+		return
+	}
+
+	// Select stream
+	var is *astiav.Stream
+	for _, s := range fc.Streams() {
+		if s.CodecParameters().MediaType() == astiav.MediaTypeVideo {
+			is = s
+			break
+		}
+	}
+	if is == nil {
+		err = errors.New("pipeline: no video stream")
+		return
+	}
+
+	// Check position
+	if v := fc.Duration(); v > 0 && v != astiav.NoPtsValue {
+		if v := time.Duration(astiav.RescaleQ(v, astiav.TimeBaseQ, astiav.NewRational(1, int(time.Second)))); position >= v {
+			err = fmt.Errorf("pipeline: position %s is past the end of the input at %s", position, v)
+			return
+		}
+	}
+
+	// Set up decoder
+	if d, err = t.openDecoder(is); err != nil {
+		return
+	}
+	d.codecContext.SetTimeBase(is.TimeBase())
+
+	// Seek position. Inputs which can't be seeked are decoded from their
+	// beginning instead.
+	if position > 0 {
+		ts := astiav.RescaleQ(int64(position), astiav.NewRational(1, int(time.Second)), astiav.TimeBaseQ)
+		if st := fc.StartTime(); st != astiav.NoPtsValue {
+			ts += st
+		}
+		fc.SeekFrame(-1, ts, astiav.NewSeekFlags(astiav.SeekFlagBackward))
+	}
+	return
+}
+
+// decodeVideo decodes the packets of the video stream of d and calls fn with
+// each frame, in d.frame, until it returns done or the stream ends
+func (t *Transcoder) decodeVideo(d *decoder, fn func() (done bool, err error)) (err error) {
+	// Alloc packet
+	pkt := t.allocPacket()
+
+	// Loop through packets until the stream ends
+	for eof := false; ; {
+		// Read frame
+		if !eof {
+			if err = t.in.readFrame(pkt); errors.Is(err, astiav.ErrEof) {
+				eof = true
+			} else if err != nil {
+				err = fmt.Errorf("pipeline: reading frame failed: %w", err)
+				return
+			} else if pkt.StreamIndex() != d.stream.Index() {
+				pkt.Unref()
+				continue
+			}
+		}
+
+		// Send packet, or flush the decoder once the input ends. While the
+		// decoder is full, its frames are received first.
+		p := pkt
+		if eof {
+			p = nil
+		}
+		var done bool
+		for {
+			if err = d.codecContext.SendPacket(p); !errors.Is(err, astiav.ErrEagain) {
+				break
+			}
+			if done, err = receiveVideo(d, fn); err != nil || done {
+				pkt.Unref()
+				return
+			}
+		}
+		pkt.Unref()
+		if err != nil && !errors.Is(err, astiav.ErrEof) {
+			err = withCode(CodeDecodeError, fmt.Errorf("pipeline: sending packet failed: %w", err))
+			return
+		}
+
+		// Receive frames
+		if done, err = receiveVideo(d, fn); err != nil || done {
+			return
+		}
+	}
+}
+
+// receiveVideo calls fn with each frame received from the decoder, in system
+// memory, until it returns done, the decoder needs another packet or the
+// stream ends
+func receiveVideo(d *decoder, fn func() (done bool, err error)) (done bool, err error) {
+	for {
+		if err = d.codecContext.ReceiveFrame(d.frame); err != nil {
+			if errors.Is(err, astiav.ErrEagain) {
+				return false, nil
+			} else if errors.Is(err, astiav.ErrEof) {
+				return true, nil
+			}
+			err = withCode(CodeDecodeError, fmt.Errorf("pipeline: receiving frame failed: %w", err))
+			return
+		}
+		if err = downloadFrame(d.frame); err != nil {
+			err = withCode(CodeDecodeError, err)
+			return
+		}
+		if done, err = fn(); err != nil || done {
+			return
+		}
+	}
+}
+
+// videoArgs returns the arguments of a buffer source of the frames of a video
+// decoder, as f received from it. Frames downloaded from hardware surfaces
+// don't have the pixel format of the decoder.
+func videoArgs(d *decoder, f *astiav.Frame) astiav.FilterArgs {
+	return astiav.FilterArgs{
+		"pix_fmt":      strconv.Itoa(int(f.PixelFormat())),
+		"pixel_aspect": d.codecContext.SampleAspectRatio().String(),
+		"time_base":    d.stream.TimeBase().String(),
+		"video_size":   fmt.Sprintf("%dx%d", f.Width(), f.Height()),
+	}
+}
+
+// videoCopy copies the packets of the first video stream of an input into the
+// outputs, interleaved with the audio by position
+type videoCopy struct {
+	d       *decoder // Only holds the stream, updated when the input is reopened
+	ended   bool
+	in      *input
+	pending bool // pkt holds a packet read but not written yet
+	pkt     *astiav.Packet
+	t       *Transcoder
+}
+
+// newVideoCopy opens the input of Options.Video, interrupted when ctx is done
+func newVideoCopy(ctx context.Context, t *Transcoder) (v *videoCopy, err error) {
+	// Open input
+	v = &videoCopy{in: newInput(t), t: t}
+	defer v.in.watch(ctx)()
+	v.in.url = t.o.Video
+	if err = v.in.open(); err != nil {
+		err = fmt.Errorf("pipeline: opening video input failed: %w", err)
+		return
+	}
+	t.c.Add(v.in.close)
+	fc := v.in.formatContext
+
+	// Find stream info
+	if err = fc.FindStreamInfo(nil); err != nil {
+		err = fmt.Errorf("pipeline: finding stream info failed: %w", err)
+		return
+	}
+
+	// Check limits
+	if err = t.checkInputLimits(fc); err != nil {
+		return
+	}
+
+	// Select stream
+	for _, s := range fc.Streams() {
+		if s.CodecParameters().MediaType() == astiav.MediaTypeVideo {
+			v.d = &decoder{stream: s}
+			break
+		}
+	}
+	if v.d == nil {
+		err = errors.New("pipeline: no video stream in the video input")
+		return
+	}
+	v.in.decoders = map[int]*decoder{v.d.stream.Index(): v.d}
+
+	// Alloc packet
+	v.pkt = t.allocPacket()
+	return
+}
+
+// addStream adds the copy of the video stream to an output, before its header
+// is written
+func (v *videoCopy) addStream(out *output) (err error) {
+	if out.video = out.formatContext.NewStream(nil); out.video == nil {
+		err = errors.New("pipeline: output stream is nil")
+		return
+	}
+	if err = v.d.stream.CodecParameters().Copy(out.video.CodecParameters()); err != nil {
+		err = fmt.Errorf("pipeline: copying codec parameters failed: %w", err)
+		return
+	}
+	out.video.CodecParameters().SetCodecTag(0)
+	out.video.SetTimeBase(v.d.stream.TimeBase())
+	return
+}
+
+// copy writes the video packets up to position in the outputs, all of them
+// when position is negative
+func (v *videoCopy) copy(position time.Duration) (err error) {
+	for !v.ended {
+		// Read packet
+		if !v.pending {
+			if err = v.in.readFrame(v.pkt); errors.Is(err, astiav.ErrEof) {
+				v.ended, err = true, nil
+				break
+			} else if err != nil {
+				err = fmt.Errorf("pipeline: reading video frame failed: %w", err)
+				return
+			} else if v.pkt.StreamIndex() != v.d.stream.Index() {
+				v.pkt.Unref()
+				continue
+			}
+			v.pending = true
+		}
+
+		// Wait for the audio to reach the packet
+		ts := v.pkt.Dts()
+		if ts == astiav.NoPtsValue {
+			ts = v.pkt.Pts()
+		}
+		if ts != astiav.NoPtsValue {
+			if st := v.d.stream.StartTime(); st != astiav.NoPtsValue {
+				ts -= st
+			}
+			if position >= 0 && time.Duration(astiav.RescaleQ(ts, v.d.stream.TimeBase(), astiav.NewRational(1, int(time.Second)))) > position {
+				break
+			}
+		}
+
+		// Write packet, with timestamps starting at 0 as the ones of the audio
+		if st := v.d.stream.StartTime(); st != astiav.NoPtsValue {
+			if v.pkt.Pts() != astiav.NoPtsValue {
+				v.pkt.SetPts(v.pkt.Pts() - st)
+			}
+			if v.pkt.Dts() != astiav.NoPtsValue {
+				v.pkt.SetDts(v.pkt.Dts() - st)
+			}
+		}
+		for _, out := range v.t.outputs {
+			if err = out.writeVideo(v.pkt, v.d.stream.TimeBase()); err != nil {
+				return
+			}
+		}
+		v.pkt.Unref()
+		v.pending = false
+	}
+	return
+}
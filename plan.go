@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+)
+
+// transcodePlan is the response of a dry run, what a transcode would do
+type transcodePlan struct {
+	Duration float64         `json:"duration,omitempty"` // Expected duration of the output in seconds, unless unknown
+	Outputs  []transcodeStep `json:"outputs"`
+	Task     *TranscodeTask  `json:"task"` // With its defaults and preset applied
+}
+
+// transcodeStep describes how the input stream is written in an output
+type transcodeStep struct {
+	Container   string `json:"container"`
+	Copy        bool   `json:"copy"` // Packets are copied as is, without being decoded and encoded
+	Decoder     string `json:"decoder,omitempty"`
+	Encoder     string `json:"encoder"`
+	FileName    string `json:"fileName"`
+	Filter      string `json:"filter,omitempty"` // Filter graph between the decoder and the encoder
+	StreamIndex int    `json:"streamIndex"`
+}
+
+// planTask opens the task input and sets up its outputs, discarding them,
+// then reports what the transcode would do without running it. The input
+// isn't cached and the output isn't pushed. task.Status is updated on
+// failure.
+func planTask(task *TranscodeTask, parent *span) (p *transcodePlan, err error) {
+	// Set up transcoder
+	task.dryRun = true
+	var t *transcoder
+	if t, err = newTranscoder(context.Background(), task, os.DevNull, parent); err != nil {
+		return
+	}
+	defer t.close()
+
+	// Describe outputs
+	p = &transcodePlan{
+		Duration: outputDuration(task, t.Duration()).Seconds(),
+		Outputs:  transcodeSteps(task, t),
+		Task:     task,
+	}
+	return
+}
+
+// transcodeSteps describes how the input stream of a task is written in each
+// output once t is set up, outputs being named as in the response of the
+// transcode
+func transcodeSteps(task *TranscodeTask, t *transcoder) (ss []transcodeStep) {
+	ss = []transcodeStep{}
+	ps, _ := parseOutputProfiles(task)
+	for _, pl := range t.Plans() {
+		s := transcodeStep{
+			Container:   pl.Container,
+			Copy:        pl.Copy,
+			Decoder:     pl.Decoder,
+			Encoder:     pl.Encoder,
+			FileName:    outputDownloadName(task),
+			Filter:      pl.Filter,
+			StreamIndex: pl.StreamIndex,
+		}
+		if len(ps) > 0 {
+			s.FileName = outputFileName(pl.Output, task.MediaType)
+			if pl.Output > 0 {
+				s.FileName = outputFileName(pl.Output, ps[pl.Output-1].MediaType)
+			}
+		}
+		ss = append(ss, s)
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var (
+	maxConcurrency int
+	maxQueueDepth  int
+	overflowStatus int // http.StatusTooManyRequests or http.StatusServiceUnavailable
+)
+
+var errPoolFull = errors.New("main: too many transcodes in progress")
+
+var (
+	memoryBudget   int64 // Estimated bytes of audio buffered by all transcodes, 0 disables the budget
+	memoryReserved int64 // Accessed atomically
+)
+
+var errMemoryBudget = errors.New("main: memory budget exhausted")
+
+// workerPool bounds the number of transcodes running simultaneously
+type workerPool struct {
+	maxWaiting int
+	slots      chan struct{}
+	waiting    int32 // Accessed atomically
+}
+
+func newWorkerPool(size, maxWaiting int) *workerPool {
+	return &workerPool{
+		maxWaiting: maxWaiting,
+		slots:      make(chan struct{}, size),
+	}
+}
+
+// acquire waits for a free slot. When limitQueue is true, it fails with
+// errPoolFull instead of waiting if too many callers are already waiting.
+func (p *workerPool) acquire(limitQueue bool) error {
+	// Free slot
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	// Queue
+	if limitQueue {
+		if int(atomic.AddInt32(&p.waiting, 1)) > p.maxWaiting {
+			atomic.AddInt32(&p.waiting, -1)
+			return errPoolFull
+		}
+		defer atomic.AddInt32(&p.waiting, -1)
+	}
+	p.slots <- struct{}{}
+	return nil
+}
+
+// release frees a slot acquired with acquire
+func (p *workerPool) release() {
+	<-p.slots
+}
+
+// reserveMemory reserves n bytes of the memory budget, which must be released
+// with releaseMemory. It fails with errMemoryBudget when less is left.
+func reserveMemory(n int64) (reserved int64, err error) {
+	if memoryBudget <= 0 || n <= 0 {
+		return
+	}
+	if atomic.AddInt64(&memoryReserved, n) > memoryBudget {
+		atomic.AddInt64(&memoryReserved, -n)
+		err = fmt.Errorf("%w: %d bytes needed", errMemoryBudget, n)
+		return
+	}
+	return n, nil
+}
+
+// releaseMemory frees bytes reserved with reserveMemory
+func releaseMemory(n int64) {
+	atomic.AddInt64(&memoryReserved, -n)
+}
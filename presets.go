@@ -0,0 +1,74 @@
+package main
+
+import (
+	"time"
+
+	"example.com/m/config"
+)
+
+// presets are the named task defaults of the enabled media types
+var presets []config.Preset
+
+// applyPreset sets the fields of a task its preset defines and it leaves
+// unset. It reports false when the preset doesn't exist.
+func applyPreset(task *TranscodeTask) bool {
+	p, ok := findPreset(task.Preset)
+	if !ok {
+		return false
+	}
+	if task.MediaType == "" {
+		task.MediaType = p.MediaType
+	}
+	if task.Encoding == "" && task.MediaType == p.MediaType {
+		task.Encoding = p.Encoding
+	}
+	if task.Channels == 0 {
+		task.Channels = p.Channels
+	}
+	if task.SampleRate == 0 {
+		task.SampleRate = p.SampleRate
+	}
+	if task.Normalize == "" {
+		task.Normalize = p.Normalize
+	}
+	if task.HighPass == 0 {
+		task.HighPass = p.HighPass
+	}
+	if task.BitRate == 0 && task.MediaType == p.MediaType {
+		task.BitRate = p.BitRate
+	}
+	return true
+}
+
+// checkPreset checks a task with a strict preset doesn't override the options
+// it sets, nor writes its output in another container with videourl
+func checkPreset(v *validator, task *TranscodeTask) {
+	p, _ := findPreset(task.Preset)
+	if !p.Strict {
+		return
+	}
+	if task.MediaType != p.MediaType || task.Encoding != p.Encoding || task.BitRate != p.BitRate ||
+		(p.Channels > 0 && task.Channels != p.Channels) ||
+		(p.SampleRate > 0 && task.SampleRate != p.SampleRate) {
+		v.addf("preset", "options of preset %s can't be overridden", p.Name)
+	} else if task.VideoUrl != "" {
+		v.addf("preset", "videourl isn't supported by preset %s", p.Name)
+	}
+}
+
+// presetMaxDuration returns the maximum duration of the outputs of a preset,
+// 0 if unlimited or if the preset doesn't exist
+func presetMaxDuration(name string) time.Duration {
+	p, _ := findPreset(name)
+	return time.Duration(p.MaxDuration)
+}
+
+// findPreset returns the preset with this name
+func findPreset(name string) (config.Preset, bool) {
+	for _, p := range presets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return config.Preset{}, false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/m/pipeline"
+)
+
+// previewRequest is the body of POST /speak/preview, which returns a short
+// clip of a video input as a GIF or MP4 preview
+type previewRequest struct {
+	AudioUrl  string   `form:"audiourl"` // Video input, named as the inputs of transcodes
+	Headers   []string `form:"headers"`
+	Start     string   `form:"start"`     // Input position such as 1m30s or number of seconds, defaults to 0
+	Duration  string   `form:"duration"`  // Duration such as 3s or number of seconds, defaults to 3s
+	Format    string   `form:"format"`    // gif or mp4, defaults to gif
+	Width     int      `form:"width"`     // In pixels, defaults to 320
+	FrameRate int      `form:"framerate"` // Frames per second, defaults to 10
+	Timeout   string   `form:"timeout"`   // Duration such as 30s or number of seconds
+	Subtitles bool     `form:"subtitles"` // Burn the first subtitle stream of the input in
+}
+
+// previewTask extracts the preview a request describes, updating task.Status
+// on failure. The extraction is interrupted once the task timeout expires.
+func previewTask(r *previewRequest, task *TranscodeTask, parent *span) (b []byte, contentType string, err error) {
+	// Check task
+	task.Status = http.StatusOK
+	if task.AudioUrl == "" {
+		task.Status = http.StatusBadRequest
+		err = errAudioURLRequired
+		return
+	}
+	var d time.Duration
+	if err = checkTimeout(task.Timeout); err != nil {
+		task.Status = http.StatusBadRequest
+		return
+	}
+
+	// Check preview
+	p := pipeline.Preview{
+		Duration:  defaultPreviewDuration,
+		Format:    strings.ToLower(r.Format),
+		FrameRate: r.FrameRate,
+		Subtitles: r.Subtitles,
+		Width:     r.Width,
+	}
+	switch p.Format {
+	case "", pipeline.PreviewGIF:
+		p.Format = pipeline.PreviewGIF
+		contentType = "image/gif"
+	case pipeline.PreviewMP4:
+		contentType = "video/mp4"
+	default:
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: preview format not supported: %s", r.Format)
+		return
+	}
+	if p.Width == 0 {
+		p.Width = defaultPreviewWidth
+	}
+	if p.FrameRate == 0 {
+		p.FrameRate = defaultPreviewFrameRate
+	}
+	if p.Width < minPreviewWidth || p.Width > maxPreviewWidth {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: preview width out of range: %d", r.Width)
+		return
+	} else if p.FrameRate < 1 || p.FrameRate > maxPreviewFrameRate {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: preview frame rate out of range: %d", r.FrameRate)
+		return
+	}
+	if p.Start, err = parseTimeout(r.Start); err != nil || p.Start < 0 {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: invalid start: %s", r.Start)
+		return
+	}
+	if r.Duration != "" {
+		if p.Duration, err = parseTimeout(r.Duration); err != nil || p.Duration <= 0 || p.Duration > maxPreviewDuration {
+			task.Status = http.StatusBadRequest
+			err = fmt.Errorf("main: invalid preview duration: %s", r.Duration)
+			return
+		}
+	}
+
+	// Read the preview through a pipe
+	var op *outputPipe
+	if op, err = newOutputPipe(); err != nil {
+		task.Status = http.StatusInternalServerError
+		err = fmt.Errorf("main: creating output pipe failed: %w", err)
+		return
+	}
+	read := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(op)
+		read <- b
+	}()
+	p.URL = op.url()
+
+	// Extract
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if d = taskTimeout(task); d > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), d)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	err = pipeline.ExtractPreview(ctx, task.AudioUrl, taskOptions(task, parent), p)
+	op.closeWrite(err)
+	b = <-read
+	if err != nil {
+		task.Status = transcodeErrorStatus(err)
+		if errors.Is(err, pipeline.ErrTimeout) {
+			err = fmt.Errorf("%w after %s", err, d)
+		}
+	}
+	return
+}
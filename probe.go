@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"example.com/m/pipeline"
+	"github.com/gofiber/fiber/v2"
+)
+
+var errAudioURLRequired = errors.New("main: audio url is required")
+
+// coverArtContentTypes are the content types of the codecs of cover art
+var coverArtContentTypes = map[string]string{
+	"bmp":   "image/bmp",
+	"gif":   "image/gif",
+	"mjpeg": "image/jpeg",
+	"png":   "image/png",
+	"webp":  "image/webp",
+}
+
+// probeResult is the response of a probe
+type probeResult struct {
+	BitRate  int64             `json:"bitRate,omitempty"`
+	Chapters []probeChapter    `json:"chapters,omitempty"`
+	CoverArt *probeCoverArt    `json:"coverArt,omitempty"`
+	Duration float64           `json:"duration,omitempty"` // Seconds
+	Format   string            `json:"format"`
+	Streams  []probeStream     `json:"streams"`
+	Tags     map[string]string `json:"tags,omitempty"` // Of the container, e.g. title
+}
+
+// probeStream is a stream of a probed input
+type probeStream struct {
+	BitRate       int64             `json:"bitRate,omitempty"`
+	ChannelLayout string            `json:"channelLayout,omitempty"`
+	Channels      int               `json:"channels,omitempty"`
+	Codec         string            `json:"codec"`
+	CoverArt      bool              `json:"coverArt,omitempty"` // Attached picture rather than a video
+	Duration      float64           `json:"duration,omitempty"` // Seconds
+	Index         int               `json:"index"`
+	Language      string            `json:"language,omitempty"`
+	MediaType     string            `json:"mediaType"`
+	SampleFormat  string            `json:"sampleFormat,omitempty"`
+	SampleRate    int               `json:"sampleRate,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+// probeChapter is a chapter of a probed input
+type probeChapter struct {
+	End   float64 `json:"end"`   // Seconds
+	Start float64 `json:"start"` // Seconds
+	Title string  `json:"title,omitempty"`
+}
+
+// probeCoverArt is the cover art of a probed input, its first attached
+// picture
+type probeCoverArt struct {
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"` // Base64 in JSON
+	StreamIndex int    `json:"streamIndex"`
+}
+
+// probeTask opens the task input and describes it without transcoding it,
+// updating task.Status on failure. The probe is interrupted once the task
+// timeout expires.
+func probeTask(task *TranscodeTask, parent *span) (r *probeResult, err error) {
+	// Check task
+	task.Status = http.StatusOK
+	if task.AudioUrl == "" {
+		task.Status = http.StatusBadRequest
+		err = errAudioURLRequired
+		return
+	}
+	var d time.Duration
+	if err = checkTimeout(task.Timeout); err != nil {
+		task.Status = http.StatusBadRequest
+		return
+	}
+
+	// Probe
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if d = taskTimeout(task); d > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), d)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	var info *pipeline.MediaInfo
+	if info, err = pipeline.Probe(ctx, task.AudioUrl, taskOptions(task, parent)); err != nil {
+		task.Status = transcodeErrorStatus(err)
+		if errors.Is(err, pipeline.ErrTimeout) {
+			err = fmt.Errorf("%w after %s", err, d)
+		}
+		return
+	}
+
+	return newProbeResult(info), nil
+}
+
+// newProbeResult converts the description of an input
+func newProbeResult(info *pipeline.MediaInfo) (r *probeResult) {
+	r = &probeResult{
+		BitRate:  info.BitRate,
+		Duration: info.Duration.Seconds(),
+		Format:   info.Format,
+		Streams:  []probeStream{},
+		Tags:     info.Tags,
+	}
+	for _, c := range info.Chapters {
+		r.Chapters = append(r.Chapters, probeChapter{
+			End:   c.End.Seconds(),
+			Start: c.Start.Seconds(),
+			Title: c.Title,
+		})
+	}
+	if p := info.CoverArt; p != nil {
+		r.CoverArt = &probeCoverArt{
+			ContentType: fiber.MIMEOctetStream,
+			Data:        p.Data,
+			StreamIndex: p.StreamIndex,
+		}
+		if ct, ok := coverArtContentTypes[p.Codec]; ok {
+			r.CoverArt.ContentType = ct
+		}
+	}
+	for _, s := range info.Streams {
+		r.Streams = append(r.Streams, probeStream{
+			BitRate:       s.BitRate,
+			ChannelLayout: s.ChannelLayout,
+			Channels:      s.Channels,
+			Codec:         s.Codec,
+			CoverArt:      s.CoverArt,
+			Duration:      s.Duration.Seconds(),
+			Index:         s.Index,
+			Language:      s.Language,
+			MediaType:     s.MediaType,
+			SampleFormat:  s.SampleFormat,
+			SampleRate:    s.SampleRate,
+			Tags:          s.Tags,
+		})
+	}
+	return
+}
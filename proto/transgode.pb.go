@@ -0,0 +1,1096 @@
+// Transcode service exposing the pipeline package over gRPC, served on
+// TRANSGODE_GRPC_ADDRESS by grpc.go.
+//
+// Go code is generated in this directory by make proto, with protoc-gen-go
+// v1.30.0 and protoc-gen-go-grpc v1.3.0 to match the vendored modules:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//		--go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//		proto/transgode.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.30.0
+// 	protoc        (unknown)
+// source: proto/transgode.proto
+
+package transgodepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Settings mirror the form fields of POST /speak/transcode
+type Settings struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	MediaType        string    `protobuf:"bytes,1,opt,name=media_type,json=mediaType,proto3" json:"media_type,omitempty"`     // wav, raw, mulaw, mp3, hls or dash
+	Channels         int32     `protobuf:"varint,2,opt,name=channels,proto3" json:"channels,omitempty"`                       // Defaults to 2
+	SampleRate       int32     `protobuf:"varint,3,opt,name=sample_rate,json=sampleRate,proto3" json:"sample_rate,omitempty"` // Defaults to 44100
+	Timeout          string    `protobuf:"bytes,4,opt,name=timeout,proto3" json:"timeout,omitempty"`                          // e.g. 30s, defaults to and can't exceed TRANSGODE_TIMEOUT
+	Gain             float64   `protobuf:"fixed64,5,opt,name=gain,proto3" json:"gain,omitempty"`                              // In dB
+	Normalize        string    `protobuf:"bytes,6,opt,name=normalize,proto3" json:"normalize,omitempty"`                      // ebu, peak or rms
+	TrimSilence      bool      `protobuf:"varint,7,opt,name=trim_silence,json=trimSilence,proto3" json:"trim_silence,omitempty"`
+	SilenceThreshold float64   `protobuf:"fixed64,8,opt,name=silence_threshold,json=silenceThreshold,proto3" json:"silence_threshold,omitempty"` // In dBFS, defaults to -50
+	SilenceDuration  string    `protobuf:"bytes,9,opt,name=silence_duration,json=silenceDuration,proto3" json:"silence_duration,omitempty"`      // e.g. 100ms
+	Start            string    `protobuf:"bytes,10,opt,name=start,proto3" json:"start,omitempty"`                                                // e.g. 1m30s
+	Duration         string    `protobuf:"bytes,11,opt,name=duration,proto3" json:"duration,omitempty"`                                          // e.g. 30s, defaults to the end of the input
+	Tempo            float64   `protobuf:"fixed64,12,opt,name=tempo,proto3" json:"tempo,omitempty"`                                              // Speed ratio between 0.25 and 4
+	Pitch            float64   `protobuf:"fixed64,13,opt,name=pitch,proto3" json:"pitch,omitempty"`                                              // In semitones between -12 and 12
+	FadeIn           string    `protobuf:"bytes,14,opt,name=fade_in,json=fadeIn,proto3" json:"fade_in,omitempty"`                                // e.g. 500ms
+	FadeOut          string    `protobuf:"bytes,15,opt,name=fade_out,json=fadeOut,proto3" json:"fade_out,omitempty"`                             // e.g. 500ms
+	Filter           string    `protobuf:"bytes,16,opt,name=filter,proto3" json:"filter,omitempty"`                                              // Custom filter chain, e.g. highpass=f=300,lowpass=f=3400
+	FilterMode       string    `protobuf:"bytes,17,opt,name=filter_mode,json=filterMode,proto3" json:"filter_mode,omitempty"`                    // append or replace
+	Resampler        string    `protobuf:"bytes,18,opt,name=resampler,proto3" json:"resampler,omitempty"`                                        // swr or soxr
+	Precision        int32     `protobuf:"varint,19,opt,name=precision,proto3" json:"precision,omitempty"`                                       // Bits of precision of soxr
+	Dither           string    `protobuf:"bytes,20,opt,name=dither,proto3" json:"dither,omitempty"`                                              // e.g. triangular or shibata, defaults to none
+	HighPass         float64   `protobuf:"fixed64,21,opt,name=high_pass,json=highPass,proto3" json:"high_pass,omitempty"`                        // Cutoff frequency in Hz
+	LowPass          float64   `protobuf:"fixed64,22,opt,name=low_pass,json=lowPass,proto3" json:"low_pass,omitempty"`                           // Cutoff frequency in Hz
+	Eq               []string  `protobuf:"bytes,23,rep,name=eq,proto3" json:"eq,omitempty"`                                                      // Bands as frequency:gain:q, e.g. 1000:-3:1.4
+	Denoise          string    `protobuf:"bytes,24,opt,name=denoise,proto3" json:"denoise,omitempty"`                                            // light, medium or strong
+	ChannelMap       string    `protobuf:"bytes,25,opt,name=channel_map,json=channelMap,proto3" json:"channel_map,omitempty"`                    // downmix, left, right, swap or a pan filter matrix
+	StreamIndex      *int32    `protobuf:"varint,26,opt,name=stream_index,json=streamIndex,proto3,oneof" json:"stream_index,omitempty"`          // Input index of the audio stream, defaults to the first one
+	Language         string    `protobuf:"bytes,27,opt,name=language,proto3" json:"language,omitempty"`                                          // Language of the audio stream, e.g. eng
+	Concat           []string  `protobuf:"bytes,28,rep,name=concat,proto3" json:"concat,omitempty"`                                              // Inputs appended to audio_url
+	Crossfade        string    `protobuf:"bytes,29,opt,name=crossfade,proto3" json:"crossfade,omitempty"`                                        // e.g. 500ms, overlap of concatenated inputs
+	Gap              string    `protobuf:"bytes,30,opt,name=gap,proto3" json:"gap,omitempty"`                                                    // e.g. 500ms, silence between concatenated inputs
+	Mix              []string  `protobuf:"bytes,31,rep,name=mix,proto3" json:"mix,omitempty"`                                                    // Inputs mixed into audio_url
+	MixGain          []float64 `protobuf:"fixed64,32,rep,packed,name=mix_gain,json=mixGain,proto3" json:"mix_gain,omitempty"`                    // In dB, of the input of mix at the same position
+	Duck             bool      `protobuf:"varint,33,opt,name=duck,proto3" json:"duck,omitempty"`                                                 // Lower the mixed inputs while audio_url is loud
+	PadStart         string    `protobuf:"bytes,34,opt,name=pad_start,json=padStart,proto3" json:"pad_start,omitempty"`                          // e.g. 250ms of silence prepended
+	PadEnd           string    `protobuf:"bytes,35,opt,name=pad_end,json=padEnd,proto3" json:"pad_end,omitempty"`                                // e.g. 250ms of silence appended
+	TargetDuration   string    `protobuf:"bytes,36,opt,name=target_duration,json=targetDuration,proto3" json:"target_duration,omitempty"`        // e.g. 30s, exact duration of the output
+	TargetMode       string    `protobuf:"bytes,37,opt,name=target_mode,json=targetMode,proto3" json:"target_mode,omitempty"`                    // pad or loop
+	ExtractAudio     bool      `protobuf:"varint,38,opt,name=extract_audio,json=extractAudio,proto3" json:"extract_audio,omitempty"`             // Input is a video whose other streams are ignored
+	VideoUrl         string    `protobuf:"bytes,39,opt,name=video_url,json=videoUrl,proto3" json:"video_url,omitempty"`                          // Video the output audio is muxed into, as Matroska
+	SegmentDuration  string    `protobuf:"bytes,40,opt,name=segment_duration,json=segmentDuration,proto3" json:"segment_duration,omitempty"`     // e.g. 6s, of packaged media types
+	SegmentType      string    `protobuf:"bytes,41,opt,name=segment_type,json=segmentType,proto3" json:"segment_type,omitempty"`                 // mpegts or fmp4, of HLS packages
+	PushUrl          string    `protobuf:"bytes,42,opt,name=push_url,json=pushUrl,proto3" json:"push_url,omitempty"`                             // Icecast mount, HTTP endpoint, RTP or SRT destination the output is pushed to live
+	Threads          int32     `protobuf:"varint,43,opt,name=threads,proto3" json:"threads,omitempty"`                                           // FFmpeg threads of the decoder and encoder, defaults to TRANSGODE_THREADS
+	Gapless          bool      `protobuf:"varint,44,opt,name=gapless,proto3" json:"gapless,omitempty"`                                           // Trim the encoder priming of concatenated lossy inputs
+	Preset           string    `protobuf:"bytes,45,opt,name=preset,proto3" json:"preset,omitempty"`                                              // Named set of defaults, e.g. asr
+	Outputs          []string  `protobuf:"bytes,46,rep,name=outputs,proto3" json:"outputs,omitempty"`                                            // Other outputs as mediatype:samplerate:channels or preset name, zipped with the first one
+	FileName         string    `protobuf:"bytes,47,opt,name=file_name,json=fileName,proto3" json:"file_name,omitempty"`                          // Of the output, defaults to the name of the input
+	Metadata         []string  `protobuf:"bytes,48,rep,name=metadata,proto3" json:"metadata,omitempty"`                                          // Tags of the output as key=value, e.g. title=Episode 1
+	StripMetadata    bool      `protobuf:"varint,49,opt,name=strip_metadata,json=stripMetadata,proto3" json:"strip_metadata,omitempty"`          // Drop the tags of the input, only writing metadata
+	Bext             bool      `protobuf:"varint,50,opt,name=bext,proto3" json:"bext,omitempty"`                                                 // Write a Broadcast Wave bext chunk in WAV outputs from the tags
+	Chapters         []string  `protobuf:"bytes,51,rep,name=chapters,proto3" json:"chapters,omitempty"`                                          // As start:end:title, e.g. 0:90:Intro, written in MP3 and Matroska outputs
+	Cuts             []string  `protobuf:"bytes,52,rep,name=cuts,proto3" json:"cuts,omitempty"`                                                  // Positions the output is split at, returned as a zip
+	CueSheet         string    `protobuf:"bytes,53,opt,name=cue_sheet,json=cueSheet,proto3" json:"cue_sheet,omitempty"`                          // CUE sheet whose tracks the output is split into
+	Encoding         string    `protobuf:"bytes,54,opt,name=encoding,proto3" json:"encoding,omitempty"`                                          // Of the samples of wav outputs, s16le or mulaw
+	BitRate          int32     `protobuf:"varint,55,opt,name=bit_rate,json=bitRate,proto3" json:"bit_rate,omitempty"`                            // Of mp3, hls and dash outputs in kbps
+	LowLatency       bool      `protobuf:"varint,56,opt,name=low_latency,json=lowLatency,proto3" json:"low_latency,omitempty"`                   // Send each packet as soon as it's encoded
+	Realtime         *bool     `protobuf:"varint,57,opt,name=realtime,proto3,oneof" json:"realtime,omitempty"`                                   // Write the output at the pace it's played, defaults to true with push_url
+	CoverUrl         string    `protobuf:"bytes,58,opt,name=cover_url,json=coverUrl,proto3" json:"cover_url,omitempty"`                          // JPEG or PNG cover art of mp3 outputs, as an http(s) or base64 data url
+}
+
+func (x *Settings) Reset() {
+	*x = Settings{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_transgode_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Settings) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Settings) ProtoMessage() {}
+
+func (x *Settings) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_transgode_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Settings.ProtoReflect.Descriptor instead.
+func (*Settings) Descriptor() ([]byte, []int) {
+	return file_proto_transgode_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Settings) GetMediaType() string {
+	if x != nil {
+		return x.MediaType
+	}
+	return ""
+}
+
+func (x *Settings) GetChannels() int32 {
+	if x != nil {
+		return x.Channels
+	}
+	return 0
+}
+
+func (x *Settings) GetSampleRate() int32 {
+	if x != nil {
+		return x.SampleRate
+	}
+	return 0
+}
+
+func (x *Settings) GetTimeout() string {
+	if x != nil {
+		return x.Timeout
+	}
+	return ""
+}
+
+func (x *Settings) GetGain() float64 {
+	if x != nil {
+		return x.Gain
+	}
+	return 0
+}
+
+func (x *Settings) GetNormalize() string {
+	if x != nil {
+		return x.Normalize
+	}
+	return ""
+}
+
+func (x *Settings) GetTrimSilence() bool {
+	if x != nil {
+		return x.TrimSilence
+	}
+	return false
+}
+
+func (x *Settings) GetSilenceThreshold() float64 {
+	if x != nil {
+		return x.SilenceThreshold
+	}
+	return 0
+}
+
+func (x *Settings) GetSilenceDuration() string {
+	if x != nil {
+		return x.SilenceDuration
+	}
+	return ""
+}
+
+func (x *Settings) GetStart() string {
+	if x != nil {
+		return x.Start
+	}
+	return ""
+}
+
+func (x *Settings) GetDuration() string {
+	if x != nil {
+		return x.Duration
+	}
+	return ""
+}
+
+func (x *Settings) GetTempo() float64 {
+	if x != nil {
+		return x.Tempo
+	}
+	return 0
+}
+
+func (x *Settings) GetPitch() float64 {
+	if x != nil {
+		return x.Pitch
+	}
+	return 0
+}
+
+func (x *Settings) GetFadeIn() string {
+	if x != nil {
+		return x.FadeIn
+	}
+	return ""
+}
+
+func (x *Settings) GetFadeOut() string {
+	if x != nil {
+		return x.FadeOut
+	}
+	return ""
+}
+
+func (x *Settings) GetFilter() string {
+	if x != nil {
+		return x.Filter
+	}
+	return ""
+}
+
+func (x *Settings) GetFilterMode() string {
+	if x != nil {
+		return x.FilterMode
+	}
+	return ""
+}
+
+func (x *Settings) GetResampler() string {
+	if x != nil {
+		return x.Resampler
+	}
+	return ""
+}
+
+func (x *Settings) GetPrecision() int32 {
+	if x != nil {
+		return x.Precision
+	}
+	return 0
+}
+
+func (x *Settings) GetDither() string {
+	if x != nil {
+		return x.Dither
+	}
+	return ""
+}
+
+func (x *Settings) GetHighPass() float64 {
+	if x != nil {
+		return x.HighPass
+	}
+	return 0
+}
+
+func (x *Settings) GetLowPass() float64 {
+	if x != nil {
+		return x.LowPass
+	}
+	return 0
+}
+
+func (x *Settings) GetEq() []string {
+	if x != nil {
+		return x.Eq
+	}
+	return nil
+}
+
+func (x *Settings) GetDenoise() string {
+	if x != nil {
+		return x.Denoise
+	}
+	return ""
+}
+
+func (x *Settings) GetChannelMap() string {
+	if x != nil {
+		return x.ChannelMap
+	}
+	return ""
+}
+
+func (x *Settings) GetStreamIndex() int32 {
+	if x != nil && x.StreamIndex != nil {
+		return *x.StreamIndex
+	}
+	return 0
+}
+
+func (x *Settings) GetLanguage() string {
+	if x != nil {
+		return x.Language
+	}
+	return ""
+}
+
+func (x *Settings) GetConcat() []string {
+	if x != nil {
+		return x.Concat
+	}
+	return nil
+}
+
+func (x *Settings) GetCrossfade() string {
+	if x != nil {
+		return x.Crossfade
+	}
+	return ""
+}
+
+func (x *Settings) GetGap() string {
+	if x != nil {
+		return x.Gap
+	}
+	return ""
+}
+
+func (x *Settings) GetMix() []string {
+	if x != nil {
+		return x.Mix
+	}
+	return nil
+}
+
+func (x *Settings) GetMixGain() []float64 {
+	if x != nil {
+		return x.MixGain
+	}
+	return nil
+}
+
+func (x *Settings) GetDuck() bool {
+	if x != nil {
+		return x.Duck
+	}
+	return false
+}
+
+func (x *Settings) GetPadStart() string {
+	if x != nil {
+		return x.PadStart
+	}
+	return ""
+}
+
+func (x *Settings) GetPadEnd() string {
+	if x != nil {
+		return x.PadEnd
+	}
+	return ""
+}
+
+func (x *Settings) GetTargetDuration() string {
+	if x != nil {
+		return x.TargetDuration
+	}
+	return ""
+}
+
+func (x *Settings) GetTargetMode() string {
+	if x != nil {
+		return x.TargetMode
+	}
+	return ""
+}
+
+func (x *Settings) GetExtractAudio() bool {
+	if x != nil {
+		return x.ExtractAudio
+	}
+	return false
+}
+
+func (x *Settings) GetVideoUrl() string {
+	if x != nil {
+		return x.VideoUrl
+	}
+	return ""
+}
+
+func (x *Settings) GetSegmentDuration() string {
+	if x != nil {
+		return x.SegmentDuration
+	}
+	return ""
+}
+
+func (x *Settings) GetSegmentType() string {
+	if x != nil {
+		return x.SegmentType
+	}
+	return ""
+}
+
+func (x *Settings) GetPushUrl() string {
+	if x != nil {
+		return x.PushUrl
+	}
+	return ""
+}
+
+func (x *Settings) GetThreads() int32 {
+	if x != nil {
+		return x.Threads
+	}
+	return 0
+}
+
+func (x *Settings) GetGapless() bool {
+	if x != nil {
+		return x.Gapless
+	}
+	return false
+}
+
+func (x *Settings) GetPreset() string {
+	if x != nil {
+		return x.Preset
+	}
+	return ""
+}
+
+func (x *Settings) GetOutputs() []string {
+	if x != nil {
+		return x.Outputs
+	}
+	return nil
+}
+
+func (x *Settings) GetFileName() string {
+	if x != nil {
+		return x.FileName
+	}
+	return ""
+}
+
+func (x *Settings) GetMetadata() []string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *Settings) GetStripMetadata() bool {
+	if x != nil {
+		return x.StripMetadata
+	}
+	return false
+}
+
+func (x *Settings) GetBext() bool {
+	if x != nil {
+		return x.Bext
+	}
+	return false
+}
+
+func (x *Settings) GetChapters() []string {
+	if x != nil {
+		return x.Chapters
+	}
+	return nil
+}
+
+func (x *Settings) GetCuts() []string {
+	if x != nil {
+		return x.Cuts
+	}
+	return nil
+}
+
+func (x *Settings) GetCueSheet() string {
+	if x != nil {
+		return x.CueSheet
+	}
+	return ""
+}
+
+func (x *Settings) GetEncoding() string {
+	if x != nil {
+		return x.Encoding
+	}
+	return ""
+}
+
+func (x *Settings) GetBitRate() int32 {
+	if x != nil {
+		return x.BitRate
+	}
+	return 0
+}
+
+func (x *Settings) GetLowLatency() bool {
+	if x != nil {
+		return x.LowLatency
+	}
+	return false
+}
+
+func (x *Settings) GetRealtime() bool {
+	if x != nil && x.Realtime != nil {
+		return *x.Realtime
+	}
+	return false
+}
+
+func (x *Settings) GetCoverUrl() string {
+	if x != nil {
+		return x.CoverUrl
+	}
+	return ""
+}
+
+type TranscodeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Settings *Settings `protobuf:"bytes,1,opt,name=settings,proto3" json:"settings,omitempty"`
+	// Types that are assignable to Input:
+	//	*TranscodeRequest_Data
+	//	*TranscodeRequest_AudioUrl
+	Input   isTranscodeRequest_Input `protobuf_oneof:"input"`
+	Headers []string                 `protobuf:"bytes,4,rep,name=headers,proto3" json:"headers,omitempty"` // Sent when fetching audio_url, as "Key: Value"
+}
+
+func (x *TranscodeRequest) Reset() {
+	*x = TranscodeRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_transgode_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TranscodeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TranscodeRequest) ProtoMessage() {}
+
+func (x *TranscodeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_transgode_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TranscodeRequest.ProtoReflect.Descriptor instead.
+func (*TranscodeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_transgode_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TranscodeRequest) GetSettings() *Settings {
+	if x != nil {
+		return x.Settings
+	}
+	return nil
+}
+
+func (m *TranscodeRequest) GetInput() isTranscodeRequest_Input {
+	if m != nil {
+		return m.Input
+	}
+	return nil
+}
+
+func (x *TranscodeRequest) GetData() []byte {
+	if x, ok := x.GetInput().(*TranscodeRequest_Data); ok {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *TranscodeRequest) GetAudioUrl() string {
+	if x, ok := x.GetInput().(*TranscodeRequest_AudioUrl); ok {
+		return x.AudioUrl
+	}
+	return ""
+}
+
+func (x *TranscodeRequest) GetHeaders() []string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+type isTranscodeRequest_Input interface {
+	isTranscodeRequest_Input()
+}
+
+type TranscodeRequest_Data struct {
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3,oneof"` // Limited by the maximum gRPC message size
+}
+
+type TranscodeRequest_AudioUrl struct {
+	AudioUrl string `protobuf:"bytes,3,opt,name=audio_url,json=audioUrl,proto3,oneof"` // Restricted by the input policy
+}
+
+func (*TranscodeRequest_Data) isTranscodeRequest_Input() {}
+
+func (*TranscodeRequest_AudioUrl) isTranscodeRequest_Input() {}
+
+type TranscodeResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data        []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	ContentType string `protobuf:"bytes,2,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+}
+
+func (x *TranscodeResponse) Reset() {
+	*x = TranscodeResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_transgode_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TranscodeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TranscodeResponse) ProtoMessage() {}
+
+func (x *TranscodeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_transgode_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TranscodeResponse.ProtoReflect.Descriptor instead.
+func (*TranscodeResponse) Descriptor() ([]byte, []int) {
+	return file_proto_transgode_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TranscodeResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *TranscodeResponse) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+type TranscodeStreamRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Message:
+	//	*TranscodeStreamRequest_Settings
+	//	*TranscodeStreamRequest_Chunk
+	Message isTranscodeStreamRequest_Message `protobuf_oneof:"message"`
+}
+
+func (x *TranscodeStreamRequest) Reset() {
+	*x = TranscodeStreamRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_transgode_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TranscodeStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TranscodeStreamRequest) ProtoMessage() {}
+
+func (x *TranscodeStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_transgode_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TranscodeStreamRequest.ProtoReflect.Descriptor instead.
+func (*TranscodeStreamRequest) Descriptor() ([]byte, []int) {
+	return file_proto_transgode_proto_rawDescGZIP(), []int{3}
+}
+
+func (m *TranscodeStreamRequest) GetMessage() isTranscodeStreamRequest_Message {
+	if m != nil {
+		return m.Message
+	}
+	return nil
+}
+
+func (x *TranscodeStreamRequest) GetSettings() *Settings {
+	if x, ok := x.GetMessage().(*TranscodeStreamRequest_Settings); ok {
+		return x.Settings
+	}
+	return nil
+}
+
+func (x *TranscodeStreamRequest) GetChunk() []byte {
+	if x, ok := x.GetMessage().(*TranscodeStreamRequest_Chunk); ok {
+		return x.Chunk
+	}
+	return nil
+}
+
+type isTranscodeStreamRequest_Message interface {
+	isTranscodeStreamRequest_Message()
+}
+
+type TranscodeStreamRequest_Settings struct {
+	Settings *Settings `protobuf:"bytes,1,opt,name=settings,proto3,oneof"` // First message only
+}
+
+type TranscodeStreamRequest_Chunk struct {
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+func (*TranscodeStreamRequest_Settings) isTranscodeStreamRequest_Message() {}
+
+func (*TranscodeStreamRequest_Chunk) isTranscodeStreamRequest_Message() {}
+
+type TranscodeStreamResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Chunk []byte `protobuf:"bytes,1,opt,name=chunk,proto3" json:"chunk,omitempty"`
+}
+
+func (x *TranscodeStreamResponse) Reset() {
+	*x = TranscodeStreamResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_transgode_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TranscodeStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TranscodeStreamResponse) ProtoMessage() {}
+
+func (x *TranscodeStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_transgode_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TranscodeStreamResponse.ProtoReflect.Descriptor instead.
+func (*TranscodeStreamResponse) Descriptor() ([]byte, []int) {
+	return file_proto_transgode_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TranscodeStreamResponse) GetChunk() []byte {
+	if x != nil {
+		return x.Chunk
+	}
+	return nil
+}
+
+var File_proto_transgode_proto protoreflect.FileDescriptor
+
+var file_proto_transgode_proto_rawDesc = []byte{
+	0x0a, 0x15, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x67, 0x6f, 0x64,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x09, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x67, 0x6f,
+	0x64, 0x65, 0x22, 0xa2, 0x0d, 0x0a, 0x08, 0x53, 0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x12,
+	0x1d, 0x0a, 0x0a, 0x6d, 0x65, 0x64, 0x69, 0x61, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x09, 0x6d, 0x65, 0x64, 0x69, 0x61, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1a,
+	0x0a, 0x08, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x08, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x73, 0x61,
+	0x6d, 0x70, 0x6c, 0x65, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x0a, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x52, 0x61, 0x74, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x74,
+	0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x74, 0x69,
+	0x6d, 0x65, 0x6f, 0x75, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x67, 0x61, 0x69, 0x6e, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x04, 0x67, 0x61, 0x69, 0x6e, 0x12, 0x1c, 0x0a, 0x09, 0x6e, 0x6f, 0x72,
+	0x6d, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6e, 0x6f,
+	0x72, 0x6d, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x12, 0x21, 0x0a, 0x0c, 0x74, 0x72, 0x69, 0x6d, 0x5f,
+	0x73, 0x69, 0x6c, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x74,
+	0x72, 0x69, 0x6d, 0x53, 0x69, 0x6c, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x2b, 0x0a, 0x11, 0x73, 0x69,
+	0x6c, 0x65, 0x6e, 0x63, 0x65, 0x5f, 0x74, 0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x18,
+	0x08, 0x20, 0x01, 0x28, 0x01, 0x52, 0x10, 0x73, 0x69, 0x6c, 0x65, 0x6e, 0x63, 0x65, 0x54, 0x68,
+	0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x12, 0x29, 0x0a, 0x10, 0x73, 0x69, 0x6c, 0x65, 0x6e,
+	0x63, 0x65, 0x5f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x09, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0f, 0x73, 0x69, 0x6c, 0x65, 0x6e, 0x63, 0x65, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x0a, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x75, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x64, 0x75, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x65, 0x6d, 0x70, 0x6f, 0x18, 0x0c, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x05, 0x74, 0x65, 0x6d, 0x70, 0x6f, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x69,
+	0x74, 0x63, 0x68, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x70, 0x69, 0x74, 0x63, 0x68,
+	0x12, 0x17, 0x0a, 0x07, 0x66, 0x61, 0x64, 0x65, 0x5f, 0x69, 0x6e, 0x18, 0x0e, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x66, 0x61, 0x64, 0x65, 0x49, 0x6e, 0x12, 0x19, 0x0a, 0x08, 0x66, 0x61, 0x64,
+	0x65, 0x5f, 0x6f, 0x75, 0x74, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x66, 0x61, 0x64,
+	0x65, 0x4f, 0x75, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x18, 0x10,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x1f, 0x0a, 0x0b,
+	0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x5f, 0x6d, 0x6f, 0x64, 0x65, 0x18, 0x11, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x4d, 0x6f, 0x64, 0x65, 0x12, 0x1c, 0x0a,
+	0x09, 0x72, 0x65, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x72, 0x18, 0x12, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x09, 0x72, 0x65, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x72, 0x12, 0x1c, 0x0a, 0x09, 0x70,
+	0x72, 0x65, 0x63, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x13, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09,
+	0x70, 0x72, 0x65, 0x63, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x69, 0x74,
+	0x68, 0x65, 0x72, 0x18, 0x14, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64, 0x69, 0x74, 0x68, 0x65,
+	0x72, 0x12, 0x1b, 0x0a, 0x09, 0x68, 0x69, 0x67, 0x68, 0x5f, 0x70, 0x61, 0x73, 0x73, 0x18, 0x15,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x68, 0x69, 0x67, 0x68, 0x50, 0x61, 0x73, 0x73, 0x12, 0x19,
+	0x0a, 0x08, 0x6c, 0x6f, 0x77, 0x5f, 0x70, 0x61, 0x73, 0x73, 0x18, 0x16, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x07, 0x6c, 0x6f, 0x77, 0x50, 0x61, 0x73, 0x73, 0x12, 0x0e, 0x0a, 0x02, 0x65, 0x71, 0x18,
+	0x17, 0x20, 0x03, 0x28, 0x09, 0x52, 0x02, 0x65, 0x71, 0x12, 0x18, 0x0a, 0x07, 0x64, 0x65, 0x6e,
+	0x6f, 0x69, 0x73, 0x65, 0x18, 0x18, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x64, 0x65, 0x6e, 0x6f,
+	0x69, 0x73, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x5f, 0x6d,
+	0x61, 0x70, 0x18, 0x19, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65,
+	0x6c, 0x4d, 0x61, 0x70, 0x12, 0x26, 0x0a, 0x0c, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x5f, 0x69,
+	0x6e, 0x64, 0x65, 0x78, 0x18, 0x1a, 0x20, 0x01, 0x28, 0x05, 0x48, 0x00, 0x52, 0x0b, 0x73, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x88, 0x01, 0x01, 0x12, 0x1a, 0x0a, 0x08,
+	0x6c, 0x61, 0x6e, 0x67, 0x75, 0x61, 0x67, 0x65, 0x18, 0x1b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x6c, 0x61, 0x6e, 0x67, 0x75, 0x61, 0x67, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x6f, 0x6e, 0x63,
+	0x61, 0x74, 0x18, 0x1c, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x63, 0x6f, 0x6e, 0x63, 0x61, 0x74,
+	0x12, 0x1c, 0x0a, 0x09, 0x63, 0x72, 0x6f, 0x73, 0x73, 0x66, 0x61, 0x64, 0x65, 0x18, 0x1d, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x72, 0x6f, 0x73, 0x73, 0x66, 0x61, 0x64, 0x65, 0x12, 0x10,
+	0x0a, 0x03, 0x67, 0x61, 0x70, 0x18, 0x1e, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x67, 0x61, 0x70,
+	0x12, 0x10, 0x0a, 0x03, 0x6d, 0x69, 0x78, 0x18, 0x1f, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x6d,
+	0x69, 0x78, 0x12, 0x19, 0x0a, 0x08, 0x6d, 0x69, 0x78, 0x5f, 0x67, 0x61, 0x69, 0x6e, 0x18, 0x20,
+	0x20, 0x03, 0x28, 0x01, 0x52, 0x07, 0x6d, 0x69, 0x78, 0x47, 0x61, 0x69, 0x6e, 0x12, 0x12, 0x0a,
+	0x04, 0x64, 0x75, 0x63, 0x6b, 0x18, 0x21, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x64, 0x75, 0x63,
+	0x6b, 0x12, 0x1b, 0x0a, 0x09, 0x70, 0x61, 0x64, 0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x22,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x70, 0x61, 0x64, 0x53, 0x74, 0x61, 0x72, 0x74, 0x12, 0x17,
+	0x0a, 0x07, 0x70, 0x61, 0x64, 0x5f, 0x65, 0x6e, 0x64, 0x18, 0x23, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x06, 0x70, 0x61, 0x64, 0x45, 0x6e, 0x64, 0x12, 0x27, 0x0a, 0x0f, 0x74, 0x61, 0x72, 0x67, 0x65,
+	0x74, 0x5f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x24, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0e, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x1f, 0x0a, 0x0b, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x5f, 0x6d, 0x6f, 0x64, 0x65, 0x18,
+	0x25, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x4d, 0x6f, 0x64,
+	0x65, 0x12, 0x23, 0x0a, 0x0d, 0x65, 0x78, 0x74, 0x72, 0x61, 0x63, 0x74, 0x5f, 0x61, 0x75, 0x64,
+	0x69, 0x6f, 0x18, 0x26, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x65, 0x78, 0x74, 0x72, 0x61, 0x63,
+	0x74, 0x41, 0x75, 0x64, 0x69, 0x6f, 0x12, 0x1b, 0x0a, 0x09, 0x76, 0x69, 0x64, 0x65, 0x6f, 0x5f,
+	0x75, 0x72, 0x6c, 0x18, 0x27, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x76, 0x69, 0x64, 0x65, 0x6f,
+	0x55, 0x72, 0x6c, 0x12, 0x29, 0x0a, 0x10, 0x73, 0x65, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x5f, 0x64,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x28, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x73,
+	0x65, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x21,
+	0x0a, 0x0c, 0x73, 0x65, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x29,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x73, 0x65, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x54, 0x79, 0x70,
+	0x65, 0x12, 0x19, 0x0a, 0x08, 0x70, 0x75, 0x73, 0x68, 0x5f, 0x75, 0x72, 0x6c, 0x18, 0x2a, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x70, 0x75, 0x73, 0x68, 0x55, 0x72, 0x6c, 0x12, 0x18, 0x0a, 0x07,
+	0x74, 0x68, 0x72, 0x65, 0x61, 0x64, 0x73, 0x18, 0x2b, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x74,
+	0x68, 0x72, 0x65, 0x61, 0x64, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x67, 0x61, 0x70, 0x6c, 0x65, 0x73,
+	0x73, 0x18, 0x2c, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x67, 0x61, 0x70, 0x6c, 0x65, 0x73, 0x73,
+	0x12, 0x16, 0x0a, 0x06, 0x70, 0x72, 0x65, 0x73, 0x65, 0x74, 0x18, 0x2d, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x70, 0x72, 0x65, 0x73, 0x65, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x6f, 0x75, 0x74, 0x70,
+	0x75, 0x74, 0x73, 0x18, 0x2e, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x6f, 0x75, 0x74, 0x70, 0x75,
+	0x74, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18,
+	0x2f, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x12,
+	0x1a, 0x0a, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x18, 0x30, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x12, 0x25, 0x0a, 0x0e, 0x73,
+	0x74, 0x72, 0x69, 0x70, 0x5f, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x18, 0x31, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x0d, 0x73, 0x74, 0x72, 0x69, 0x70, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61,
+	0x74, 0x61, 0x12, 0x12, 0x0a, 0x04, 0x62, 0x65, 0x78, 0x74, 0x18, 0x32, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x04, 0x62, 0x65, 0x78, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x68, 0x61, 0x70, 0x74, 0x65,
+	0x72, 0x73, 0x18, 0x33, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x63, 0x68, 0x61, 0x70, 0x74, 0x65,
+	0x72, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x63, 0x75, 0x74, 0x73, 0x18, 0x34, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x04, 0x63, 0x75, 0x74, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x63, 0x75, 0x65, 0x5f, 0x73, 0x68,
+	0x65, 0x65, 0x74, 0x18, 0x35, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x75, 0x65, 0x53, 0x68,
+	0x65, 0x65, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x65, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x18,
+	0x36, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x65, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x12,
+	0x19, 0x0a, 0x08, 0x62, 0x69, 0x74, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18, 0x37, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x07, 0x62, 0x69, 0x74, 0x52, 0x61, 0x74, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x6c, 0x6f,
+	0x77, 0x5f, 0x6c, 0x61, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x38, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x0a, 0x6c, 0x6f, 0x77, 0x4c, 0x61, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x12, 0x1f, 0x0a, 0x08, 0x72,
+	0x65, 0x61, 0x6c, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x39, 0x20, 0x01, 0x28, 0x08, 0x48, 0x01, 0x52,
+	0x08, 0x72, 0x65, 0x61, 0x6c, 0x74, 0x69, 0x6d, 0x65, 0x88, 0x01, 0x01, 0x12, 0x1b, 0x0a, 0x09,
+	0x63, 0x6f, 0x76, 0x65, 0x72, 0x5f, 0x75, 0x72, 0x6c, 0x18, 0x3a, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x08, 0x63, 0x6f, 0x76, 0x65, 0x72, 0x55, 0x72, 0x6c, 0x42, 0x0f, 0x0a, 0x0d, 0x5f, 0x73, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x72,
+	0x65, 0x61, 0x6c, 0x74, 0x69, 0x6d, 0x65, 0x22, 0x9b, 0x01, 0x0a, 0x10, 0x54, 0x72, 0x61, 0x6e,
+	0x73, 0x63, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x2f, 0x0a, 0x08,
+	0x73, 0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13,
+	0x2e, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x67, 0x6f, 0x64, 0x65, 0x2e, 0x53, 0x65, 0x74, 0x74, 0x69,
+	0x6e, 0x67, 0x73, 0x52, 0x08, 0x73, 0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x12, 0x14, 0x0a,
+	0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x04, 0x64,
+	0x61, 0x74, 0x61, 0x12, 0x1d, 0x0a, 0x09, 0x61, 0x75, 0x64, 0x69, 0x6f, 0x5f, 0x75, 0x72, 0x6c,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x08, 0x61, 0x75, 0x64, 0x69, 0x6f, 0x55,
+	0x72, 0x6c, 0x12, 0x18, 0x0a, 0x07, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x18, 0x04, 0x20,
+	0x03, 0x28, 0x09, 0x52, 0x07, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x42, 0x07, 0x0a, 0x05,
+	0x69, 0x6e, 0x70, 0x75, 0x74, 0x22, 0x4a, 0x0a, 0x11, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x63, 0x6f,
+	0x64, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61,
+	0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x21,
+	0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x54, 0x79, 0x70,
+	0x65, 0x22, 0x6e, 0x0a, 0x16, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x63, 0x6f, 0x64, 0x65, 0x53, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x31, 0x0a, 0x08, 0x73,
+	0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e,
+	0x74, 0x72, 0x61, 0x6e, 0x73, 0x67, 0x6f, 0x64, 0x65, 0x2e, 0x53, 0x65, 0x74, 0x74, 0x69, 0x6e,
+	0x67, 0x73, 0x48, 0x00, 0x52, 0x08, 0x73, 0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x12, 0x16,
+	0x0a, 0x05, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52,
+	0x05, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x42, 0x09, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67,
+	0x65, 0x22, 0x2f, 0x0a, 0x17, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x63, 0x6f, 0x64, 0x65, 0x53, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05,
+	0x63, 0x68, 0x75, 0x6e, 0x6b, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x63, 0x68, 0x75,
+	0x6e, 0x6b, 0x32, 0xb2, 0x01, 0x0a, 0x0a, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x63, 0x6f, 0x64, 0x65,
+	0x72, 0x12, 0x46, 0x0a, 0x09, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x63, 0x6f, 0x64, 0x65, 0x12, 0x1b,
+	0x2e, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x67, 0x6f, 0x64, 0x65, 0x2e, 0x54, 0x72, 0x61, 0x6e, 0x73,
+	0x63, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x74, 0x72,
+	0x61, 0x6e, 0x73, 0x67, 0x6f, 0x64, 0x65, 0x2e, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x63, 0x6f, 0x64,
+	0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x5c, 0x0a, 0x0f, 0x54, 0x72, 0x61,
+	0x6e, 0x73, 0x63, 0x6f, 0x64, 0x65, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x21, 0x2e, 0x74,
+	0x72, 0x61, 0x6e, 0x73, 0x67, 0x6f, 0x64, 0x65, 0x2e, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x63, 0x6f,
+	0x64, 0x65, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x22, 0x2e, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x67, 0x6f, 0x64, 0x65, 0x2e, 0x54, 0x72, 0x61, 0x6e,
+	0x73, 0x63, 0x6f, 0x64, 0x65, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x28, 0x01, 0x30, 0x01, 0x42, 0x21, 0x5a, 0x1f, 0x65, 0x78, 0x61, 0x6d, 0x70,
+	0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6d, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x3b, 0x74,
+	0x72, 0x61, 0x6e, 0x73, 0x67, 0x6f, 0x64, 0x65, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
+}
+
+var (
+	file_proto_transgode_proto_rawDescOnce sync.Once
+	file_proto_transgode_proto_rawDescData = file_proto_transgode_proto_rawDesc
+)
+
+func file_proto_transgode_proto_rawDescGZIP() []byte {
+	file_proto_transgode_proto_rawDescOnce.Do(func() {
+		file_proto_transgode_proto_rawDescData = protoimpl.X.CompressGZIP(file_proto_transgode_proto_rawDescData)
+	})
+	return file_proto_transgode_proto_rawDescData
+}
+
+var file_proto_transgode_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_proto_transgode_proto_goTypes = []interface{}{
+	(*Settings)(nil),                // 0: transgode.Settings
+	(*TranscodeRequest)(nil),        // 1: transgode.TranscodeRequest
+	(*TranscodeResponse)(nil),       // 2: transgode.TranscodeResponse
+	(*TranscodeStreamRequest)(nil),  // 3: transgode.TranscodeStreamRequest
+	(*TranscodeStreamResponse)(nil), // 4: transgode.TranscodeStreamResponse
+}
+var file_proto_transgode_proto_depIdxs = []int32{
+	0, // 0: transgode.TranscodeRequest.settings:type_name -> transgode.Settings
+	0, // 1: transgode.TranscodeStreamRequest.settings:type_name -> transgode.Settings
+	1, // 2: transgode.Transcoder.Transcode:input_type -> transgode.TranscodeRequest
+	3, // 3: transgode.Transcoder.TranscodeStream:input_type -> transgode.TranscodeStreamRequest
+	2, // 4: transgode.Transcoder.Transcode:output_type -> transgode.TranscodeResponse
+	4, // 5: transgode.Transcoder.TranscodeStream:output_type -> transgode.TranscodeStreamResponse
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_proto_transgode_proto_init() }
+func file_proto_transgode_proto_init() {
+	if File_proto_transgode_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_proto_transgode_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Settings); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_transgode_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TranscodeRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_transgode_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TranscodeResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_transgode_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TranscodeStreamRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_transgode_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TranscodeStreamResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_proto_transgode_proto_msgTypes[0].OneofWrappers = []interface{}{}
+	file_proto_transgode_proto_msgTypes[1].OneofWrappers = []interface{}{
+		(*TranscodeRequest_Data)(nil),
+		(*TranscodeRequest_AudioUrl)(nil),
+	}
+	file_proto_transgode_proto_msgTypes[3].OneofWrappers = []interface{}{
+		(*TranscodeStreamRequest_Settings)(nil),
+		(*TranscodeStreamRequest_Chunk)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_proto_transgode_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_transgode_proto_goTypes,
+		DependencyIndexes: file_proto_transgode_proto_depIdxs,
+		MessageInfos:      file_proto_transgode_proto_msgTypes,
+	}.Build()
+	File_proto_transgode_proto = out.File
+	file_proto_transgode_proto_rawDesc = nil
+	file_proto_transgode_proto_goTypes = nil
+	file_proto_transgode_proto_depIdxs = nil
+}
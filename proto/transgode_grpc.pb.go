@@ -0,0 +1,201 @@
+// Transcode service exposing the pipeline package over gRPC, served on
+// TRANSGODE_GRPC_ADDRESS by grpc.go.
+//
+// Go code is generated in this directory by make proto, with protoc-gen-go
+// v1.30.0 and protoc-gen-go-grpc v1.3.0 to match the vendored modules:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//		--go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//		proto/transgode.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: proto/transgode.proto
+
+package transgodepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Transcoder_Transcode_FullMethodName       = "/transgode.Transcoder/Transcode"
+	Transcoder_TranscodeStream_FullMethodName = "/transgode.Transcoder/TranscodeStream"
+)
+
+// TranscoderClient is the client API for Transcoder service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TranscoderClient interface {
+	// Transcode transcodes a small input sent in a single message, or fetched
+	// from audio_url, and returns the whole output
+	Transcode(ctx context.Context, in *TranscodeRequest, opts ...grpc.CallOption) (*TranscodeResponse, error)
+	// TranscodeStream transcodes an input sent in chunks: the first message
+	// holds the settings, the following ones the input chunks, and the client
+	// half-closes the stream once the input is sent. Output chunks are sent
+	// back as soon as they are encoded.
+	TranscodeStream(ctx context.Context, opts ...grpc.CallOption) (Transcoder_TranscodeStreamClient, error)
+}
+
+type transcoderClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTranscoderClient(cc grpc.ClientConnInterface) TranscoderClient {
+	return &transcoderClient{cc}
+}
+
+func (c *transcoderClient) Transcode(ctx context.Context, in *TranscodeRequest, opts ...grpc.CallOption) (*TranscodeResponse, error) {
+	out := new(TranscodeResponse)
+	err := c.cc.Invoke(ctx, Transcoder_Transcode_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transcoderClient) TranscodeStream(ctx context.Context, opts ...grpc.CallOption) (Transcoder_TranscodeStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Transcoder_ServiceDesc.Streams[0], Transcoder_TranscodeStream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &transcoderTranscodeStreamClient{stream}
+	return x, nil
+}
+
+type Transcoder_TranscodeStreamClient interface {
+	Send(*TranscodeStreamRequest) error
+	Recv() (*TranscodeStreamResponse, error)
+	grpc.ClientStream
+}
+
+type transcoderTranscodeStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *transcoderTranscodeStreamClient) Send(m *TranscodeStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *transcoderTranscodeStreamClient) Recv() (*TranscodeStreamResponse, error) {
+	m := new(TranscodeStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TranscoderServer is the server API for Transcoder service.
+// All implementations must embed UnimplementedTranscoderServer
+// for forward compatibility
+type TranscoderServer interface {
+	// Transcode transcodes a small input sent in a single message, or fetched
+	// from audio_url, and returns the whole output
+	Transcode(context.Context, *TranscodeRequest) (*TranscodeResponse, error)
+	// TranscodeStream transcodes an input sent in chunks: the first message
+	// holds the settings, the following ones the input chunks, and the client
+	// half-closes the stream once the input is sent. Output chunks are sent
+	// back as soon as they are encoded.
+	TranscodeStream(Transcoder_TranscodeStreamServer) error
+	mustEmbedUnimplementedTranscoderServer()
+}
+
+// UnimplementedTranscoderServer must be embedded to have forward compatible implementations.
+type UnimplementedTranscoderServer struct {
+}
+
+func (UnimplementedTranscoderServer) Transcode(context.Context, *TranscodeRequest) (*TranscodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Transcode not implemented")
+}
+func (UnimplementedTranscoderServer) TranscodeStream(Transcoder_TranscodeStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method TranscodeStream not implemented")
+}
+func (UnimplementedTranscoderServer) mustEmbedUnimplementedTranscoderServer() {}
+
+// UnsafeTranscoderServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TranscoderServer will
+// result in compilation errors.
+type UnsafeTranscoderServer interface {
+	mustEmbedUnimplementedTranscoderServer()
+}
+
+func RegisterTranscoderServer(s grpc.ServiceRegistrar, srv TranscoderServer) {
+	s.RegisterService(&Transcoder_ServiceDesc, srv)
+}
+
+func _Transcoder_Transcode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TranscodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TranscoderServer).Transcode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Transcoder_Transcode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TranscoderServer).Transcode(ctx, req.(*TranscodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Transcoder_TranscodeStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TranscoderServer).TranscodeStream(&transcoderTranscodeStreamServer{stream})
+}
+
+type Transcoder_TranscodeStreamServer interface {
+	Send(*TranscodeStreamResponse) error
+	Recv() (*TranscodeStreamRequest, error)
+	grpc.ServerStream
+}
+
+type transcoderTranscodeStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *transcoderTranscodeStreamServer) Send(m *TranscodeStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *transcoderTranscodeStreamServer) Recv() (*TranscodeStreamRequest, error) {
+	m := new(TranscodeStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Transcoder_ServiceDesc is the grpc.ServiceDesc for Transcoder service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Transcoder_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "transgode.Transcoder",
+	HandlerType: (*TranscoderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Transcode",
+			Handler:    _Transcoder_Transcode_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "TranscodeStream",
+			Handler:       _Transcoder_TranscodeStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/transgode.proto",
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var pushHosts []string // Allowed push destinations as host or host:port, empty disables pushes
+
+// Push connection settings
+const (
+	defaultIcecastPort = "8000"
+	pushDialTimeout    = 10 * time.Second
+)
+
+var errPushFailed = errors.New("main: pushing output failed")
+
+// pushFormat is the muxer and encoder of the outputs pushed to a scheme
+type pushFormat struct {
+	codec  string
+	format string
+}
+
+// pushFormats are the push url schemes FFmpeg writes to itself, as live
+// contribution feeds whose format doesn't depend on the media type: MPEG-TS
+// carrying AAC, in RTP packets for rtp
+var pushFormats = map[string]pushFormat{
+	"rtp": {codec: "aac", format: "rtp_mpegts"},
+	"srt": {codec: "aac", format: "mpegts"},
+}
+
+// parsePushURL parses an icecast://, http://, https://, rtp:// or srt:// push
+// url and checks its host is allowed
+func parsePushURL(raw string) (u *url.URL, err error) {
+	// Parse
+	if u, err = url.Parse(raw); err != nil {
+		err = fmt.Errorf("main: parsing push url failed: %w", err)
+		return
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	_, ffmpeg := pushFormats[u.Scheme]
+	if u.Scheme != "icecast" && u.Scheme != "http" && u.Scheme != "https" && !ffmpeg {
+		err = fmt.Errorf("main: push url scheme not supported: %s", u.Scheme)
+		return
+	} else if u.Hostname() == "" {
+		err = fmt.Errorf("main: push url has no host: %s", u.Redacted())
+		return
+	} else if ffmpeg && u.Port() == "" {
+		err = fmt.Errorf("main: push url has no port: %s", u.Redacted())
+		return
+	}
+
+	// Check host is allowed
+	for _, h := range pushHosts {
+		if strings.EqualFold(h, u.Host) || strings.EqualFold(h, u.Hostname()) {
+			return
+		}
+	}
+	err = fmt.Errorf("main: push url not allowed: %s", u.Host)
+	return
+}
+
+// pusher pushes what FFmpeg writes in a pipe to an Icecast mount or an HTTP
+// endpoint while the output is being transcoded
+type pusher struct {
+	body *pushBody
+	done chan struct{}
+	err  error // Set once done is closed
+}
+
+// pushBody reads the pipe and remembers whether it was closed with an error,
+// in which case the push was aborted rather than failed by itself
+type pushBody struct {
+	*outputPipe
+	err error
+}
+
+// Read implements io.Reader
+func (b *pushBody) Read(p []byte) (n int, err error) {
+	if n, err = b.outputPipe.Read(p); err != nil && err != io.EOF && err != io.ErrClosedPipe {
+		b.err = err
+	}
+	return
+}
+
+// startPush starts pushing the output written in the pipe of the pusher to u
+// until ctx is done
+func startPush(ctx context.Context, u *url.URL, contentType string) (ps *pusher, err error) {
+	// Create pipe
+	ps = &pusher{done: make(chan struct{})}
+	var p *outputPipe
+	if p, err = newOutputPipe(); err != nil {
+		err = fmt.Errorf("main: creating push pipe failed: %w", err)
+		return
+	}
+	ps.body = &pushBody{outputPipe: p}
+
+	// Push in the background, FFmpeg writes start failing if it stops early
+	go func() {
+		defer close(ps.done)
+		var err error
+		if u.Scheme == "icecast" {
+			err = pushIcecast(ctx, u, contentType, ps.body)
+		} else {
+			err = pushHTTP(ctx, u, contentType, ps.body)
+		}
+		if err != nil {
+			ps.err = fmt.Errorf("%w: %v", errPushFailed, err)
+		}
+		p.Close()
+	}()
+	return
+}
+
+// url returns the url FFmpeg can open to write the pushed output
+func (ps *pusher) url() string {
+	return ps.body.url()
+}
+
+// finish must be called once FFmpeg is done writing, a non nil err aborts
+// the push. It returns the error of the push unless it was aborted.
+func (ps *pusher) finish(err error) error {
+	ps.body.closeWrite(err)
+	<-ps.done
+	if ps.body.err != nil {
+		return nil
+	}
+	return ps.err
+}
+
+// pushHTTP pushes body to an HTTP endpoint in a PUT request sent with chunked
+// transfer encoding. Credentials in the url are sent with basic auth.
+func pushHTTP(ctx context.Context, u *url.URL, contentType string, body io.Reader) (err error) {
+	// Create request
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPut, u.String(), body); err != nil {
+		err = fmt.Errorf("creating request failed: %w", err)
+		return
+	}
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", contentType)
+
+	// Send request
+	var res *http.Response
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		err = fmt.Errorf("sending request failed: %w", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		err = fmt.Errorf("endpoint responded %d: %s", res.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return
+}
+
+// pushIcecast pushes body to an Icecast mount as a source client: the PUT
+// request of Icecast 2.4 is sent without a length, then body is streamed
+// on the connection until it ends. Credentials in the url are sent with
+// basic auth, the user defaults to source.
+func pushIcecast(ctx context.Context, u *url.URL, contentType string, body io.Reader) (err error) {
+	// Connect
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), defaultIcecastPort)
+	}
+	d := net.Dialer{Timeout: pushDialTimeout}
+	var conn net.Conn
+	if conn, err = d.DialContext(ctx, "tcp", addr); err != nil {
+		err = fmt.Errorf("connecting failed: %w", err)
+		return
+	}
+	defer conn.Close()
+
+	// Close the connection when the context is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	// Send request
+	w := bufio.NewWriter(conn)
+	mount := u.EscapedPath()
+	if mount == "" {
+		mount = "/"
+	}
+	fmt.Fprintf(w, "PUT %s HTTP/1.1\r\nHost: %s\r\n", mount, u.Host)
+	if u.User != nil {
+		user := u.User.Username()
+		if user == "" {
+			user = "source"
+		}
+		password, _ := u.User.Password()
+		fmt.Fprintf(w, "Authorization: Basic %s\r\n", base64.StdEncoding.EncodeToString([]byte(user+":"+password)))
+	}
+	fmt.Fprintf(w, "Content-Type: %s\r\nIce-Public: 0\r\nUser-Agent: transgode\r\n\r\n", contentType)
+	if err = w.Flush(); err != nil {
+		err = fmt.Errorf("sending request failed: %w", err)
+		return
+	}
+
+	// Check response
+	var res *http.Response
+	if res, err = http.ReadResponse(bufio.NewReader(conn), nil); err != nil {
+		err = fmt.Errorf("reading response failed: %w", err)
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("icecast responded %s", res.Status)
+		return
+	}
+
+	// Stream
+	if _, err = io.Copy(conn, body); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		err = fmt.Errorf("streaming failed: %w", err)
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// quota limits the decoded audio duration per UTC day. Usage is tracked in
+// memory by each instance.
+type quota struct {
+	day   string // UTC day usage is counted for
+	limit time.Duration
+	m     *sync.Mutex
+	used  time.Duration
+}
+
+func newQuota(limit time.Duration) *quota {
+	return &quota{
+		limit: limit,
+		m:     &sync.Mutex{},
+	}
+}
+
+// resetLocked starts counting from zero on a new day, the lock must be held
+func (q *quota) resetLocked() {
+	if d := time.Now().UTC().Format("2006-01-02"); d != q.day {
+		q.day = d
+		q.used = 0
+	}
+}
+
+// add counts decoded audio in the quota
+func (q *quota) add(d time.Duration) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.resetLocked()
+	q.used += d
+}
+
+// remaining returns the decoded audio duration left today
+func (q *quota) remaining() time.Duration {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.resetLocked()
+	return q.limit - q.used
+}
+
+// usage returns the decoded audio duration used today
+func (q *quota) usage() time.Duration {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.resetLocked()
+	return q.used
+}
+
+// nextQuotaReset returns when quotas are reset
+func nextQuotaReset(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
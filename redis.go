@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisDequeueTimeout    = 5 * time.Second
+	redisHeartbeatInterval = 10 * time.Second
+	redisInstanceTTL       = 30 * time.Second // Instances which didn't send a heartbeat since are dead
+	redisKeyPrefix         = "transgode:"
+	redisTimeout           = 10 * time.Second
+)
+
+// redisJobBackend stores jobs in redis so that they survive restarts and are
+// distributed among all instances sharing it. Dequeued jobs are moved to a
+// list per instance until they are done, so that jobs interrupted by a
+// restart are queued again. Instances send heartbeats, and the jobs of those
+// which stopped sending them, because they died or were renamed, are queued
+// again by the others.
+type redisJobBackend struct {
+	c *redis.Client
+}
+
+// newRedisJobBackend creates a backend from a
+// redis://[user:password@]host:port[/db] url, rediss:// connects with TLS
+func newRedisJobBackend(rawURL string) (b *redisJobBackend, err error) {
+	// Create client
+	var o *redis.Options
+	if o, err = redis.ParseURL(rawURL); err != nil {
+		err = fmt.Errorf("main: parsing redis url failed: %w", err)
+		return
+	}
+	o.DialTimeout = redisTimeout
+	o.ReadTimeout = redisTimeout
+	o.WriteTimeout = redisTimeout
+	b = &redisJobBackend{c: redis.NewClient(o)}
+
+	// Send a first heartbeat so that other instances don't take this one as
+	// dead
+	ctx := context.Background()
+	if err = b.heartbeat(ctx); err != nil {
+		err = fmt.Errorf("main: sending redis heartbeat failed: %w", err)
+		return
+	}
+
+	// Queue again jobs interrupted by a restart of this instance
+	if _, err = b.requeue(ctx, jobInstance); err != nil {
+		err = fmt.Errorf("main: requeuing interrupted jobs failed: %w", err)
+		return
+	}
+	return
+}
+
+// start sends heartbeats and queues again the jobs of dead instances in the
+// background
+func (b *redisJobBackend) start() {
+	go func() {
+		ctx := context.Background()
+		for range time.Tick(redisHeartbeatInterval) {
+			if err := b.heartbeat(ctx); err != nil {
+				rootLogger.error("main: sending redis heartbeat failed", "error", err)
+			}
+			if err := b.reap(ctx); err != nil {
+				rootLogger.error("main: requeuing jobs of dead instances failed", "error", err)
+			}
+		}
+	}()
+}
+
+// heartbeat marks this instance as alive for the instance ttl
+func (b *redisJobBackend) heartbeat(ctx context.Context) error {
+	_, err := b.c.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, b.instanceKey(jobInstance), "1", redisInstanceTTL)
+		p.SAdd(ctx, b.instancesKey(), jobInstance)
+		return nil
+	})
+	return err
+}
+
+// reap queues again the jobs of the instances whose heartbeat expired
+func (b *redisJobBackend) reap(ctx context.Context) error {
+	names, err := b.c.SMembers(ctx, b.instancesKey()).Result()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		// Check heartbeat
+		if name == jobInstance {
+			continue
+		}
+		var n int64
+		if n, err = b.c.Exists(ctx, b.instanceKey(name)).Result(); err != nil {
+			return err
+		} else if n > 0 {
+			continue
+		}
+
+		// Queue jobs again, then forget the instance. It's added again by its
+		// next heartbeat if it was only late.
+		if n, err = b.requeue(ctx, name); err != nil {
+			return err
+		} else if n > 0 {
+			rootLogger.warn("main: requeued jobs of dead instance", "instance", name, "jobs", n)
+		}
+		if err = b.c.SRem(ctx, b.instancesKey(), name).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requeue moves the jobs dequeued by an instance back to the queue
+func (b *redisJobBackend) requeue(ctx context.Context, instance string) (n int64, err error) {
+	for {
+		if err = b.c.RPopLPush(ctx, b.processingKey(instance), b.queueKey()).Err(); errors.Is(err, redis.Nil) {
+			return n, nil
+		} else if err != nil {
+			return
+		}
+		n++
+	}
+}
+
+func (b *redisJobBackend) canceledKey(id string) string {
+	return redisKeyPrefix + "job:" + id + ":canceled"
+}
+
+func (b *redisJobBackend) instanceKey(instance string) string {
+	return redisKeyPrefix + "instance:" + instance
+}
+
+func (b *redisJobBackend) instancesKey() string {
+	return redisKeyPrefix + "instances"
+}
+
+func (b *redisJobBackend) jobKey(id string) string {
+	return redisKeyPrefix + "job:" + id
+}
+
+func (b *redisJobBackend) processingKey(instance string) string {
+	return redisKeyPrefix + "jobs:" + instance
+}
+
+func (b *redisJobBackend) queueKey() string {
+	return redisKeyPrefix + "jobs"
+}
+
+func (b *redisJobBackend) cancel(id string) error {
+	return b.c.Set(context.Background(), b.canceledKey(id), "1", 0).Err()
+}
+
+func (b *redisJobBackend) canceled(id string) (bool, error) {
+	n, err := b.c.Exists(context.Background(), b.canceledKey(id)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (b *redisJobBackend) claim(r *jobRecord) (err error) {
+	if err = b.save(r); err != nil {
+		return
+	}
+	return b.c.LPush(context.Background(), b.processingKey(jobInstance), r.ID).Err()
+}
+
+func (b *redisJobBackend) dequeue() (string, error) {
+	id, err := b.c.BRPopLPush(context.Background(), b.queueKey(), b.processingKey(jobInstance), redisDequeueTimeout).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return id, err
+}
+
+func (b *redisJobBackend) done(id string) (err error) {
+	ctx := context.Background()
+	if err = b.c.LRem(ctx, b.processingKey(jobInstance), 1, id).Err(); err != nil {
+		return
+	}
+
+	// The cancellation flag is only useful until the job is done
+	return b.c.Expire(ctx, b.canceledKey(id), jobRetention).Err()
+}
+
+func (b *redisJobBackend) enqueue(r *jobRecord) (err error) {
+	// Check queue size
+	ctx := context.Background()
+	var n int64
+	if n, err = b.c.LLen(ctx, b.queueKey()).Result(); err != nil {
+		return
+	} else if n >= int64(jobQueueSize) {
+		return errJobQueueFull
+	}
+
+	// Store job
+	if err = b.save(r); err != nil {
+		return
+	}
+
+	// Queue job
+	return b.c.LPush(ctx, b.queueKey(), r.ID).Err()
+}
+
+func (b *redisJobBackend) load(id string) (*jobRecord, error) {
+	v, err := b.c.Get(context.Background(), b.jobKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	r := &jobRecord{}
+	if err = json.Unmarshal(v, r); err != nil {
+		return nil, fmt.Errorf("main: unmarshaling job failed: %w", err)
+	}
+	return r, nil
+}
+
+func (b *redisJobBackend) remove(id string) error {
+	return b.c.Del(context.Background(), b.jobKey(id), b.canceledKey(id)).Err()
+}
+
+func (b *redisJobBackend) save(r *jobRecord) error {
+	// Marshal
+	v, err := json.Marshal(r.stored())
+	if err != nil {
+		return fmt.Errorf("main: marshaling job failed: %w", err)
+	}
+
+	// Finished jobs expire after the retention
+	var expiration time.Duration
+	if r.finished() {
+		expiration = jobRetention
+	}
+	return b.c.Set(context.Background(), b.jobKey(r.ID), v, expiration).Err()
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	minFreeDisk     int64 // 0 disables the check
+	resultDir       string
+	resultRetention time.Duration // 0 disables storing synchronous outputs
+)
+
+var (
+	errInsufficientStorage = errors.New("main: insufficient free disk space")
+	errResultNotFound      = errors.New("main: result not found")
+)
+
+// result is an output stored on disk
+type result struct {
+	checksum   string // Hex SHA-256 of the output
+	etag       string
+	expiresAt  time.Time
+	id         string
+	info       outputInfo // Sent in the headers along with the output
+	modifiedAt time.Time  // Commit time, at a second precision as sent in Last-Modified
+	path       string
+	size       int64
+}
+
+// resultStore keeps outputs until they expire so that they can be
+// downloaded again
+type resultStore struct {
+	m       *sync.Mutex
+	results map[string]*result
+}
+
+func newResultStore() (s *resultStore, err error) {
+	// Create directory
+	if err = os.MkdirAll(resultDir, 0700); err != nil {
+		err = fmt.Errorf("main: creating result directory failed: %w", err)
+		return
+	}
+	s = &resultStore{
+		m:       &sync.Mutex{},
+		results: make(map[string]*result),
+	}
+	return
+}
+
+// start starts the janitor removing expired results
+func (s *resultStore) start() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			s.removeExpired()
+		}
+	}()
+}
+
+// create creates the empty output file of a result, which must then be either
+// committed or discarded. It fails with errInsufficientStorage when the free
+// space of the result directory is below minFreeDisk.
+func (s *resultStore) create(id, mediaType string) (r *result, err error) {
+	// Check free space
+	if err = checkFreeDisk(); err != nil {
+		return
+	}
+
+	r = &result{
+		id:   id,
+		info: outputInfo{contentType: outputContentType(mediaType)},
+		path: filepath.Join(resultDir, fmt.Sprintf("%s.%s", id, mediaType)),
+	}
+	var f *os.File
+	if f, err = os.OpenFile(r.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600); err != nil {
+		err = fmt.Errorf("main: creating output file failed: %w", err)
+		return
+	}
+	f.Close()
+	return
+}
+
+// commit makes a fully written result available until the ttl expires
+func (s *resultStore) commit(r *result, ttl time.Duration) (err error) {
+	// Hash output
+	var f *os.File
+	if f, err = os.Open(r.path); err != nil {
+		err = fmt.Errorf("main: opening output file failed: %w", err)
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	if r.size, err = io.Copy(h, f); err != nil {
+		err = fmt.Errorf("main: hashing output file failed: %w", err)
+		return
+	}
+	r.checksum = hex.EncodeToString(h.Sum(nil))
+	r.etag = fmt.Sprintf(`"%s"`, r.checksum[:32])
+	r.modifiedAt = time.Now().UTC().Truncate(time.Second)
+	r.expiresAt = r.modifiedAt.Add(ttl)
+
+	// Store result
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.results[r.id] = r
+	return
+}
+
+// resultErrorStatus returns the status of a task whose result can't be created
+func resultErrorStatus(err error) int {
+	if errors.Is(err, errInsufficientStorage) {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// checkFreeDisk checks the free space of the result directory
+func checkFreeDisk() (err error) {
+	if minFreeDisk <= 0 {
+		return
+	}
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(resultDir, &st); err != nil {
+		err = fmt.Errorf("main: getting free disk space failed: %w", err)
+		return
+	}
+	if free := int64(st.Bavail) * int64(st.Bsize); free < minFreeDisk {
+		err = fmt.Errorf("%w: %d bytes left in %s", errInsufficientStorage, free, resultDir)
+		return
+	}
+	return
+}
+
+// discard removes the output file of a result which won't be committed
+func (s *resultStore) discard(r *result) {
+	os.Remove(r.path)
+}
+
+// get returns the result with this id or nil
+func (s *resultStore) get(id string) *result {
+	s.m.Lock()
+	defer s.m.Unlock()
+	r, ok := s.results[id]
+	if !ok || time.Now().After(r.expiresAt) {
+		return nil
+	}
+	return r
+}
+
+// remove removes the result with this id along with its output file
+func (s *resultStore) remove(id string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if r, ok := s.results[id]; ok {
+		os.Remove(r.path)
+		delete(s.results, id)
+	}
+}
+
+// removeExpired removes expired results along with their output file
+func (s *resultStore) removeExpired() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := time.Now()
+	for id, r := range s.results {
+		if now.After(r.expiresAt) {
+			os.Remove(r.path)
+			delete(s.results, id)
+		}
+	}
+}
+
+// send sends the result output, honoring If-None-Match, If-Modified-Since
+// and Range headers. The ETag is the hash of the output, so caches can
+// revalidate it without downloading it again.
+func (s *resultStore) send(ct *fiber.Ctx, r *result) error {
+	// Not modified, If-Modified-Since is ignored when If-None-Match is sent
+	ct.Set(fiber.HeaderETag, r.etag)
+	ct.Set(fiber.HeaderLastModified, r.modifiedAt.Format(http.TimeFormat))
+	if inm := ct.Get(fiber.HeaderIfNoneMatch); inm != "" {
+		if etagMatches(inm, r.etag) {
+			return ct.SendStatus(fiber.StatusNotModified)
+		}
+	} else if ims, err := http.ParseTime(ct.Get(fiber.HeaderIfModifiedSince)); err == nil && !r.modifiedAt.After(ims) {
+		return ct.SendStatus(fiber.StatusNotModified)
+	}
+
+	// Send file, ranges are handled by fasthttp
+	if err := ct.SendFile(r.path); err != nil {
+		return err
+	}
+	r.info.setHeaders(ct)
+	ct.Set(headerChecksum, r.checksum)
+	return nil
+}
+
+// etagMatches reports whether an If-None-Match header, a list of entity tags
+// compared weakly, matches etag
+func etagMatches(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// resultReader records the output read from rc in a result, which is
+// committed once rc is fully read and discarded otherwise
+type resultReader struct {
+	committed func(r *result) // Nil unless set
+	f         *os.File
+	finished  bool
+	r         *result
+	rc        io.ReadCloser
+	s         *resultStore
+	ttl       time.Duration
+}
+
+func (rr *resultReader) Read(p []byte) (n int, err error) {
+	n, err = rr.rc.Read(p)
+
+	// Record output
+	if rr.f != nil && n > 0 {
+		if _, werr := rr.f.Write(p[:n]); werr != nil {
+			rr.f.Close()
+			rr.f = nil
+		}
+	}
+
+	// Finish
+	if err == io.EOF {
+		rr.finish(rr.f != nil)
+	} else if err != nil {
+		rr.finish(false)
+	}
+	return
+}
+
+func (rr *resultReader) Close() error {
+	rr.finish(false)
+	return rr.rc.Close()
+}
+
+func (rr *resultReader) finish(ok bool) {
+	if rr.finished {
+		return
+	}
+	rr.finished = true
+	if rr.f != nil {
+		if err := rr.f.Close(); err != nil {
+			ok = false
+		}
+	}
+	if ok {
+		if err := rr.s.commit(rr.r, rr.ttl); err == nil {
+			if rr.committed != nil {
+				rr.committed(rr.r)
+			}
+			return
+		}
+	}
+	rr.s.discard(rr.r)
+}
+
+// newStoredOutput creates a result recording the output described by info
+// read from rc
+func newStoredOutput(s *resultStore, mediaType string, info outputInfo, rc io.ReadCloser) (rr *resultReader, err error) {
+	// Create result
+	var id string
+	if id, err = newID(); err != nil {
+		err = fmt.Errorf("main: creating result id failed: %w", err)
+		return
+	}
+	var r *result
+	if r, err = s.create(id, mediaType); err != nil {
+		return
+	}
+	r.info = info
+
+	// Open output file
+	rr = &resultReader{
+		r:   r,
+		rc:  rc,
+		s:   s,
+		ttl: resultRetention,
+	}
+	if rr.f, err = os.OpenFile(r.path, os.O_WRONLY, 0600); err != nil {
+		s.discard(r)
+		err = fmt.Errorf("main: opening output file failed: %w", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		match  bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"def", "abc"`, true},
+		{` "def" ,W/"abc" `, true},
+		{`*`, true},
+		{`"def"`, false},
+		{`abc`, false},
+		{`"ABC"`, false},
+		{``, false},
+	} {
+		if v := etagMatches(c.header, `"abc"`); v != c.match {
+			t.Errorf("%q: expected %v, got %v", c.header, c.match, v)
+		}
+	}
+}
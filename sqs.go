@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	sqsErrorDelay        = 5 * time.Second
+	sqsMaxMessages       = 10
+	sqsRetryDelay        = 10 * time.Second // Delay before a failed task is received again
+	sqsTimeout           = 10 * time.Second
+	sqsVisibilityTimeout = time.Minute // Extended every half while the task runs
+	sqsWaitTime          = 20 * time.Second
+)
+
+var sqsQueueURL string // Empty disables the worker
+
+// sqsWorker long polls the queue for tasks and runs them, no more than the
+// worker pool can run at once. Messages are kept invisible while their task
+// runs and deleted once it succeeded. Failed ones are received again after a
+// delay, the redrive policy of the queue moves them to its dead-letter queue
+// after too many receives.
+type sqsWorker struct {
+	client *sqs.Client
+	sem    chan struct{} // Slots of the messages being handled
+	tc     *taskConsumer
+}
+
+// newSQSWorker creates a worker for the queue, with the AWS config of the S3
+// destinations. Requests are sent to the host of the queue url.
+func newSQSWorker(tc *taskConsumer, queueURL string) (w *sqsWorker, err error) {
+	// Parse queue url
+	var u *url.URL
+	if u, err = url.Parse(queueURL); err != nil {
+		err = fmt.Errorf("main: parsing sqs queue url failed: %w", err)
+		return
+	} else if u.Scheme != "https" && u.Scheme != "http" {
+		err = fmt.Errorf("main: sqs queue url scheme not supported: %s", u.Scheme)
+		return
+	}
+
+	// Create client
+	var cfg aws.Config
+	if cfg, err = loadAWSConfig(); err != nil {
+		return
+	}
+	w = &sqsWorker{
+		client: sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+			o.EndpointResolver = sqs.EndpointResolverFromURL(u.Scheme + "://" + u.Host)
+		}),
+		sem: make(chan struct{}, maxConcurrency),
+		tc:  tc,
+	}
+	return
+}
+
+// start polls the queue in the background
+func (w *sqsWorker) start() {
+	rootLogger.info("main: polling sqs queue", "url", sqsQueueURL)
+	go func() {
+		for {
+			// Receive as many messages as there are free slots
+			n := w.acquire(sqsMaxMessages)
+			ms, err := w.receive(n)
+			for i := len(ms); i < n; i++ {
+				<-w.sem
+			}
+			if err != nil {
+				rootLogger.error("main: receiving sqs messages failed", "error", err)
+				time.Sleep(sqsErrorDelay)
+				continue
+			}
+
+			// Handle messages
+			for _, m := range ms {
+				go w.handle(m)
+			}
+		}
+	}()
+}
+
+// acquire waits for a free slot and takes up to max of them
+func (w *sqsWorker) acquire(max int) (n int) {
+	w.sem <- struct{}{}
+	for n = 1; n < max; n++ {
+		select {
+		case w.sem <- struct{}{}:
+		default:
+			return
+		}
+	}
+	return
+}
+
+// receive long polls up to n messages, they are invisible to other workers
+// for the visibility timeout
+func (w *sqsWorker) receive(n int) ([]types.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sqsWaitTime+sqsTimeout)
+	defer cancel()
+	out, err := w.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		MaxNumberOfMessages: int32(n),
+		QueueUrl:            aws.String(sqsQueueURL),
+		VisibilityTimeout:   int32(sqsVisibilityTimeout / time.Second),
+		WaitTimeSeconds:     int32(sqsWaitTime / time.Second),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("main: sqs ReceiveMessage failed: %w", err)
+	}
+	return out.Messages, nil
+}
+
+// changeVisibility makes the message visible again after the timeout
+func (w *sqsWorker) changeVisibility(m types.Message, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqsTimeout)
+	defer cancel()
+	if _, err := w.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(sqsQueueURL),
+		ReceiptHandle:     m.ReceiptHandle,
+		VisibilityTimeout: int32(timeout / time.Second),
+	}); err != nil {
+		return fmt.Errorf("main: sqs ChangeMessageVisibility failed: %w", err)
+	}
+	return nil
+}
+
+// delete deletes the message from the queue
+func (w *sqsWorker) delete(m types.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sqsTimeout)
+	defer cancel()
+	if _, err := w.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(sqsQueueURL),
+		ReceiptHandle: m.ReceiptHandle,
+	}); err != nil {
+		return fmt.Errorf("main: sqs DeleteMessage failed: %w", err)
+	}
+	return nil
+}
+
+// handle runs the task of a message and deletes it on success
+func (w *sqsWorker) handle(m types.Message) {
+	defer func() { <-w.sem }()
+	l := rootLogger.with("sqs_message_id", aws.ToString(m.MessageId))
+
+	// Keep the message invisible while the task waits for a free slot in the
+	// worker pool and runs
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(sqsVisibilityTimeout / 2)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if err := w.changeVisibility(m, sqsVisibilityTimeout); err != nil {
+					l.warn("main: extending sqs message visibility failed", "error", err)
+				}
+			}
+		}
+	}()
+
+	// Run task
+	task, _ := w.tc.handle([]byte(aws.ToString(m.Body)), l, "sqs message")
+	close(done)
+	<-stopped
+
+	// Receive failed message again after a delay
+	if !task.Success {
+		if err := w.changeVisibility(m, sqsRetryDelay); err != nil {
+			l.error("main: changing sqs message visibility failed", "error", err)
+		}
+		return
+	}
+
+	// Delete message
+	if err := w.delete(m); err != nil {
+		l.error("main: deleting sqs message failed", "error", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/m/pipeline"
+)
+
+// subtitlesRequest is the body of POST /speak/subtitles, which returns a
+// subtitle stream of an input as SRT or WebVTT
+type subtitlesRequest struct {
+	AudioUrl    string   `form:"audiourl"` // Input, named as the inputs of transcodes
+	Headers     []string `form:"headers"`
+	StreamIndex *int     `form:"streamindex"` // Input index of the subtitle stream, defaults to the first one
+	Language    string   `form:"language"`    // Language of the subtitle stream, e.g. eng
+	Format      string   `form:"format"`      // srt or webvtt, defaults to srt
+	Timeout     string   `form:"timeout"`     // Duration such as 30s or number of seconds
+}
+
+// subtitlesTask extracts the subtitles a request describes, updating
+// task.Status on failure. The extraction is interrupted once the task timeout
+// expires.
+func subtitlesTask(r *subtitlesRequest, task *TranscodeTask, parent *span) (b []byte, contentType string, err error) {
+	// Check task
+	task.Status = http.StatusOK
+	if task.AudioUrl == "" {
+		task.Status = http.StatusBadRequest
+		err = errAudioURLRequired
+		return
+	}
+	var d time.Duration
+	if err = checkTimeout(task.Timeout); err != nil {
+		task.Status = http.StatusBadRequest
+		return
+	}
+	if task.StreamIndex != nil && *task.StreamIndex < 0 {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: invalid stream index: %d", *task.StreamIndex)
+		return
+	}
+
+	// Check subtitles
+	s := pipeline.Subtitles{Format: strings.ToLower(r.Format)}
+	switch s.Format {
+	case "", pipeline.SubtitleSRT:
+		s.Format = pipeline.SubtitleSRT
+		contentType = "application/x-subrip"
+	case pipeline.SubtitleWebVTT:
+		contentType = "text/vtt"
+	default:
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: subtitle format not supported: %s", r.Format)
+		return
+	}
+
+	// Read the subtitles through a pipe
+	var op *outputPipe
+	if op, err = newOutputPipe(); err != nil {
+		task.Status = http.StatusInternalServerError
+		err = fmt.Errorf("main: creating output pipe failed: %w", err)
+		return
+	}
+	read := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(op)
+		read <- b
+	}()
+	s.URL = op.url()
+
+	// Extract
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if d = taskTimeout(task); d > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), d)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	err = pipeline.ExtractSubtitles(ctx, task.AudioUrl, taskOptions(task, parent), s)
+	op.closeWrite(err)
+	b = <-read
+	if err != nil {
+		task.Status = transcodeErrorStatus(err)
+		if errors.Is(err, pipeline.ErrTimeout) {
+			err = fmt.Errorf("%w after %s", err, d)
+		}
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/m/pipeline"
+)
+
+// thumbnailRequest is the body of POST /speak/thumbnail, which returns a frame
+// of a video input as an image
+type thumbnailRequest struct {
+	AudioUrl string   `form:"audiourl"` // Video input, named as the inputs of transcodes
+	Headers  []string `form:"headers"`
+	Position string   `form:"position"` // Input position such as 1m30s or number of seconds, defaults to 0
+	Format   string   `form:"format"`   // jpeg or png, defaults to jpeg
+	Width    int      `form:"width"`    // In pixels, defaults to the width of the video
+	Height   int      `form:"height"`   // In pixels, defaults to the height of the video
+	Timeout  string   `form:"timeout"`  // Duration such as 30s or number of seconds
+
+	// Burn the first subtitle stream of the input in
+	Subtitles bool `form:"subtitles"`
+}
+
+// thumbnailTask extracts the thumbnail a request describes, updating
+// task.Status on failure. The extraction is interrupted once the task timeout
+// expires.
+func thumbnailTask(r *thumbnailRequest, task *TranscodeTask, parent *span) (b []byte, format string, err error) {
+	// Check task
+	task.Status = http.StatusOK
+	if task.AudioUrl == "" {
+		task.Status = http.StatusBadRequest
+		err = errAudioURLRequired
+		return
+	}
+	var d time.Duration
+	if err = checkTimeout(task.Timeout); err != nil {
+		task.Status = http.StatusBadRequest
+		return
+	}
+
+	// Check thumbnail
+	th := pipeline.Thumbnail{
+		Format:    strings.ToLower(r.Format),
+		Height:    r.Height,
+		Subtitles: r.Subtitles,
+		Width:     r.Width,
+	}
+	if th.Format == "" {
+		th.Format = pipeline.ThumbnailJPEG
+	}
+	if th.Format != pipeline.ThumbnailJPEG && th.Format != pipeline.ThumbnailPNG {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: thumbnail format not supported: %s", r.Format)
+		return
+	}
+	if th.Width < 0 || th.Width > maxThumbnailSize || th.Height < 0 || th.Height > maxThumbnailSize {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: thumbnail size out of range: %dx%d", th.Width, th.Height)
+		return
+	}
+	if th.Position, err = parseTimeout(r.Position); err != nil || th.Position < 0 {
+		task.Status = http.StatusBadRequest
+		err = fmt.Errorf("main: invalid position: %s", r.Position)
+		return
+	}
+
+	// Extract
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if d = taskTimeout(task); d > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), d)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	if b, err = pipeline.ExtractThumbnail(ctx, task.AudioUrl, taskOptions(task, parent), th); err != nil {
+		task.Status = transcodeErrorStatus(err)
+		if errors.Is(err, pipeline.ErrTimeout) {
+			err = fmt.Errorf("%w after %s", err, d)
+		}
+		return
+	}
+	format = th.Format
+	return
+}
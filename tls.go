@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	tlsCertFile     string // Empty disables TLS
+	tlsClientAuth   string // require or verify-if-given
+	tlsClientCAFile string // Empty doesn't ask for client certificates
+	tlsKeyFile      string
+	tlsMinVersion   string // 1.2 or 1.3
+)
+
+// newListener listens on the address, with TLS when a certificate is
+// configured
+func newListener(addr string) (ln net.Listener, err error) {
+	// Listen
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		err = fmt.Errorf("main: listening on %s failed: %w", addr, err)
+		return
+	}
+	if tlsCertFile == "" {
+		return
+	}
+
+	// Wrap with TLS
+	var c *tls.Config
+	if c, err = newTLSConfig(); err != nil {
+		ln.Close()
+		return
+	}
+	ln = tls.NewListener(ln, c)
+	return
+}
+
+// newTLSConfig loads the server certificate and, for mTLS, the CAs client
+// certificates must be signed by
+func newTLSConfig() (c *tls.Config, err error) {
+	c = &tls.Config{MinVersion: tls.VersionTLS12}
+	if tlsMinVersion == "1.3" {
+		c.MinVersion = tls.VersionTLS13
+	}
+
+	// Load certificate
+	var cert tls.Certificate
+	if cert, err = tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile); err != nil {
+		err = fmt.Errorf("main: loading tls certificate failed: %w", err)
+		return
+	}
+	c.Certificates = []tls.Certificate{cert}
+
+	// Client certificates
+	if tlsClientCAFile == "" {
+		return
+	}
+	var b []byte
+	if b, err = ioutil.ReadFile(tlsClientCAFile); err != nil {
+		err = fmt.Errorf("main: reading tls client ca failed: %w", err)
+		return
+	}
+	c.ClientCAs = x509.NewCertPool()
+	if !c.ClientCAs.AppendCertsFromPEM(b) {
+		err = fmt.Errorf("main: no certificate found in tls client ca %s", tlsClientCAFile)
+		return
+	}
+	c.ClientAuth = tls.RequireAndVerifyClientCert
+	if tlsClientAuth == "verify-if-given" {
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return
+}
+
+// clientCertificateName returns the subject common name of the verified
+// client certificate of the request, nil without one so that it isn't logged
+func clientCertificateName(ct *fiber.Ctx) interface{} {
+	if cs := ct.Context().TLSConnectionState(); cs != nil && len(cs.VerifiedChains) > 0 {
+		return cs.VerifiedChains[0][0].Subject.CommonName
+	}
+	return nil
+}
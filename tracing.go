@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	traceEndpoint      string // OTLP/HTTP traces endpoint, empty disables tracing
+	traceExportTimeout = 10 * time.Second
+	traceServiceName   string
+)
+
+// tracePropagator reads and writes W3C traceparent headers
+var tracePropagator = propagation.TraceContext{}
+
+// span is a timed operation of a trace
+type span struct {
+	ctx  context.Context // Holds the span, children are started in it
+	span trace.Span
+	t    trace.Tracer
+}
+
+// tracer exports spans to an OTLP/HTTP collector in batches. A nil tracer
+// creates nil spans, whose methods are no-ops.
+type tracer struct {
+	t trace.Tracer
+}
+
+// newTracer creates a tracer exporting spans to the OTLP/HTTP traces
+// endpoint, with the OTLP protobuf encoding
+func newTracer(endpoint string) (tr *tracer, err error) {
+	// Parse endpoint
+	var u *url.URL
+	if u, err = url.Parse(endpoint); err != nil {
+		err = fmt.Errorf("main: parsing traces endpoint failed: %w", err)
+		return
+	} else if u.Scheme != "https" && u.Scheme != "http" {
+		err = fmt.Errorf("main: traces endpoint scheme not supported: %s", u.Scheme)
+		return
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithTimeout(traceExportTimeout),
+		otlptracehttp.WithURLPath(u.Path),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	// Create exporter, it's only connected to once spans are exported
+	var e *otlptrace.Exporter
+	if e, err = otlptracehttp.New(context.Background(), opts...); err != nil {
+		err = fmt.Errorf("main: creating otlp exporter failed: %w", err)
+		return
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		rootLogger.error("main: exporting spans failed", "error", err)
+	}))
+
+	// Create provider, spans are dropped if the exporter can't keep up
+	p := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(e),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", traceServiceName))),
+	)
+	tr = &tracer{t: p.Tracer("transgode")}
+	return
+}
+
+// startSpan starts a server span continuing the trace of a W3C traceparent
+// header, or a new trace if it's empty or invalid
+func (tr *tracer) startSpan(traceparent, name string) *span {
+	if tr == nil {
+		return nil
+	}
+	ctx := tracePropagator.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceparent})
+	ctx, s := tr.t.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+	return &span{ctx: ctx, span: s, t: tr.t}
+}
+
+// child starts a span within this one
+func (s *span) child(name string) *span {
+	if s == nil {
+		return nil
+	}
+	ctx, c := s.t.Start(s.ctx, name)
+	return &span{ctx: ctx, span: c, t: s.t}
+}
+
+// setAttribute adds an attribute to the span
+func (s *span) setAttribute(k, v string) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attribute.String(k, v))
+}
+
+// finish ends the span and queues it for export
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// traceparent returns the W3C traceparent header of the span
+func (s *span) traceparent() string {
+	if s == nil {
+		return ""
+	}
+	c := propagation.MapCarrier{}
+	tracePropagator.Inject(s.ctx, c)
+	return c.Get("traceparent")
+}
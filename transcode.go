@@ -0,0 +1,688 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"example.com/m/pipeline"
+)
+
+var (
+	maxInputDuration time.Duration    // 0 disables the limit
+	maxInputSize     int64            // 0 disables the limit
+	maxInputStreams  int              // 0 disables the limit
+	maxMemory        int64            // 0 disables the limit
+	maxOutputSize    int64            // 0 disables the limit
+	allowedFilters   []string         // Filter names allowed in custom filter chains, empty disables them
+	hwAccel          pipeline.HWAccel // Device video inputs are decoded on by hwaccels
+	hwDecoders       []string         // Suffixes of the hardware decoders tried first for video inputs
+	transcodeTimeout time.Duration    // Default and maximum timeout of tasks, 0 disables it
+	defaultThreads   int              // FFmpeg threads of decoders and encoders of tasks which don't set them
+)
+
+var (
+	inputAllowPrivate    bool
+	inputAllowedSchemes  []string
+	inputFileRoots       []string
+	inputRetries         int
+	inputRetryBackoff    time.Duration
+	inputRetryBackoffMax = 10 * time.Second
+)
+
+var (
+	parallelMinDuration time.Duration // Inputs shorter than this are decoded in one piece
+	parallelSegments    int           // Up to 1 disables parallel decoding
+)
+
+// transcoder runs the pipeline of a task, updating task.Status on failure
+type transcoder struct {
+	*pipeline.Transcoder
+	cancel      context.CancelFunc
+	ctx         context.Context // Done once canceled or once the task timeout expires
+	packageDir  string          // Temporary directory the segments of packaged media types are written in
+	packageFile bool            // The output is written in packageDir and copied as is rather than zipped
+	memory      int64           // Reserved in the memory budget
+	packageURL  string          // Where the package is zipped once transcoded
+	push        *pusher         // Nil unless the output is pushed live
+	release     func()          // Releases the cached input, nil unless cached
+	steps       *stepTimings    // Nil unless the task is debugged
+	task        *TranscodeTask
+}
+
+// newTranscoder opens the task input and sets up its output to outputURL. On
+// failure task.Status is updated and all resources are freed. The transcode
+// is interrupted when ctx is done or once the task timeout expires.
+// Each step is traced as a child of parent, which can be nil.
+func newTranscoder(ctx context.Context, task *TranscodeTask, outputURL string, parent *span) (*transcoder, error) {
+	return openTranscoder(ctx, task, outputURL, parent, func(t *transcoder) error {
+		return t.Open(t.ctx, t.inputURL())
+	})
+}
+
+// newSignalTranscoder generates the test signal s instead of opening the task
+// input, and is otherwise like newTranscoder
+func newSignalTranscoder(ctx context.Context, task *TranscodeTask, s pipeline.Signal, outputURL string, parent *span) (*transcoder, error) {
+	return openTranscoder(ctx, task, outputURL, parent, func(t *transcoder) error {
+		return t.OpenSignal(s)
+	})
+}
+
+// openTranscoder sets up the transcoder of a task whose audio is opened by open
+func openTranscoder(ctx context.Context, task *TranscodeTask, outputURL string, parent *span, open func(t *transcoder) error) (t *transcoder, err error) {
+	// Create transcoder
+	t = newTaskTranscoder(ctx, task, parent)
+	defer func() {
+		if err != nil {
+			t.close()
+			t = nil
+		}
+	}()
+
+	// Write packages, and the outputs of tasks with several, in a temporary
+	// directory, zipped into outputURL once transcoded. Outputs whose header is
+	// rewritten once transcoded are copied rather than zipped.
+	playlist, packaged := packagePlaylists[task.MediaType]
+	t.packageFile = isRewritten(task)
+	if packaged || len(task.Outputs) > 0 || isCut(task) || t.packageFile {
+		if t.packageDir, err = os.MkdirTemp(packageTempDir, "transgode-"); err != nil {
+			task.Status = http.StatusInternalServerError
+			err = fmt.Errorf("main: creating package directory failed: %w", err)
+			return
+		}
+		t.packageURL = outputURL
+		if packaged {
+			outputURL = filepath.Join(t.packageDir, playlist)
+		} else if isCut(task) {
+			outputURL = filepath.Join(t.packageDir, cutFileName(task.MediaType))
+		} else {
+			outputURL = filepath.Join(t.packageDir, outputFileName(0, task.MediaType))
+		}
+	}
+	defer t.checkError(&err)
+
+	// Open input
+	if err = open(t); err != nil {
+		return
+	}
+
+	// Read cover art
+	var cover *pipeline.Picture
+	if cover, err = coverArt(t.ctx, task); err != nil {
+		return
+	}
+
+	// Add output
+	format, _ := outputFormat(task.MediaType)
+	codec := outputCodec(task.MediaType, task.Encoding)
+	var muxerOptions map[string]string
+	if packaged {
+		muxerOptions = packageMuxerOptions(task)
+	} else if task.VideoUrl != "" {
+		format = "matroska"
+	} else if isCut(task) {
+		muxerOptions = cutMuxerOptions(task, format)
+		format = "segment"
+	} else if task.Bext {
+		muxerOptions = map[string]string{"write_bext": "1"}
+	}
+	fadeIn, _ := parseTimeout(task.FadeIn)
+	fadeOut, _ := parseTimeout(task.FadeOut)
+	padStart, _ := parseTimeout(task.PadStart)
+	padEnd, _ := parseTimeout(task.PadEnd)
+	targetDuration, _ := parseTimeout(task.TargetDuration)
+	silenceDuration, _ := parseTimeout(task.SilenceDuration)
+	eq, _ := parseEQBands(task.EQ)
+	metadata, _ := parseMetadata(task.Metadata)
+	chapters, _ := parseChapters(task.Chapters)
+	o := pipeline.Output{
+		BitRate:    outputBitRate(task.MediaType, task.BitRate),
+		ChannelMap: task.ChannelMap,
+		Channels:   task.Channels,
+		Codec:      codec,
+		Denoise:    task.Denoise,
+		EQ:         eq,
+		FadeIn:     fadeIn,
+		FadeOut:    fadeOut,
+		Format:     format,
+		Gain:       task.Gain,
+		HighPass:   task.HighPass,
+		LowPass:    task.LowPass,
+		Normalize:  task.Normalize,
+		PadEnd:     padEnd,
+		PadStart:   padStart,
+		Pitch:      task.Pitch,
+		SampleRate: task.SampleRate,
+		Tempo:      task.Tempo,
+		URL:        outputURL,
+
+		SilenceDuration:  silenceDuration,
+		SilenceThreshold: task.SilenceThreshold,
+		TrimSilence:      task.TrimSilence,
+
+		Filter:         task.Filter,
+		ReplaceFilters: task.FilterMode == filterModeReplace,
+
+		Loop:           task.TargetMode == targetModeLoop,
+		TargetDuration: targetDuration,
+
+		Dither:             task.Dither,
+		Resampler:          task.Resampler,
+		ResamplerPrecision: task.Precision,
+
+		Chapters:      chapters,
+		CoverArt:      cover,
+		Metadata:      metadata,
+		MuxerOptions:  muxerOptions,
+		StripMetadata: task.StripMetadata,
+
+		MaxDuration: presetMaxDuration(task.Preset),
+	}
+	if err = checkOutputDuration(task, t.Duration(), o.MaxDuration); err != nil {
+		return
+	}
+	if err = t.AddOutput(o); err != nil {
+		return
+	}
+
+	// Add the other outputs, encoded from the same decoded audio
+	ps, _ := parseOutputProfiles(task)
+	for i, p := range ps {
+		po := o
+		po.Format, _ = outputFormat(p.MediaType)
+		po.Codec = outputCodec(p.MediaType, p.Encoding)
+		po.Channels, po.Normalize, po.SampleRate = p.Channels, p.Normalize, p.SampleRate
+		po.BitRate, po.MaxDuration = outputBitRate(p.MediaType, p.BitRate), p.MaxDuration
+		if err = checkOutputDuration(task, t.Duration(), po.MaxDuration); err != nil {
+			return
+		}
+		po.URL = filepath.Join(t.packageDir, outputFileName(i+1, p.MediaType))
+		if po.Format != "wav" {
+			po.MuxerOptions = nil
+		}
+		if p.MediaType != "mp3" {
+			po.CoverArt = nil
+		}
+		if err = t.AddOutput(po); err != nil {
+			return
+		}
+	}
+
+	// Push the output live as well, through a second output. RTP and SRT
+	// destinations are written by FFmpeg itself in their own format. Dry runs
+	// don't connect to the destination.
+	if task.PushUrl != "" && !task.dryRun {
+		o.CoverArt = nil
+		var u *url.URL
+		if u, err = parsePushURL(task.PushUrl); err != nil {
+			return
+		}
+		if f, ok := pushFormats[u.Scheme]; ok {
+			o.Codec, o.Format, o.URL = f.codec, f.format, task.PushUrl
+			o.MuxerOptions = nil
+			if err = t.AddOutput(o); err != nil {
+				err = fmt.Errorf("%w: %v", errPushFailed, err)
+				return
+			}
+		} else {
+			if t.push, err = startPush(t.ctx, u, outputContentType(outputMediaType(task))); err != nil {
+				task.Status = http.StatusInternalServerError
+				return
+			}
+			o.URL = t.push.url()
+			if err = t.AddOutput(o); err != nil {
+				return
+			}
+		}
+	}
+
+	// Reserve the audio the outputs buffer in the memory budget
+	t.memory, err = reserveMemory(t.MemoryEstimate())
+	return
+}
+
+// newTaskTranscoder creates the transcoder of a task, interrupted when ctx is
+// done or once the task timeout expires
+func newTaskTranscoder(ctx context.Context, task *TranscodeTask, parent *span) (t *transcoder) {
+	t = &transcoder{task: task}
+	if d := taskTimeout(task); d > 0 {
+		t.ctx, t.cancel = context.WithTimeout(ctx, d)
+	} else {
+		t.ctx, t.cancel = context.WithCancel(ctx)
+	}
+
+	// Download remote inputs in the input cache, the options allowing the
+	// cached copy to be read
+	if inputs != nil && !task.dryRun {
+		task.cachedInput, t.release = inputs.open(t.ctx, task)
+	}
+
+	// Record steps of debugged tasks
+	o := taskOptions(task, parent)
+	if task.Debug {
+		t.steps = newStepTimings()
+		o.Hooks.Step = t.steps.wrap(o.Hooks.Step)
+	}
+	t.Transcoder = pipeline.New(o)
+	return
+}
+
+// inputURL returns the url of the task input, its cached copy if any
+func (t *transcoder) inputURL() string {
+	if t.task.cachedInput != "" {
+		return "file://" + t.task.cachedInput
+	}
+	return t.task.AudioUrl
+}
+
+// run transcodes the input into the output
+func (t *transcoder) run() (err error) {
+	// Count decoded audio in the quota of the task
+	if t.task.quota != nil {
+		defer func() { t.task.quota.add(t.Position()) }()
+	}
+	defer t.checkError(&err)
+	err = t.Run(t.ctx)
+
+	// Wait for the push to end, its own failure being more telling than the
+	// write errors it causes
+	if t.push != nil {
+		if errPush := t.push.finish(err); errPush != nil && t.ctx.Err() == nil {
+			err = errPush
+		}
+		t.push = nil
+	}
+	if err != nil || t.packageDir == "" {
+		return
+	}
+
+	// Zip the package
+	if t.packageFile {
+		return copyOutput(filepath.Join(t.packageDir, outputFileName(0, t.task.MediaType)), t.packageURL)
+	}
+	return writePackage(t.packageDir, t.packageURL)
+}
+
+// close frees all resources
+func (t *transcoder) close() {
+	t.cancel()
+	t.Close()
+	if t.push != nil {
+		t.push.finish(pipeline.ErrCanceled)
+	}
+	if t.packageDir != "" {
+		os.RemoveAll(t.packageDir)
+	}
+	releaseMemory(t.memory)
+	t.memory = 0
+	if t.release != nil {
+		t.release()
+		t.release = nil
+		t.task.cachedInput = ""
+	}
+}
+
+// checkError updates the task status according to the error
+func (t *transcoder) checkError(err *error) {
+	if *err == nil {
+		return
+	}
+	t.task.Status = transcodeErrorStatus(*err)
+	if errors.Is(*err, pipeline.ErrTimeout) {
+		*err = fmt.Errorf("%w after %s", *err, taskTimeout(t.task))
+	}
+}
+
+// transcodeErrorStatus returns the http status of a transcode error
+func transcodeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, pipeline.ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, errCoverArtUnavailable):
+		return http.StatusBadGateway
+	case errors.Is(err, errCoverArtInvalid):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errCoverArtTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errPushFailed):
+		return http.StatusBadGateway
+	case errors.Is(err, errPackageWrite):
+		return http.StatusInternalServerError
+	case errors.Is(err, errMemoryBudget):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, pipeline.ErrInputLimitExceeded), errors.Is(err, pipeline.ErrMemoryLimitExceeded), errors.Is(err, pipeline.ErrOutputLimitExceeded):
+		return http.StatusRequestEntityTooLarge
+	case pipeline.IsTransient(err):
+		return http.StatusBadGateway
+	case errors.Is(err, pipeline.ErrNoAudioStream), errors.Is(err, pipeline.ErrNoSubtitleStream), errors.Is(err, pipeline.ErrSubtitleCodec):
+		return http.StatusUnprocessableEntity
+	}
+	switch pipeline.ErrorCode(err) {
+	case pipeline.CodeDecodeError, pipeline.CodeUnsupportedCodec:
+		return http.StatusUnprocessableEntity
+	case pipeline.CodeEncodeError:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// taskOptions returns the pipeline options of the task: FFmpeg logs are
+// attributed to its logger, steps are traced as children of parent and the
+// input is restricted by the input policy unless the task is trusted
+func taskOptions(task *TranscodeTask, parent *span) (o pipeline.Options) {
+	o = pipeline.Options{
+		Headers:    task.Headers,
+		HWAccel:    hwAccel,
+		HWDecoders: hwDecoders,
+		Hooks: pipeline.Hooks{
+			FFmpegContext: taskLogger(task).trackFFmpeg,
+			Step: func(name string) func(err error) {
+				return parent.child(name).finish
+			},
+		},
+		Limits: pipeline.Limits{
+			MaxInputDuration: inputDurationLimit(task),
+			MaxInputSize:     maxInputSize,
+			MaxInputStreams:  maxInputStreams,
+			MaxMemory:        maxMemory,
+			MaxOutputSize:    maxOutputSize,
+		},
+		Language:        task.Language,
+		Retries:         inputRetries,
+		RetryBackoff:    inputRetryBackoff,
+		RetryBackoffMax: inputRetryBackoffMax,
+		Split: pipeline.Split{
+			Dir:         packageTempDir,
+			MinDuration: parallelMinDuration,
+			Segments:    parallelSegments,
+		},
+		StreamIndex: task.StreamIndex,
+		Threads:     defaultThreads,
+	}
+	if task.Threads > 0 {
+		o.Threads = task.Threads
+	}
+	o.ExtractAudio = task.ExtractAudio
+	o.Realtime = task.PushUrl != ""
+	if task.Realtime != nil {
+		o.Realtime = *task.Realtime
+	}
+	if task.LowLatency {
+		// Parallel decoding only writes once all the segments are decoded
+		o.LowLatency, o.Split = true, pipeline.Split{}
+	}
+	o.Video = task.VideoUrl
+	o.Start, _ = parseTimeout(task.Start)
+	o.Duration, _ = parseTimeout(task.Duration)
+	o.Concat.URLs = task.Concat
+	o.Concat.Crossfade, _ = parseTimeout(task.Crossfade)
+	o.Concat.Gap, _ = parseTimeout(task.Gap)
+	o.Concat.Gapless = task.Gapless
+	o.Mix.Duck = task.Duck
+	for i, u := range task.Mix {
+		mi := pipeline.MixInput{URL: u}
+		if i < len(task.MixGain) {
+			mi.Gain = task.MixGain[i]
+		}
+		o.Mix.Inputs = append(o.Mix.Inputs, mi)
+	}
+	if !task.trusted {
+		o.InputPolicy = &pipeline.InputPolicy{
+			AllowPrivate: inputAllowPrivate,
+			FileRoots:    inputFileRoots,
+			Schemes:      inputAllowedSchemes,
+		}
+
+		// Only the cached copy of the task input is readable in the cache
+		if task.cachedInput != "" {
+			o.InputPolicy.FileRoots = append(append([]string{}, inputFileRoots...), task.cachedInput)
+		}
+	}
+	return
+}
+
+// outputFormat returns the muxer and encoder names of a media type
+func outputFormat(mediaType string) (format, codec string) {
+	format = strings.ToLower(mediaType)
+	codec = format
+	if v := supportedEncCodecs[format]; v != "" {
+		codec = v
+	}
+	if format == "raw" {
+		format = "data"
+	}
+	return
+}
+
+// isRewritten reports whether the single output of a task is written in a
+// temporary file before being sent, so that its muxer can rewrite its header
+// once transcoded: mu-law WAV files need the sizes and fact chunk players rely
+// on, which can't be written in a pipe
+func isRewritten(task *TranscodeTask) bool {
+	return task.Encoding == "mulaw" && !isPackaged(task.MediaType) && len(task.Outputs) == 0 && !isCut(task)
+}
+
+// outputBitRate returns the bit rate in bits per second of outputs of the
+// media type encoded at kbps, 0 keeping the encoder default
+func outputBitRate(mediaType string, kbps int) int64 {
+	if !lossyMediaTypes[mediaType] {
+		return 0
+	}
+	return int64(kbps) * 1000
+}
+
+// checkOutputDuration fails before anything is written when the expected
+// duration of the output of a task whose transcoded range lasts probed
+// exceeds max, 0 being unlimited. Outputs of unknown duration are checked as
+// they're written.
+func checkOutputDuration(task *TranscodeTask, probed, max time.Duration) error {
+	if d := outputDuration(task, probed); max > 0 && d > max {
+		return fmt.Errorf("%w: duration %s exceeds %s", pipeline.ErrOutputLimitExceeded, d, max)
+	}
+	return nil
+}
+
+// outputCodec returns the encoder of outputs of the media type, the one of
+// the sample encoding of wav outputs when set
+func outputCodec(mediaType, encoding string) string {
+	if c := wavEncodings[encoding]; c != "" {
+		return c
+	}
+	_, codec := outputFormat(mediaType)
+	return codec
+}
+
+// inputDurationLimit returns the maximum input duration of the task, 0 if
+// there is no limit
+func inputDurationLimit(task *TranscodeTask) time.Duration {
+	if task.MaxDuration > 0 && (maxInputDuration <= 0 || task.MaxDuration < maxInputDuration) {
+		return task.MaxDuration
+	}
+	return maxInputDuration
+}
+
+// checkTimeout checks the timeout of a task, which must be positive when set
+func checkTimeout(v string) error {
+	if d, err := parseTimeout(v); err != nil || (v != "" && d <= 0) {
+		return fmt.Errorf("main: invalid timeout: %s", v)
+	}
+	return nil
+}
+
+// taskTimeout returns the timeout of the task, the server timeout is both
+// its default and its maximum
+func taskTimeout(task *TranscodeTask) time.Duration {
+	d, _ := parseTimeout(task.Timeout)
+	if d <= 0 || (transcodeTimeout > 0 && d > transcodeTimeout) {
+		return transcodeTimeout
+	}
+	return d
+}
+
+// parseMetadata parses the tags of an output written as key=value, e.g.
+// title=Episode 1, an empty value removing the tag of the input
+func parseMetadata(vs []string) (tags map[string]string, err error) {
+	if len(vs) > maxMetadataTags {
+		err = fmt.Errorf("main: more than %d metadata tags", maxMetadataTags)
+		return
+	}
+	for _, v := range vs {
+		i := strings.Index(v, "=")
+		if i <= 0 || strings.IndexFunc(v, unicode.IsControl) >= 0 {
+			err = fmt.Errorf("main: invalid metadata tag: %q", v)
+			return
+		} else if len(v)-i-1 > maxMetadataSize {
+			err = fmt.Errorf("main: metadata tag exceeds %d bytes: %s", maxMetadataSize, v[:i])
+			return
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[v[:i]] = v[i+1:]
+	}
+	return
+}
+
+// parseChapters parses chapters written as start:end:title, e.g. 0:90:Intro
+// or 1m30s:5m:Chapter 1, the title being optional. Chapters must be in order
+// and can't overlap.
+func parseChapters(vs []string) (cs []pipeline.Chapter, err error) {
+	if len(vs) > maxChapters {
+		err = fmt.Errorf("main: more than %d chapters", maxChapters)
+		return
+	}
+	var end time.Duration
+	for _, v := range vs {
+		ps := strings.SplitN(v, ":", 3)
+		if len(ps) < 2 || strings.IndexFunc(v, unicode.IsControl) >= 0 {
+			err = fmt.Errorf("main: invalid chapter: %q", v)
+			return
+		}
+		var c pipeline.Chapter
+		var err1, err2 error
+		c.Start, err1 = parseTimeout(ps[0])
+		c.End, err2 = parseTimeout(ps[1])
+		if len(ps) == 3 {
+			c.Title = ps[2]
+		}
+		if err1 != nil || err2 != nil || ps[0] == "" || ps[1] == "" {
+			err = fmt.Errorf("main: invalid chapter: %q", v)
+			return
+		} else if c.Start < end || c.End <= c.Start {
+			err = fmt.Errorf("main: chapter out of order: %q", v)
+			return
+		} else if len(c.Title) > maxMetadataSize {
+			err = fmt.Errorf("main: chapter title exceeds %d bytes: %s", maxMetadataSize, ps[0])
+			return
+		}
+		end = c.End
+		cs = append(cs, c)
+	}
+	return
+}
+
+// bextFields are the text fields of Broadcast Wave bext chunks, written from
+// the tags of the same name, with their size
+var bextFields = []struct {
+	name string
+	size int
+}{
+	{"description", 256},
+	{"origination_date", 10},
+	{"origination_time", 8},
+	{"originator", 32},
+	{"originator_reference", 32},
+}
+
+// checkBext checks a Broadcast Wave bext chunk can be written in the output
+// of a task with these tags: the date as yyyy-mm-dd, the time as hh:mm:ss and
+// the time reference as a number of samples since midnight
+func checkBext(v *validator, task *TranscodeTask, tags map[string]string) {
+	if task.MediaType != "wav" || task.VideoUrl != "" {
+		v.addf("bext", "bext is only supported by wav")
+		return
+	}
+	for _, f := range bextFields {
+		if len(tags[f.name]) > f.size {
+			v.addf("metadata", "%s exceeds %d bytes", f.name, f.size)
+		}
+	}
+	if d := tags["origination_date"]; d != "" {
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			v.addf("metadata", "invalid origination_date: %s", d)
+		}
+	}
+	if d := tags["origination_time"]; d != "" {
+		if _, err := time.Parse("15:04:05", d); err != nil {
+			v.addf("metadata", "invalid origination_time: %s", d)
+		}
+	}
+	if r := tags["time_reference"]; r != "" {
+		if _, err := strconv.ParseUint(r, 10, 64); err != nil {
+			v.addf("metadata", "invalid time_reference: %s", r)
+		}
+	}
+}
+
+// parseEQBands parses equalizer bands written as frequency:gain:q, e.g.
+// 1000:-3:1.4, the quality factor being optional
+func parseEQBands(vs []string) (bs []pipeline.EQBand, err error) {
+	if len(vs) > maxEQBands {
+		err = fmt.Errorf("main: more than %d eq bands", maxEQBands)
+		return
+	}
+	for _, v := range vs {
+		// Parse
+		ps := strings.Split(v, ":")
+		if len(ps) < 2 || len(ps) > 3 {
+			err = fmt.Errorf("main: invalid eq band: %s", v)
+			return
+		}
+		var b pipeline.EQBand
+		var err1, err2, err3 error
+		b.Frequency, err1 = strconv.ParseFloat(ps[0], 64)
+		b.Gain, err2 = strconv.ParseFloat(ps[1], 64)
+		if len(ps) == 3 {
+			b.Q, err3 = strconv.ParseFloat(ps[2], 64)
+		}
+		if err1 != nil || err2 != nil || err3 != nil {
+			err = fmt.Errorf("main: invalid eq band: %s", v)
+			return
+		}
+
+		// Check ranges
+		if !(b.Frequency >= minFilterFrequency && b.Frequency <= maxFilterFrequency) ||
+			!(b.Gain >= -maxEQGain && b.Gain <= maxEQGain) ||
+			(len(ps) == 3 && !(b.Q >= minEQQ && b.Q <= maxEQQ)) {
+			err = fmt.Errorf("main: eq band out of range: %s", v)
+			return
+		}
+		bs = append(bs, b)
+	}
+	return
+}
+
+// parseTimeout parses a timeout written as a duration such as 30s or as a
+// number of seconds
+func parseTimeout(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	if s, err := strconv.ParseFloat(v, 64); err == nil {
+		// NaN, infinities and numbers of seconds overflowing durations
+		if !(math.Abs(s) < math.MaxInt64/float64(time.Second)) {
+			return 0, fmt.Errorf("main: invalid duration: %s", v)
+		}
+		return time.Duration(s * float64(time.Second)), nil
+	}
+	return time.ParseDuration(v)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeout(t *testing.T) {
+	for _, c := range []struct {
+		v     string
+		d     time.Duration
+		valid bool
+	}{
+		{"", 0, true},
+		{"30", 30 * time.Second, true},
+		{"1.5", 1500 * time.Millisecond, true},
+		{"0", 0, true},
+		{"-1", -time.Second, true},
+		{"30s", 30 * time.Second, true},
+		{"1m30s", 90 * time.Second, true},
+		{"250ms", 250 * time.Millisecond, true},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"-Inf", 0, false},
+		{"1e300", 0, false},
+		{"1e10", 0, false},
+		{"30x", 0, false},
+		{"s", 0, false},
+	} {
+		d, err := parseTimeout(c.v)
+		if c.valid && (err != nil || d != c.d) {
+			t.Errorf("%q: expected %s, got %s, %v", c.v, c.d, d, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%q: expected an error, got %s", c.v, d)
+		}
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	for _, c := range []struct {
+		v     string
+		valid bool
+	}{
+		{"", true},
+		{"30", true},
+		{"0.5", true},
+		{"30s", true},
+		{"0", false},
+		{"0s", false},
+		{"-1", false},
+		{"-30s", false},
+		{"NaN", false},
+		{"forever", false},
+	} {
+		if err := checkTimeout(c.v); c.valid && err != nil {
+			t.Errorf("%q: expected no error, got %v", c.v, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%q: expected an error", c.v)
+		}
+	}
+}
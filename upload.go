@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const (
+	gcsEndpoint    = "https://storage.googleapis.com"
+	uploadPartSize = 16 << 20
+)
+
+var (
+	awsConfig          aws.Config
+	awsConfigErr       error
+	awsConfigOnce      sync.Once
+	gcsAccessKeyID     string
+	gcsSecretAccessKey string
+	outputBuckets      []string // Allowed output destinations as scheme://bucket, empty disables uploads
+	s3AccessKeyID      string
+	s3Endpoint         string // Empty defaults to AWS
+	s3Region           string
+	s3SecretAccessKey  string
+	s3SessionToken     string
+	uploadTimeout      = 10 * time.Minute
+)
+
+// objectDestination is where an output is uploaded in object storage
+type objectDestination struct {
+	bucket string
+	key    string // Prefix when ending with a slash
+	scheme string
+}
+
+// parseOutputDestination parses a s3://bucket/key or gs://bucket/key
+// destination and checks it's allowed
+func parseOutputDestination(raw string) (d *objectDestination, err error) {
+	// Parse
+	var u *url.URL
+	if u, err = url.Parse(raw); err != nil {
+		err = fmt.Errorf("main: parsing output destination failed: %w", err)
+		return
+	}
+	d = &objectDestination{
+		bucket: u.Host,
+		key:    strings.TrimPrefix(u.Path, "/"),
+		scheme: strings.ToLower(u.Scheme),
+	}
+	if d.scheme != "s3" && d.scheme != "gs" {
+		err = fmt.Errorf("main: output destination scheme not supported: %s", u.Scheme)
+		return
+	} else if d.bucket == "" {
+		err = fmt.Errorf("main: output destination has no bucket: %s", raw)
+		return
+	}
+
+	// Check bucket is allowed
+	for _, b := range outputBuckets {
+		if strings.EqualFold(b, d.scheme+"://"+d.bucket) {
+			return
+		}
+	}
+	err = fmt.Errorf("main: output destination not allowed: %s://%s", d.scheme, d.bucket)
+	return
+}
+
+// objectKey returns the key of the object, named after the id if the
+// destination is a prefix
+func (d *objectDestination) objectKey(id, mediaType string) string {
+	if d.key == "" || strings.HasSuffix(d.key, "/") {
+		return fmt.Sprintf("%s%s.%s", d.key, id, mediaType)
+	}
+	return d.key
+}
+
+// packageKey returns the key prefix of the files of a package, named after
+// the id if the destination is a prefix
+func (d *objectDestination) packageKey(id string) string {
+	if d.key == "" || strings.HasSuffix(d.key, "/") {
+		return d.key + id + "/"
+	}
+	return d.key + "/"
+}
+
+// uploadOutput uploads the output file and returns the object url
+func uploadOutput(d *objectDestination, key, path, contentType string) (objectURL string, err error) {
+	// Open output
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		err = fmt.Errorf("main: opening output file failed: %w", err)
+		return
+	}
+	defer f.Close()
+	return uploadObject(d, key, f, contentType)
+}
+
+// uploadObject uploads body and returns the object url. Bodies larger than a
+// part are uploaded in parts, which is how bodies that can't be seeked are
+// sent too.
+func uploadObject(d *objectDestination, key string, body io.Reader, contentType string) (objectURL string, err error) {
+	// Get client
+	var c *s3.Client
+	if c, objectURL, err = objectClient(d); err != nil {
+		return
+	}
+	objectURL += "/" + escapeObjectKey(key)
+
+	// Upload
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	if _, err = manager.NewUploader(c, func(u *manager.Uploader) {
+		u.PartSize = uploadPartSize
+	}).Upload(ctx, &s3.PutObjectInput{
+		Body:        body,
+		Bucket:      aws.String(d.bucket),
+		ContentType: aws.String(contentType),
+		Key:         aws.String(key),
+	}); err != nil {
+		err = fmt.Errorf("main: uploading output failed: %w", err)
+		return
+	}
+	return
+}
+
+// objectClient returns the client of the bucket of the destination and the
+// bucket url. GCS is accessed through its S3 compatible XML API with HMAC keys.
+func objectClient(d *objectDestination) (c *s3.Client, bucketURL string, err error) {
+	// Load config
+	var cfg aws.Config
+	if cfg, err = loadAWSConfig(); err != nil {
+		return
+	}
+
+	switch d.scheme {
+	case "gs":
+		cfg.Credentials = credentials.NewStaticCredentialsProvider(gcsAccessKeyID, gcsSecretAccessKey, "")
+		cfg.Region = "auto"
+		c = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			// GCS rewrites the Accept-Encoding header, which then doesn't
+			// match the signature
+			o.APIOptions = append(o.APIOptions, func(s *middleware.Stack) error {
+				_, err := s.Finalize.Remove("DisableAcceptEncodingGzip")
+				return err
+			})
+			o.EndpointResolver = s3.EndpointResolverFromURL(gcsEndpoint)
+			o.UsePathStyle = true
+		})
+		bucketURL = gcsEndpoint + "/" + d.bucket
+	default:
+		if s3Endpoint != "" {
+			c = s3.NewFromConfig(cfg, func(o *s3.Options) {
+				o.EndpointResolver = s3.EndpointResolverFromURL(s3Endpoint)
+				o.UsePathStyle = true
+			})
+			bucketURL = strings.TrimSuffix(s3Endpoint, "/") + "/" + d.bucket
+		} else {
+			c = s3.NewFromConfig(cfg)
+			bucketURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", d.bucket, cfg.Region)
+		}
+	}
+	return
+}
+
+// loadAWSConfig loads the AWS config of S3 destinations and of the SQS queue
+// once. Credentials are the ones of the storage config when set, or else the
+// ones of the default chain: shared files, web identity, then ECS and EC2
+// roles.
+func loadAWSConfig() (aws.Config, error) {
+	awsConfigOnce.Do(func() {
+		opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(s3Region)}
+		if s3AccessKeyID != "" {
+			opts = append(opts, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s3AccessKeyID, s3SecretAccessKey, s3SessionToken)))
+		}
+		if awsConfig, awsConfigErr = awsconfig.LoadDefaultConfig(context.Background(), opts...); awsConfigErr != nil {
+			awsConfigErr = fmt.Errorf("main: loading aws config failed: %w", awsConfigErr)
+		}
+	})
+	return awsConfig, awsConfigErr
+}
+
+// escapeObjectKey escapes the key of an object url, keeping slashes
+func escapeObjectKey(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// uploadResult uploads the result to the task output destination and stores
+// the object url in the task
+func uploadResult(task *TranscodeTask, r *result, parent *span) (err error) {
+	// Trace
+	sp := parent.child("upload")
+	defer func() { sp.finish(err) }()
+
+	// Parse destination
+	var d *objectDestination
+	if d, err = parseOutputDestination(task.OutputDestination); err != nil {
+		task.Status = http.StatusBadRequest
+		return
+	}
+
+	// Upload, packages file by file so that they can be streamed from the
+	// bucket and several outputs each in its own object
+	if p, ok := packagePlaylists[task.MediaType]; ok {
+		task.OutputURL, err = uploadPackage(d, d.packageKey(r.id), r.path, p)
+	} else if len(task.Outputs) > 0 || isCut(task) {
+		if task.OutputURLs, err = uploadOutputs(d, d.packageKey(r.id), r.path); err == nil {
+			task.OutputURL = task.OutputURLs[0]
+		}
+	} else {
+		task.OutputURL, err = uploadOutput(d, d.objectKey(r.id, outputMediaType(task)), r.path, r.info.contentType)
+	}
+	if err != nil {
+		task.Status = http.StatusBadGateway
+		return
+	}
+	return
+}
+
+// transcodeAndUpload transcodes the task into a temporary result and uploads
+// it to the task output destination
+func transcodeAndUpload(task *TranscodeTask, results *resultStore, parent *span) (err error) {
+	// Create output file
+	var id string
+	if id, err = newID(); err != nil {
+		task.Status = http.StatusInternalServerError
+		err = fmt.Errorf("main: creating result id failed: %w", err)
+		return
+	}
+	var r *result
+	if r, err = results.create(id, outputMediaType(task)); err != nil {
+		task.Status = resultErrorStatus(err)
+		return
+	}
+	defer results.discard(r)
+
+	// Transcode
+	var t *transcoder
+	if t, err = newTranscoder(context.Background(), task, r.path, parent); err != nil {
+		return
+	}
+	err = t.run()
+	t.close()
+	if err != nil {
+		return
+	}
+
+	// Upload
+	return uploadResult(task, r, parent)
+}
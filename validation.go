@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// fieldError is an invalid field of a request
+type fieldError struct {
+	Field   string `json:"field"`   // Form name of the field
+	Message string `json:"message"` // Why it's invalid
+}
+
+// validationError lists all the invalid fields of a request, so that they
+// can be fixed at once
+type validationError struct {
+	fields []fieldError
+}
+
+func (e *validationError) Error() string {
+	if len(e.fields) == 1 {
+		return "main: " + e.fields[0].Message
+	}
+	ms := make([]string, 0, len(e.fields))
+	for _, f := range e.fields {
+		ms = append(ms, f.Field+": "+f.Message)
+	}
+	return "main: invalid fields: " + strings.Join(ms, "; ")
+}
+
+// validator collects the invalid fields of a request
+type validator struct {
+	fields []fieldError
+	status int // 400 unless a field sets another one
+}
+
+// add records err as the reason field is invalid, the package prefix of its
+// message being trimmed
+func (v *validator) add(field string, err error) {
+	m := err.Error()
+	for _, p := range []string{"main: ", "pipeline: "} {
+		m = strings.TrimPrefix(m, p)
+	}
+	v.fields = append(v.fields, fieldError{Field: field, Message: m})
+}
+
+// addf records a formatted message as the reason field is invalid
+func (v *validator) addf(field, format string, args ...interface{}) {
+	v.fields = append(v.fields, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// invalid reports whether field has been recorded as invalid
+func (v *validator) invalid(field string) bool {
+	for _, f := range v.fields {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// err returns the *validationError listing the invalid fields, nil if there
+// are none. The task status and errors are updated.
+func (v *validator) err(task *TranscodeTask) error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	task.Errors = v.fields
+	task.Status = http.StatusBadRequest
+	if v.status != 0 {
+		task.Status = v.status
+	}
+	return &validationError{fields: v.fields}
+}
+
+// checkInputURL checks the syntax of an input url, and its scheme against the
+// input policy unless the task is trusted
+func checkInputURL(task *TranscodeTask, rawurl string) error {
+	if task.trusted {
+		return nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return errors.New("invalid url")
+	} else if u.Scheme == "" {
+		return fmt.Errorf("url has no scheme: %s", rawurl)
+	}
+	if strings.EqualFold(u.Scheme, "file") {
+		if len(inputFileRoots) == 0 {
+			return errors.New("file inputs aren't allowed")
+		}
+		return nil
+	}
+	for _, s := range inputAllowedSchemes {
+		if strings.EqualFold(s, u.Scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("scheme not allowed: %s", u.Scheme)
+}
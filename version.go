@@ -0,0 +1,63 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"example.com/m/pipeline"
+)
+
+// Set at build time, e.g. with -ldflags "-X main.version=1.4.0 -X main.commit=0fb0825"
+var (
+	commit  = "unknown"
+	version = "dev"
+)
+
+// versionInfo describes the running binary and the FFmpeg it's linked with,
+// whose build decides which codecs are available
+type versionInfo struct {
+	Commit    string     `json:"commit"`
+	FFmpeg    ffmpegInfo `json:"ffmpeg"`
+	GoAstiav  string     `json:"goAstiav"`
+	GoVersion string     `json:"goVersion"`
+	Version   string     `json:"version"`
+}
+
+// ffmpegInfo describes the linked FFmpeg libraries
+type ffmpegInfo struct {
+	Configuration []string          `json:"configuration"` // Flags FFmpeg was configured with
+	Libraries     map[string]string `json:"libraries"`     // Versions by library, e.g. libavcodec
+	Version       string            `json:"version"`
+}
+
+// queryVersion describes the running binary, the version of go-astiav being
+// read from the build info
+func queryVersion() (v versionInfo) {
+	b := pipeline.FFmpegBuild()
+	v = versionInfo{
+		Commit: commit,
+		FFmpeg: ffmpegInfo{
+			Configuration: b.Configuration,
+			Libraries:     make(map[string]string),
+			Version:       b.Version,
+		},
+		GoAstiav:  "unknown",
+		GoVersion: runtime.Version(),
+		Version:   version,
+	}
+	for _, l := range b.Libraries {
+		v.FFmpeg.Libraries[l.Name] = l.Version
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range bi.Deps {
+			if m.Path != "github.com/asticode/go-astiav" {
+				continue
+			}
+			v.GoAstiav = m.Version
+			if m.Replace != nil {
+				v.GoAstiav = m.Replace.Version
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/m/config"
+)
+
+const (
+	watchDoneDir      = "done"   // Subdirectory inputs are moved to once transcoded
+	watchFailedDir    = "failed" // Subdirectory inputs are moved to when failing
+	watchPollInterval = 5 * time.Second
+	watchSettleTime   = 10 * time.Second // Time a file must be left unmodified to be picked by a scan
+)
+
+var watchFolders []config.WatchFolder
+
+// folderWatcher transcodes the files dropped in a folder with its profile.
+// Files are picked once written as notified by inotify on Linux, or once left
+// unmodified for a while when the folder is scanned, which also picks the
+// files dropped while transgode was stopped. Hidden files are ignored since
+// uploads often use them as temporary files. Inputs are then moved to the done
+// or failed subdirectory.
+type folderWatcher struct {
+	folder  config.WatchFolder
+	m       *sync.Mutex     // Locks pending
+	pending map[string]bool // Names of the files being transcoded
+	pool    *workerPool
+	tracer  *tracer
+}
+
+func newFolderWatcher(folder config.WatchFolder, pool *workerPool, tracer *tracer) *folderWatcher {
+	return &folderWatcher{
+		folder:  folder,
+		m:       &sync.Mutex{},
+		pending: make(map[string]bool),
+		pool:    pool,
+		tracer:  tracer,
+	}
+}
+
+// start creates the directories and watches the folder in the background
+func (w *folderWatcher) start() error {
+	// Create directories
+	for _, d := range []string{
+		filepath.Join(w.folder.Dir, watchDoneDir),
+		filepath.Join(w.folder.Dir, watchFailedDir),
+		w.folder.OutputDir,
+	} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return fmt.Errorf("main: creating watch folder directory failed: %w", err)
+		}
+	}
+
+	// Watch
+	rootLogger.info("main: watching folder", "dir", w.folder.Dir, "output_dir", w.folder.OutputDir, "mediatype", w.folder.MediaType)
+	go w.notify()
+	go func() {
+		for {
+			w.scan()
+			time.Sleep(watchPollInterval)
+		}
+	}()
+	return nil
+}
+
+// scan picks the files left unmodified for the settle time
+func (w *folderWatcher) scan() {
+	fis, err := ioutil.ReadDir(w.folder.Dir)
+	if err != nil {
+		rootLogger.error("main: scanning watch folder failed", "dir", w.folder.Dir, "error", err)
+		return
+	}
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && time.Since(fi.ModTime()) >= watchSettleTime {
+			w.pick(fi.Name())
+		}
+	}
+}
+
+// pick transcodes a file in the background unless it's already being
+func (w *folderWatcher) pick(name string) {
+	if strings.HasPrefix(name, ".") {
+		return
+	}
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.pending[name] {
+		return
+	}
+	w.pending[name] = true
+	go func() {
+		w.handle(name)
+		w.m.Lock()
+		delete(w.pending, name)
+		w.m.Unlock()
+	}()
+}
+
+// handle transcodes a file and moves it out of the folder
+func (w *folderWatcher) handle(name string) {
+	// Transcode
+	path := filepath.Join(w.folder.Dir, name)
+	l := rootLogger.with("path", path)
+	task := &TranscodeTask{
+		AudioUrl:   path,
+		Channels:   w.folder.Channels,
+		MediaType:  w.folder.MediaType,
+		SampleRate: w.folder.SampleRate,
+		log:        l,
+		trusted:    true,
+	}
+	sp := w.tracer.startSpan("", "watch file")
+	sp.setAttribute("transgode.mediatype", task.MediaType)
+	output, err := w.transcode(task, sp)
+	sp.finish(err)
+
+	// Move input
+	dir := watchDoneDir
+	if err != nil {
+		dir = watchFailedDir
+		l.error("main: transcoding failed", "status", task.Status, "error", err)
+	} else {
+		l.info("main: watch folder file transcoded", "output", output)
+	}
+	if err = os.Rename(path, filepath.Join(w.folder.Dir, dir, name)); err != nil {
+		l.error("main: moving input failed", "error", err)
+	}
+}
+
+// transcode transcodes the input into the output directory, under a hidden
+// name until done so that partial outputs aren't picked up
+func (w *folderWatcher) transcode(task *TranscodeTask, sp *span) (output string, err error) {
+	// Prepare task
+	if err = prepareTask(task); err != nil {
+		return
+	}
+	name := filepath.Base(task.AudioUrl)
+	output = filepath.Join(w.folder.OutputDir, strings.TrimSuffix(name, filepath.Ext(name))+"."+outputMediaType(task))
+	tmp := filepath.Join(w.folder.OutputDir, "."+filepath.Base(output)+".part")
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	// Wait for a free slot in the worker pool
+	w.pool.acquire(false)
+	defer w.pool.release()
+
+	// Transcode
+	var t *transcoder
+	if t, err = newTranscoder(context.Background(), task, tmp, sp); err != nil {
+		return
+	}
+	err = t.run()
+	t.close()
+	if err != nil {
+		return
+	}
+
+	// Publish output
+	if err = os.Rename(tmp, output); err != nil {
+		err = fmt.Errorf("main: renaming output failed: %w", err)
+		return
+	}
+	task.Success = true
+	return
+}
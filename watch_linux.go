@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// notify picks the files written or moved into the folder as soon as inotify
+// notifies them, scans still pick them otherwise
+func (w *folderWatcher) notify() {
+	// Watch
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		rootLogger.warn("main: inotify unavailable, watch folder is only scanned", "dir", w.folder.Dir, "error", err)
+		return
+	}
+	defer syscall.Close(fd)
+	if _, err = syscall.InotifyAddWatch(fd, w.folder.Dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		rootLogger.warn("main: inotify unavailable, watch folder is only scanned", "dir", w.folder.Dir, "error", err)
+		return
+	}
+
+	// Read events
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			rootLogger.error("main: reading inotify events failed", "dir", w.folder.Dir, "error", err)
+			return
+		}
+		for i := 0; i+syscall.SizeofInotifyEvent <= n; {
+			e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[i]))
+			start := i + syscall.SizeofInotifyEvent
+			i = start + int(e.Len)
+			if i > n {
+				break
+			}
+			if e.Mask&syscall.IN_ISDIR == 0 {
+				w.pick(strings.TrimRight(string(buf[start:i]), "\x00"))
+			}
+		}
+	}
+}
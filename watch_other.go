@@ -0,0 +1,7 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// notify does nothing without inotify, scans pick the files
+func (w *folderWatcher) notify() {}
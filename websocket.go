@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// WebSocket opcodes
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// WebSocket close codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseTooBig        = 1009
+	wsCloseInternalError = 1011
+	wsCloseTryAgainLater = 1013
+)
+
+// wsEndOfInput is the text message ending the input
+const wsEndOfInput = "end"
+
+var (
+	errWebSocketClosed   = errors.New("main: websocket closed")
+	errWebSocketProtocol = errors.New("main: websocket protocol error")
+	errWebSocketTooBig   = errors.New("main: websocket message too big")
+	errWebSocketUpgrade  = errors.New("main: websocket upgrade required")
+)
+
+// isWebSocketUpgrade checks the request is a WebSocket handshake
+func isWebSocketUpgrade(ct *fiber.Ctx) bool {
+	return ct.Method() == fiber.MethodGet &&
+		strings.EqualFold(ct.Get(fiber.HeaderUpgrade), "websocket") &&
+		strings.Contains(strings.ToLower(ct.Get(fiber.HeaderConnection)), "upgrade") &&
+		ct.Get("Sec-WebSocket-Version") == "13" &&
+		ct.Get("Sec-WebSocket-Key") != ""
+}
+
+// upgradeWebSocket answers the handshake and calls handler with the
+// connection once the response is sent. The connection is closed once
+// handler returns.
+func upgradeWebSocket(ct *fiber.Ctx, handler func(c *wsConn)) error {
+	h := sha1.Sum([]byte(ct.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	ct.Set(fiber.HeaderUpgrade, "websocket")
+	ct.Set(fiber.HeaderConnection, "Upgrade")
+	ct.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(h[:]))
+	ct.Status(fiber.StatusSwitchingProtocols)
+	ct.Context().Hijack(func(c net.Conn) {
+		handler(&wsConn{
+			c:  c,
+			m:  &sync.Mutex{},
+			r:  bufio.NewReader(c),
+			rl: int64(maxBodySize),
+		})
+	})
+	return nil
+}
+
+// wsConn is the server side of a WebSocket connection. Messages are read by
+// a single goroutine while writes can be concurrent.
+type wsConn struct {
+	c  net.Conn
+	m  *sync.Mutex // Locks writes
+	r  *bufio.Reader
+	rl int64 // Maximum size of a message read, 0 disables the limit
+}
+
+// readMessage reads the next text or binary message, answering pings. A close
+// message is answered and errWebSocketClosed is returned.
+func (c *wsConn) readMessage() (op byte, data []byte, err error) {
+	for {
+		// Read frame
+		var fin bool
+		var fop byte
+		var b []byte
+		if fin, fop, b, err = c.readFrame(); err != nil {
+			if errors.Is(err, errWebSocketProtocol) {
+				c.close(wsCloseProtocolError, "")
+			} else if errors.Is(err, errWebSocketTooBig) {
+				c.close(wsCloseTooBig, "")
+			}
+			return
+		}
+
+		// Handle control frames, they can be interleaved with fragments
+		switch fop {
+		case wsOpClose:
+			c.close(wsCloseNormal, "")
+			err = errWebSocketClosed
+			return
+		case wsOpPing:
+			if err = c.writeMessage(wsOpPong, b); err != nil {
+				return
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpContinuation:
+			if op == 0 {
+				err = fmt.Errorf("%w: unexpected continuation frame", errWebSocketProtocol)
+				c.close(wsCloseProtocolError, "")
+				return
+			}
+		case wsOpText, wsOpBinary:
+			if op != 0 {
+				err = fmt.Errorf("%w: unfinished fragmented message", errWebSocketProtocol)
+				c.close(wsCloseProtocolError, "")
+				return
+			}
+			op = fop
+		default:
+			err = fmt.Errorf("%w: unknown opcode %d", errWebSocketProtocol, fop)
+			c.close(wsCloseProtocolError, "")
+			return
+		}
+
+		// Append fragment
+		data = append(data, b...)
+		if c.rl > 0 && int64(len(data)) > c.rl {
+			err = errWebSocketTooBig
+			c.close(wsCloseTooBig, "")
+			return
+		}
+		if fin {
+			return
+		}
+	}
+}
+
+// readFrame reads a frame and unmasks its payload
+func (c *wsConn) readFrame() (fin bool, op byte, data []byte, err error) {
+	// Read header
+	var h [2]byte
+	if _, err = io.ReadFull(c.r, h[:]); err != nil {
+		return
+	}
+	fin = h[0]&0x80 != 0
+	op = h[0] & 0x0f
+	if h[0]&0x70 != 0 {
+		err = fmt.Errorf("%w: reserved bits set", errWebSocketProtocol)
+		return
+	}
+	if h[1]&0x80 == 0 {
+		err = fmt.Errorf("%w: client frame not masked", errWebSocketProtocol)
+		return
+	}
+
+	// Read length
+	n := int64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.r, b[:]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.r, b[:]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint64(b[:]))
+	}
+	if op >= wsOpClose && (n > 125 || !fin) {
+		err = fmt.Errorf("%w: invalid control frame", errWebSocketProtocol)
+		return
+	}
+	if n < 0 || (c.rl > 0 && n > c.rl) {
+		err = errWebSocketTooBig
+		return
+	}
+
+	// Read payload
+	var mask [4]byte
+	if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+		return
+	}
+	data = make([]byte, n)
+	if _, err = io.ReadFull(c.r, data); err != nil {
+		return
+	}
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+	return
+}
+
+// writeMessage writes a message in a single unmasked frame
+func (c *wsConn) writeMessage(op byte, data []byte) (err error) {
+	// Build header
+	h := []byte{0x80 | op, 0}
+	switch n := len(data); {
+	case n <= 125:
+		h[1] = byte(n)
+	case n <= 0xffff:
+		h[1] = 126
+		h = append(h, 0, 0)
+		binary.BigEndian.PutUint16(h[2:], uint16(n))
+	default:
+		h[1] = 127
+		h = append(h, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(h[2:], uint64(n))
+	}
+
+	// Write
+	c.m.Lock()
+	defer c.m.Unlock()
+	if _, err = c.c.Write(h); err != nil {
+		return
+	}
+	_, err = c.c.Write(data)
+	return
+}
+
+// writeJSON writes v as a text message
+func (c *wsConn) writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(wsOpText, b)
+}
+
+// close sends a close message, the connection is closed once the handler
+// returns
+func (c *wsConn) close(code uint16, reason string) {
+	b := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(b, code)
+	c.c.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeMessage(wsOpClose, append(b, reason...))
+}
+
+// transcodeWebSocket transcodes the input chunks received on the connection
+// and sends the output chunks back as soon as they are encoded. The input is
+// fed to FFmpeg through a pipe and the output is read from another one. Once
+// done, the task is sent as a text message and the connection is closed.
+// The worker pool slot is acquired once upgraded so that it's always released.
+func transcodeWebSocket(c *wsConn, task *TranscodeTask, pool *workerPool, parent *span) (err error) {
+	code := uint16(wsCloseNormal)
+	defer func() {
+		if err != nil {
+			setTaskError(task, err)
+			if code == wsCloseNormal {
+				code = wsCloseInternalError
+			}
+		}
+		c.writeJSON(task.redacted())
+		c.close(code, "")
+	}()
+
+	// Wait for a free slot in the worker pool
+	if err = pool.acquire(true); err != nil {
+		task.Status = overflowStatus
+		code = wsCloseTryAgainLater
+		return
+	}
+	defer pool.release()
+
+	// Create input pipe, the input comes from the connection so it's not
+	// restricted by the input policy
+	ir, iw, err := os.Pipe()
+	if err != nil {
+		task.Status = fiber.StatusInternalServerError
+		err = fmt.Errorf("main: creating input pipe failed: %w", err)
+		return
+	}
+	defer ir.Close()
+	task.AudioUrl = fmt.Sprintf("pipe:%d", ir.Fd())
+	task.trusted = true
+
+	// Create output pipe
+	p, err := newOutputPipe()
+	if err != nil {
+		iw.Close()
+		task.Status = fiber.StatusInternalServerError
+		err = fmt.Errorf("main: creating output pipe failed: %w", err)
+		return
+	}
+
+	// Write input messages in the input pipe until the end of the input,
+	// the transcode is canceled if the client goes away before
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		ended := false
+		defer func() {
+			if !ended {
+				iw.Close()
+			}
+		}()
+		for {
+			op, b, err := c.readMessage()
+			if err != nil {
+				if !ended {
+					cancel()
+				}
+				return
+			}
+			if ended {
+				continue
+			}
+			if op == wsOpText && string(b) == wsEndOfInput {
+				ended = true
+				iw.Close()
+				continue
+			}
+			if _, err = iw.Write(b); err != nil {
+				// The transcoder stopped reading
+				ended = true
+				iw.Close()
+			}
+		}
+	}()
+
+	// Send output chunks
+	sent := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := p.Read(buf)
+			if n > 0 {
+				if werr := c.writeMessage(wsOpBinary, buf[:n]); werr != nil {
+					p.Close()
+					sent <- werr
+					return
+				}
+			}
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				sent <- err
+				return
+			}
+		}
+	}()
+
+	// Set up transcoder
+	var t *transcoder
+	if t, err = newTranscoder(ctx, task, p.url(), parent); err != nil {
+		p.abort(err)
+		<-sent
+		return
+	}
+
+	// Run
+	err = t.run()
+	t.close()
+	p.closeWrite(err)
+	if serr := <-sent; err == nil && serr != nil {
+		task.Status = fiber.StatusInternalServerError
+		err = fmt.Errorf("main: sending output failed: %w", serr)
+	}
+	if err != nil {
+		return
+	}
+	task.Success = true
+	return
+}